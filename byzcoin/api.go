@@ -2,7 +2,9 @@ package byzcoin
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"math"
 	"time"
 
@@ -72,6 +74,22 @@ func (c *Client) AddTransaction(tx ClientTransaction) (*AddTxResponse, error) {
 	return c.AddTransactionAndWait(tx, 0)
 }
 
+// SimulateTransaction applies the transaction to the current state of the
+// ledger and returns the resulting state changes, without storing them. It
+// returns the error of the first instruction that fails.
+func (c *Client) SimulateTransaction(tx ClientTransaction) ([]StateChange, error) {
+	reply := &SimulateTransactionResponse{}
+	err := c.SendProtobuf(c.Roster.List[0], &SimulateTransactionRequest{
+		Version:     CurrentVersion,
+		SkipchainID: c.ID,
+		Transaction: tx,
+	}, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply.StateChanges, nil
+}
+
 // AddTransactionAndWait adds a transaction and will wait for it to be included
 // in the ledger, up to a maximum of wait block intervals. It does not return
 // any feedback on the transaction. The Client's Roster and ID should be
@@ -108,6 +126,32 @@ func (c *Client) GetProof(key []byte) (*GetProofResponse, error) {
 	return reply, nil
 }
 
+// GetProofBatch returns the proofs for all the keys with a single request to
+// the service. The proofs are returned in the same order as the keys. The
+// service refuses requests with more than MaxProofBatchSize keys.
+func (c *Client) GetProofBatch(keys [][]byte) ([]GetProofResponse, error) {
+	reply := &GetProofBatchResponse{}
+	err := c.SendProtobuf(c.Roster.List[0], &GetProofBatchRequest{
+		Version: CurrentVersion,
+		ID:      c.ID,
+		Keys:    keys,
+	}, reply)
+	if err != nil {
+		return nil, err
+	}
+	if len(reply.Proofs) != len(keys) {
+		return nil, fmt.Errorf("got %d proofs for %d keys", len(reply.Proofs), len(keys))
+	}
+	proofs := make([]GetProofResponse, len(reply.Proofs))
+	for i, p := range reply.Proofs {
+		proofs[i] = GetProofResponse{
+			Version: reply.Version,
+			Proof:   p,
+		}
+	}
+	return proofs, nil
+}
+
 // CheckAuthorization verifies which actions the given set of identities can
 // execute in the given darc.
 func (c *Client) CheckAuthorization(dID darc.ID, ids ...darc.Identity) ([]darc.Action, error) {
@@ -270,6 +314,52 @@ func (c *Client) StreamTransactions(handler func(StreamingResponse, error)) erro
 	}
 }
 
+// SubscribeStateDiffs returns a channel with the state changes of every block,
+// starting with the block at index fromBlock. The channel is closed when the
+// context is done or the connection to the service is lost.
+func (c *Client) SubscribeStateDiffs(ctx context.Context, fromBlock uint64) (<-chan StateDiff, error) {
+	// The subscription has its own connection, so that it can be closed
+	// without closing the connections of the client.
+	cl := onet.NewClient(cothority.Suite, ServiceName)
+	conn, err := cl.Stream(c.Roster.List[0], &StateDiffRequest{
+		ID:        c.ID,
+		FromBlock: fromBlock,
+	})
+	if err != nil {
+		return nil, err
+	}
+	diffs := make(chan StateDiff)
+	// done stops the closing of the connection if the subscription ends
+	// before the context.
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		cl.Close()
+	}()
+	go func() {
+		defer close(diffs)
+		defer close(done)
+		for {
+			var diff StateDiff
+			if err := conn.ReadMessage(&diff); err != nil {
+				if ctx.Err() == nil {
+					log.Error("lost state diff subscription:", err)
+				}
+				return
+			}
+			select {
+			case diffs <- diff:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return diffs, nil
+}
+
 // GetSignerCounters gets the signer counters from ByzCoin. The counter must be
 // set correctly in the instruction for it to be verified. Every counter maps
 // to a signer, if the most recent instruction is signed by the signer at count
@@ -1,6 +1,7 @@
 package byzcoin
 
 import (
+	"context"
 	"sync"
 	"testing"
 	"time"
@@ -8,6 +9,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/kyber/v3/util/random"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
@@ -64,6 +66,150 @@ func TestClient_GetProof(t *testing.T) {
 	require.Equal(t, value, v0)
 }
 
+// Gets the proofs of the genesis darc and of missing keys in one request and
+// compares them to the proofs returned by GetProof.
+func TestClient_GetProofBatch(t *testing.T) {
+	l := onet.NewTCPTest(cothority.Suite)
+	_, roster, _ := l.GenTree(3, true)
+	defer l.CloseAll()
+
+	c, keys := newProofBatchLedger(t, roster)
+	proofs, err := c.GetProofBatch(keys)
+	require.Nil(t, err)
+	require.Equal(t, len(keys), len(proofs))
+	require.True(t, proofs[0].Proof.InclusionProof.Match(keys[0]))
+	for i, key := range keys {
+		require.Nil(t, proofs[i].Proof.Verify(c.ID))
+		p, err := c.GetProof(key)
+		require.Nil(t, err)
+		require.Equal(t, p.Proof.InclusionProof.Match(key),
+			proofs[i].Proof.InclusionProof.Match(key))
+	}
+
+	proofs, err = c.GetProofBatch(nil)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(proofs))
+
+	_, err = c.GetProofBatch(make([][]byte, MaxProofBatchSize+1))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "cannot return more than")
+}
+
+// Compares the time needed to get 10 proofs with GetProof and with
+// GetProofBatch.
+func BenchmarkClient_GetProofBatch(b *testing.B) {
+	l := onet.NewTCPTest(cothority.Suite)
+	_, roster, _ := l.GenTree(3, true)
+	defer l.CloseAll()
+
+	c, keys := newProofBatchLedger(b, roster)
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, key := range keys {
+				_, err := c.GetProof(key)
+				require.Nil(b, err)
+			}
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := c.GetProofBatch(keys)
+			require.Nil(b, err)
+		}
+	})
+}
+
+// newProofBatchLedger creates a ledger and returns 10 keys: the first one is
+// the genesis darc, the others are not in the trie.
+func newProofBatchLedger(t testing.TB, roster *onet.Roster) (*Client, [][]byte) {
+	signer := darc.NewSignerEd25519(nil, nil)
+	msg, err := DefaultGenesisMsg(CurrentVersion, roster, []string{"spawn:dummy"}, signer.Identity())
+	require.Nil(t, err)
+	c, _, err := NewLedger(msg, false)
+	require.Nil(t, err)
+
+	keys := [][]byte{msg.GenesisDarc.GetBaseID()}
+	for i := 1; i < 10; i++ {
+		keys = append(keys, random.Bits(256, true, random.New()))
+	}
+	return c, keys
+}
+
+// Subscribes to the state diffs of a chain with 5 blocks: 3 before and 2
+// after the subscription.
+func TestClient_SubscribeStateDiffs(t *testing.T) {
+	l := onet.NewTCPTest(cothority.Suite)
+	servers, roster, _ := l.GenTree(3, true)
+	registerDummy(servers)
+	defer l.CloseAll()
+
+	signer := darc.NewSignerEd25519(nil, nil)
+	msg, err := DefaultGenesisMsg(CurrentVersion, roster, []string{"spawn:dummy"}, signer.Identity())
+	require.Nil(t, err)
+	msg.BlockInterval = 100 * time.Millisecond
+	d := msg.GenesisDarc
+	c, _, err := NewLedger(msg, false)
+	require.Nil(t, err)
+
+	addBlock := func(counter uint64) {
+		tx, err := createOneClientTxWithCounter(d.GetBaseID(), "dummy", []byte{byte(counter)}, signer, counter)
+		require.Nil(t, err)
+		_, err = c.AddTransactionAndWait(tx, 10)
+		require.Nil(t, err)
+	}
+	addBlock(1)
+	addBlock(2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	diffs, err := c.SubscribeStateDiffs(ctx, 0)
+	require.Nil(t, err)
+	addBlock(3)
+	addBlock(4)
+
+	for i := uint64(0); i < 5; i++ {
+		select {
+		case diff := <-diffs:
+			require.Equal(t, i, diff.BlockIndex)
+			require.NotEqual(t, 0, len(diff.Creates))
+			require.Equal(t, 0, len(diff.Deletes))
+			if i == 0 {
+				require.Equal(t, 0, len(diff.Updates))
+				continue
+			}
+			var values [][]byte
+			for _, sc := range diff.Creates {
+				if string(sc.ContractID) == "dummy" {
+					values = append(values, sc.Value)
+				}
+			}
+			require.Equal(t, [][]byte{{byte(i)}}, values)
+		case <-time.After(10 * msg.BlockInterval):
+			require.Fail(t, "didn't get all state diffs")
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-diffs:
+		require.False(t, ok)
+	case <-time.After(time.Second):
+		require.Fail(t, "channel not closed")
+	}
+
+	// Starting later only sends the later blocks.
+	ctx, cancel = context.WithCancel(context.Background())
+	diffs, err = c.SubscribeStateDiffs(ctx, 3)
+	require.Nil(t, err)
+	require.Equal(t, uint64(3), (<-diffs).BlockIndex)
+	require.Equal(t, uint64(4), (<-diffs).BlockIndex)
+	cancel()
+
+	// The service only notices the closed connections when sending, so
+	// add some blocks to stop its streaming go-routines.
+	addBlock(5)
+	addBlock(6)
+}
+
 // Create a streaming client and add blocks in the background. The client
 // should receive valid blocks.
 func TestClient_Streaming(t *testing.T) {
@@ -574,6 +574,7 @@ func mint(c *cli.Context) error {
 		rules := darc.NewRules()
 		rules.AddRule(darc.Action("spawn:coin"), expression.Expr(signer.Identity().String()))
 		rules.AddRule(darc.Action("invoke:coin.transfer"), expression.Expr(pubI.String()))
+		rules.AddRule(darc.Action("invoke:coin.fetch"), expression.Expr(pubI.String()))
 		rules.AddRule(darc.Action("invoke:coin.mint"), expression.Expr(signer.Identity().String()))
 		d := darc.NewDarc(rules, []byte("new coin for mba"))
 		dBuf, err := d.ToProto()
@@ -83,6 +83,20 @@ func (b BasicContract) Delete(ReadOnlyStateTrie, Instruction, []Coin) (sc []Stat
 	return
 }
 
+// ContractVersioned is a type that the instance data of contracts may embed to
+// store the version of its schema. Instance data written before the schema
+// was versioned decodes with SchemaVersion 0.
+type ContractVersioned struct {
+	SchemaVersion uint32 `protobuf:"opt"`
+}
+
+// Migrator is implemented by contracts that can read instance data of an
+// older schema. Migrate returns the data converted from oldVersion to the
+// current schema of the contract.
+type Migrator interface {
+	Migrate(oldVersion uint32, data []byte) ([]byte, error)
+}
+
 //
 // Built-in contracts necessary for bootstrapping the ledger.
 //  * Config
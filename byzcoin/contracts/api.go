@@ -0,0 +1,577 @@
+package contracts
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/protobuf"
+)
+
+// StreamSpawn fetches the given number of coins from the source coin instance
+// and locks them in a new coin stream with the schedule of stream. The darc
+// must allow the signer to spawn a coin stream, and the source must allow the
+// signer to fetch coins. It returns the instance ID of the new stream.
+func StreamSpawn(cl *byzcoin.Client, darcID darc.ID, source byzcoin.InstanceID, coins uint64,
+	stream CoinStream, signer darc.Signer) (byzcoin.InstanceID, error) {
+	streamBuf, err := protobuf.Encode(&stream)
+	if err != nil {
+		return byzcoin.InstanceID{}, errors.New("couldn't encode stream: " + err.Error())
+	}
+	coinsBuf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(coinsBuf, coins)
+
+	signerCtrs, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	if len(signerCtrs.Counters) != 1 {
+		return byzcoin.InstanceID{}, errors.New("incorrect signer counters")
+	}
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{
+			{
+				InstanceID: source,
+				Invoke: &byzcoin.Invoke{
+					ContractID: ContractCoinID,
+					Command:    "fetch",
+					Args:       byzcoin.Arguments{{Name: "coins", Value: coinsBuf}},
+				},
+				SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
+			},
+			{
+				InstanceID: byzcoin.NewInstanceID(darcID),
+				Spawn: &byzcoin.Spawn{
+					ContractID: ContractCoinStreamID,
+					Args:       byzcoin.Arguments{{Name: "stream", Value: streamBuf}},
+				},
+				SignerCounter: []uint64{signerCtrs.Counters[0] + 2},
+			},
+		},
+	}
+	if err = ctx.FillSignersAndSignWith(signer); err != nil {
+		return byzcoin.InstanceID{}, errors.New("couldn't sign instructions: " + err.Error())
+	}
+	if _, err = cl.AddTransactionAndWait(ctx, 10); err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	return ctx.Instructions[1].DeriveID(""), nil
+}
+
+// StreamCollect sends the coins of all the periods that elapsed since the last
+// collect to the recipient of the stream.
+func StreamCollect(cl *byzcoin.Client, streamID byzcoin.InstanceID,
+	signer darc.Signer) (*byzcoin.AddTxResponse, error) {
+	signerCtrs, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return nil, err
+	}
+	if len(signerCtrs.Counters) != 1 {
+		return nil, errors.New("incorrect signer counters")
+	}
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: streamID,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractCoinStreamID,
+				Command:    "collect",
+			},
+			SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
+		}},
+	}
+	if err = ctx.FillSignersAndSignWith(signer); err != nil {
+		return nil, errors.New("couldn't sign instruction: " + err.Error())
+	}
+	return cl.AddTransactionAndWait(ctx, 10)
+}
+
+// EscrowSpawn fetches the given number of coins from the source coin instance
+// and locks them in a new coin escrow between the parties of escrow. The darc
+// must allow the signer to spawn a coin escrow, and the source must allow the
+// signer to fetch coins. It returns the instance ID of the new escrow.
+func EscrowSpawn(cl *byzcoin.Client, darcID darc.ID, source byzcoin.InstanceID, coins uint64,
+	escrow CoinEscrow, signer darc.Signer) (byzcoin.InstanceID, error) {
+	escrowBuf, err := protobuf.Encode(&escrow)
+	if err != nil {
+		return byzcoin.InstanceID{}, errors.New("couldn't encode escrow: " + err.Error())
+	}
+	coinsBuf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(coinsBuf, coins)
+
+	signerCtrs, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	if len(signerCtrs.Counters) != 1 {
+		return byzcoin.InstanceID{}, errors.New("incorrect signer counters")
+	}
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{
+			{
+				InstanceID: source,
+				Invoke: &byzcoin.Invoke{
+					ContractID: ContractCoinID,
+					Command:    "fetch",
+					Args:       byzcoin.Arguments{{Name: "coins", Value: coinsBuf}},
+				},
+				SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
+			},
+			{
+				InstanceID: byzcoin.NewInstanceID(darcID),
+				Spawn: &byzcoin.Spawn{
+					ContractID: ContractCoinEscrowID,
+					Args:       byzcoin.Arguments{{Name: "escrow", Value: escrowBuf}},
+				},
+				SignerCounter: []uint64{signerCtrs.Counters[0] + 2},
+			},
+		},
+	}
+	if err = ctx.FillSignersAndSignWith(signer); err != nil {
+		return byzcoin.InstanceID{}, errors.New("couldn't sign instructions: " + err.Error())
+	}
+	if _, err = cl.AddTransactionAndWait(ctx, 10); err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	return ctx.Instructions[1].DeriveID(""), nil
+}
+
+// EscrowRelease sends the coins of the escrow to the seller. The buyer and the
+// seller must both sign.
+func EscrowRelease(cl *byzcoin.Client, escrowID byzcoin.InstanceID,
+	buyer, seller darc.Signer) (*byzcoin.AddTxResponse, error) {
+	return escrowInvoke(cl, escrowID, "release", nil, buyer, seller)
+}
+
+// EscrowDispute hands the escrow over to the arbitrator. The signer must be
+// the buyer or the seller.
+func EscrowDispute(cl *byzcoin.Client, escrowID byzcoin.InstanceID,
+	signer darc.Signer) (*byzcoin.AddTxResponse, error) {
+	return escrowInvoke(cl, escrowID, "dispute", nil, signer)
+}
+
+// EscrowArbitrate resolves a disputed escrow. If release is true, the coins
+// are sent to the seller, else they are refunded to the buyer. The signer
+// must be the arbitrator.
+func EscrowArbitrate(cl *byzcoin.Client, escrowID byzcoin.InstanceID, release bool,
+	signer darc.Signer) (*byzcoin.AddTxResponse, error) {
+	outcome := "refund"
+	if release {
+		outcome = "release"
+	}
+	return escrowInvoke(cl, escrowID, "arbitrate",
+		byzcoin.Arguments{{Name: "outcome", Value: []byte(outcome)}}, signer)
+}
+
+// escrowInvoke sends the command to the escrow, signed by all the signers.
+func escrowInvoke(cl *byzcoin.Client, escrowID byzcoin.InstanceID, command string,
+	args byzcoin.Arguments, signers ...darc.Signer) (*byzcoin.AddTxResponse, error) {
+	ids := make([]string, len(signers))
+	for i, s := range signers {
+		ids[i] = s.Identity().String()
+	}
+	signerCtrs, err := cl.GetSignerCounters(ids...)
+	if err != nil {
+		return nil, err
+	}
+	if len(signerCtrs.Counters) != len(signers) {
+		return nil, errors.New("incorrect signer counters")
+	}
+	counters := make([]uint64, len(signers))
+	for i, ctr := range signerCtrs.Counters {
+		counters[i] = ctr + 1
+	}
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: escrowID,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractCoinEscrowID,
+				Command:    command,
+				Args:       args,
+			},
+			SignerCounter: counters,
+		}},
+	}
+	if err = ctx.FillSignersAndSignWith(signers...); err != nil {
+		return nil, errors.New("couldn't sign instruction: " + err.Error())
+	}
+	return cl.AddTransactionAndWait(ctx, 10)
+}
+
+// SwapSpawn creates a new atomic swap with the terms of swap. The darc must
+// allow the signer to spawn an atomic swap. It returns the instance ID of the
+// new swap.
+func SwapSpawn(cl *byzcoin.Client, darcID darc.ID, swap AtomicSwap,
+	signer darc.Signer) (byzcoin.InstanceID, error) {
+	swapBuf, err := protobuf.Encode(&swap)
+	if err != nil {
+		return byzcoin.InstanceID{}, errors.New("couldn't encode swap: " + err.Error())
+	}
+	signerCtrs, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	if len(signerCtrs.Counters) != 1 {
+		return byzcoin.InstanceID{}, errors.New("incorrect signer counters")
+	}
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: byzcoin.NewInstanceID(darcID),
+			Spawn: &byzcoin.Spawn{
+				ContractID: ContractAtomicSwapID,
+				Args:       byzcoin.Arguments{{Name: "swap", Value: swapBuf}},
+			},
+			SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
+		}},
+	}
+	if err = ctx.FillSignersAndSignWith(signer); err != nil {
+		return byzcoin.InstanceID{}, errors.New("couldn't sign instruction: " + err.Error())
+	}
+	if _, err = cl.AddTransactionAndWait(ctx, 10); err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	return ctx.Instructions[0].DeriveID(""), nil
+}
+
+// SwapConfirm fetches the given number of coins from the source coin instance
+// and locks them in the swap. The source must allow the signer to fetch
+// coins.
+func SwapConfirm(cl *byzcoin.Client, swapID byzcoin.InstanceID, source byzcoin.InstanceID,
+	coins uint64, signer darc.Signer) (*byzcoin.AddTxResponse, error) {
+	coinsBuf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(coinsBuf, coins)
+
+	signerCtrs, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return nil, err
+	}
+	if len(signerCtrs.Counters) != 1 {
+		return nil, errors.New("incorrect signer counters")
+	}
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{
+			{
+				InstanceID: source,
+				Invoke: &byzcoin.Invoke{
+					ContractID: ContractCoinID,
+					Command:    "fetch",
+					Args:       byzcoin.Arguments{{Name: "coins", Value: coinsBuf}},
+				},
+				SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
+			},
+			{
+				InstanceID: swapID,
+				Invoke: &byzcoin.Invoke{
+					ContractID: ContractAtomicSwapID,
+					Command:    "confirm",
+				},
+				SignerCounter: []uint64{signerCtrs.Counters[0] + 2},
+			},
+		},
+	}
+	if err = ctx.FillSignersAndSignWith(signer); err != nil {
+		return nil, errors.New("couldn't sign instructions: " + err.Error())
+	}
+	return cl.AddTransactionAndWait(ctx, 10)
+}
+
+// SwapRefund sends the locked coins of a timed out swap back to the party
+// that confirmed.
+func SwapRefund(cl *byzcoin.Client, swapID byzcoin.InstanceID,
+	signer darc.Signer) (*byzcoin.AddTxResponse, error) {
+	signerCtrs, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return nil, err
+	}
+	if len(signerCtrs.Counters) != 1 {
+		return nil, errors.New("incorrect signer counters")
+	}
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: swapID,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractAtomicSwapID,
+				Command:    "refund",
+			},
+			SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
+		}},
+	}
+	if err = ctx.FillSignersAndSignWith(signer); err != nil {
+		return nil, errors.New("couldn't sign instruction: " + err.Error())
+	}
+	return cl.AddTransactionAndWait(ctx, 10)
+}
+
+// NameRegistrySpawn creates the name registry of the skipchain. The darc must
+// allow the signer to spawn a name registry. It returns the instance ID of the
+// registry, which is always NameRegistryInstanceID.
+func NameRegistrySpawn(cl *byzcoin.Client, darcID darc.ID,
+	signer darc.Signer) (byzcoin.InstanceID, error) {
+	signerCtrs, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	if len(signerCtrs.Counters) != 1 {
+		return byzcoin.InstanceID{}, errors.New("incorrect signer counters")
+	}
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: byzcoin.NewInstanceID(darcID),
+			Spawn: &byzcoin.Spawn{
+				ContractID: ContractNameRegistryID,
+			},
+			SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
+		}},
+	}
+	if err = ctx.FillSignersAndSignWith(signer); err != nil {
+		return byzcoin.InstanceID{}, errors.New("couldn't sign instruction: " + err.Error())
+	}
+	if _, err = cl.AddTransactionAndWait(ctx, 10); err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	return NameRegistryInstanceID, nil
+}
+
+// NameRegister adds name to the registry, pointing to iid. Only the signers
+// of the owner darc can transfer the name later.
+func NameRegister(cl *byzcoin.Client, registryIID byzcoin.InstanceID, name string,
+	iid byzcoin.InstanceID, owner darc.ID, signer darc.Signer) (*byzcoin.AddTxResponse, error) {
+	return nameRegistryInvoke(cl, registryIID, "register", name, iid,
+		byzcoin.Arguments{{Name: "owner", Value: owner}}, signer)
+}
+
+// NameTransfer points the existing name in the registry to iid. The signers
+// must fulfill the rules of the registry and the sign rule of the owner darc
+// of the name.
+func NameTransfer(cl *byzcoin.Client, registryIID byzcoin.InstanceID, name string,
+	iid byzcoin.InstanceID, signers ...darc.Signer) (*byzcoin.AddTxResponse, error) {
+	return nameRegistryInvoke(cl, registryIID, "transfer", name, iid, nil, signers...)
+}
+
+// LookupInstanceID returns the instance ID the name points to in the
+// registry.
+func LookupInstanceID(cl *byzcoin.Client, registryIID byzcoin.InstanceID,
+	name string) (byzcoin.InstanceID, error) {
+	reply, err := cl.GetProof(registryIID.Slice())
+	if err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	if !reply.Proof.InclusionProof.Match(registryIID.Slice()) {
+		return byzcoin.InstanceID{}, errors.New("name registry is not in the ledger")
+	}
+	_, buf, cid, _, err := reply.Proof.KeyValue()
+	if err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	if cid != ContractNameRegistryID {
+		return byzcoin.InstanceID{}, errors.New("instance is not a name registry")
+	}
+	var nr NameRegistry
+	if err = protobuf.Decode(buf, &nr); err != nil {
+		return byzcoin.InstanceID{}, errors.New("couldn't decode NameRegistry: " + err.Error())
+	}
+	return nr.Lookup(name)
+}
+
+// nameRegistryInvoke sends the command to the registry, signed by all the
+// signers.
+func nameRegistryInvoke(cl *byzcoin.Client, registryIID byzcoin.InstanceID, command string,
+	name string, iid byzcoin.InstanceID, args byzcoin.Arguments,
+	signers ...darc.Signer) (*byzcoin.AddTxResponse, error) {
+	ids := make([]string, len(signers))
+	for i, s := range signers {
+		ids[i] = s.Identity().String()
+	}
+	signerCtrs, err := cl.GetSignerCounters(ids...)
+	if err != nil {
+		return nil, err
+	}
+	if len(signerCtrs.Counters) != len(signers) {
+		return nil, errors.New("incorrect signer counters")
+	}
+	counters := make([]uint64, len(signers))
+	for i, ctr := range signerCtrs.Counters {
+		counters[i] = ctr + 1
+	}
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: registryIID,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractNameRegistryID,
+				Command:    command,
+				Args: append(byzcoin.Arguments{
+					{Name: "name", Value: []byte(name)},
+					{Name: "instanceID", Value: iid.Slice()},
+				}, args...),
+			},
+			SignerCounter: counters,
+		}},
+	}
+	if err = ctx.FillSignersAndSignWith(signers...); err != nil {
+		return nil, errors.New("couldn't sign instruction: " + err.Error())
+	}
+	return cl.AddTransactionAndWait(ctx, 10)
+}
+
+// RevocationListSpawn creates a new, empty credential revocation list. The
+// darc must allow the signer to spawn a revocation list, and should only
+// allow the admins to revoke credentials.
+func RevocationListSpawn(cl *byzcoin.Client, darcID darc.ID,
+	signer darc.Signer) (byzcoin.InstanceID, error) {
+	signerCtrs, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	if len(signerCtrs.Counters) != 1 {
+		return byzcoin.InstanceID{}, errors.New("incorrect signer counters")
+	}
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: byzcoin.NewInstanceID(darcID),
+			Spawn: &byzcoin.Spawn{
+				ContractID: ContractCredentialRevocationID,
+			},
+			SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
+		}},
+	}
+	if err = ctx.FillSignersAndSignWith(signer); err != nil {
+		return byzcoin.InstanceID{}, errors.New("couldn't sign instruction: " + err.Error())
+	}
+	if _, err = cl.AddTransactionAndWait(ctx, 10); err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	return ctx.Instructions[0].DeriveID(""), nil
+}
+
+// RevokeCredential adds the credential to the revocation list.
+func RevokeCredential(cl *byzcoin.Client, revListIID byzcoin.InstanceID,
+	credIID byzcoin.InstanceID, signer darc.Signer) (*byzcoin.AddTxResponse, error) {
+	return revocationInvoke(cl, revListIID, "revoke", credIID, signer)
+}
+
+// LookupRevocationList returns the revoked credentials of the revocation
+// list.
+func LookupRevocationList(cl *byzcoin.Client, revListIID byzcoin.InstanceID) ([]byzcoin.InstanceID, error) {
+	reply, err := cl.GetProof(revListIID.Slice())
+	if err != nil {
+		return nil, err
+	}
+	if !reply.Proof.InclusionProof.Match(revListIID.Slice()) {
+		return nil, errors.New("revocation list is not in the ledger")
+	}
+	_, buf, cid, _, err := reply.Proof.KeyValue()
+	if err != nil {
+		return nil, err
+	}
+	if cid != ContractCredentialRevocationID {
+		return nil, errors.New("instance is not a revocation list")
+	}
+	var rl RevocationList
+	if err = protobuf.Decode(buf, &rl); err != nil {
+		return nil, errors.New("couldn't decode RevocationList: " + err.Error())
+	}
+	return rl.Revoked, nil
+}
+
+func revocationInvoke(cl *byzcoin.Client, revListIID byzcoin.InstanceID, command string,
+	credIID byzcoin.InstanceID, signer darc.Signer) (*byzcoin.AddTxResponse, error) {
+	signerCtrs, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return nil, err
+	}
+	if len(signerCtrs.Counters) != 1 {
+		return nil, errors.New("incorrect signer counters")
+	}
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: revListIID,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractCredentialRevocationID,
+				Command:    command,
+				Args: byzcoin.Arguments{
+					{Name: "credential", Value: credIID.Slice()},
+				},
+			},
+			SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
+		}},
+	}
+	if err = ctx.FillSignersAndSignWith(signer); err != nil {
+		return nil, errors.New("couldn't sign instruction: " + err.Error())
+	}
+	return cl.AddTransactionAndWait(ctx, 10)
+}
+
+// ReputationSpawn creates a new reputation with a score of 0. If owner is
+// not nil, the reputation is created at ReputationInstanceID of the ed25519
+// public key of owner, which signs the spawning. The darc must allow the
+// signer to spawn a reputation.
+func ReputationSpawn(cl *byzcoin.Client, darcID darc.ID, owner *darc.Signer,
+	signer darc.Signer) (byzcoin.InstanceID, error) {
+	var args byzcoin.Arguments
+	var pub []byte
+	if owner != nil {
+		if owner.Ed25519 == nil {
+			return byzcoin.InstanceID{}, errors.New("the owner needs an ed25519 key")
+		}
+		var err error
+		pub, err = owner.Ed25519.Point.MarshalBinary()
+		if err != nil {
+			return byzcoin.InstanceID{}, err
+		}
+		sig, err := owner.Sign(ReputationSpawnMessage(darcID, pub))
+		if err != nil {
+			return byzcoin.InstanceID{}, err
+		}
+		args = byzcoin.Arguments{{Name: "public", Value: pub}, {Name: "signature", Value: sig}}
+	}
+	signerCtrs, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	if len(signerCtrs.Counters) != 1 {
+		return byzcoin.InstanceID{}, errors.New("incorrect signer counters")
+	}
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: byzcoin.NewInstanceID(darcID),
+			Spawn: &byzcoin.Spawn{
+				ContractID: ContractReputationID,
+				Args:       args,
+			},
+			SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
+		}},
+	}
+	if err = ctx.FillSignersAndSignWith(signer); err != nil {
+		return byzcoin.InstanceID{}, errors.New("couldn't sign instruction: " + err.Error())
+	}
+	if _, err = cl.AddTransactionAndWait(ctx, 10); err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	if pub != nil {
+		return ReputationInstanceID(pub), nil
+	}
+	return ctx.Instructions[0].DeriveID(""), nil
+}
+
+// GetReputation returns the score of the reputation instance.
+func GetReputation(cl *byzcoin.Client, credIID byzcoin.InstanceID) (uint64, error) {
+	reply, err := cl.GetProof(credIID.Slice())
+	if err != nil {
+		return 0, err
+	}
+	if !reply.Proof.InclusionProof.Match(credIID.Slice()) {
+		return 0, errors.New("reputation is not in the ledger")
+	}
+	_, buf, cid, _, err := reply.Proof.KeyValue()
+	if err != nil {
+		return 0, err
+	}
+	if cid != ContractReputationID {
+		return 0, errors.New("instance is not a reputation")
+	}
+	var r Reputation
+	if err = protobuf.Decode(buf, &r); err != nil {
+		return 0, errors.New("couldn't decode Reputation: " + err.Error())
+	}
+	return r.Score, nil
+}
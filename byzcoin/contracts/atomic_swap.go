@@ -0,0 +1,217 @@
+package contracts
+
+import (
+	"errors"
+	"fmt"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+)
+
+// ContractAtomicSwapID denotes a contract that exchanges coins of one type
+// for coins of another type between two parties.
+var ContractAtomicSwapID = "atomicSwap"
+
+// The states of an AtomicSwap.
+const (
+	// SwapPending waits for the confirmations of the parties.
+	SwapPending = iota + 1
+	// SwapExecuted means both parties received the coins of the other party.
+	SwapExecuted
+	// SwapRefunded means the locked coins have been sent back after the
+	// timeout.
+	SwapRefunded
+)
+
+// ContractAtomicSwap exchanges coins between two parties without a trusted
+// third party. The following methods are available:
+//  - spawn takes the terms from the argument "swap", which must be a protobuf
+//    encoded AtomicSwap. The types of CoinA and CoinB must differ.
+//  - confirm locks the coins of a party, which are taken from the coins given
+//    to the instruction: AmountA coins of the type of CoinA confirm for party
+//    A, AmountB coins of the type of CoinB confirm for party B. Once both
+//    parties confirmed, the coins are sent to PartyA and PartyB.
+//  - refund sends the locked coins back to the coin of the party that
+//    confirmed, once the block of Timeout has passed.
+// The index of the block of an instruction is the index of the latest block
+// plus one.
+
+func contractAtomicSwapFromBytes(in []byte) (byzcoin.Contract, error) {
+	c := &contractAtomicSwap{}
+	err := protobuf.Decode(in, &c.AtomicSwap)
+	if err != nil {
+		return nil, errors.New("couldn't unmarshal instance data: " + err.Error())
+	}
+	return c, nil
+}
+
+type contractAtomicSwap struct {
+	byzcoin.BasicContract
+	AtomicSwap
+}
+
+func (c *contractAtomicSwap) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	swapBuf := inst.Spawn.Args.Search("swap")
+	if swapBuf == nil {
+		return nil, nil, errors.New("argument \"swap\" is missing")
+	}
+	err = protobuf.Decode(swapBuf, &c.AtomicSwap)
+	if err != nil {
+		return nil, nil, errors.New("couldn't unmarshal swap: " + err.Error())
+	}
+	if c.AmountA == 0 || c.AmountB == 0 {
+		return nil, nil, errors.New("need a positive amount for both parties")
+	}
+	if c.Timeout <= uint64(rst.GetIndex()+1) {
+		return nil, nil, errors.New("timeout must be after the current block")
+	}
+	names := make([]byzcoin.InstanceID, 4)
+	for i, iid := range []byzcoin.InstanceID{c.CoinA, c.CoinB, c.PartyA, c.PartyB} {
+		var coin *byzcoin.Coin
+		coin, _, err = loadCoin(rst, iid)
+		if err != nil {
+			return
+		}
+		names[i] = coin.Name
+	}
+	if names[0].Equal(names[1]) {
+		return nil, nil, errors.New("both parties have the same type of coins")
+	}
+	if !names[2].Equal(names[1]) || !names[3].Equal(names[0]) {
+		return nil, nil, errors.New("the parties must receive the type of coins of the other party")
+	}
+	c.ConfirmedA = false
+	c.ConfirmedB = false
+	c.State = SwapPending
+
+	var asBuf []byte
+	asBuf, err = protobuf.Encode(&c.AtomicSwap)
+	if err != nil {
+		return nil, nil, errors.New("couldn't encode AtomicSwap: " + err.Error())
+	}
+	sc = []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Create, inst.DeriveID(""),
+			ContractAtomicSwapID, asBuf, darcID),
+	}
+	return
+}
+
+func (c *contractAtomicSwap) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	if c.State != SwapPending {
+		return nil, nil, fmt.Errorf("swap is not pending anymore, current state is %d", c.State)
+	}
+	block := uint64(rst.GetIndex() + 1)
+	switch inst.Invoke.Command {
+	case "confirm":
+		if block > c.Timeout {
+			return nil, nil, errors.New("the swap timed out")
+		}
+		var coinA, coinB *byzcoin.Coin
+		coinA, _, err = loadCoin(rst, c.CoinA)
+		if err != nil {
+			return
+		}
+		coinB, _, err = loadCoin(rst, c.CoinB)
+		if err != nil {
+			return
+		}
+		var lockedA, lockedB bool
+		if !c.ConfirmedA {
+			cout, lockedA = lockCoins(cout, coinA.Name, c.AmountA)
+		}
+		if !c.ConfirmedB {
+			cout, lockedB = lockCoins(cout, coinB.Name, c.AmountB)
+		}
+		if !lockedA && !lockedB {
+			return nil, nil, errors.New("not enough coins to confirm the swap")
+		}
+		c.ConfirmedA = c.ConfirmedA || lockedA
+		c.ConfirmedB = c.ConfirmedB || lockedB
+
+		if c.ConfirmedA && c.ConfirmedB {
+			var paySC byzcoin.StateChange
+			paySC, err = payCoin(rst, c.PartyA, c.AmountB)
+			if err != nil {
+				return
+			}
+			sc = append(sc, paySC)
+			paySC, err = payCoin(rst, c.PartyB, c.AmountA)
+			if err != nil {
+				return
+			}
+			sc = append(sc, paySC)
+			c.State = SwapExecuted
+			log.Lvlf2("executed swap %x", inst.InstanceID.Slice())
+		}
+	case "refund":
+		if block <= c.Timeout {
+			return nil, nil, errors.New("can only refund after the timeout")
+		}
+		var paySC byzcoin.StateChange
+		switch {
+		case c.ConfirmedA:
+			paySC, err = payCoin(rst, c.CoinA, c.AmountA)
+		case c.ConfirmedB:
+			paySC, err = payCoin(rst, c.CoinB, c.AmountB)
+		default:
+			err = errors.New("no coins to refund")
+		}
+		if err != nil {
+			return
+		}
+		sc = append(sc, paySC)
+		c.State = SwapRefunded
+	default:
+		return nil, nil, errors.New("atomic swap contract can only confirm and refund")
+	}
+
+	var asBuf []byte
+	asBuf, err = protobuf.Encode(&c.AtomicSwap)
+	if err != nil {
+		return nil, nil, errors.New("couldn't encode AtomicSwap: " + err.Error())
+	}
+	sc = append(sc, byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID,
+		ContractAtomicSwapID, asBuf, darcID))
+	return
+}
+
+// lockCoins takes amount coins of the given type out of coins. It returns the
+// remaining coins and false if there are not enough coins of that type.
+func lockCoins(coins []byzcoin.Coin, name byzcoin.InstanceID, amount uint64) ([]byzcoin.Coin, bool) {
+	available := byzcoin.Coin{Name: name}
+	var rest []byzcoin.Coin
+	for _, co := range coins {
+		if name.Equal(co.Name) {
+			if available.SafeAdd(co.Value) != nil {
+				return coins, false
+			}
+		} else {
+			rest = append(rest, co)
+		}
+	}
+	if available.SafeSub(amount) != nil {
+		return coins, false
+	}
+	if available.Value > 0 {
+		rest = append(rest, available)
+	}
+	return rest, true
+}
@@ -0,0 +1,140 @@
+package contracts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/protobuf"
+)
+
+// Party A swaps 30 gold coins for 20 silver coins of party B.
+func TestAtomicSwap_Swap(t *testing.T) {
+	sl := newSwapLedger(t)
+	defer sl.local.CloseAll()
+
+	swapID, err := SwapSpawn(sl.cl, sl.darcID, sl.swap(30, 20, 20), sl.signer)
+	require.Nil(t, err)
+
+	_, err = SwapConfirm(sl.cl, swapID, sl.coinA, 30, sl.signer)
+	require.Nil(t, err)
+	as := sl.getSwap(swapID)
+	require.True(t, as.ConfirmedA)
+	require.False(t, as.ConfirmedB)
+	require.Equal(t, uint64(70), getTestCoin(t, sl.cl, sl.coinA).Value)
+	// Party A cannot confirm twice.
+	_, err = SwapConfirm(sl.cl, swapID, sl.coinA, 30, sl.signer)
+	require.NotNil(t, err)
+	// Too few coins for party B.
+	_, err = SwapConfirm(sl.cl, swapID, sl.coinB, 10, sl.signer)
+	require.NotNil(t, err)
+
+	_, err = SwapConfirm(sl.cl, swapID, sl.coinB, 20, sl.signer)
+	require.Nil(t, err)
+	require.Equal(t, SwapExecuted, sl.getSwap(swapID).State)
+	require.Equal(t, uint64(70), getTestCoin(t, sl.cl, sl.coinA).Value)
+	require.Equal(t, uint64(30), getTestCoin(t, sl.cl, sl.coinB).Value)
+	require.Equal(t, uint64(20), getTestCoin(t, sl.cl, sl.partyA).Value)
+	require.Equal(t, uint64(30), getTestCoin(t, sl.cl, sl.partyB).Value)
+
+	_, err = SwapRefund(sl.cl, swapID, sl.signer)
+	require.NotNil(t, err)
+}
+
+// Only party A confirms and gets its coins back after the timeout.
+func TestAtomicSwap_Timeout(t *testing.T) {
+	sl := newSwapLedger(t)
+	defer sl.local.CloseAll()
+
+	swapID, err := SwapSpawn(sl.cl, sl.darcID, sl.swap(30, 20, 3), sl.signer)
+	require.Nil(t, err)
+	timeout := sl.getSwap(swapID).Timeout
+
+	_, err = SwapConfirm(sl.cl, swapID, sl.coinA, 30, sl.signer)
+	require.Nil(t, err)
+	require.Equal(t, uint64(70), getTestCoin(t, sl.cl, sl.coinA).Value)
+	_, err = SwapRefund(sl.cl, swapID, sl.signer)
+	require.NotNil(t, err)
+
+	for uint64(latestIndex(t, sl.cl)) < timeout {
+		mintTestCoin(t, sl.cl, sl.signer, sl.coinA, 0)
+	}
+	_, err = SwapConfirm(sl.cl, swapID, sl.coinB, 20, sl.signer)
+	require.NotNil(t, err)
+	_, err = SwapRefund(sl.cl, swapID, sl.signer)
+	require.Nil(t, err)
+	require.Equal(t, SwapRefunded, sl.getSwap(swapID).State)
+	require.Equal(t, uint64(100), getTestCoin(t, sl.cl, sl.coinA).Value)
+	require.Equal(t, uint64(50), getTestCoin(t, sl.cl, sl.coinB).Value)
+	require.Equal(t, uint64(0), getTestCoin(t, sl.cl, sl.partyA).Value)
+	require.Equal(t, uint64(0), getTestCoin(t, sl.cl, sl.partyB).Value)
+}
+
+type swapLedger struct {
+	t      *testing.T
+	local  *onet.LocalTest
+	cl     *byzcoin.Client
+	signer darc.Signer
+	darcID darc.ID
+	// coinA holds 100 gold coins and coinB 50 silver coins.
+	coinA, coinB   byzcoin.InstanceID
+	partyA, partyB byzcoin.InstanceID
+}
+
+func newSwapLedger(t *testing.T) *swapLedger {
+	sl := &swapLedger{
+		t:      t,
+		local:  onet.NewTCPTest(cothority.Suite),
+		signer: darc.NewSignerEd25519(nil, nil),
+	}
+	_, roster, _ := sl.local.GenTree(3, true)
+
+	msg, err := byzcoin.DefaultGenesisMsg(byzcoin.CurrentVersion, roster,
+		[]string{"spawn:" + ContractCoinID, "invoke:" + ContractCoinID + ".mint",
+			"invoke:" + ContractCoinID + ".fetch", "spawn:" + ContractAtomicSwapID,
+			"invoke:" + ContractAtomicSwapID + ".confirm",
+			"invoke:" + ContractAtomicSwapID + ".refund"}, sl.signer.Identity())
+	require.Nil(t, err)
+	msg.BlockInterval = 200 * time.Millisecond
+	sl.cl, _, err = byzcoin.NewLedger(msg, false)
+	require.Nil(t, err)
+	sl.darcID = msg.GenesisDarc.GetBaseID()
+
+	gold, silver := iid("gold"), iid("silver")
+	sl.coinA = spawnTestCoinType(t, sl.cl, sl.signer, sl.darcID, gold)
+	sl.coinB = spawnTestCoinType(t, sl.cl, sl.signer, sl.darcID, silver)
+	sl.partyA = spawnTestCoinType(t, sl.cl, sl.signer, sl.darcID, silver)
+	sl.partyB = spawnTestCoinType(t, sl.cl, sl.signer, sl.darcID, gold)
+	mintTestCoin(t, sl.cl, sl.signer, sl.coinA, 100)
+	mintTestCoin(t, sl.cl, sl.signer, sl.coinB, 50)
+	return sl
+}
+
+// swap returns the terms of a swap that times out after the given number of
+// blocks.
+func (sl *swapLedger) swap(amountA, amountB uint64, blocks int) AtomicSwap {
+	return AtomicSwap{
+		PartyA:  sl.partyA,
+		PartyB:  sl.partyB,
+		CoinA:   sl.coinA,
+		CoinB:   sl.coinB,
+		AmountA: amountA,
+		AmountB: amountB,
+		Timeout: uint64(latestIndex(sl.t, sl.cl) + blocks),
+	}
+}
+
+func (sl *swapLedger) getSwap(swapID byzcoin.InstanceID) AtomicSwap {
+	reply, err := sl.cl.GetProof(swapID.Slice())
+	require.Nil(sl.t, err)
+	_, buf, cid, _, err := reply.Proof.KeyValue()
+	require.Nil(sl.t, err)
+	require.Equal(sl.t, ContractAtomicSwapID, cid)
+	var as AtomicSwap
+	require.Nil(sl.t, protobuf.Decode(buf, &as))
+	return as
+}
@@ -0,0 +1,225 @@
+package contracts
+
+import (
+	"errors"
+	"fmt"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+)
+
+// ContractCoinEscrowID denotes a contract that holds coins of a buyer until
+// both parties agree to release them to the seller, or an arbitrator resolves
+// a dispute.
+var ContractCoinEscrowID = "coinEscrow"
+
+// The states of a CoinEscrow.
+const (
+	// EscrowPending holds the coins until they are released or disputed.
+	EscrowPending = iota + 1
+	// EscrowReleased means the coins have been sent to the seller.
+	EscrowReleased
+	// EscrowDisputed means one of the parties disputed the escrow and the
+	// arbitrator has to decide.
+	EscrowDisputed
+	// EscrowRefunded means the coins have been sent back to the buyer.
+	EscrowRefunded
+)
+
+// ContractCoinEscrow locks the coins of a buyer. The following methods are
+// available:
+//  - spawn takes the parties from the argument "escrow", which must be a
+//    protobuf encoded CoinEscrow, and locks all the coins given to the
+//    instruction that are of the same type as the coins of the buyer and the
+//    seller. It creates a new darc that guards the escrow instance.
+//  - release sends the coins to the seller. It must be signed by the buyer
+//    and the seller.
+//  - dispute can be called by the buyer or the seller and hands the decision
+//    over to the arbitrator.
+//  - arbitrate must be signed by the arbitrator and takes the argument
+//    "outcome", which is either "release" or "refund".
+
+func contractCoinEscrowFromBytes(in []byte) (byzcoin.Contract, error) {
+	c := &contractCoinEscrow{}
+	err := protobuf.Decode(in, &c.CoinEscrow)
+	if err != nil {
+		return nil, errors.New("couldn't unmarshal instance data: " + err.Error())
+	}
+	return c, nil
+}
+
+type contractCoinEscrow struct {
+	byzcoin.BasicContract
+	CoinEscrow
+}
+
+func (c *contractCoinEscrow) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	escrowBuf := inst.Spawn.Args.Search("escrow")
+	if escrowBuf == nil {
+		return nil, nil, errors.New("argument \"escrow\" is missing")
+	}
+	err = protobuf.Decode(escrowBuf, &c.CoinEscrow)
+	if err != nil {
+		return nil, nil, errors.New("couldn't unmarshal escrow: " + err.Error())
+	}
+	for _, id := range []darc.ID{c.Buyer, c.Seller, c.Arbitrator} {
+		var cid string
+		_, _, cid, _, err = rst.GetValues(id)
+		if err == nil && cid != byzcoin.ContractDarcID {
+			err = fmt.Errorf("instance %x is not a darc", id)
+		}
+		if err != nil {
+			return
+		}
+	}
+	buyerCoin, _, err := loadCoin(rst, c.BuyerCoin)
+	if err != nil {
+		return
+	}
+	sellerCoin, _, err := loadCoin(rst, c.SellerCoin)
+	if err != nil {
+		return
+	}
+	if !buyerCoin.Name.Equal(sellerCoin.Name) {
+		return nil, nil, errors.New("buyer and seller coins are not of the same type")
+	}
+
+	// Lock all the coins of the type of the buyer and the seller.
+	locked := byzcoin.Coin{Name: sellerCoin.Name}
+	cout = []byzcoin.Coin{}
+	for _, co := range coins {
+		if locked.Name.Equal(co.Name) {
+			err = locked.SafeAdd(co.Value)
+			if err != nil {
+				return
+			}
+		} else {
+			cout = append(cout, co)
+		}
+	}
+	if locked.Value == 0 {
+		return nil, nil, errors.New("no coins to lock")
+	}
+	c.Amount = locked.Value
+	c.State = EscrowPending
+
+	escrowID := inst.DeriveID("")
+	d, darcSC, err := c.createDarc(escrowID, darcID)
+	if err != nil {
+		return
+	}
+	var ceBuf []byte
+	ceBuf, err = protobuf.Encode(&c.CoinEscrow)
+	if err != nil {
+		return nil, nil, errors.New("couldn't encode CoinEscrow: " + err.Error())
+	}
+	log.Lvlf2("Locking %d coins in escrow %x", c.Amount, escrowID.Slice())
+	sc = []byzcoin.StateChange{
+		darcSC,
+		byzcoin.NewStateChange(byzcoin.Create, escrowID,
+			ContractCoinEscrowID, ceBuf, d.GetBaseID()),
+	}
+	return
+}
+
+func (c *contractCoinEscrow) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	var target byzcoin.InstanceID
+	switch inst.Invoke.Command {
+	case "release":
+		if c.State != EscrowPending {
+			return nil, nil, fmt.Errorf("can only release a pending escrow, but current state is %d", c.State)
+		}
+		target = c.SellerCoin
+		c.State = EscrowReleased
+	case "dispute":
+		if c.State != EscrowPending {
+			return nil, nil, fmt.Errorf("can only dispute a pending escrow, but current state is %d", c.State)
+		}
+		c.State = EscrowDisputed
+	case "arbitrate":
+		if c.State != EscrowDisputed {
+			return nil, nil, fmt.Errorf("can only arbitrate a disputed escrow, but current state is %d", c.State)
+		}
+		switch outcome := string(inst.Invoke.Args.Search("outcome")); outcome {
+		case "release":
+			target = c.SellerCoin
+			c.State = EscrowReleased
+		case "refund":
+			target = c.BuyerCoin
+			c.State = EscrowRefunded
+		default:
+			return nil, nil, fmt.Errorf("unknown outcome \"%s\"", outcome)
+		}
+	default:
+		return nil, nil, errors.New("coin escrow contract can only release, dispute and arbitrate")
+	}
+
+	if target != (byzcoin.InstanceID{}) {
+		var paySC byzcoin.StateChange
+		paySC, err = payCoin(rst, target, c.Amount)
+		if err != nil {
+			return
+		}
+		log.Lvlf2("sending %d coins of escrow to %x", c.Amount, target.Slice())
+		sc = append(sc, paySC)
+	}
+	var ceBuf []byte
+	ceBuf, err = protobuf.Encode(&c.CoinEscrow)
+	if err != nil {
+		return nil, nil, errors.New("couldn't encode CoinEscrow: " + err.Error())
+	}
+	sc = append(sc, byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID,
+		ContractCoinEscrowID, ceBuf, darcID))
+	return sc, cout, nil
+}
+
+// createDarc returns the darc guarding the escrow and the state change that
+// creates it. The buyer and the seller together can evolve it.
+func (c CoinEscrow) createDarc(escrowID byzcoin.InstanceID, darcID darc.ID) (d *darc.Darc, sc byzcoin.StateChange, err error) {
+	buyer := darc.NewIdentityDarc(c.Buyer)
+	seller := darc.NewIdentityDarc(c.Seller)
+	arbitrator := darc.NewIdentityDarc(c.Arbitrator)
+	rules := darc.InitRules([]darc.Identity{buyer, seller}, []darc.Identity{buyer, seller})
+	for _, r := range []struct {
+		cmd  string
+		expr expression.Expr
+	}{
+		{"release", expression.InitAndExpr(buyer.String(), seller.String())},
+		{"dispute", expression.InitOrExpr(buyer.String(), seller.String())},
+		{"arbitrate", expression.Expr(arbitrator.String())},
+	} {
+		err = rules.AddRule(darc.Action("invoke:"+ContractCoinEscrowID+"."+r.cmd), r.expr)
+		if err != nil {
+			return
+		}
+	}
+	// The instance ID makes the darc of every escrow unique.
+	d = darc.NewDarc(rules, append([]byte("Escrow darc for "), escrowID.Slice()...))
+	darcBuf, err := d.ToProto()
+	if err != nil {
+		err = errors.New("couldn't marshal darc: " + err.Error())
+		return
+	}
+	sc = byzcoin.NewStateChange(byzcoin.Create, byzcoin.NewInstanceID(d.GetBaseID()),
+		byzcoin.ContractDarcID, darcBuf, darcID)
+	return
+}
@@ -0,0 +1,113 @@
+package contracts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/protobuf"
+)
+
+// Locks coins of the buyer and releases them to the seller once both agree.
+// A second escrow is disputed and refunded by the arbitrator.
+func TestCoinEscrow(t *testing.T) {
+	local := onet.NewTCPTest(cothority.Suite)
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(3, true)
+
+	buyer := darc.NewSignerEd25519(nil, nil)
+	seller := darc.NewSignerEd25519(nil, nil)
+	arbitrator := darc.NewSignerEd25519(nil, nil)
+	msg, err := byzcoin.DefaultGenesisMsg(byzcoin.CurrentVersion, roster,
+		[]string{"spawn:" + ContractCoinID, "invoke:" + ContractCoinID + ".mint",
+			"invoke:" + ContractCoinID + ".fetch", "spawn:" + ContractCoinEscrowID},
+		buyer.Identity())
+	require.Nil(t, err)
+	msg.BlockInterval = 200 * time.Millisecond
+	cl, _, err := byzcoin.NewLedger(msg, false)
+	require.Nil(t, err)
+	darcID := msg.GenesisDarc.GetBaseID()
+
+	escrow := CoinEscrow{
+		Buyer:      spawnTestDarc(t, cl, buyer, darcID, buyer),
+		Seller:     spawnTestDarc(t, cl, buyer, darcID, seller),
+		Arbitrator: spawnTestDarc(t, cl, buyer, darcID, arbitrator),
+		BuyerCoin:  spawnTestCoin(t, cl, buyer, darcID),
+		SellerCoin: spawnTestCoin(t, cl, buyer, darcID),
+	}
+	mintTestCoin(t, cl, buyer, escrow.BuyerCoin, 1000)
+
+	// Happy path: buyer and seller release the coins.
+	escrowID, err := EscrowSpawn(cl, darcID, escrow.BuyerCoin, 100, escrow, buyer)
+	require.Nil(t, err)
+	require.Equal(t, uint64(900), getTestCoin(t, cl, escrow.BuyerCoin).Value)
+	ce := getTestEscrow(t, cl, escrowID)
+	require.Equal(t, uint64(100), ce.Amount)
+	require.Equal(t, EscrowPending, ce.State)
+
+	_, err = EscrowRelease(cl, escrowID, buyer, arbitrator)
+	require.NotNil(t, err)
+	_, err = EscrowRelease(cl, escrowID, buyer, seller)
+	require.Nil(t, err)
+	require.Equal(t, uint64(100), getTestCoin(t, cl, escrow.SellerCoin).Value)
+	require.Equal(t, EscrowReleased, getTestEscrow(t, cl, escrowID).State)
+	_, err = EscrowDispute(cl, escrowID, buyer)
+	require.NotNil(t, err)
+
+	// Dispute path: the seller disputes and the arbitrator refunds the buyer.
+	escrowID, err = EscrowSpawn(cl, darcID, escrow.BuyerCoin, 200, escrow, buyer)
+	require.Nil(t, err)
+	require.Equal(t, uint64(700), getTestCoin(t, cl, escrow.BuyerCoin).Value)
+
+	_, err = EscrowArbitrate(cl, escrowID, false, arbitrator)
+	require.NotNil(t, err)
+	_, err = EscrowDispute(cl, escrowID, seller)
+	require.Nil(t, err)
+	require.Equal(t, EscrowDisputed, getTestEscrow(t, cl, escrowID).State)
+	_, err = EscrowRelease(cl, escrowID, buyer, seller)
+	require.NotNil(t, err)
+	_, err = EscrowArbitrate(cl, escrowID, true, seller)
+	require.NotNil(t, err)
+	_, err = EscrowArbitrate(cl, escrowID, false, arbitrator)
+	require.Nil(t, err)
+	require.Equal(t, EscrowRefunded, getTestEscrow(t, cl, escrowID).State)
+	require.Equal(t, uint64(900), getTestCoin(t, cl, escrow.BuyerCoin).Value)
+	require.Equal(t, uint64(100), getTestCoin(t, cl, escrow.SellerCoin).Value)
+}
+
+// spawnTestDarc spawns a darc that the owner can sign for.
+func spawnTestDarc(t *testing.T, cl *byzcoin.Client, signer darc.Signer, darcID darc.ID, owner darc.Signer) darc.ID {
+	d := darc.NewDarc(darc.InitRules([]darc.Identity{owner.Identity()},
+		[]darc.Identity{owner.Identity()}), []byte("test darc"))
+	darcBuf, err := d.ToProto()
+	require.Nil(t, err)
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: byzcoin.NewInstanceID(darcID),
+			Spawn: &byzcoin.Spawn{
+				ContractID: byzcoin.ContractDarcID,
+				Args:       byzcoin.Arguments{{Name: "darc", Value: darcBuf}},
+			},
+			SignerCounter: []uint64{nextCounter(t, cl, signer)},
+		}},
+	}
+	require.Nil(t, ctx.FillSignersAndSignWith(signer))
+	_, err = cl.AddTransactionAndWait(ctx, 10)
+	require.Nil(t, err)
+	return d.GetBaseID()
+}
+
+func getTestEscrow(t *testing.T, cl *byzcoin.Client, escrowID byzcoin.InstanceID) CoinEscrow {
+	reply, err := cl.GetProof(escrowID.Slice())
+	require.Nil(t, err)
+	_, buf, cid, _, err := reply.Proof.KeyValue()
+	require.Nil(t, err)
+	require.Equal(t, ContractCoinEscrowID, cid)
+	var ce CoinEscrow
+	require.Nil(t, protobuf.Decode(buf, &ce))
+	return ce
+}
@@ -0,0 +1,197 @@
+package contracts
+
+import (
+	"errors"
+	"fmt"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+)
+
+// ContractCoinStreamID denotes a contract that releases locked coins to a
+// coin instance at a fixed rate, for example for a vesting schedule.
+var ContractCoinStreamID = "coinStream"
+
+// ContractCoinStream locks coins and releases AmountPerRelease of them to the
+// Recipient every BlocksPerRelease blocks, starting at StartBlock.
+// The following methods are available:
+//  - spawn takes the schedule from the argument "stream", which must be a
+//    protobuf encoded CoinStream, and locks all the coins given to the
+//    instruction. The coins must be of the same type as the recipient.
+//  - collect sends the coins of all the periods that elapsed since the last
+//    collect to the recipient.
+// The index of the block of an instruction is the index of the latest block
+// plus one.
+
+func contractCoinStreamFromBytes(in []byte) (byzcoin.Contract, error) {
+	c := &contractCoinStream{}
+	err := protobuf.Decode(in, &c.CoinStream)
+	if err != nil {
+		return nil, errors.New("couldn't unmarshal instance data: " + err.Error())
+	}
+	return c, nil
+}
+
+type contractCoinStream struct {
+	byzcoin.BasicContract
+	CoinStream
+}
+
+func (c *contractCoinStream) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	streamBuf := inst.Spawn.Args.Search("stream")
+	if streamBuf == nil {
+		return nil, nil, errors.New("argument \"stream\" is missing")
+	}
+	err = protobuf.Decode(streamBuf, &c.CoinStream)
+	if err != nil {
+		return nil, nil, errors.New("couldn't unmarshal stream: " + err.Error())
+	}
+	if c.AmountPerRelease == 0 || c.BlocksPerRelease == 0 {
+		return nil, nil, errors.New("need a positive amount and number of blocks per release")
+	}
+	recipient, _, err := loadCoin(rst, c.Recipient)
+	if err != nil {
+		return
+	}
+
+	// Lock all the coins of the type of the recipient.
+	c.Collected = 0
+	c.Locked = byzcoin.Coin{Name: recipient.Name}
+	cout = []byzcoin.Coin{}
+	for _, co := range coins {
+		if c.Locked.Name.Equal(co.Name) {
+			err = c.Locked.SafeAdd(co.Value)
+			if err != nil {
+				return
+			}
+		} else {
+			cout = append(cout, co)
+		}
+	}
+	if c.Locked.Value == 0 {
+		return nil, nil, errors.New("no coins to lock")
+	}
+
+	var csBuf []byte
+	csBuf, err = protobuf.Encode(&c.CoinStream)
+	if err != nil {
+		return nil, nil, errors.New("couldn't encode CoinStream: " + err.Error())
+	}
+	log.Lvlf2("Locking %d coins in stream %x", c.Locked.Value, inst.DeriveID("").Slice())
+	sc = []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Create, inst.DeriveID(""),
+			ContractCoinStreamID, csBuf, darcID),
+	}
+	return
+}
+
+func (c *contractCoinStream) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	switch inst.Invoke.Command {
+	case "collect":
+		periods := c.periods(uint64(rst.GetIndex() + 1))
+		amount := c.releases(periods)
+		if amount == 0 {
+			return nil, nil, errors.New("no coins to collect")
+		}
+		c.Collected = periods
+		err = c.Locked.SafeSub(amount)
+		if err != nil {
+			return
+		}
+
+		var paySC byzcoin.StateChange
+		paySC, err = payCoin(rst, c.Recipient, amount)
+		if err != nil {
+			return
+		}
+		var csBuf []byte
+		csBuf, err = protobuf.Encode(&c.CoinStream)
+		if err != nil {
+			return nil, nil, errors.New("couldn't encode CoinStream: " + err.Error())
+		}
+		log.Lvlf2("releasing %d coins to %x", amount, c.Recipient.Slice())
+		sc = []byzcoin.StateChange{
+			paySC,
+			byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID, ContractCoinStreamID,
+				csBuf, darcID),
+		}
+		return
+	default:
+		return nil, nil, errors.New("coin stream contract can only collect")
+	}
+}
+
+// periods returns the number of periods that elapsed at the given block.
+func (c CoinStream) periods(block uint64) uint64 {
+	if block < c.StartBlock {
+		return 0
+	}
+	return (block - c.StartBlock) / c.BlocksPerRelease
+}
+
+// releases returns how many coins are released by the periods that elapsed
+// since the last collect. It is at most the number of locked coins.
+func (c CoinStream) releases(periods uint64) uint64 {
+	if periods <= c.Collected {
+		return 0
+	}
+	if periods-c.Collected > c.Locked.Value/c.AmountPerRelease {
+		return c.Locked.Value
+	}
+	return (periods - c.Collected) * c.AmountPerRelease
+}
+
+// loadCoin returns the coin stored in the instance and its darc.
+func loadCoin(rst byzcoin.ReadOnlyStateTrie, iid byzcoin.InstanceID) (*byzcoin.Coin, darc.ID, error) {
+	v, _, cid, did, err := rst.GetValues(iid.Slice())
+	if err == nil && cid != ContractCoinID {
+		err = fmt.Errorf("instance %x is not a coin contract", iid.Slice())
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var coin byzcoin.Coin
+	err = protobuf.Decode(v, &coin)
+	if err != nil {
+		return nil, nil, errors.New("couldn't unmarshal account: " + err.Error())
+	}
+	return &coin, did, nil
+}
+
+// payCoin returns the state change that adds amount coins to the coin
+// instance.
+func payCoin(rst byzcoin.ReadOnlyStateTrie, iid byzcoin.InstanceID, amount uint64) (sc byzcoin.StateChange, err error) {
+	coin, darcID, err := loadCoin(rst, iid)
+	if err != nil {
+		return
+	}
+	err = coin.SafeAdd(amount)
+	if err != nil {
+		return
+	}
+	coinBuf, err := protobuf.Encode(coin)
+	if err != nil {
+		err = errors.New("couldn't marshal account: " + err.Error())
+		return
+	}
+	sc = byzcoin.NewStateChange(byzcoin.Update, iid, ContractCoinID, coinBuf, darcID)
+	return
+}
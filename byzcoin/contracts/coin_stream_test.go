@@ -0,0 +1,159 @@
+package contracts
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/protobuf"
+)
+
+func TestCoinStream_Releases(t *testing.T) {
+	cs := CoinStream{
+		Locked:           byzcoin.Coin{Value: 100},
+		AmountPerRelease: 7,
+		BlocksPerRelease: 3,
+		StartBlock:       10,
+	}
+	for _, tc := range []struct {
+		block     uint64
+		collected uint64
+		released  uint64
+	}{
+		{5, 0, 0},
+		{10, 0, 0},
+		{12, 0, 0},
+		{13, 0, 7},
+		{15, 0, 7},
+		{16, 0, 14},
+		{16, 1, 7},
+		{16, 2, 0},
+		{100, 20, 70},
+		// at most the locked coins are released
+		{100, 0, 100},
+	} {
+		cs.Collected = tc.collected
+		require.Equal(t, tc.released, cs.releases(cs.periods(tc.block)),
+			"block %d, collected %d", tc.block, tc.collected)
+	}
+}
+
+// Spawns a stream, advances 10 blocks and collects the coins of the 10
+// periods.
+func TestCoinStream_Collect(t *testing.T) {
+	local := onet.NewTCPTest(cothority.Suite)
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(3, true)
+
+	signer := darc.NewSignerEd25519(nil, nil)
+	msg, err := byzcoin.DefaultGenesisMsg(byzcoin.CurrentVersion, roster,
+		[]string{"spawn:" + ContractCoinID, "invoke:" + ContractCoinID + ".mint",
+			"invoke:" + ContractCoinID + ".fetch", "spawn:" + ContractCoinStreamID,
+			"invoke:" + ContractCoinStreamID + ".collect"}, signer.Identity())
+	require.Nil(t, err)
+	msg.BlockInterval = 200 * time.Millisecond
+	cl, _, err := byzcoin.NewLedger(msg, false)
+	require.Nil(t, err)
+	darcID := msg.GenesisDarc.GetBaseID()
+
+	source := spawnTestCoin(t, cl, signer, darcID)
+	mintTestCoin(t, cl, signer, source, 1000)
+	recipient := spawnTestCoin(t, cl, signer, darcID)
+
+	// The stream starts in the block of the spawn instruction.
+	start := latestIndex(t, cl) + 1
+	stream, err := StreamSpawn(cl, darcID, source, 100, CoinStream{
+		Recipient:        recipient,
+		AmountPerRelease: 7,
+		BlocksPerRelease: 1,
+		StartBlock:       uint64(start),
+	}, signer)
+	require.Nil(t, err)
+	require.Equal(t, start, latestIndex(t, cl))
+	require.Equal(t, uint64(900), getTestCoin(t, cl, source).Value)
+
+	for i := 0; i < 9; i++ {
+		mintTestCoin(t, cl, signer, source, 0)
+	}
+	_, err = StreamCollect(cl, stream, signer)
+	require.Nil(t, err)
+	require.Equal(t, start+10, latestIndex(t, cl))
+	require.Equal(t, uint64(10*7), getTestCoin(t, cl, recipient).Value)
+
+	reply, err := cl.GetProof(stream.Slice())
+	require.Nil(t, err)
+	_, buf, cid, _, err := reply.Proof.KeyValue()
+	require.Nil(t, err)
+	require.Equal(t, ContractCoinStreamID, cid)
+	var cs CoinStream
+	require.Nil(t, protobuf.Decode(buf, &cs))
+	require.Equal(t, uint64(100-10*7), cs.Locked.Value)
+	require.Equal(t, uint64(10), cs.Collected)
+}
+
+func spawnTestCoin(t *testing.T, cl *byzcoin.Client, signer darc.Signer, darcID darc.ID) byzcoin.InstanceID {
+	return spawnTestCoinType(t, cl, signer, darcID, CoinName)
+}
+
+func spawnTestCoinType(t *testing.T, cl *byzcoin.Client, signer darc.Signer, darcID darc.ID,
+	name byzcoin.InstanceID) byzcoin.InstanceID {
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: byzcoin.NewInstanceID(darcID),
+			Spawn: &byzcoin.Spawn{
+				ContractID: ContractCoinID,
+				Args:       byzcoin.Arguments{{Name: "type", Value: name.Slice()}},
+			},
+			SignerCounter: []uint64{nextCounter(t, cl, signer)},
+		}},
+	}
+	require.Nil(t, ctx.FillSignersAndSignWith(signer))
+	_, err := cl.AddTransactionAndWait(ctx, 10)
+	require.Nil(t, err)
+	return ctx.Instructions[0].DeriveID("")
+}
+
+func mintTestCoin(t *testing.T, cl *byzcoin.Client, signer darc.Signer, coin byzcoin.InstanceID, coins uint64) {
+	coinsBuf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(coinsBuf, coins)
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: coin,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractCoinID,
+				Command:    "mint",
+				Args:       byzcoin.Arguments{{Name: "coins", Value: coinsBuf}},
+			},
+			SignerCounter: []uint64{nextCounter(t, cl, signer)},
+		}},
+	}
+	require.Nil(t, ctx.FillSignersAndSignWith(signer))
+	_, err := cl.AddTransactionAndWait(ctx, 10)
+	require.Nil(t, err)
+}
+
+func getTestCoin(t *testing.T, cl *byzcoin.Client, coin byzcoin.InstanceID) byzcoin.Coin {
+	reply, err := cl.GetProof(coin.Slice())
+	require.Nil(t, err)
+	var c byzcoin.Coin
+	require.Nil(t, reply.Proof.VerifyAndDecode(cothority.Suite, ContractCoinID, &c))
+	return c
+}
+
+func nextCounter(t *testing.T, cl *byzcoin.Client, signer darc.Signer) uint64 {
+	ctrs, err := cl.GetSignerCounters(signer.Identity().String())
+	require.Nil(t, err)
+	return ctrs.Counters[0] + 1
+}
+
+// latestIndex returns the index of the latest block of the ledger.
+func latestIndex(t *testing.T, cl *byzcoin.Client) int {
+	reply, err := cl.GetProof(byzcoin.ConfigInstanceID.Slice())
+	require.Nil(t, err)
+	return reply.Proof.Latest.Index
+}
@@ -0,0 +1,112 @@
+package contracts
+
+import (
+	"errors"
+	"fmt"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+)
+
+// ContractCredentialRevocationID denotes a contract that holds a list of
+// revoked credentials.
+var ContractCredentialRevocationID = "credentialRevocation"
+
+// ContractCredentialRevocation stores the instance IDs of credentials that
+// are not valid anymore, e.g. because the key of the user got lost. The
+// following methods are available:
+//  - spawn creates an empty revocation list.
+//  - revoke adds the credential in the argument "credential" to the list.
+//    Only the admins in the darc of the list are allowed to revoke.
+//  - isRevoked fails if the credential in the argument "credential" is not
+//    in the list. It does not change the list.
+
+func contractCredentialRevocationFromBytes(in []byte) (byzcoin.Contract, error) {
+	c := &contractCredentialRevocation{}
+	err := protobuf.Decode(in, &c.RevocationList)
+	if err != nil {
+		return nil, errors.New("couldn't unmarshal instance data: " + err.Error())
+	}
+	return c, nil
+}
+
+type contractCredentialRevocation struct {
+	byzcoin.BasicContract
+	RevocationList
+}
+
+func (c *contractCredentialRevocation) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	var rlBuf []byte
+	rlBuf, err = protobuf.Encode(&RevocationList{})
+	if err != nil {
+		return nil, nil, errors.New("couldn't encode RevocationList: " + err.Error())
+	}
+	sc = []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Create, inst.DeriveID(""),
+			ContractCredentialRevocationID, rlBuf, darcID),
+	}
+	return
+}
+
+func (c *contractCredentialRevocation) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	credBuf := inst.Invoke.Args.Search("credential")
+	if len(credBuf) != len(byzcoin.InstanceID{}) {
+		return nil, nil, errors.New("argument \"credential\" needs to be an InstanceID")
+	}
+	cred := byzcoin.NewInstanceID(credBuf)
+
+	switch inst.Invoke.Command {
+	case "revoke":
+		if c.IsRevoked(cred) {
+			return nil, nil, fmt.Errorf("credential %x is already revoked", cred.Slice())
+		}
+		c.Revoked = append(c.Revoked, cred)
+		log.Lvlf2("revoking credential %x", cred.Slice())
+	case "isRevoked":
+		if !c.IsRevoked(cred) {
+			return nil, nil, fmt.Errorf("credential %x is not revoked", cred.Slice())
+		}
+		return
+	default:
+		return nil, nil, errors.New("credential revocation contract can only revoke and isRevoked")
+	}
+
+	var rlBuf []byte
+	rlBuf, err = protobuf.Encode(&c.RevocationList)
+	if err != nil {
+		return nil, nil, errors.New("couldn't encode RevocationList: " + err.Error())
+	}
+	sc = []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID,
+			ContractCredentialRevocationID, rlBuf, darcID),
+	}
+	return
+}
+
+// IsRevoked returns true if the credential is in the list.
+func (rl RevocationList) IsRevoked(cred byzcoin.InstanceID) bool {
+	for _, r := range rl.Revoked {
+		if r.Equal(cred) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package contracts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3"
+)
+
+func TestCredentialRevocation(t *testing.T) {
+	local := onet.NewTCPTest(cothority.Suite)
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(3, true)
+
+	admin := darc.NewSignerEd25519(nil, nil)
+	msg, err := byzcoin.DefaultGenesisMsg(byzcoin.CurrentVersion, roster,
+		[]string{"spawn:" + ContractCredentialRevocationID,
+			"invoke:" + ContractCredentialRevocationID + ".revoke",
+			"invoke:" + ContractCredentialRevocationID + ".isRevoked"}, admin.Identity())
+	require.Nil(t, err)
+	msg.BlockInterval = 200 * time.Millisecond
+	cl, _, err := byzcoin.NewLedger(msg, false)
+	require.Nil(t, err)
+	darcID := msg.GenesisDarc.GetBaseID()
+
+	revList, err := RevocationListSpawn(cl, darcID, admin)
+	require.Nil(t, err)
+	revoked, err := LookupRevocationList(cl, revList)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(revoked))
+
+	cred := iid("credential")
+	_, err = revocationInvoke(cl, revList, "isRevoked", cred, admin)
+	require.NotNil(t, err)
+
+	// Only the admins of the darc can revoke.
+	user := darc.NewSignerEd25519(nil, nil)
+	_, err = RevokeCredential(cl, revList, cred, user)
+	require.NotNil(t, err)
+
+	_, err = RevokeCredential(cl, revList, cred, admin)
+	require.Nil(t, err)
+	_, err = RevokeCredential(cl, revList, cred, admin)
+	require.NotNil(t, err)
+	revoked, err = LookupRevocationList(cl, revList)
+	require.Nil(t, err)
+	require.Equal(t, []byzcoin.InstanceID{cred}, revoked)
+	_, err = revocationInvoke(cl, revList, "isRevoked", cred, admin)
+	require.Nil(t, err)
+}
@@ -0,0 +1,161 @@
+package contracts
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+)
+
+// ContractNameRegistryID denotes a contract that maps human-readable names to
+// instance IDs.
+var ContractNameRegistryID = "nameRegistry"
+
+// NameRegistryInstanceID is the instance ID of the name registry. There is
+// only one registry per skipchain.
+var NameRegistryInstanceID = iid(ContractNameRegistryID)
+
+// ContractNameRegistry stores a mapping from names to instance IDs. The
+// following methods are available:
+//  - spawn creates the empty registry at NameRegistryInstanceID.
+//  - register adds the name in the argument "name", pointing to the instance
+//    ID in the argument "instanceID" and owned by the darc in the argument
+//    "owner". The name must not exist yet.
+//  - lookup fails if the name in the argument "name" does not point to the
+//    instance ID in the argument "instanceID". It does not change the
+//    registry.
+//  - transfer points the existing name in the argument "name" to the instance
+//    ID in the argument "instanceID". The signers of the instruction must
+//    fulfill the sign rule of the owner darc of the name.
+
+func contractNameRegistryFromBytes(in []byte) (byzcoin.Contract, error) {
+	c := &contractNameRegistry{}
+	err := protobuf.Decode(in, &c.NameRegistry)
+	if err != nil {
+		return nil, errors.New("couldn't unmarshal instance data: " + err.Error())
+	}
+	return c, nil
+}
+
+type contractNameRegistry struct {
+	byzcoin.BasicContract
+	NameRegistry
+}
+
+func (c *contractNameRegistry) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	if _, _, _, _, err = rst.GetValues(NameRegistryInstanceID.Slice()); err == nil {
+		return nil, nil, errors.New("the name registry already exists")
+	}
+	var nrBuf []byte
+	nrBuf, err = protobuf.Encode(&NameRegistry{})
+	if err != nil {
+		return nil, nil, errors.New("couldn't encode NameRegistry: " + err.Error())
+	}
+	sc = []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Create, NameRegistryInstanceID,
+			ContractNameRegistryID, nrBuf, darcID),
+	}
+	return
+}
+
+func (c *contractNameRegistry) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	name := string(inst.Invoke.Args.Search("name"))
+	if name == "" {
+		return nil, nil, errors.New("argument \"name\" is missing")
+	}
+	iidBuf := inst.Invoke.Args.Search("instanceID")
+	if len(iidBuf) != len(byzcoin.InstanceID{}) {
+		return nil, nil, errors.New("argument \"instanceID\" needs to be an InstanceID")
+	}
+	target := byzcoin.NewInstanceID(iidBuf)
+
+	i := c.search(name)
+	found := i < len(c.Entries) && c.Entries[i].Name == name
+	switch inst.Invoke.Command {
+	case "register":
+		if found {
+			return nil, nil, fmt.Errorf("name \"%s\" is already registered", name)
+		}
+		owner := darc.ID(inst.Invoke.Args.Search("owner"))
+		if _, err = byzcoin.LoadDarcFromTrie(rst, owner); err != nil {
+			return nil, nil, errors.New("argument \"owner\" needs to be a darc: " + err.Error())
+		}
+		c.Entries = append(c.Entries, NameEntry{})
+		copy(c.Entries[i+1:], c.Entries[i:])
+		c.Entries[i] = NameEntry{Name: name, InstanceID: target, Owner: owner}
+		log.Lvlf2("registering name %s for %x", name, target.Slice())
+	case "lookup":
+		if !found {
+			return nil, nil, fmt.Errorf("name \"%s\" is not registered", name)
+		}
+		if !c.Entries[i].InstanceID.Equal(target) {
+			return nil, nil, fmt.Errorf("name \"%s\" points to another instance", name)
+		}
+		return
+	case "transfer":
+		if !found {
+			return nil, nil, fmt.Errorf("name \"%s\" is not registered", name)
+		}
+		ids := make([]string, len(inst.SignerIdentities))
+		for j, id := range inst.SignerIdentities {
+			ids[j] = id.String()
+		}
+		owner := darc.NewIdentityDarc(c.Entries[i].Owner).String()
+		if darc.EvalExpr(expression.Expr(owner), trieDarcs(rst), ids...) != nil {
+			return nil, nil, fmt.Errorf("only the owner of name \"%s\" can transfer it", name)
+		}
+		c.Entries[i].InstanceID = target
+		log.Lvlf2("transferring name %s to %x", name, target.Slice())
+	default:
+		return nil, nil, errors.New("name registry contract can only register, lookup and transfer")
+	}
+
+	var nrBuf []byte
+	nrBuf, err = protobuf.Encode(&c.NameRegistry)
+	if err != nil {
+		return nil, nil, errors.New("couldn't encode NameRegistry: " + err.Error())
+	}
+	sc = []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID,
+			ContractNameRegistryID, nrBuf, darcID),
+	}
+	return
+}
+
+// search returns the index of the entry with the given name, or the index
+// where it would be inserted.
+func (nr NameRegistry) search(name string) int {
+	return sort.Search(len(nr.Entries), func(i int) bool {
+		return nr.Entries[i].Name >= name
+	})
+}
+
+// Lookup returns the instance ID the name points to.
+func (nr NameRegistry) Lookup(name string) (byzcoin.InstanceID, error) {
+	i := nr.search(name)
+	if i == len(nr.Entries) || nr.Entries[i].Name != name {
+		return byzcoin.InstanceID{}, fmt.Errorf("name \"%s\" is not registered", name)
+	}
+	return nr.Entries[i].InstanceID, nil
+}
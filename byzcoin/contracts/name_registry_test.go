@@ -0,0 +1,96 @@
+package contracts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3"
+)
+
+func TestNameRegistry_Lookup(t *testing.T) {
+	var nr NameRegistry
+	_, err := nr.Lookup("spawner")
+	require.NotNil(t, err)
+
+	nr.Entries = []NameEntry{
+		{Name: "credential", InstanceID: iid("credential")},
+		{Name: "spawner", InstanceID: iid("spawner")},
+	}
+	id, err := nr.Lookup("spawner")
+	require.Nil(t, err)
+	require.Equal(t, iid("spawner"), id)
+	require.Equal(t, 1, nr.search("party"))
+	_, err = nr.Lookup("party")
+	require.NotNil(t, err)
+}
+
+func TestNameRegistry(t *testing.T) {
+	local := onet.NewTCPTest(cothority.Suite)
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(3, true)
+
+	signer := darc.NewSignerEd25519(nil, nil)
+	msg, err := byzcoin.DefaultGenesisMsg(byzcoin.CurrentVersion, roster,
+		[]string{"spawn:" + ContractNameRegistryID,
+			"invoke:" + ContractNameRegistryID + ".register",
+			"invoke:" + ContractNameRegistryID + ".lookup",
+			"invoke:" + ContractNameRegistryID + ".transfer"}, signer.Identity())
+	require.Nil(t, err)
+	msg.BlockInterval = 200 * time.Millisecond
+	cl, _, err := byzcoin.NewLedger(msg, false)
+	require.Nil(t, err)
+	darcID := msg.GenesisDarc.GetBaseID()
+
+	_, err = LookupInstanceID(cl, NameRegistryInstanceID, "genesis")
+	require.NotNil(t, err)
+	registry, err := NameRegistrySpawn(cl, darcID, signer)
+	require.Nil(t, err)
+	require.Equal(t, NameRegistryInstanceID, registry)
+	_, err = NameRegistrySpawn(cl, darcID, signer)
+	require.NotNil(t, err)
+
+	genesis := byzcoin.NewInstanceID(darcID)
+	_, err = NameRegister(cl, registry, "genesis", genesis, registry.Slice(), signer)
+	require.NotNil(t, err)
+	_, err = NameRegister(cl, registry, "genesis", genesis, darcID, signer)
+	require.Nil(t, err)
+	id, err := LookupInstanceID(cl, registry, "genesis")
+	require.Nil(t, err)
+	require.Equal(t, genesis, id)
+	_, err = NameRegister(cl, registry, "genesis", registry, darcID, signer)
+	require.NotNil(t, err)
+	_, err = LookupInstanceID(cl, registry, "config")
+	require.NotNil(t, err)
+
+	// The lookup instruction asserts the mapping on-chain.
+	_, err = nameRegistryInvoke(cl, registry, "lookup", "genesis", genesis, nil, signer)
+	require.Nil(t, err)
+	_, err = nameRegistryInvoke(cl, registry, "lookup", "genesis", registry, nil, signer)
+	require.NotNil(t, err)
+
+	_, err = NameTransfer(cl, registry, "config", byzcoin.ConfigInstanceID, signer)
+	require.NotNil(t, err)
+	_, err = NameTransfer(cl, registry, "genesis", byzcoin.ConfigInstanceID, signer)
+	require.Nil(t, err)
+	id, err = LookupInstanceID(cl, registry, "genesis")
+	require.Nil(t, err)
+	require.Equal(t, byzcoin.ConfigInstanceID, id)
+
+	// Only the signers of the owner darc can transfer a name, even if the
+	// registry lets others invoke transfer.
+	owner := darc.NewSignerEd25519(nil, nil)
+	ownerDarc := spawnTestDarc(t, cl, signer, darcID, owner)
+	_, err = NameRegister(cl, registry, "owned", genesis, ownerDarc, signer)
+	require.Nil(t, err)
+	_, err = NameTransfer(cl, registry, "owned", registry, signer)
+	require.NotNil(t, err)
+	_, err = NameTransfer(cl, registry, "owned", registry, signer, owner)
+	require.Nil(t, err)
+	id, err = LookupInstanceID(cl, registry, "owned")
+	require.Nil(t, err)
+	require.Equal(t, registry, id)
+}
@@ -0,0 +1,117 @@
+package contracts
+
+import (
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+)
+
+// PROTOSTART
+// package contracts;
+// type :byzcoin.InstanceID:bytes
+// type :darc.ID:bytes
+// import "byzcoin.proto";
+//
+// option java_package = "ch.epfl.dedis.lib.proto";
+// option java_outer_classname = "ContractsProto";
+
+// CoinStream holds coins that are released to a recipient coin instance at a
+// fixed rate.
+type CoinStream struct {
+	// Locked holds the coins that are not released yet.
+	Locked byzcoin.Coin
+	// Recipient is the coin instance the released coins are sent to.
+	Recipient byzcoin.InstanceID
+	// AmountPerRelease is the number of coins released in every period.
+	AmountPerRelease uint64
+	// BlocksPerRelease is the length of a period in blocks.
+	BlocksPerRelease uint64
+	// StartBlock is the index of the block where the first period starts.
+	StartBlock uint64
+	// Collected is the number of periods that have been collected.
+	Collected uint64
+}
+
+// CoinEscrow holds coins of a buyer until they are released to the seller or
+// refunded to the buyer.
+type CoinEscrow struct {
+	// Buyer is the darc of the party that pays.
+	Buyer darc.ID
+	// Seller is the darc of the party that gets paid.
+	Seller darc.ID
+	// Arbitrator is the darc that resolves a dispute.
+	Arbitrator darc.ID
+	// BuyerCoin is the coin instance a refund is sent to.
+	BuyerCoin byzcoin.InstanceID
+	// SellerCoin is the coin instance a release is sent to.
+	SellerCoin byzcoin.InstanceID
+	// Amount is the number of coins held in the escrow.
+	Amount uint64
+	// State is one of EscrowPending, EscrowReleased, EscrowDisputed or
+	// EscrowRefunded.
+	State int
+}
+
+// AtomicSwap exchanges AmountA coins of party A for AmountB coins of party B.
+type AtomicSwap struct {
+	// PartyA is the coin instance where party A receives the coins of party
+	// B. It must be of the same type as CoinB.
+	PartyA byzcoin.InstanceID
+	// PartyB is the coin instance where party B receives the coins of party
+	// A. It must be of the same type as CoinA.
+	PartyB byzcoin.InstanceID
+	// CoinA is the coin instance of party A. Its type is the type of the coins
+	// party A locks, and a refund is sent to it.
+	CoinA byzcoin.InstanceID
+	// CoinB is the coin instance of party B. Its type is the type of the coins
+	// party B locks, and a refund is sent to it.
+	CoinB byzcoin.InstanceID
+	// AmountA is the number of coins party A locks.
+	AmountA uint64
+	// AmountB is the number of coins party B locks.
+	AmountB uint64
+	// Timeout is the index of the last block where the parties can confirm.
+	Timeout uint64
+	// ConfirmedA is true once party A locked its coins.
+	ConfirmedA bool
+	// ConfirmedB is true once party B locked its coins.
+	ConfirmedB bool
+	// State is one of SwapPending, SwapExecuted or SwapRefunded.
+	State int
+}
+
+// NameRegistry maps human-readable names to instance IDs.
+type NameRegistry struct {
+	// Entries are the registered names, sorted by name.
+	Entries []NameEntry
+}
+
+// NameEntry is a name registered in a NameRegistry.
+type NameEntry struct {
+	Name       string
+	InstanceID byzcoin.InstanceID
+	// Owner is the darc whose signers can transfer the name.
+	Owner darc.ID
+}
+
+// RevocationList holds the credentials that have been revoked.
+type RevocationList struct {
+	Revoked []byzcoin.InstanceID
+}
+
+// Reputation is the score of an identity, built up from the events that
+// added to it.
+type Reputation struct {
+	// Score is the sum of the scores of all events.
+	Score uint64
+	// History holds all events that added to the score.
+	History []ReputationEvent
+}
+
+// ReputationEvent is an addition to a Reputation.
+type ReputationEvent struct {
+	// Source is the instance that added the score, for example a
+	// pop-party.
+	Source byzcoin.InstanceID
+	// Score is the number added to the reputation.
+	Score uint64
+}
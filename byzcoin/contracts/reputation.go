@@ -0,0 +1,224 @@
+package contracts
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+)
+
+// ContractReputationID denotes a contract that keeps the reputation score of
+// an identity.
+var ContractReputationID = "reputation"
+
+// ErrReputationNotWhitelisted is returned by AddReputationScore if the darc of
+// the reputation does not whitelist the darc of the calling instance.
+var ErrReputationNotWhitelisted = errors.New("instance is not allowed to add to this reputation")
+
+// ContractReputation holds a score and the events that added to it. The
+// following methods are available:
+//  - spawn creates a reputation with a score of 0. If the argument "public"
+//    is given, the instance ID is ReputationInstanceID of it, so that other
+//    contracts can find the reputation of a public key. The argument
+//    "signature" must then hold the signature of ReputationSpawnMessage by
+//    this ed25519 key.
+//  - addScore adds the 64-bit LittleEndian uint in the argument "score". The
+//    optional argument "source" is the InstanceID stored in the event.
+// Other contracts add to a reputation with AddReputationScore, if the
+// expression of the rule ReputationWhitelistAction of the contract in the
+// darc of the reputation accepts the darc of the calling instance.
+
+func contractReputationFromBytes(in []byte) (byzcoin.Contract, error) {
+	c := &contractReputation{}
+	err := protobuf.Decode(in, &c.Reputation)
+	if err != nil {
+		return nil, errors.New("couldn't unmarshal instance data: " + err.Error())
+	}
+	return c, nil
+}
+
+type contractReputation struct {
+	byzcoin.BasicContract
+	Reputation
+}
+
+// ReputationInstanceID returns the instance ID of the reputation spawned for
+// the public key.
+func ReputationInstanceID(pub []byte) byzcoin.InstanceID {
+	h := sha256.New()
+	h.Write([]byte(ContractReputationID))
+	h.Write(pub)
+	return byzcoin.NewInstanceID(h.Sum(nil))
+}
+
+// ReputationSpawnMessage returns the message the owner of a public key signs
+// to spawn its reputation under the darc.
+func ReputationSpawnMessage(darcID darc.ID, pub []byte) []byte {
+	msg := append([]byte(ContractReputationID), darcID...)
+	return append(msg, pub...)
+}
+
+// ReputationWhitelistAction returns the darc action whose expression lists the
+// darcs whose instances of the contract can add to the reputations guarded by
+// the darc. As a contract has no signature, the expression is evaluated with
+// the darc of the calling instance as the only identity.
+func ReputationWhitelistAction(contractID string) darc.Action {
+	return darc.Action("addScore:" + contractID)
+}
+
+// AddReputationScore returns the state change that adds the event to the
+// reputation instance. The darc of the reputation must whitelist sourceDarc,
+// the darc of the calling instance of contractID.
+func AddReputationScore(rst byzcoin.ReadOnlyStateTrie, iid byzcoin.InstanceID, contractID string,
+	sourceDarc darc.ID, event ReputationEvent) (sc byzcoin.StateChange, err error) {
+	v, _, cid, darcID, err := rst.GetValues(iid.Slice())
+	if err == nil && cid != ContractReputationID {
+		err = fmt.Errorf("instance %x is not a reputation contract", iid.Slice())
+	}
+	if err != nil {
+		return
+	}
+	dBuf, _, _, _, err := rst.GetValues(darcID)
+	if err != nil {
+		return
+	}
+	d, err := darc.NewFromProtobuf(dBuf)
+	if err != nil {
+		return
+	}
+	d = darc.CleanExpiredRules(d, int64(rst.GetIndex()+1))
+	expr := d.Rules.Get(ReputationWhitelistAction(contractID))
+	if expr == nil || darc.EvalExprDarc(expr, trieDarcs(rst), true,
+		darc.NewIdentityDarc(sourceDarc).String()) != nil {
+		err = ErrReputationNotWhitelisted
+		return
+	}
+	var r Reputation
+	err = protobuf.Decode(v, &r)
+	if err != nil {
+		err = errors.New("couldn't unmarshal reputation: " + err.Error())
+		return
+	}
+	if err = r.add(event); err != nil {
+		return
+	}
+	rBuf, err := protobuf.Encode(&r)
+	if err != nil {
+		err = errors.New("couldn't encode Reputation: " + err.Error())
+		return
+	}
+	sc = byzcoin.NewStateChange(byzcoin.Update, iid, ContractReputationID, rBuf, darcID)
+	return
+}
+
+func (c *contractReputation) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	rid := inst.DeriveID("")
+	if pub := inst.Spawn.Args.Search("public"); pub != nil {
+		point := cothority.Suite.Point()
+		if err = point.UnmarshalBinary(pub); err != nil {
+			return nil, nil, errors.New("argument \"public\" is not an ed25519 key: " + err.Error())
+		}
+		err = darc.NewIdentityEd25519(point).Verify(ReputationSpawnMessage(darcID, pub),
+			inst.Spawn.Args.Search("signature"))
+		if err != nil {
+			return nil, nil, errors.New("argument \"signature\" is not a signature by \"public\": " + err.Error())
+		}
+		rid = ReputationInstanceID(pub)
+	}
+	var rBuf []byte
+	rBuf, err = protobuf.Encode(&Reputation{})
+	if err != nil {
+		return nil, nil, errors.New("couldn't encode Reputation: " + err.Error())
+	}
+	log.Lvlf2("Spawning reputation to %x", rid.Slice())
+	sc = []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Create, rid, ContractReputationID, rBuf, darcID),
+	}
+	return
+}
+
+func (c *contractReputation) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	switch inst.Invoke.Command {
+	case "addScore":
+		scoreBuf := inst.Invoke.Args.Search("score")
+		if len(scoreBuf) != 8 {
+			return nil, nil, errors.New("argument \"score\" needs to be 8 bytes")
+		}
+		event := ReputationEvent{Score: binary.LittleEndian.Uint64(scoreBuf)}
+		if source := inst.Invoke.Args.Search("source"); source != nil {
+			if len(source) != len(byzcoin.InstanceID{}) {
+				return nil, nil, errors.New("argument \"source\" needs to be an InstanceID")
+			}
+			event.Source = byzcoin.NewInstanceID(source)
+		}
+		if err = c.add(event); err != nil {
+			return
+		}
+	default:
+		return nil, nil, errors.New("reputation contract can only addScore")
+	}
+
+	var rBuf []byte
+	rBuf, err = protobuf.Encode(&c.Reputation)
+	if err != nil {
+		return nil, nil, errors.New("couldn't encode Reputation: " + err.Error())
+	}
+	sc = []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID, ContractReputationID, rBuf, darcID),
+	}
+	return
+}
+
+// add appends the event to the history and adds its score.
+func (r *Reputation) add(event ReputationEvent) error {
+	if event.Score > math.MaxUint64-r.Score {
+		return errors.New("uint64 overflow")
+	}
+	r.Score += event.Score
+	r.History = append(r.History, event)
+	return nil
+}
+
+// trieDarcs returns a darc.GetDarc that loads the darcs from the trie, to
+// follow delegations in the whitelist.
+func trieDarcs(rst byzcoin.ReadOnlyStateTrie) darc.GetDarc {
+	return func(id string, latest bool) *darc.Darc {
+		if !strings.HasPrefix(id, "darc:") {
+			return nil
+		}
+		key, err := hex.DecodeString(id[len("darc:"):])
+		if err != nil {
+			return nil
+		}
+		d, err := byzcoin.LoadDarcFromTrie(rst, key)
+		if err != nil {
+			return nil
+		}
+		return darc.CleanExpiredRules(d, int64(rst.GetIndex()+1))
+	}
+}
@@ -0,0 +1,110 @@
+package contracts
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
+	"go.dedis.ch/protobuf"
+)
+
+func TestReputation_AddScore(t *testing.T) {
+	ct := newCT()
+	owner := []darc.Identity{gsigner.Identity()}
+	party := darc.NewDarc(darc.InitRules(owner, owner), []byte("party"))
+	other := darc.NewDarc(darc.InitRules(owner, owner), []byte("other party"))
+	repDarc := ct.storeReputationDarc(t, party.GetBaseID())
+	rid := ReputationInstanceID([]byte("public"))
+	rBuf, err := protobuf.Encode(&Reputation{})
+	require.Nil(t, err)
+	ct.Store(rid, rBuf, ContractReputationID, repDarc)
+
+	score := make([]byte, 8)
+	binary.LittleEndian.PutUint64(score, 3)
+	inst := byzcoin.Instruction{
+		InstanceID: rid,
+		Invoke: &byzcoin.Invoke{
+			ContractID: ContractReputationID,
+			Command:    "addScore",
+			Args:       byzcoin.Arguments{{Name: "score", Value: score}},
+		},
+	}
+	c, err := contractReputationFromBytes(rBuf)
+	require.Nil(t, err)
+	sc, _, err := c.Invoke(ct, inst, nil)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(sc))
+	ct.Store(rid, sc[0].Value, ContractReputationID, repDarc)
+
+	// Only instances of whitelisted darcs can add to the reputation.
+	partyIID := byzcoin.NewInstanceID([]byte("party"))
+	event := ReputationEvent{Source: partyIID, Score: 10}
+	_, err = AddReputationScore(ct, rid, "otherContract", party.GetBaseID(), event)
+	require.Equal(t, ErrReputationNotWhitelisted, err)
+	_, err = AddReputationScore(ct, rid, "popParty", other.GetBaseID(), event)
+	require.Equal(t, ErrReputationNotWhitelisted, err)
+	scAdd, err := AddReputationScore(ct, rid, "popParty", party.GetBaseID(), event)
+	require.Nil(t, err)
+
+	var r Reputation
+	require.Nil(t, protobuf.Decode(scAdd.Value, &r))
+	require.Equal(t, uint64(13), r.Score)
+	require.Equal(t, []ReputationEvent{{Score: 3}, event}, r.History)
+}
+
+// Only the owner of a public key can spawn the reputation of that key.
+func TestReputation_SpawnPublic(t *testing.T) {
+	ct := newCT()
+	repDarc := ct.storeReputationDarc(t, gdarc.GetBaseID())
+	otherDarc := gdarc.GetBaseID()
+	owner := darc.NewSignerEd25519(nil, nil)
+	pub, err := owner.Ed25519.Point.MarshalBinary()
+	require.Nil(t, err)
+
+	spawn := func(sig []byte) ([]byzcoin.StateChange, error) {
+		sc, _, err := (&contractReputation{}).Spawn(ct, byzcoin.Instruction{
+			InstanceID: byzcoin.NewInstanceID(repDarc),
+			Spawn: &byzcoin.Spawn{
+				ContractID: ContractReputationID,
+				Args: byzcoin.Arguments{{Name: "public", Value: pub},
+					{Name: "signature", Value: sig}},
+			},
+		}, nil)
+		return sc, err
+	}
+	_, err = spawn(nil)
+	require.NotNil(t, err)
+	sig, err := darc.NewSignerEd25519(nil, nil).Sign(ReputationSpawnMessage(repDarc, pub))
+	require.Nil(t, err)
+	_, err = spawn(sig)
+	require.NotNil(t, err)
+	sig, err = owner.Sign(ReputationSpawnMessage(otherDarc, pub))
+	require.Nil(t, err)
+	_, err = spawn(sig)
+	require.NotNil(t, err)
+
+	sig, err = owner.Sign(ReputationSpawnMessage(repDarc, pub))
+	require.Nil(t, err)
+	sc, err := spawn(sig)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(sc))
+	require.Equal(t, ReputationInstanceID(pub).Slice(), sc[0].InstanceID)
+	require.Equal(t, repDarc, sc[0].DarcID)
+}
+
+// storeReputationDarc stores a darc owned by gsigner that lets the instances
+// of the pop-parties guarded by partyDarc add to its reputations.
+func (ct *cvTest) storeReputationDarc(t *testing.T, partyDarc darc.ID) darc.ID {
+	owner := []darc.Identity{gsigner.Identity()}
+	rules := darc.InitRules(owner, owner)
+	require.Nil(t, rules.AddRule(ReputationWhitelistAction("popParty"),
+		expression.Expr(darc.NewIdentityDarc(partyDarc).String())))
+	d := darc.NewDarc(rules, []byte("reputation"))
+	dBuf, err := d.ToProto()
+	require.Nil(t, err)
+	ct.Store(byzcoin.NewInstanceID(d.GetBaseID()), dBuf, byzcoin.ContractDarcID, d.GetBaseID())
+	return d.GetBaseID()
+}
@@ -33,6 +33,12 @@ func newService(c *onet.Context) (onet.Service, error) {
 	}
 	byzcoin.RegisterContract(c, ContractValueID, contractValueFromBytes)
 	byzcoin.RegisterContract(c, ContractCoinID, contractCoinFromBytes)
+	byzcoin.RegisterContract(c, ContractCoinStreamID, contractCoinStreamFromBytes)
+	byzcoin.RegisterContract(c, ContractCoinEscrowID, contractCoinEscrowFromBytes)
+	byzcoin.RegisterContract(c, ContractAtomicSwapID, contractAtomicSwapFromBytes)
+	byzcoin.RegisterContract(c, ContractNameRegistryID, contractNameRegistryFromBytes)
+	byzcoin.RegisterContract(c, ContractCredentialRevocationID, contractCredentialRevocationFromBytes)
+	byzcoin.RegisterContract(c, ContractReputationID, contractReputationFromBytes)
 	byzcoin.RegisterContract(c, ContractInsecureDarcID, s.contractInsecureDarcFromBytes)
 	return s, nil
 }
@@ -96,6 +96,27 @@ type AddTxResponse struct {
 	Version Version
 }
 
+// SimulateTransactionRequest requests to apply a transaction to the current
+// state of the ledger without storing the result.
+type SimulateTransactionRequest struct {
+	// Version of the protocol
+	Version Version
+	// SkipchainID is the hash of the first skipblock
+	SkipchainID skipchain.SkipBlockID
+	// Transaction to be simulated
+	Transaction ClientTransaction
+}
+
+// SimulateTransactionResponse holds the state changes the transaction would
+// create.
+type SimulateTransactionResponse struct {
+	// Version of the protocol
+	Version Version
+	// StateChanges created by the instructions of the transaction, including
+	// the updates of the signer counters.
+	StateChanges []StateChange
+}
+
 // GetProof returns the proof that the given key is in the trie.
 type GetProof struct {
 	// Version of the protocol
@@ -117,6 +138,29 @@ type GetProofResponse struct {
 	Proof Proof
 }
 
+// GetProofBatchRequest returns the proofs that the given keys are in the trie.
+// All the proofs are made from the same state of the trie.
+type GetProofBatchRequest struct {
+	// Version of the protocol
+	Version Version
+	// Keys are the keys we want to look up, at most MaxProofBatchSize
+	Keys [][]byte
+	// ID is any block that is known to us in the skipchain, can be the genesis
+	// block or any later block. The proofs returned will be starting at this
+	// block.
+	ID skipchain.SkipBlockID
+}
+
+// GetProofBatchResponse holds one proof for every key of the request, in the
+// same order.
+type GetProofBatchResponse struct {
+	// Version of the protocol
+	Version Version
+	// Proofs contains everything necessary to prove the inclusion or the
+	// absence of every key given a genesis skipblock.
+	Proofs []Proof
+}
+
 // CheckAuthorization returns the list of actions that could be executed if the
 // signatures of the given identities are present and valid
 type CheckAuthorization struct {
@@ -274,6 +318,25 @@ type StreamingResponse struct {
 	Block *skipchain.SkipBlock
 }
 
+// StateDiffRequest asks the service to stream the state changes of every
+// block of the chain specified by ID, starting with the block at index
+// FromBlock. It fails if the state changes of that block have already been
+// cleaned from the storage of the service.
+type StateDiffRequest struct {
+	ID        skipchain.SkipBlockID
+	FromBlock uint64
+}
+
+// StateDiff holds the state changes of one block, sorted by their action.
+// Applying them in the order of the blocks to an empty state gives the
+// current state, as long as the changes are not cleaned by the service.
+type StateDiff struct {
+	BlockIndex uint64
+	Creates    []StateChange
+	Updates    []StateChange
+	Deletes    []StateChange
+}
+
 // DownloadState requests the current global state of that node.
 // If it is the first call to the service, then Reset
 // must be true, else an error will be returned, or old data
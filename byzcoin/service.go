@@ -57,6 +57,10 @@ var rotationWindow time.Duration = 10
 
 const noTimeout time.Duration = 0
 
+// MaxProofBatchSize is the maximum number of keys in a GetProofBatchRequest.
+// All the proofs of a request are made while holding the lock of the trie.
+const MaxProofBatchSize = 100
+
 const collectTxProtocol = "CollectTxProtocol"
 
 const viewChangeSubFtCosi = "viewchange_sub_ftcosi"
@@ -368,6 +372,64 @@ func (s *Service) AddTransaction(req *AddTxRequest) (*AddTxResponse, error) {
 	}, nil
 }
 
+// SimulateTransaction executes the instructions of the transaction on a copy
+// of the latest state trie and returns the state changes. Nothing is stored,
+// so the transaction can still be sent with AddTransaction afterwards.
+func (s *Service) SimulateTransaction(req *SimulateTransactionRequest) (*SimulateTransactionResponse, error) {
+	s.updateTrieLock.Lock()
+	defer s.updateTrieLock.Unlock()
+	if s.catchingUp {
+		return nil, errors.New("currently catching up on our state")
+	}
+	if req.Version != CurrentVersion {
+		return nil, errors.New("version mismatch")
+	}
+	if len(req.Transaction.Instructions) == 0 {
+		return nil, errors.New("no instructions to simulate")
+	}
+	gen := s.db().GetByID(req.SkipchainID)
+	if gen == nil || gen.Index != 0 {
+		return nil, errors.New("skipchain ID does not exist")
+	}
+
+	st, err := s.getStateTrie(req.SkipchainID)
+	if err != nil {
+		return nil, err
+	}
+	sst := st.MakeStagingStateTrie()
+	h := req.Transaction.Instructions.Hash()
+	var cin []Coin
+	var states StateChanges
+	for i, instr := range req.Transaction.Instructions {
+		scs, cout, err := s.executeInstruction(sst, cin, instr, h)
+		if err != nil {
+			return nil, fmt.Errorf("instruction %d: %v", i, err)
+		}
+		counterScs, err := incrementSignerCounters(sst, instr.SignerIdentities)
+		if err != nil {
+			return nil, fmt.Errorf("instruction %d: failed to update signature counters: %v", i, err)
+		}
+		for _, sc := range scs {
+			if reason := stateChangeConflict(sst, sc); reason != "" {
+				return nil, fmt.Errorf("instruction %d: %s", i, reason)
+			}
+			if err = sst.StoreAll(StateChanges{sc}); err != nil {
+				return nil, err
+			}
+		}
+		if err = sst.StoreAll(counterScs); err != nil {
+			return nil, err
+		}
+		states = append(states, scs...)
+		states = append(states, counterScs...)
+		cin = cout
+	}
+	return &SimulateTransactionResponse{
+		Version:      CurrentVersion,
+		StateChanges: states,
+	}, nil
+}
+
 // GetProof searches for a key and returns a proof of the
 // presence or the absence of this key.
 func (s *Service) GetProof(req *GetProof) (resp *GetProofResponse, err error) {
@@ -382,33 +444,73 @@ func (s *Service) GetProof(req *GetProof) (resp *GetProofResponse, err error) {
 
 	log.Lvlf2("Returning proof for %x from chain '%x'", req.Key, req.ID)
 
-	sb := s.db().GetByID(req.ID)
-	if sb == nil {
-		err = errors.New("cannot find skipblock while getting proof")
-		return
-	}
-	st, err := s.GetReadOnlyStateTrie(sb.SkipChainID())
+	proofs, err := s.getProofs(req.ID, [][]byte{req.Key})
 	if err != nil {
 		return nil, err
 	}
-	proof, err := NewProof(st, s.db(), req.ID, req.Key)
-	if err != nil {
-		log.Error(s.ServerIdentity(), err)
-		return
+	resp = &GetProofResponse{
+		Version: CurrentVersion,
+		Proof:   proofs[0],
 	}
+	return
+}
 
-	// Sanity check
-	if err = proof.Verify(sb.SkipChainID()); err != nil {
-		return
+// GetProofBatch returns the proofs of the presence or the absence of all the
+// keys of the request, made from the same state of the trie.
+func (s *Service) GetProofBatch(req *GetProofBatchRequest) (*GetProofBatchResponse, error) {
+	if len(req.Keys) > MaxProofBatchSize {
+		return nil, fmt.Errorf("cannot return more than %d proofs in one request", MaxProofBatchSize)
+	}
+	s.updateTrieLock.Lock()
+	defer s.updateTrieLock.Unlock()
+	if s.catchingUp {
+		return nil, errors.New("currently catching up on our state")
+	}
+	if req.Version != CurrentVersion {
+		return nil, errors.New("version mismatch")
 	}
 
-	_, v := proof.InclusionProof.KeyValue()
-	log.Lvlf3("value is %x", v)
-	resp = &GetProofResponse{
+	log.Lvlf2("Returning %d proofs from chain '%x'", len(req.Keys), req.ID)
+
+	proofs, err := s.getProofs(req.ID, req.Keys)
+	if err != nil {
+		return nil, err
+	}
+	return &GetProofBatchResponse{
 		Version: CurrentVersion,
-		Proof:   *proof,
+		Proofs:  proofs,
+	}, nil
+}
+
+// getProofs creates and verifies the proofs of the keys, starting at the
+// block with the given ID. The caller must hold updateTrieLock.
+func (s *Service) getProofs(id skipchain.SkipBlockID, keys [][]byte) ([]Proof, error) {
+	sb := s.db().GetByID(id)
+	if sb == nil {
+		return nil, errors.New("cannot find skipblock while getting proof")
 	}
-	return
+	st, err := s.GetReadOnlyStateTrie(sb.SkipChainID())
+	if err != nil {
+		return nil, err
+	}
+	proofs := make([]Proof, len(keys))
+	for i, key := range keys {
+		proof, err := NewProof(st, s.db(), id, key)
+		if err != nil {
+			log.Error(s.ServerIdentity(), err)
+			return nil, err
+		}
+
+		// Sanity check
+		if err = proof.Verify(sb.SkipChainID()); err != nil {
+			return nil, err
+		}
+
+		_, v := proof.InclusionProof.KeyValue()
+		log.Lvlf3("value of %x is %x", key, v)
+		proofs[i] = *proof
+	}
+	return proofs, nil
 }
 
 // CheckAuthorization verifies whether a given combination of identities can
@@ -992,6 +1094,13 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock) error {
 				return errors.New("got wrong database, merkle roots don't work out")
 			}
 
+			// The state changes of the blocks up to the downloaded
+			// state are not available on this node.
+			err = s.stateChangeStorage.setMissing(sb.SkipChainID(), st.GetIndex())
+			if err != nil {
+				return errors.New("couldn't mark missing state changes: " + err.Error())
+			}
+
 			// Finally initialize the stateTrie using the new database.
 			s.stateTriesLock.Lock()
 			s.stateTries[idStr] = st
@@ -1852,21 +1961,7 @@ clientTransactions:
 			//  - refuse to create existing instances
 			//  - refuse to delete non-existing instances
 			for _, sc := range scs {
-				var reason string
-				switch sc.StateAction {
-				case Create:
-					if v, err := sstTempC.Get(sc.InstanceID); err != nil || v != nil {
-						reason = "tried to create existing instanceID"
-					}
-				case Update:
-					if v, err := sstTempC.Get(sc.InstanceID); err != nil || v == nil {
-						reason = "tried to update non-existing instanceID"
-					}
-				case Remove:
-					if v, err := sstTempC.Get(sc.InstanceID); err != nil || v == nil {
-						reason = "tried to remove non-existing instanceID"
-					}
-				}
+				reason := stateChangeConflict(sstTempC, sc)
 				err = sstTempC.StoreAll(StateChanges{sc})
 				if reason != "" || err != nil {
 					tx.Accepted = false
@@ -1939,6 +2034,26 @@ clientTransactions:
 	return
 }
 
+// stateChangeConflict returns why the state change cannot be applied to the
+// trie, or an empty string if it can be applied.
+func stateChangeConflict(sst *stagingStateTrie, sc StateChange) string {
+	switch sc.StateAction {
+	case Create:
+		if v, err := sst.Get(sc.InstanceID); err != nil || v != nil {
+			return "tried to create existing instanceID"
+		}
+	case Update:
+		if v, err := sst.Get(sc.InstanceID); err != nil || v == nil {
+			return "tried to update non-existing instanceID"
+		}
+	case Remove:
+		if v, err := sst.Get(sc.InstanceID); err != nil || v == nil {
+			return "tried to remove non-existing instanceID"
+		}
+	}
+	return ""
+}
+
 // GetContractConstructor gets the contract constructor of the contract
 // contractName.
 func (s *Service) GetContractConstructor(contractName string) (ContractFn, bool) {
@@ -2425,7 +2540,9 @@ func newService(c *onet.Context) (onet.Service, error) {
 	err := s.RegisterHandlers(
 		s.CreateGenesisBlock,
 		s.AddTransaction,
+		s.SimulateTransaction,
 		s.GetProof,
+		s.GetProofBatch,
 		s.CheckAuthorization,
 		s.GetSignerCounters,
 		s.DownloadState,
@@ -2439,7 +2556,7 @@ func newService(c *onet.Context) (onet.Service, error) {
 		log.ErrFatal(err, "Couldn't register messages")
 	}
 
-	if err := s.RegisterStreamingHandlers(s.StreamTransactions); err != nil {
+	if err := s.RegisterStreamingHandlers(s.StreamTransactions, s.StreamStateDiffs); err != nil {
 		log.ErrFatal(err, "Couldn't register streaming messages")
 	}
 	s.RegisterProcessorFunc(viewChangeMsgID, s.handleViewChangeReq)
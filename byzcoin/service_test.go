@@ -1361,6 +1361,31 @@ func TestService_SetConfig(t *testing.T) {
 	require.Equal(t, blocksize, newBlocksize)
 }
 
+// A client that doesn't read its state diffs must not stop the service from
+// adding new blocks.
+func TestService_StreamStateDiffsSlowClient(t *testing.T) {
+	s := newSer(t, 1, testInterval)
+	defer s.local.CloseAll()
+
+	diffs, stop, err := s.service().StreamStateDiffs(&StateDiffRequest{ID: s.genesis.SkipChainID()})
+	require.NoError(t, err)
+	defer close(stop)
+	for i := uint64(1); i <= 3; i++ {
+		tx, err := createOneClientTxWithCounter(s.darc.GetBaseID(), dummyContract, []byte{byte(i)}, s.signer, i)
+		require.NoError(t, err)
+		s.sendTxAndWait(t, tx, 10)
+	}
+
+	for i := uint64(0); i <= 3; i++ {
+		select {
+		case diff := <-diffs:
+			require.Equal(t, i, diff.BlockIndex)
+		case <-time.After(10 * s.interval):
+			require.Fail(t, "didn't get all state diffs")
+		}
+	}
+}
+
 func TestService_SetConfigInterval(t *testing.T) {
 	defer log.SetShowTime(log.ShowTime())
 	log.SetShowTime(true)
@@ -24,6 +24,9 @@ type ReadOnlyStateTrie interface {
 // byzcoin.
 type stagingStateTrie struct {
 	trie.StagingTrie
+	// index is the index of the source trie when the staging trie was
+	// created, so the index of the latest block.
+	index int
 }
 
 // Clone makes a copy of the staged data of the structure, the source Trie is
@@ -31,6 +34,7 @@ type stagingStateTrie struct {
 func (t *stagingStateTrie) Clone() *stagingStateTrie {
 	return &stagingStateTrie{
 		StagingTrie: *t.StagingTrie.Clone(),
+		index:       t.index,
 	}
 }
 
@@ -78,9 +82,10 @@ func (t *stagingStateTrie) Commit() error {
 	return errors.New("not implemented")
 }
 
-// GetIndex returns the index of the current trie.
+// GetIndex returns the index of the source trie, which is the index of the
+// latest block. The staged state changes will be stored in the next block.
 func (t *stagingStateTrie) GetIndex() int {
-	panic("cannot get index in stagingStateTrie")
+	return t.index
 }
 
 const trieIndexKey = "trieIndexKey"
@@ -194,6 +199,7 @@ func (t *stateTrie) GetIndex() int {
 func (t *stateTrie) MakeStagingStateTrie() *stagingStateTrie {
 	return &stagingStateTrie{
 		StagingTrie: *t.MakeStagingTrie(),
+		index:       t.GetIndex(),
 	}
 }
 
@@ -205,6 +211,7 @@ func newMemStagingStateTrie(nonce []byte) (*stagingStateTrie, error) {
 	}
 	et := stagingStateTrie{
 		StagingTrie: *memTrie.MakeStagingTrie(),
+		index:       -1,
 	}
 	return &et, nil
 }
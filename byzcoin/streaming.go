@@ -4,11 +4,13 @@ import (
 	"sync"
 
 	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
 )
 
 func init() {
-	network.RegisterMessages(&StreamingRequest{}, &StreamingResponse{})
+	network.RegisterMessages(&StreamingRequest{}, &StreamingResponse{},
+		&StateDiffRequest{}, &StateDiff{})
 }
 
 type streamingManager struct {
@@ -33,7 +35,7 @@ func (s *streamingManager) notify(scID string, block *skipchain.SkipBlock) {
 	}
 }
 
-func (s *streamingManager) newListener(scID string) (chan *StreamingResponse, int) {
+func (s *streamingManager) newListener(scID string) chan *StreamingResponse {
 	s.Lock()
 	defer s.Unlock()
 
@@ -41,27 +43,26 @@ func (s *streamingManager) newListener(scID string) (chan *StreamingResponse, in
 		s.listeners = make(map[string][]chan *StreamingResponse)
 	}
 
-	ls := s.listeners[scID]
-	id := len(s.listeners)
 	outChan := make(chan *StreamingResponse)
-	ls = append(ls, outChan)
-	s.listeners[scID] = ls
-	return outChan, id
+	s.listeners[scID] = append(s.listeners[scID], outChan)
+	return outChan
 }
 
-func (s *streamingManager) stopListener(scID string, i int) {
+// stopListener removes and closes the listener. The listeners are identified
+// by their channel, as the indexes change when other listeners are removed.
+func (s *streamingManager) stopListener(scID string, c chan *StreamingResponse) {
 	s.Lock()
 	defer s.Unlock()
 
-	ls, ok := s.listeners[scID]
-	if !ok || i >= len(ls) {
-		panic("listener does not exist")
+	ls := s.listeners[scID]
+	for i, l := range ls {
+		if l == c {
+			close(c)
+			s.listeners[scID] = append(ls[:i], ls[i+1:]...)
+			return
+		}
 	}
-
-	close(ls[i])
-
-	ls = append(ls[:i], ls[i+1:]...)
-	s.listeners[scID] = ls
+	panic("listener does not exist")
 }
 
 // StreamTransactions will stream all transactions IDs to the client until the
@@ -69,10 +70,97 @@ func (s *streamingManager) stopListener(scID string, i int) {
 func (s *Service) StreamTransactions(msg *StreamingRequest) (chan *StreamingResponse, chan bool, error) {
 	stopChan := make(chan bool)
 	key := string(msg.ID)
-	outChan, idx := s.streamingMan.newListener(key)
+	outChan := s.streamingMan.newListener(key)
 	go func() {
 		<-stopChan
-		s.streamingMan.stopListener(key, idx)
+		s.streamingMan.stopListener(key, outChan)
+	}()
+	return outChan, stopChan, nil
+}
+
+// StreamStateDiffs streams the state changes of all blocks, starting with
+// msg.FromBlock, until the client closes the connection.
+func (s *Service) StreamStateDiffs(msg *StateDiffRequest) (chan *StateDiff, chan bool, error) {
+	latest, err := s.db().GetLatestByID(msg.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !s.stateChangeStorage.hasBlock(msg.ID, int(msg.FromBlock)) {
+		return nil, nil, errStateChangesPruned
+	}
+	stopChan := make(chan bool)
+	outChan := make(chan *StateDiff)
+	key := string(msg.ID)
+	// Listen to new blocks before sending the past ones, so that no block
+	// is missed.
+	blocks := s.streamingMan.newListener(key)
+	// The notification of new blocks holds the lock of the trie, so blocks
+	// must always be read, even if the client is slow. Only the index of the
+	// newest block is kept, as send catches up with all blocks up to it.
+	newest := make(chan int, 1)
+	go func() {
+		for resp := range blocks {
+			select {
+			case <-newest:
+			default:
+			}
+			newest <- resp.Block.Index
+		}
+	}()
+	go func() {
+		defer func() {
+			close(outChan)
+			s.streamingMan.stopListener(key, blocks)
+		}()
+		next := int(msg.FromBlock)
+		send := func(upTo int) bool {
+			for ; next <= upTo; next++ {
+				diff, err := s.stateDiff(msg.ID, next)
+				if err != nil {
+					log.Error(s.ServerIdentity(), "couldn't get state changes:", err)
+					return false
+				}
+				select {
+				case outChan <- diff:
+				case <-stopChan:
+					return false
+				}
+			}
+			return true
+		}
+		if !send(latest.Index) {
+			return
+		}
+		for {
+			select {
+			case idx := <-newest:
+				if !send(idx) {
+					return
+				}
+			case <-stopChan:
+				return
+			}
+		}
 	}()
 	return outChan, stopChan, nil
 }
+
+// stateDiff returns the state changes of the block at index idx.
+func (s *Service) stateDiff(scID skipchain.SkipBlockID, idx int) (*StateDiff, error) {
+	entries, err := s.stateChangeStorage.getByBlock(scID, idx)
+	if err != nil {
+		return nil, err
+	}
+	diff := &StateDiff{BlockIndex: uint64(idx)}
+	for _, e := range entries {
+		switch e.StateChange.StateAction {
+		case Create:
+			diff.Creates = append(diff.Creates, e.StateChange)
+		case Update:
+			diff.Updates = append(diff.Updates, e.StateChange)
+		case Remove:
+			diff.Deletes = append(diff.Deletes, e.StateChange)
+		}
+	}
+	return diff, nil
+}
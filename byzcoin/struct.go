@@ -23,6 +23,11 @@ const cleanThreshold = 0.8
 
 var bucketStateChangeStorage = []byte("statechangestorage")
 var errLengthInstanceID = errors.New("InstanceID must have 32 bytes")
+var errStateChangesPruned = errors.New("the state changes of the block have been pruned")
+
+// ErrCoinUnderflow is returned by Coin.SafeSub if the coin holds less than the
+// amount to subtract.
+var ErrCoinUnderflow = errors.New("uint64 underflow")
 
 // StateChangeEntry is the object stored to keep track of instance history. It
 // contains the state change and the block index
@@ -183,6 +188,47 @@ func (s *stateChangeStorage) getBucket(tx *bbolt.Tx, sid skipchain.SkipBlockID)
 	return b.Bucket(sid)
 }
 
+// prunedKey returns the key of the pruning watermark of the skipchain. It is
+// stored in the main bucket, next to the buckets of the skipchains, so that it
+// survives a restart.
+func prunedKey(sid []byte) []byte {
+	return append([]byte("pruned-"), sid...)
+}
+
+// setPruned records that the state changes of the blocks up to index idx
+// have been partially or entirely cleaned.
+func (s *stateChangeStorage) setPruned(tx *bbolt.Tx, sid []byte, idx int) error {
+	if s.isPruned(tx, sid, idx) {
+		return nil
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(idx))
+	return tx.Bucket(s.bucket).Put(prunedKey(sid), buf)
+}
+
+// setPrunedByKey records that the state changes of the block of the given key
+// have been cleaned.
+func (s *stateChangeStorage) setPrunedByKey(tx *bbolt.Tx, sid []byte, key []byte) error {
+	return s.setPruned(tx, sid, int(binary.BigEndian.Uint64(key[len(key)-8:])))
+}
+
+// isPruned returns true if some state changes of the block at index idx
+// might have been cleaned.
+func (s *stateChangeStorage) isPruned(tx *bbolt.Tx, sid []byte, idx int) bool {
+	buf := tx.Bucket(s.bucket).Get(prunedKey(sid))
+	return buf != nil && idx <= int(binary.BigEndian.Uint64(buf))
+}
+
+// setMissing records that the state changes of the blocks up to index idx are
+// not available, e.g. because the state has been downloaded from another node.
+func (s *stateChangeStorage) setMissing(sid skipchain.SkipBlockID, idx int) error {
+	s.accessLock.Lock()
+	defer s.accessLock.Unlock()
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return s.setPruned(tx, sid, idx)
+	})
+}
+
 // setMaxSize enables the cleaning of old state changes when the storage
 // size is above a given threshold. Note that the value is not strict.
 func (s *stateChangeStorage) setMaxSize(size int) {
@@ -238,6 +284,10 @@ func (s *stateChangeStorage) cleanBySize() error {
 			if err != nil {
 				return err
 			}
+			err = s.setPrunedByKey(tx, sortedKeys[0].scid, k)
+			if err != nil {
+				return err
+			}
 
 			s.size -= len(v)
 			k, v = c.Next()
@@ -305,6 +355,10 @@ func (s *stateChangeStorage) cleanByBlock(scs StateChanges, sb *skipchain.SkipBl
 						if err != nil {
 							return err
 						}
+						err = s.setPrunedByKey(tx, sb.SkipChainID(), k)
+						if err != nil {
+							return err
+						}
 						size -= len(v)
 					}
 				}
@@ -495,11 +549,15 @@ func (s *stateChangeStorage) getByVersion(iid []byte,
 }
 
 // getByBlock looks for the state changes associated with a given
-// skipblock
+// skipblock. It returns an error if the block is older than the retained
+// history.
 func (s *stateChangeStorage) getByBlock(sid skipchain.SkipBlockID, idx int) (entries StateChangeEntries, err error) {
 	s.accessLock.Lock()
 	defer s.accessLock.Unlock()
 	err = s.db.View(func(tx *bbolt.Tx) error {
+		if s.isPruned(tx, sid, idx) {
+			return errStateChangesPruned
+		}
 		b := s.getBucket(tx, sid)
 
 		var suffix bytes.Buffer
@@ -526,6 +584,19 @@ func (s *stateChangeStorage) getByBlock(sid skipchain.SkipBlockID, idx int) (ent
 	return
 }
 
+// hasBlock returns false if the state changes of the block at index idx
+// might have been cleaned.
+func (s *stateChangeStorage) hasBlock(sid skipchain.SkipBlockID, idx int) bool {
+	s.accessLock.Lock()
+	defer s.accessLock.Unlock()
+	pruned := true
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		pruned = s.isPruned(tx, sid, idx)
+		return nil
+	})
+	return err == nil && !pruned
+}
+
 // getLast looks for the last version of a given instance and return the entry. Use
 // the bool value to know if there is a hit or not.
 func (s *stateChangeStorage) getLast(iid []byte, sid skipchain.SkipBlockID) (sce StateChangeEntry, ok bool, err error) {
@@ -628,14 +699,14 @@ func (c *Coin) SafeAdd(a uint64) error {
 	return nil
 }
 
-// SafeSub subtracts a from the value of the coin if there
-// will be no underflow.
+// SafeSub subtracts a from the value of the coin if there will be no
+// underflow. Else ErrCoinUnderflow is returned and the value is unchanged.
 func (c *Coin) SafeSub(a uint64) error {
-	if a <= c.Value {
-		c.Value -= a
-		return nil
+	if a > c.Value {
+		return ErrCoinUnderflow
 	}
-	return errors.New("uint64 underflow")
+	c.Value -= a
+	return nil
 }
 
 type bcNotifications struct {
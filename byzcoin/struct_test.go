@@ -15,6 +15,20 @@ import (
 
 // Checks that the size of the storage is correctly restored
 // after reading the DB and that the indices are correct
+func TestCoin_SafeSub(t *testing.T) {
+	c := Coin{Value: 10}
+	require.Nil(t, c.SafeSub(3))
+	require.Equal(t, uint64(7), c.Value)
+
+	require.Equal(t, ErrCoinUnderflow, c.SafeSub(8))
+	require.Equal(t, uint64(7), c.Value)
+
+	require.Nil(t, c.SafeSub(7))
+	require.Equal(t, uint64(0), c.Value)
+	require.Equal(t, ErrCoinUnderflow, c.SafeSub(1))
+	require.Equal(t, uint64(0), c.Value)
+}
+
 func TestStateChangeStorage_Init(t *testing.T) {
 	scs, name := generateDB(t)
 	defer os.Remove(name)
@@ -139,6 +153,54 @@ func TestStateChangeStorage_GetByBlock(t *testing.T) {
 	sce, err := store.getByBlock(sbs[n-1].SkipChainID(), 0)
 	require.Nil(t, err)
 	require.Equal(t, k, len(sce))
+	require.True(t, store.hasBlock(sbs[n-1].SkipChainID(), 0))
+}
+
+// Checks that the blocks older than the retained history are refused
+// instead of returning no state changes.
+func TestStateChangeStorage_GetByBlockPruned(t *testing.T) {
+	store, name := generateDB(t)
+	store.maxNbrBlock = 2
+	defer os.Remove(name)
+
+	iid := genID().Slice()
+	sb := createBlock()
+	for i := 0; i < 5; i++ {
+		sb.Index = i
+		err := store.append(StateChanges{{
+			InstanceID: iid,
+			Version:    uint64(i),
+			Value:      []byte{},
+		}}, sb)
+		require.Nil(t, err)
+	}
+
+	for i := 0; i <= 2; i++ {
+		_, err := store.getByBlock(sb.SkipChainID(), i)
+		require.Equal(t, errStateChangesPruned, err)
+		require.False(t, store.hasBlock(sb.SkipChainID(), i))
+	}
+	for i := 3; i < 5; i++ {
+		sce, err := store.getByBlock(sb.SkipChainID(), i)
+		require.Nil(t, err)
+		require.Equal(t, 1, len(sce))
+	}
+
+	// The history of the other skipchains is not affected.
+	sb2 := createBlock()
+	require.True(t, store.hasBlock(sb2.SkipChainID(), 0))
+
+	// The pruned blocks are still refused after a restart.
+	restarted := &stateChangeStorage{db: store.db, bucket: store.bucket}
+	_, err := restarted.getByBlock(sb.SkipChainID(), 2)
+	require.Equal(t, errStateChangesPruned, err)
+	require.True(t, restarted.hasBlock(sb.SkipChainID(), 3))
+
+	// Blocks that were never stored, as before a downloaded state, are
+	// refused too.
+	require.Nil(t, restarted.setMissing(sb2.SkipChainID(), 1))
+	require.False(t, restarted.hasBlock(sb2.SkipChainID(), 1))
+	require.True(t, restarted.hasBlock(sb2.SkipChainID(), 2))
 }
 
 // Checks the independance of the skipchains for the state changes
@@ -285,7 +285,10 @@ func (instr Instruction) Verify(st ReadOnlyStateTrie, msg []byte) error {
 		return errors.New("no signatures - nothing to verify")
 	}
 
-	// check the action
+	// check the action, rules that expired at the index of the current
+	// block are treated as absent
+	blockIndex := int64(st.GetIndex() + 1)
+	d = darc.CleanExpiredRules(d, blockIndex)
 	if !d.Rules.Contains(darc.Action(instr.Action())) {
 		return fmt.Errorf("action '%v' does not exist", instr.Action())
 	}
@@ -310,7 +313,7 @@ func (instr Instruction) Verify(st ReadOnlyStateTrie, msg []byte) error {
 		if err != nil {
 			return nil
 		}
-		return d
+		return darc.CleanExpiredRules(d, blockIndex)
 	}
 	return darc.EvalExpr(d.Rules.Get(darc.Action(instr.Action())), getDarc, instr.GetIdentityStrings()...)
 }
@@ -26,7 +26,7 @@ func TestTransaction_Signing(t *testing.T) {
 	mdb := trie.NewMemDB()
 	tr, err := trie.NewTrie(mdb, []byte("my nonce"))
 	require.NoError(t, err)
-	sst := &stagingStateTrie{*tr.MakeStagingTrie()}
+	sst := &stagingStateTrie{StagingTrie: *tr.MakeStagingTrie()}
 
 	// verification should fail because trie is empty
 	ctxHash := ctx.Instructions.Hash()
@@ -73,6 +73,19 @@ func TestTransaction_Signing(t *testing.T) {
 	}
 	require.NoError(t, sst.StoreAll([]StateChange{sc}))
 	require.NoError(t, ctx.Instructions[0].Verify(sst, ctxHash))
+
+	// the rule is treated as absent from the block of its expiry on
+	d.Rules.List[len(d.Rules.List)-1].ExpiresAtBlock = 2
+	darcBuf, err = d.ToProto()
+	require.NoError(t, err)
+	sc.StateAction = Update
+	sc.Value = darcBuf
+	require.NoError(t, sst.StoreAll([]StateChange{sc}))
+	sst.index = 0
+	require.NoError(t, ctx.Instructions[0].Verify(sst, ctxHash))
+	sst.index = 1
+	require.Error(t, ctx.Instructions[0].Verify(sst, ctxHash))
+	require.Contains(t, ctx.Instructions[0].Verify(sst, ctxHash).Error(), "does not exist")
 }
 
 func setSignerCounter(sst *stagingStateTrie, id string, v uint64) error {
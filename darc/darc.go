@@ -56,6 +56,14 @@ import (
 const evolve = "_evolve"
 const sign = "_sign"
 
+// DefaultMaxDelegationDepth is the maximum number of delegations to other
+// darcs that are followed when evaluating an expression.
+const DefaultMaxDelegationDepth = 10
+
+// ErrDelegationTooDeep is returned if an expression can only be evaluated by
+// following more delegations than allowed.
+var ErrDelegationTooDeep = errors.New("delegation chain is too deep")
+
 // GetDarc is a callback function that we expect the user of this library to
 // supply in some of our methods. The user is free to choose how he/she wants
 // to store the darc. Hence, during verification, we need a way to retrieve an
@@ -177,6 +185,13 @@ func (d Darc) GetID() ID {
 	for _, rule := range d.Rules.List {
 		h.Write([]byte(rule.Action))
 		h.Write(rule.Expr)
+		// Only hash the expiry if it is set, so that the IDs of the
+		// existing darcs stay the same.
+		if rule.ExpiresAtBlock != 0 {
+			expBytes := make([]byte, 8)
+			binary.LittleEndian.PutUint64(expBytes, uint64(rule.ExpiresAtBlock))
+			h.Write(expBytes)
+		}
 	}
 	return h.Sum(nil)
 }
@@ -204,7 +219,7 @@ func (r *Rules) AddRule(a Action, expr expression.Expr) error {
 	if r.exists(a) != -1 {
 		return errors.New("action already exists")
 	}
-	r.List = append(r.List, Rule{a, expr})
+	r.List = append(r.List, Rule{Action: a, Expr: expr})
 	return nil
 }
 
@@ -248,7 +263,7 @@ func (r *Rules) updateRule(a Action, expr expression.Expr) error {
 	if i == -1 {
 		return fmt.Errorf("updateRule: action '%v' does not exist", a)
 	}
-	r.List[i] = Rule{a, expr}
+	r.List[i].Expr = expr
 	return nil
 }
 
@@ -316,6 +331,22 @@ func (r Rules) exists(a Action) int {
 	return -1
 }
 
+// CleanExpiredRules returns a copy of the darc where all the rules that
+// expired at the block with the given index are removed. As the rules are part
+// of the ID, the copy must only be used to evaluate the rules. The evaluation
+// functions of this package don't remove expired rules, as they don't know
+// the index of the block; the caller cleans the darcs.
+func CleanExpiredRules(d *Darc, blockIndex int64) *Darc {
+	dCopy := d.Copy()
+	dCopy.Rules.List = dCopy.Rules.List[:0]
+	for _, rule := range d.Rules.List {
+		if !rule.Expired(blockIndex) {
+			dCopy.Rules.List = append(dCopy.Rules.List, rule)
+		}
+	}
+	return dCopy
+}
+
 func isDefault(action Action) bool {
 	if action == evolve || action == sign {
 		return true
@@ -336,6 +367,49 @@ func (d *Darc) EvolveFrom(prev *Darc) error {
 	return nil
 }
 
+// Rotate returns a new version of the darc where the identity of oldSigner is
+// replaced by the identity of newSigner in all the rules. The evolution is
+// signed by oldSigner. An error is returned if the identity of oldSigner is
+// not in any rule.
+func Rotate(oldSigner Signer, newSigner Signer, d *Darc) (*Darc, error) {
+	if d == nil {
+		return nil, errors.New("darc is nil")
+	}
+	oldID := oldSigner.Identity().String()
+	newID := newSigner.Identity().String()
+	rotated := d.Copy()
+	var found bool
+	for i, rule := range rotated.Rules.List {
+		// Rules can be empty, which the parser refuses.
+		if !bytes.Contains(rule.Expr, []byte(oldID)) {
+			continue
+		}
+		expr, n, err := expression.ReplaceID(rule.Expr, oldID, newID)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't rotate rule %s: %v", rule.Action, err)
+		}
+		if n > 0 {
+			found = true
+			rotated.Rules.List[i].Expr = expr
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("identity %s is not in any rule", oldID)
+	}
+	if err := rotated.EvolveFrom(d); err != nil {
+		return nil, err
+	}
+	r, _, err := rotated.MakeEvolveRequest(oldSigner)
+	if err != nil {
+		return nil, err
+	}
+	rotated.Signatures = []Signature{{
+		Signature: r.Signatures[0],
+		Signer:    r.Identities[0],
+	}}
+	return rotated, nil
+}
+
 // MakeEvolveRequest creates a request and signs it such that it can be sent to
 // the darc service (for example) to execute the evolution. This function
 // assumes that the receiver has all the correct attributes to form a valid
@@ -610,8 +684,18 @@ func EvalExpr(expr expression.Expr, getDarc GetDarc, ids ...string) error {
 
 // EvalExprDarc checks whether the expression evaluates to true given a list of
 // identities. It takes 'acceptDarc', and, if it is true, doesn't recurse into
-// darcs that fit one of the ids.
+// darcs that fit one of the ids. At most DefaultMaxDelegationDepth delegations
+// are followed.
 func EvalExprDarc(expr expression.Expr, getDarc GetDarc, acceptDarc bool, ids ...string) error {
+	return EvalExprDarcWithDepth(expr, getDarc, acceptDarc, DefaultMaxDelegationDepth, ids...)
+}
+
+// EvalExprDarcWithDepth works like EvalExprDarc, but follows at most maxDepth
+// delegations to other darcs. If the expression evaluates to false and a
+// delegation has not been followed because of maxDepth, ErrDelegationTooDeep
+// is returned.
+func EvalExprDarcWithDepth(expr expression.Expr, getDarc GetDarc, acceptDarc bool, maxDepth int, ids ...string) error {
+	tooDeep := false
 	Y := expression.InitParser(func(s string) bool {
 		found := false
 		for _, id := range ids {
@@ -623,6 +707,10 @@ func EvalExprDarc(expr expression.Expr, getDarc GetDarc, acceptDarc bool, ids ..
 			if acceptDarc && found {
 				return true
 			}
+			if maxDepth <= 0 {
+				tooDeep = true
+				return false
+			}
 			// getDarc is responsible for returning the latest Darc
 			d := getDarc(s, true)
 			if d == nil {
@@ -642,7 +730,10 @@ func EvalExprDarc(expr expression.Expr, getDarc GetDarc, acceptDarc bool, ids ..
 			}
 			// Recursively evaluate the sign expression until we
 			// find the final signer.
-			if err := EvalExprDarc(signExpr, getDarc, acceptDarc, ids...); err != nil {
+			if err := EvalExprDarcWithDepth(signExpr, getDarc, acceptDarc, maxDepth-1, ids...); err != nil {
+				if err == ErrDelegationTooDeep {
+					tooDeep = true
+				}
 				return false
 			}
 			return true
@@ -653,6 +744,9 @@ func EvalExprDarc(expr expression.Expr, getDarc GetDarc, acceptDarc bool, ids ..
 	if err != nil {
 		return fmt.Errorf("evaluation failed on '%s' with error: %v", expr, err)
 	}
+	if res != true && tooDeep {
+		return ErrDelegationTooDeep
+	}
 	if res != true {
 		return fmt.Errorf("expression '%s' evaluated to false with ids %s", expr, ids)
 	}
@@ -1021,6 +1115,12 @@ func (r Rule) String() string {
 	return fmt.Sprintf("%s:%s", r.Action, r.Expr)
 }
 
+// Expired returns true if the rule has an expiry that is not after the block
+// with the given index.
+func (r Rule) Expired(blockIndex int64) bool {
+	return r.ExpiresAtBlock != 0 && r.ExpiresAtBlock <= blockIndex
+}
+
 // NewRequest initialises a request, the caller must provide all the fields of
 // the request. There is no guarantee that this request is valid, please see
 // InitAndSignRequest is a valid request needs to be created.
@@ -1,6 +1,7 @@
 package darc
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -200,6 +201,40 @@ func TestDarc_EvolveMoreOnline(t *testing.T) {
 	require.Nil(t, lightDarc2.VerifyWithCB(getDarc, true))
 }
 
+func TestDarc_Rotate(t *testing.T) {
+	td := createDarc(1, "rotate")
+	oldSigner := td.owners[0]
+	other := createIdentity().String()
+	require.Nil(t, td.darc.Rules.AddRule("use",
+		expression.InitAndExpr(oldSigner.Identity().String(), other)))
+	newSigner := createSigner()
+
+	rotated, err := Rotate(oldSigner, newSigner, td.darc)
+	require.Nil(t, err)
+	rotated.VerificationDarcs = []*Darc{td.darc}
+	require.Nil(t, rotated.Verify(true))
+	require.Equal(t, td.darc.Version+1, rotated.Version)
+	require.Equal(t, expression.InitAndExpr(newSigner.Identity().String(), other),
+		rotated.Rules.Get("use"))
+	require.Equal(t, expression.Expr(newSigner.Identity().String()),
+		rotated.Rules.GetEvolutionExpr())
+	for _, rule := range rotated.Rules.List {
+		require.NotContains(t, string(rule.Expr), oldSigner.Identity().String())
+	}
+
+	// the old signer is not in the rotated darc anymore
+	_, err = Rotate(oldSigner, newSigner, rotated)
+	require.NotNil(t, err)
+	// an identity starting with the identity of the old signer is kept
+	longer := oldSigner.Identity().String() + "ff"
+	require.Nil(t, td.darc.Rules.AddRule("prefix",
+		expression.InitOrExpr(longer, oldSigner.Identity().String())))
+	rotated, err = Rotate(oldSigner, newSigner, td.darc)
+	require.Nil(t, err)
+	require.Equal(t, expression.InitOrExpr(longer, newSigner.Identity().String()),
+		rotated.Rules.Get("prefix"))
+}
+
 // TestDarc_Rules is, other than the test, is an example of how one would use
 // the Darc with a user-defined rule.
 func TestDarc_Rules(t *testing.T) {
@@ -256,6 +291,46 @@ func TestDarc_Rules(t *testing.T) {
 	require.NotNil(t, r.Verify(d))
 }
 
+func TestDarc_RuleExpiry(t *testing.T) {
+	blockIndex := int64(100)
+	user := NewSignerEd25519(nil, nil)
+	for _, tc := range []struct {
+		name           string
+		expiresAtBlock int64
+		valid          bool
+	}{
+		{"expired", blockIndex - 1, false},
+		{"expires at this block", blockIndex, false},
+		{"expires at the next block", blockIndex + 1, true},
+		{"permanent", 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := createDarc(1, "expiry").darc
+			require.Nil(t, d.Rules.AddRule("use", expression.Expr(user.Identity().String())))
+			d.Rules.List[len(d.Rules.List)-1].ExpiresAtBlock = tc.expiresAtBlock
+
+			cleaned := CleanExpiredRules(d, blockIndex)
+			require.Equal(t, tc.valid, cleaned.Rules.Contains("use"))
+			require.True(t, cleaned.Rules.Contains(evolve))
+			// the original darc is not changed
+			require.True(t, d.Rules.Contains("use"))
+
+			// the evaluation itself doesn't look at the expiry, the
+			// caller has to clean the darc
+			r, err := InitAndSignRequest(d.GetBaseID(), "use", []byte("contract work"), user)
+			require.Nil(t, err)
+			require.Nil(t, r.Verify(d))
+		})
+	}
+
+	// the expiry is part of the ID, so it cannot be changed without an
+	// evolution
+	d := createDarc(1, "expiry").darc
+	id := d.GetID()
+	d.Rules.List[0].ExpiresAtBlock = blockIndex
+	require.NotEqual(t, id, d.GetID())
+}
+
 func TestDarc_EvolveRequest(t *testing.T) {
 	td := createDarc(1, "testdarc")
 	require.Nil(t, td.darc.Verify(true))
@@ -383,6 +458,37 @@ func TestDarc_DelegationChain(t *testing.T) {
 	require.Nil(t, td.darc.VerifyWithCB(getDarc, true))
 }
 
+func TestDarc_DelegationDepth(t *testing.T) {
+	expr, getDarc, owner := delegationChain(DefaultMaxDelegationDepth)
+	require.Nil(t, EvalExpr(expr, getDarc, owner.Identity().String()))
+
+	// one more delegation than allowed
+	expr, getDarc, owner = delegationChain(DefaultMaxDelegationDepth + 1)
+	err := EvalExpr(expr, getDarc, owner.Identity().String())
+	require.Equal(t, ErrDelegationTooDeep, err)
+	require.Nil(t, EvalExprDarcWithDepth(expr, getDarc, false,
+		DefaultMaxDelegationDepth+1, owner.Identity().String()))
+
+	// a wrong signer in a short chain is not reported as a too deep chain
+	expr, getDarc, _ = delegationChain(DefaultMaxDelegationDepth)
+	err = EvalExpr(expr, getDarc, createSigner().Identity().String())
+	require.NotNil(t, err)
+	require.NotEqual(t, ErrDelegationTooDeep, err)
+}
+
+func BenchmarkDelegationDepth(b *testing.B) {
+	for _, depth := range []int{5, 50} {
+		expr, getDarc, owner := delegationChain(depth)
+		id := owner.Identity().String()
+		b.Run(fmt.Sprintf("depth-%d", depth), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				err := EvalExprDarcWithDepth(expr, getDarc, false, depth, id)
+				require.Nil(b, err)
+			}
+		})
+	}
+}
+
 func TestDarc_X509(t *testing.T) {
 	// TODO
 }
@@ -465,3 +571,19 @@ func localEvolution(newDarc *Darc, oldDarc *Darc, signers ...Signer) error {
 	newDarc.VerificationDarcs = append(oldDarc.VerificationDarcs, oldDarc)
 	return nil
 }
+
+// delegationChain returns an expression that delegates through depth darcs
+// before reaching the key of the returned signer.
+func delegationChain(depth int) (expression.Expr, GetDarc, Signer) {
+	darcs := make([]*Darc, depth)
+	owner := createSigner()
+	for i := range darcs {
+		darcs[i] = createDarc(1, fmt.Sprintf("chain %d", i)).darc
+	}
+	// the darcID depends on the rules, so create the chain backwards
+	darcs[depth-1].Rules.UpdateSign([]byte(owner.Identity().String()))
+	for i := depth - 2; i >= 0; i-- {
+		darcs[i].Rules.UpdateSign([]byte(darcs[i+1].GetIdentityString()))
+	}
+	return expression.Expr(darcs[0].GetIdentityString()), DarcsToGetDarcs(darcs), owner
+}
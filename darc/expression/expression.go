@@ -30,6 +30,7 @@ package expression
 import (
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 
 	parsec "github.com/prataprc/goparsec"
@@ -121,11 +122,50 @@ func InitOrExpr(ids ...string) Expr {
 	return Expr(strings.Join(ids, " | "))
 }
 
+// ReplaceID returns a copy of expr where every id equal to oldID is replaced
+// by newID, and the number of replaced ids. Only whole ids are compared, so an
+// id that starts with oldID is kept. An error is returned if expr or the
+// resulting expression cannot be parsed.
+func ReplaceID(expr Expr, oldID, newID string) (Expr, int, error) {
+	if _, err := Evaluate(InitParser(func(string) bool { return true }), expr); err != nil {
+		return nil, 0, err
+	}
+	var out []byte
+	var replaced int
+	for rest := []byte(expr); len(rest) > 0; {
+		id := idRegexp.Find(rest)
+		if id == nil {
+			// Whitespace, parenthesis or operator.
+			out = append(out, rest[0])
+			rest = rest[1:]
+			continue
+		}
+		rest = rest[len(id):]
+		if string(id) == oldID {
+			id = []byte(newID)
+			replaced++
+		}
+		out = append(out, id...)
+	}
+	if _, err := Evaluate(InitParser(func(string) bool { return true }), out); err != nil {
+		return nil, 0, err
+	}
+	return out, replaced, nil
+}
+
+const typeHexPattern = `(darc|ed25519|x509ec):[0-9a-fA-F]+`
+
+const proxyPattern = `proxy:[0-9a-fA-F]+:[^ \n\t]*`
+
+// idRegexp matches an id at the start of an expression, trying the tokens in
+// the same order as the parser.
+var idRegexp = regexp.MustCompile(`^(` + typeHexPattern + `|` + proxyPattern + `)`)
+
 // Accepts tokens of the form "type:HEX"
 func typeHex() parsec.Parser {
 	return func(s parsec.Scanner) (parsec.ParsecNode, parsec.Scanner) {
 		_, s = s.SkipAny(`^[ \n\t]+`)
-		p := parsec.Token(`(darc|ed25519|x509ec):[0-9a-fA-F]+`, "HEX")
+		p := parsec.Token(typeHexPattern, "HEX")
 		return p(s)
 	}
 }
@@ -134,7 +174,7 @@ func typeHex() parsec.Parser {
 func proxy() parsec.Parser {
 	return func(s parsec.Scanner) (parsec.ParsecNode, parsec.Scanner) {
 		_, s = s.SkipAny(`^[ \n\t]+`)
-		p := parsec.Token(`proxy:[0-9a-fA-F]+:[^ \n\t]*`, "PROXY")
+		p := parsec.Token(proxyPattern, "PROXY")
 		return p(s)
 	}
 }
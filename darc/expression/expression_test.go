@@ -218,3 +218,33 @@ func TestEval_DefaultParser(t *testing.T) {
 		t.Fatal("evaluation should return false")
 	}
 }
+
+func TestReplaceID(t *testing.T) {
+	tests := []struct {
+		expr, oldID, newID, result string
+		replaced                   int
+	}{
+		{"(ed25519:ab & ed25519:abcd) | ed25519:ab", "ed25519:ab", "darc:cd",
+			"(darc:cd & ed25519:abcd) | darc:cd", 2},
+		{"proxy:ab:data | ed25519:ab", "proxy:ab:data", "proxy:ab:other",
+			"proxy:ab:other | ed25519:ab", 1},
+		{"ed25519:abcd", "ed25519:ab", "darc:cd", "ed25519:abcd", 0},
+	}
+	for _, test := range tests {
+		expr, n, err := ReplaceID(Expr(test.expr), test.oldID, test.newID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(expr) != test.result || n != test.replaced {
+			t.Fatalf("got %s with %d replaced ids, expected %s with %d",
+				expr, n, test.result, test.replaced)
+		}
+	}
+
+	if _, _, err := ReplaceID(Expr("ed25519:ab &"), "ed25519:ab", "darc:cd"); err == nil {
+		t.Fatal("invalid expression should fail")
+	}
+	if _, _, err := ReplaceID(Expr("ed25519:ab"), "ed25519:ab", "invalid"); err == nil {
+		t.Fatal("invalid new id should fail")
+	}
+}
@@ -145,4 +145,9 @@ type Rules struct {
 type Rule struct {
 	Action Action
 	Expr   expression.Expr
+	// ExpiresAtBlock is the index of the block from which on byzcoin treats
+	// the rule as absent. If it is 0, the rule never expires. It is a block
+	// index and not a unix time, as the index is the only notion of time that
+	// all nodes evaluate the same way.
+	ExpiresAtBlock int64 `protobuf:"opt"`
 }
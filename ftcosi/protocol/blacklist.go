@@ -0,0 +1,65 @@
+package protocol
+
+import (
+	"sync"
+	"time"
+
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// blacklistTTL is how long a subleader that didn't respond is left out when
+// generating the subtrees.
+const blacklistTTL = time.Minute
+
+// DefaultBlacklist is the blacklist used by the protocols created with
+// NewFtCosi.
+var DefaultBlacklist = &CoSiBlacklist{}
+
+// CoSiBlacklist holds the nodes that failed as subleaders, so that they are
+// not chosen again as subleaders until their entry expires.
+type CoSiBlacklist struct {
+	// expiries maps the network.ServerIdentityID of the nodes to the
+	// time.Time their entry expires.
+	expiries sync.Map
+}
+
+// Add blacklists the node for ttl.
+func (b *CoSiBlacklist) Add(id *network.ServerIdentity, ttl time.Duration) {
+	b.expiries.Store(id.ID, time.Now().Add(ttl))
+}
+
+// Contains returns whether the node is blacklisted. Expired entries are
+// removed.
+func (b *CoSiBlacklist) Contains(id *network.ServerIdentity) bool {
+	expiry, ok := b.expiries.Load(id.ID)
+	if !ok {
+		return false
+	}
+	if time.Now().After(expiry.(time.Time)) {
+		b.expiries.Delete(id.ID)
+		return false
+	}
+	return true
+}
+
+// ExpireAll removes all nodes from the blacklist.
+func (b *CoSiBlacklist) ExpireAll() {
+	b.expiries.Range(func(key, _ interface{}) bool {
+		b.expiries.Delete(key)
+		return true
+	})
+}
+
+// avoid reorders the nodes of a subtree, given as for genSubtree, so that the
+// subleader is the first node that is not blacklisted. The nodes before it
+// are moved to the end. If all nodes are blacklisted, the order is kept.
+func (b *CoSiBlacklist) avoid(roster *onet.Roster, nodes []int) []int {
+	for i := 1; i < len(nodes); i++ {
+		if !b.Contains(roster.List[nodes[i]]) {
+			reordered := append([]int{nodes[0]}, nodes[i:]...)
+			return append(reordered, nodes[1:i]...)
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,34 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v3"
+)
+
+func TestCoSiBlacklist(t *testing.T) {
+	local := onet.NewLocalTest(testSuite)
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(4, false)
+
+	b := &CoSiBlacklist{}
+	require.False(t, b.Contains(roster.List[1]))
+	b.Add(roster.List[1], time.Hour)
+	b.Add(roster.List[2], -time.Second)
+	require.True(t, b.Contains(roster.List[1]))
+	require.False(t, b.Contains(roster.List[2]))
+
+	// The first node that is not blacklisted becomes the subleader.
+	require.Equal(t, []int{0, 2, 3, 1}, b.avoid(roster, []int{0, 1, 2, 3}))
+	require.Equal(t, []int{0, 2, 1}, b.avoid(roster, []int{0, 2, 1}))
+	b.Add(roster.List[2], time.Hour)
+	b.Add(roster.List[3], time.Hour)
+	require.Equal(t, []int{0, 1, 2, 3}, b.avoid(roster, []int{0, 1, 2, 3}))
+
+	b.ExpireAll()
+	for _, si := range roster.List {
+		require.False(t, b.Contains(si))
+	}
+}
@@ -79,6 +79,17 @@ func genTrees(roster *onet.Roster, root, nNodes, nSubtrees int) ([]*onet.Tree, e
 	return trees, nil
 }
 
+// subtreeNodes returns the indexes to the roster of the root, the subleader
+// and the leaves of a subtree generated by genSubtree.
+func subtreeNodes(tree *onet.Tree) []int {
+	subleader := tree.Root.Children[0]
+	nodes := []int{tree.Root.RosterIndex, subleader.RosterIndex}
+	for _, leaf := range subleader.Children {
+		nodes = append(nodes, leaf.RosterIndex)
+	}
+	return nodes
+}
+
 // genSubtree generates a single subtree defined by the list of indexes
 // to the rootRoster.
 // The generated tree will have a root with one child (the subleader)
@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"fmt"
+	"time"
 
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/sign/cosi"
@@ -111,3 +112,19 @@ func GetLeafsIDs(tree *onet.Tree, root, nNodes, nSubtrees int) ([]network.Server
 	}
 	return leafsIDs, nil
 }
+
+// depthTimeout returns the timeout divided by the number of levels from the
+// node down to the deepest level of the tree, the node included.
+func depthTimeout(tree *onet.Tree, node *onet.TreeNode, timeout time.Duration) time.Duration {
+	treeDepth := 0
+	tree.Root.Visit(0, func(depth int, n *onet.TreeNode) {
+		if depth > treeDepth {
+			treeDepth = depth
+		}
+	})
+	nodeDepth := 0
+	for n := node.Parent; n != nil; n = n.Parent {
+		nodeDepth++
+	}
+	return timeout / time.Duration(treeDepth-nodeDepth+1)
+}
@@ -21,8 +21,9 @@ import (
 )
 
 // VerificationFn is called on every node. Where msg is the message that is
-// co-signed and the data is additional data for verification.
-type VerificationFn func(msg []byte, data []byte) bool
+// co-signed, the data is additional data for verification and signer is the
+// node running the verification.
+type VerificationFn func(msg []byte, data []byte, signer *network.ServerIdentity) bool
 
 // init is done at startup. It defines every messages that is handled by the network
 // and registers the protocols.
@@ -45,6 +46,13 @@ type FtCosi struct {
 	Timeout        time.Duration
 	Threshold      int
 	FinalSignature chan []byte
+	// Redundancy is the number of subprotocols started on each subtree, each
+	// with a different subleader. The first one to send a commitment is kept
+	// and the others are stopped. It defaults to 1.
+	Redundancy int
+	// Blacklist holds the subleaders that failed, which are not chosen as
+	// subleaders again. It is DefaultBlacklist unless changed.
+	Blacklist *CoSiBlacklist
 
 	publics         []kyber.Point
 	stoppedOnce     sync.Once
@@ -62,7 +70,7 @@ type CreateProtocolFunction func(name string, t *onet.Tree) (onet.ProtocolInstan
 // NewDefaultProtocol is the default protocol function used for registration
 // with an always-true verification.
 func NewDefaultProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
-	vf := func(a, b []byte) bool { return true }
+	vf := func(a, b []byte, c *network.ServerIdentity) bool { return true }
 	return NewFtCosi(n, vf, DefaultSubProtocolName, cothority.Suite)
 }
 
@@ -79,6 +87,8 @@ func NewFtCosi(n *onet.TreeNodeInstance, vf VerificationFn, subProtocolName stri
 	c := &FtCosi{
 		TreeNodeInstance: n,
 		FinalSignature:   make(chan []byte, 1),
+		Redundancy:       1,
+		Blacklist:        DefaultBlacklist,
 		Data:             make([]byte, 0),
 		publics:          n.Roster().Publics(),
 		startChan:        make(chan bool, 1),
@@ -124,7 +134,7 @@ func (p *FtCosi) Dispatch() error {
 	verifyChan := make(chan bool, 1)
 	go func() {
 		log.Lvl3(p.ServerIdentity().Address, "starting verification")
-		verifyChan <- p.verificationFn(p.Msg, p.Data)
+		verifyChan <- p.verificationFn(p.Msg, p.Data, p.ServerIdentity())
 	}()
 
 	// generate trees
@@ -134,6 +144,18 @@ func (p *FtCosi) Dispatch() error {
 		p.FinalSignature <- nil
 		return fmt.Errorf("error in tree generation: %s", err)
 	}
+	for i, tree := range trees {
+		if len(tree.Root.Children) == 0 {
+			continue
+		}
+		// skip blacklisted subleaders
+		nodes := p.Blacklist.avoid(tree.Roster, subtreeNodes(tree))
+		trees[i], err = genSubtree(tree.Roster, nodes)
+		if err != nil {
+			p.FinalSignature <- nil
+			return fmt.Errorf("error in tree generation: %s", err)
+		}
+	}
 
 	// if one node or threshold is one, sign without subprotocols
 	if nNodes == 1 || p.Threshold == 1 {
@@ -142,17 +164,23 @@ func (p *FtCosi) Dispatch() error {
 
 	// start all subprotocols
 	p.subProtocols = make([]*SubFtCosi, len(trees))
+	backups := make([][]*SubFtCosi, len(trees))
 	for i, tree := range trees {
 		p.subProtocols[i], err = p.startSubProtocol(tree)
 		if err != nil {
 			p.FinalSignature <- nil
 			return err
 		}
+		backups[i], err = p.startBackupSubProtocols(tree)
+		if err != nil {
+			p.FinalSignature <- nil
+			return err
+		}
 	}
 	log.Lvl3(p.ServerIdentity().Address, "all protocols started")
 
 	// collect commitments
-	commitments, runningSubProtocols, err := p.collectCommitments(trees, p.subProtocols)
+	commitments, runningSubProtocols, err := p.collectCommitments(trees, p.subProtocols, backups)
 	if err != nil {
 		p.FinalSignature <- nil
 		return err
@@ -308,6 +336,9 @@ func (p *FtCosi) Start() error {
 		log.Warn("no number of subtree specified, using one subtree")
 		p.NSubtrees = 1
 	}
+	if p.Redundancy < 1 {
+		p.Redundancy = 1
+	}
 	if p.NSubtrees >= p.Tree().Size() && p.NSubtrees > 1 {
 		p.Shutdown()
 		return fmt.Errorf("cannot create more subtrees (%d) than there are non-root nodes (%d) in the tree",
@@ -351,3 +382,24 @@ func (p *FtCosi) startSubProtocol(tree *onet.Tree) (*SubFtCosi, error) {
 
 	return cosiSubProtocol, err
 }
+
+// startBackupSubProtocols starts Redundancy-1 more subprotocols on the nodes of
+// the tree, each with the next node as subleader.
+func (p *FtCosi) startBackupSubProtocols(tree *onet.Tree) ([]*SubFtCosi, error) {
+	nodes := subtreeNodes(tree)
+	var backups []*SubFtCosi
+	for i := 1; i < p.Redundancy && i < len(nodes)-1; i++ {
+		rotated := append([]int{nodes[0]}, nodes[1+i:]...)
+		rotated = append(rotated, nodes[1:1+i]...)
+		backupTree, err := genSubtree(tree.Roster, rotated)
+		if err != nil {
+			return nil, err
+		}
+		backup, err := p.startSubProtocol(backupTree)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, backup)
+	}
+	return backups, nil
+}
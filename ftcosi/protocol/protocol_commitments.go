@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -11,9 +12,11 @@ import (
 	"go.dedis.ch/onet/v3/log"
 )
 
-// get all commitments, restart subprotocols where subleaders do not respond
+// get all commitments, restart subprotocols where subleaders do not respond.
+// If there are backups for a subtree, the first of its subprotocols to send a
+// commitment replaces the one in subProtocols.
 func (p *FtCosi) collectCommitments(trees []*onet.Tree,
-	subProtocols []*SubFtCosi) ([]StructCommitment, []*SubFtCosi, error) {
+	subProtocols []*SubFtCosi, backups [][]*SubFtCosi) ([]StructCommitment, []*SubFtCosi, error) {
 
 	type commitmentProtocol struct {
 		structCommitment StructCommitment
@@ -31,13 +34,32 @@ func (p *FtCosi) collectCommitments(trees []*onet.Tree,
 		go func(i int, subProtocol *SubFtCosi) {
 			defer closingWg.Done()
 			timeout := time.After(p.Timeout / 2)
+			failures := 0
+			if len(backups[i]) > 0 {
+				winner, com, err := p.raceSubProtocols(append([]*SubFtCosi{subProtocol}, backups[i]...),
+					timeout, closingChan)
+				if err != nil {
+					errChan <- fmt.Errorf("(subprotocol %v) %s", i, err)
+					return
+				}
+				if winner == nil {
+					return
+				}
+				subProtocol = winner
+				subProtocols[i] = winner
+				commitmentsChan <- commitmentProtocol{com, winner}
+				timeout = make(chan time.Time) // deactivate timeout
+			}
 			for {
 				select {
 				case <-closingChan:
 					return
 				case <-subProtocol.subleaderNotResponding:
-					subleaderID := trees[i].Root.Children[0].RosterIndex
+					subleader := trees[i].Root.Children[0]
+					subleaderID := subleader.RosterIndex
 					log.Lvlf2("(subprotocol %v) subleader with id %d failed, restarting subprotocol", i, subleaderID)
+					p.Blacklist.Add(subleader.ServerIdentity, blacklistTTL)
+					failures++
 
 					// generate new tree by adding the current subleader to the end of the
 					// leafs and taking the first leaf for the new subleader.
@@ -45,15 +67,15 @@ func (p *FtCosi) collectCommitments(trees []*onet.Tree,
 					for _, child := range trees[i].Root.Children[0].Children {
 						nodes = append(nodes, child.RosterIndex)
 					}
-					if subleaderID > nodes[len(nodes)-1] {
+					nodes = append(nodes, subleaderID)
+					if failures >= len(nodes)-1 {
 						errChan <- fmt.Errorf("(subprotocol %v) failed with every subleader, ignoring this subtree",
 							i)
 						return
 					}
-					nodes = append(nodes, subleaderID)
 
 					var err error
-					trees[i], err = genSubtree(trees[i].Roster, nodes)
+					trees[i], err = genSubtree(trees[i].Roster, p.Blacklist.avoid(trees[i].Roster, nodes))
 					if err != nil {
 						errChan <- fmt.Errorf("(subprotocol %v) error in tree generation: %v", i, err)
 						return
@@ -162,6 +184,65 @@ func (p *FtCosi) collectCommitments(trees []*onet.Tree,
 	return commitments, runningSubProtocols, nil
 }
 
+// raceSubProtocols waits for the first commitment of the subprotocols running
+// on the same nodes and stops the others. It returns a nil subprotocol if the
+// collection of the commitments is closed before.
+func (p *FtCosi) raceSubProtocols(subProtocols []*SubFtCosi, timeout <-chan time.Time,
+	closingChan chan bool) (*SubFtCosi, StructCommitment, error) {
+
+	type result struct {
+		subProtocol *SubFtCosi
+		commitment  StructCommitment
+		ok          bool
+	}
+	results := make(chan result, len(subProtocols))
+	done := make(chan bool)
+	defer close(done)
+	for _, subProtocol := range subProtocols {
+		go func(subProtocol *SubFtCosi) {
+			select {
+			case com := <-subProtocol.subCommitment:
+				results <- result{subProtocol, com, true}
+			case <-subProtocol.subleaderNotResponding:
+				results <- result{subProtocol: subProtocol}
+			case <-done:
+			}
+		}(subProtocol)
+	}
+	stopOthers := func(winner *SubFtCosi) {
+		for _, subProtocol := range subProtocols {
+			if subProtocol != winner {
+				subProtocol.HandleStop(StructStop{subProtocol.TreeNode(), Stop{}})
+			}
+		}
+	}
+
+	failed := 0
+	for {
+		select {
+		case r := <-results:
+			if !r.ok {
+				subleader := r.subProtocol.Root().Children[0]
+				log.Lvlf2("subleader with id %d failed", subleader.RosterIndex)
+				p.Blacklist.Add(subleader.ServerIdentity, blacklistTTL)
+				failed++
+				if failed == len(subProtocols) {
+					return nil, StructCommitment{}, errors.New("failed with every subleader, ignoring this subtree")
+				}
+				break
+			}
+			stopOthers(r.subProtocol)
+			return r.subProtocol, r.commitment, nil
+		case <-timeout:
+			stopOthers(nil)
+			return nil, StructCommitment{}, fmt.Errorf("didn't get commitment after timeout %v", p.Timeout)
+		case <-closingChan:
+			stopOthers(nil)
+			return nil, StructCommitment{}, nil
+		}
+	}
+}
+
 func sumRefusals(commitmentsMap map[*SubFtCosi]StructCommitment) int {
 	sumRefusal := 0
 	for _, commitment := range commitmentsMap {
@@ -11,8 +11,10 @@ import (
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/sign/cosi"
+	"go.dedis.ch/kyber/v3/util/key"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
 )
 
 const FailureProtocolName = "FailureProtocol"
@@ -21,25 +23,34 @@ const FailureSubProtocolName = "FailureSubProtocol"
 const RefuseOneProtocolName = "RefuseOneProtocol"
 const RefuseOneSubProtocolName = "RefuseOneSubProtocol"
 
+const WhitelistProtocolName = "WhitelistProtocol"
+const WhitelistSubProtocolName = "WhitelistSubProtocol"
+
 func init() {
 	GlobalRegisterDefaultProtocols()
 	onet.GlobalProtocolRegister(FailureProtocolName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
-		vf := func(a, b []byte) bool { return true }
+		vf := func(a, b []byte, c *network.ServerIdentity) bool { return true }
 		return NewFtCosi(n, vf, FailureSubProtocolName, cothority.Suite)
 	})
 	onet.GlobalProtocolRegister(FailureSubProtocolName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
-		vf := func(a, b []byte) bool { return false }
+		vf := func(a, b []byte, c *network.ServerIdentity) bool { return false }
 		return NewSubFtCosi(n, vf, cothority.Suite)
 	})
 	onet.GlobalProtocolRegister(RefuseOneProtocolName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
-		vf := func(a, b []byte) bool { return true }
+		vf := func(a, b []byte, c *network.ServerIdentity) bool { return true }
 		return NewFtCosi(n, vf, RefuseOneSubProtocolName, cothority.Suite)
 	})
 	onet.GlobalProtocolRegister(RefuseOneSubProtocolName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
-		return NewSubFtCosi(n, func(msg, data []byte) bool {
+		return NewSubFtCosi(n, func(msg, data []byte, signer *network.ServerIdentity) bool {
 			return refuse(n, msg, data)
 		}, cothority.Suite)
 	})
+	onet.GlobalProtocolRegister(WhitelistProtocolName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return NewFtCosi(n, whitelisted, WhitelistSubProtocolName, cothority.Suite)
+	})
+	onet.GlobalProtocolRegister(WhitelistSubProtocolName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return NewSubFtCosi(n, whitelisted, cothority.Suite)
+	})
 }
 
 var testSuite = cothority.Suite
@@ -303,6 +314,87 @@ func TestUnresponsiveSubleader(t *testing.T) {
 	}
 }
 
+// A subleader that always fails is blacklisted, so that the next rounds use
+// a backup node as subleader from the start.
+func TestBlacklistedSubleader(t *testing.T) {
+	nNodes := 5
+	proposal := []byte{0xFF}
+	local := onet.NewLocalTest(testSuite)
+	defer local.CloseAll()
+	servers, _, tree := local.GenTree(nNodes, false)
+	publics := tree.Roster.Publics()
+
+	subleaderIds, err := GetSubleaderIDs(tree, 0, nNodes, 1)
+	require.Nil(t, err)
+	var failing *onet.Server
+	for _, s := range servers {
+		if s.ServerIdentity.ID == subleaderIds[0] {
+			failing = s
+		}
+	}
+	failing.Pause()
+
+	for round := 0; round < 2; round++ {
+		pi, err := local.CreateProtocol(DefaultProtocolName, tree)
+		require.Nil(t, err)
+		cosiProtocol := pi.(*FtCosi)
+		cosiProtocol.CreateProtocol = local.CreateProtocol
+		cosiProtocol.Msg = proposal
+		cosiProtocol.NSubtrees = 1
+		cosiProtocol.Timeout = defaultTimeout
+		cosiProtocol.Threshold = nNodes - 1
+		require.Nil(t, cosiProtocol.Start())
+
+		_, err = getAndVerifySignature(cosiProtocol, publics, proposal, cosi.NewThresholdPolicy(nNodes-1))
+		require.Nil(t, err)
+		require.True(t, DefaultBlacklist.Contains(failing.ServerIdentity))
+		subleader := cosiProtocol.subProtocols[0].Root().Children[0]
+		require.NotEqual(t, failing.ServerIdentity.ID, subleader.ServerIdentity.ID)
+	}
+	DefaultBlacklist.ExpireAll()
+}
+
+// With redundant subtrees, a slow subleader doesn't delay the round: the
+// subtree with the backup subleader is used instead.
+func TestRedundantSubtrees(t *testing.T) {
+	nNodes := 5
+	proposal := []byte{0xFF}
+	local := onet.NewLocalTest(testSuite)
+	defer local.CloseAll()
+	servers, _, tree := local.GenTree(nNodes, false)
+	publics := tree.Roster.Publics()
+
+	subleaderIds, err := GetSubleaderIDs(tree, 0, nNodes, 1)
+	require.Nil(t, err)
+	var slow *onet.Server
+	for _, s := range servers {
+		if s.ServerIdentity.ID == subleaderIds[0] {
+			slow = s
+		}
+	}
+	slow.Pause()
+	defer slow.Unpause()
+
+	pi, err := local.CreateProtocol(DefaultProtocolName, tree)
+	require.Nil(t, err)
+	cosiProtocol := pi.(*FtCosi)
+	cosiProtocol.CreateProtocol = local.CreateProtocol
+	cosiProtocol.Msg = proposal
+	cosiProtocol.NSubtrees = 1
+	cosiProtocol.Redundancy = 2
+	cosiProtocol.Timeout = defaultTimeout
+	cosiProtocol.Threshold = nNodes - 1
+	start := time.Now()
+	require.Nil(t, cosiProtocol.Start())
+
+	_, err = getAndVerifySignature(cosiProtocol, publics, proposal, cosi.NewThresholdPolicy(nNodes-1))
+	require.Nil(t, err)
+	// The subleader didn't time out, which would take a sixth of the timeout.
+	require.True(t, time.Since(start) < cosiProtocol.Timeout/6)
+	subleader := cosiProtocol.subProtocols[0].Root().Children[0]
+	require.NotEqual(t, slow.ServerIdentity.ID, subleader.ServerIdentity.ID)
+}
+
 // Tests that the protocol throws errors with invalid configurations
 func TestProtocolErrors(t *testing.T) {
 	nodes := []int{1, 2, 24}
@@ -494,6 +586,133 @@ func TestProtocolRefuseOne(t *testing.T) {
 	}
 }
 
+// The nodes with more levels below them get a shorter commitment timeout.
+func TestDepthTimeout(t *testing.T) {
+	tree := binaryTree(31)
+	timeout := 200 * time.Millisecond
+	require.Equal(t, timeout/5, depthTimeout(tree, tree.Root, timeout))
+	require.Equal(t, timeout/4, depthTimeout(tree, tree.Root.Children[0], timeout))
+	leaf := tree.Root.Children[0].Children[0].Children[0].Children[0]
+	require.True(t, leaf.IsLeaf())
+	require.Equal(t, timeout, depthTimeout(tree, leaf, timeout))
+}
+
+// Simulates the collection of the commitments in a tree of depth 4 with a
+// latency of 20ms per hop, and counts how often the root gives up on one of
+// its children, with a fixed and with the adaptive timeout.
+func BenchmarkDepthTimeout(b *testing.B) {
+	tree := binaryTree(31)
+	timeout := 200 * time.Millisecond
+	hop := 20 * time.Millisecond
+	for _, bm := range []struct {
+		name        string
+		commitPhase func(n *onet.TreeNode) time.Duration
+	}{
+		{"fixed", func(n *onet.TreeNode) time.Duration {
+			return timeout / 2
+		}},
+		{"adaptive", func(n *onet.TreeNode) time.Duration {
+			if n.IsRoot() {
+				return timeout / 2
+			}
+			return depthTimeout(tree, n, timeout) / 2
+		}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			var rootTimeouts int
+			for i := 0; i < b.N; i++ {
+				rootTimeouts += simulateCommitments(tree.Root, 0, hop, bm.commitPhase)
+			}
+			b.ReportMetric(float64(rootTimeouts)/float64(b.N), "root-timeouts/op")
+		})
+	}
+}
+
+// simulateCommitments returns how many children of the node have their
+// commitment arriving after the node stopped waiting. The announcement
+// reaches the node at start.
+func simulateCommitments(node *onet.TreeNode, start, hop time.Duration,
+	commitPhase func(*onet.TreeNode) time.Duration) int {
+	var arrival func(n *onet.TreeNode, start time.Duration) time.Duration
+	arrival = func(n *onet.TreeNode, start time.Duration) time.Duration {
+		done := start
+		for _, c := range n.Children {
+			if a := arrival(c, start+hop); a > done {
+				done = a
+			}
+		}
+		if deadline := start + commitPhase(n); done > deadline {
+			done = deadline
+		}
+		return done + hop
+	}
+	var timeouts int
+	deadline := start + commitPhase(node)
+	for _, c := range node.Children {
+		if arrival(c, start+hop) > deadline {
+			timeouts++
+		}
+	}
+	return timeouts
+}
+
+// binaryTree returns a binary tree of n nodes that are not running.
+func binaryTree(n int) *onet.Tree {
+	ids := make([]*network.ServerIdentity, n)
+	for i := range ids {
+		kp := key.NewKeyPair(testSuite)
+		ids[i] = network.NewServerIdentity(kp.Public,
+			network.NewTCPAddress(fmt.Sprintf("127.0.0.1:%d", 2000+2*i)))
+	}
+	return onet.NewRoster(ids).GenerateBinaryTree()
+}
+
+// Only the nodes in the whitelist accept to sign.
+func TestProtocolWhitelist(t *testing.T) {
+	nNodes := 5
+	proposal := []byte{0xFF}
+	local := onet.NewLocalTest(testSuite)
+	defer local.CloseAll()
+	_, roster, tree := local.GenTree(nNodes, false)
+	publics := tree.Roster.Publics()
+
+	whitelist.Lock()
+	whitelist.ids = make(map[network.ServerIdentityID]bool)
+	whitelist.seen = make(map[network.ServerIdentityID]bool)
+	for i, si := range roster.List {
+		if i != 2 {
+			whitelist.ids[si.ID] = true
+		}
+	}
+	whitelist.Unlock()
+
+	pi, err := local.CreateProtocol(WhitelistProtocolName, tree)
+	require.Nil(t, err)
+	cosiProtocol := pi.(*FtCosi)
+	cosiProtocol.CreateProtocol = local.CreateProtocol
+	cosiProtocol.Msg = proposal
+	cosiProtocol.NSubtrees = 1
+	cosiProtocol.Timeout = defaultTimeout
+	cosiProtocol.Threshold = nNodes - 1
+	require.Nil(t, cosiProtocol.Start())
+
+	var signature []byte
+	select {
+	case signature = <-cosiProtocol.FinalSignature:
+	case <-time.After(defaultTimeout * 2):
+		t.Fatal("didn't get signature in time")
+	}
+	require.NotNil(t, verifySignature(signature, publics, proposal, cosi.CompletePolicy{}))
+	require.Nil(t, verifySignature(signature, publics, proposal, cosi.NewThresholdPolicy(nNodes-1)))
+
+	// Every node verified the proposal with its own identity.
+	whitelist.Lock()
+	defer whitelist.Unlock()
+	for _, si := range roster.List {
+		require.True(t, whitelist.seen[si.ID])
+	}
+}
+
 func getAndVerifySignature(cosiProtocol *FtCosi, publics []kyber.Point,
 	proposal []byte, policy cosi.Policy) ([]byte, error) {
 	var signature []byte
@@ -536,3 +755,16 @@ func refuse(n *onet.TreeNodeInstance, msg, data []byte) bool {
 	}
 	return true
 }
+
+var whitelist struct {
+	ids  map[network.ServerIdentityID]bool
+	seen map[network.ServerIdentityID]bool
+	sync.Mutex
+}
+
+func whitelisted(msg, data []byte, signer *network.ServerIdentity) bool {
+	whitelist.Lock()
+	defer whitelist.Unlock()
+	whitelist.seen[signer.ID] = true
+	return whitelist.ids[signer.ID]
+}
@@ -53,6 +53,7 @@ type Announcement struct {
 	Publics   []kyber.Point
 	Timeout   time.Duration
 	Threshold int
+	MaxFanout int
 }
 
 // StructAnnouncement just contains Announcement and the data necessary to identify and
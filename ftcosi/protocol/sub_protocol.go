@@ -11,6 +11,7 @@ import (
 	"go.dedis.ch/kyber/v3/sign/cosi"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
 )
 
 func init() {
@@ -24,11 +25,23 @@ type SubFtCosi struct {
 	Msg            []byte
 	Data           []byte
 	Timeout        time.Duration
+	DepthTimeout   time.Duration // time to collect the commitments, shorter for the nodes with more levels below
 	Threshold      int
 	stoppedOnce    sync.Once
 	verificationFn VerificationFn
 	suite          cosi.Suite
 
+	// VerificationTimeout is how long the verification function may run
+	// before the node refuses the proposal. It defaults to half the Timeout.
+	VerificationTimeout time.Duration
+	// MaxFanout is the maximum number of children a node waits on for their
+	// commitments. The other children are treated as abstaining. If it is 0,
+	// the nodes wait on all of their children.
+	MaxFanout int
+	// LastMetrics holds the metrics of the round, once the node collected
+	// the responses.
+	LastMetrics SubprotocolMetrics
+
 	// protocol/subprotocol channels
 	// these are used to communicate between the subprotocol and the main protocol
 	subleaderNotResponding chan bool
@@ -44,10 +57,27 @@ type SubFtCosi struct {
 	stopOnce sync.Once
 }
 
+// SubprotocolMetrics holds the duration of the phases of a round of the
+// subprotocol on one node.
+type SubprotocolMetrics struct {
+	// CommitmentCollectionDuration is the time from the announcement to the
+	// challenge.
+	CommitmentCollectionDuration time.Duration
+	// ChallengeDeliveryDuration is the time to send the challenge to the
+	// children.
+	ChallengeDeliveryDuration time.Duration
+	// ResponseCollectionDuration is the time from the challenge until all
+	// responses of the children are received.
+	ResponseCollectionDuration time.Duration
+	// NumCommitmentsReceived is the number of valid commitments received from
+	// the children.
+	NumCommitmentsReceived int
+}
+
 // NewDefaultSubProtocol is the default sub-protocol function used for registration
 // with an always-true verification.
 func NewDefaultSubProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
-	vf := func(a, b []byte) bool { return true }
+	vf := func(a, b []byte, c *network.ServerIdentity) bool { return true }
 	return NewSubFtCosi(n, vf, cothority.Suite)
 }
 
@@ -131,9 +161,20 @@ func (p *SubFtCosi) Dispatch() error {
 		// only have half of the time budget of the root.
 		p.Timeout /= 2
 	}
+	// The root always uses the whole timeout. The other nodes have to answer
+	// before their parent gives up, so the deeper their subtree, the earlier
+	// they stop waiting for commitments.
+	p.DepthTimeout = p.Timeout
+	if !p.IsRoot() {
+		p.DepthTimeout = depthTimeout(p.Tree(), p.TreeNode(), p.Timeout)
+	}
 	p.Msg = announcement.Msg
 	p.Data = announcement.Data
 	p.Threshold = announcement.Threshold
+	p.MaxFanout = announcement.MaxFanout
+	if p.VerificationTimeout == 0 {
+		p.VerificationTimeout = p.Timeout / 2
+	}
 
 	// verify that threshold is valid
 	maxThreshold := p.Tree().Size() - 1
@@ -147,7 +188,16 @@ func (p *SubFtCosi) Dispatch() error {
 	if !p.IsRoot() {
 		go func() {
 			log.Lvl3(p.ServerIdentity(), "starting verification in the background")
-			verificationOk := p.verificationFn(p.Msg, p.Data)
+			verified := make(chan bool, 1)
+			go func() {
+				verified <- p.verificationFn(p.Msg, p.Data, p.ServerIdentity())
+			}()
+			var verificationOk bool
+			select {
+			case verificationOk = <-verified:
+			case <-time.After(p.VerificationTimeout):
+				log.Warn(p.ServerIdentity(), "verification timed out after", p.VerificationTimeout, ", refusing")
+			}
 
 			var personalStructCommitment StructCommitment
 			var err error
@@ -171,18 +221,27 @@ func (p *SubFtCosi) Dispatch() error {
 	}
 
 	// ----- Commitment & Challenge -----
+	var metrics SubprotocolMetrics
+	commitmentStart := time.Now()
+	challengeDelivered := make(chan time.Duration, 1)
 
 	var commitments = make([]StructCommitment, 0)                  // list of received commitments
 	var challenge StructChallenge                                  // the challenge that will be received
 	var nodesCanCommit = make([]*onet.TreeNode, len(p.Children())) // the list of nodes that can commit. Nodes will be removed from the list once they commit.
 	var challengeMask *cosi.Mask                                   // the mask received in the challenge, set only if not root.
 	var childrenCanResponse = make([]*onet.TreeNode, 0)            // the list of children that can send a response. That is the list of children present in the challenge mask.
+	var seenCommitters = make(map[network.ServerIdentityID]bool)   // the nodes that already committed, only used if not root.
 
 	var refusalCount = 0            // number of refusal received. Will be used only for the subleader
 	var firstCommitmentSent = false // to avoid sending the quick commitment multiple times
 	var verificationDone = false    // to send the aggregate commitment only once this node has done its verification
 	var timedOut = false            // to refuse new commitments once it times out
-	commitTimeout := p.Timeout / 2
+	var childAnswers = 0            // number of commitments and refusals received from children, used only if not root
+	var waitedChildren = len(p.Children())
+	if p.MaxFanout > 0 && p.MaxFanout < waitedChildren {
+		waitedChildren = p.MaxFanout
+	}
+	commitTimeout := p.DepthTimeout / 2
 	responseTimeout := p.Timeout / 2
 	var t = time.After(commitTimeout) // the timeout for the commitment phase
 
@@ -205,16 +264,26 @@ loop:
 				break
 			}
 
+			// the root gets a quick and a final commitment from the subleader,
+			// the other nodes only one from every child.
+			if !p.IsRoot() && seenCommitters[commitment.ServerIdentity.ID] {
+				log.Warn(p.ServerIdentity(), "received a second Commitment from node", commitment.ServerIdentity,
+					", ignored")
+				break // discards it
+			}
 			if !isValidSender(commitment.TreeNode, nodesCanCommit...) {
 				log.Warn(p.ServerIdentity(), "received a Commitment from node", commitment.ServerIdentity,
 					"that is not in the list of nodes that can still commit, ignored")
 				break // discards it
 			}
+			seenCommitters[commitment.ServerIdentity.ID] = true
 			nodesCanCommit = remove(nodesCanCommit, commitment.TreeNode)
 
 			// if is own commitment
 			if commitment.TreeNode.ID.Equal(p.TreeNode().ID) {
 				verificationDone = true
+			} else {
+				metrics.NumCommitmentsReceived++
 			}
 
 			if p.IsRoot() {
@@ -240,6 +309,16 @@ loop:
 					break
 				}
 
+				// only wait on the first MaxFanout children
+				if !commitment.TreeNode.ID.Equal(p.TreeNode().ID) {
+					if childAnswers >= waitedChildren {
+						log.Lvl3(p.ServerIdentity(), "ignoring commitment of", commitment.ServerIdentity,
+							"after", waitedChildren, "children")
+						break
+					}
+					childAnswers++
+				}
+
 				// checks if commitment is a refusal or acceptance
 				if commitment.CoSiCommitment.Equal(p.suite.Point().Null()) { // refusal
 					refusalCount++
@@ -253,15 +332,15 @@ loop:
 					commitments = append(commitments, commitment)
 				}
 
-				thresholdRefusal := (1 + len(p.Children()) - p.Threshold) + 1
+				thresholdRefusal := (1 + waitedChildren - p.Threshold) + 1
 
 				// checks if threshold is reached or unreachable
 				quickAnswer := !firstCommitmentSent &&
 					(len(commitments) >= p.Threshold || // quick valid answer
 						refusalCount >= thresholdRefusal) // quick refusal answer
 
-				// checks if every child and himself committed
-				finalAnswer := len(commitments)+refusalCount == len(p.Children())+1
+				// checks if every waited child and himself committed
+				finalAnswer := len(commitments)+refusalCount == waitedChildren+1
 
 				if (quickAnswer || finalAnswer) && verificationDone {
 
@@ -325,10 +404,13 @@ loop:
 			// send challenge to children
 			childrenToSendChallenge := make([]*onet.TreeNode, len(childrenCanResponse))
 			copy(childrenToSendChallenge, childrenCanResponse) // copy to avoid data race
+			metrics.CommitmentCollectionDuration = time.Since(commitmentStart)
 			go func() {
+				start := time.Now()
 				if errs := p.multicastParallel(&challenge.Challenge, childrenToSendChallenge...); len(errs) > 0 {
 					log.Error(p.ServerIdentity(), errs)
 				}
+				challengeDelivered <- time.Since(start)
 			}()
 
 			break loop
@@ -354,6 +436,7 @@ loop:
 	responses := make([]StructResponse, 0)
 
 	// Second half of our time budget for the responses.
+	responseStart := time.Now()
 	timeout := time.After(responseTimeout)
 	for len(childrenCanResponse) > 0 {
 		select {
@@ -375,6 +458,10 @@ loop:
 		}
 	}
 	log.Lvl3(p.ServerIdentity(), "received all", len(responses), "response(s)")
+	metrics.ResponseCollectionDuration = time.Since(responseStart)
+	// the children responded, so the challenge has been sent
+	metrics.ChallengeDeliveryDuration = <-challengeDelivered
+	p.LastMetrics = metrics
 
 	// if root, send response to super-protocol and finish
 	if p.IsRoot() {
@@ -499,7 +586,7 @@ func (p *SubFtCosi) Start() error {
 
 	announcement := StructAnnouncement{
 		p.TreeNode(),
-		Announcement{p.Msg, p.Data, p.Publics, p.Timeout, p.Threshold},
+		Announcement{p.Msg, p.Data, p.Publics, p.Timeout, p.Threshold, p.MaxFanout},
 	}
 	p.ChannelAnnouncement <- announcement
 	return nil
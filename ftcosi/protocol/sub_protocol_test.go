@@ -0,0 +1,264 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3/sign/cosi"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+)
+
+const DispatchSubProtocolName = "DispatchSubProtocol"
+const DuplicateSubProtocolName = "DuplicateSubProtocol"
+const SlowSubProtocolName = "SlowSubProtocol"
+
+func init() {
+	onet.GlobalProtocolRegister(DispatchSubProtocolName, newDispatchSubFtCosi(
+		func(a, b []byte, c *network.ServerIdentity) bool { return true }, 0))
+	onet.GlobalProtocolRegister(SlowSubProtocolName, newDispatchSubFtCosi(
+		func(a, b []byte, c *network.ServerIdentity) bool {
+			<-slowVerification
+			return true
+		}, 10*time.Millisecond))
+	onet.GlobalProtocolRegister(DuplicateSubProtocolName, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		vf := func(a, b []byte, c *network.ServerIdentity) bool {
+			if !n.IsRoot() && !n.IsLeaf() {
+				// Let the commitments of the leaf arrive first.
+				time.Sleep(100 * time.Millisecond)
+			}
+			return true
+		}
+		pi, err := NewSubFtCosi(n, vf, cothority.Suite)
+		if err != nil {
+			return nil, err
+		}
+		return &duplicateSubFtCosi{pi.(*SubFtCosi)}, nil
+	})
+}
+
+// slowVerification blocks the verification of SlowSubProtocol until it is
+// closed.
+var slowVerification chan bool
+
+// dispatchSubFtCosi sends the result of Dispatch to a channel.
+type dispatchSubFtCosi struct {
+	*SubFtCosi
+	dispatched chan error
+}
+
+func newDispatchSubFtCosi(vf VerificationFn, verificationTimeout time.Duration) onet.NewProtocol {
+	return func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		pi, err := NewSubFtCosi(n, vf, cothority.Suite)
+		if err != nil {
+			return nil, err
+		}
+		pi.(*SubFtCosi).VerificationTimeout = verificationTimeout
+		return &dispatchSubFtCosi{pi.(*SubFtCosi), make(chan error, 1)}, nil
+	}
+}
+
+func (p *dispatchSubFtCosi) Dispatch() error {
+	err := p.SubFtCosi.Dispatch()
+	p.dispatched <- err
+	return err
+}
+
+// duplicateSubFtCosi is a leaf that sends a refusal and then an acceptance.
+type duplicateSubFtCosi struct {
+	*SubFtCosi
+}
+
+func (p *duplicateSubFtCosi) Dispatch() error {
+	if !p.IsLeaf() {
+		return p.SubFtCosi.Dispatch()
+	}
+	defer p.Done()
+	announcement, ok := <-p.ChannelAnnouncement
+	if !ok {
+		return nil
+	}
+	p.Publics = announcement.Publics
+	for _, accepts := range []bool{false, true} {
+		_, commitment, err := p.getCommitment(accepts)
+		if err != nil {
+			return err
+		}
+		if err := p.SendToParent(&commitment.Commitment); err != nil {
+			return err
+		}
+	}
+	<-p.ChannelChallenge
+	return nil
+}
+
+// The root of the subprotocol reports a subleader that doesn't send its
+// commitment, and stops without error.
+func TestSubFtCosi_SubleaderNotResponding(t *testing.T) {
+	local := onet.NewLocalTest(testSuite)
+	defer local.CloseAll()
+	servers, roster, _ := local.GenTree(3, false)
+	tree, err := genSubtree(roster, []int{0, 1, 2})
+	require.Nil(t, err)
+
+	// The subleader never gets the announcement.
+	servers[1].Pause()
+	defer servers[1].Unpause()
+
+	pi, err := local.CreateProtocol(DispatchSubProtocolName, tree)
+	require.Nil(t, err)
+	p := pi.(*dispatchSubFtCosi)
+	p.Publics = roster.Publics()
+	p.Msg = []byte{0xFF}
+	p.Data = []byte{}
+	timeout := 50 * time.Millisecond
+	p.Timeout = timeout
+	p.Threshold = 2
+	require.Nil(t, p.Start())
+
+	select {
+	case <-p.subleaderNotResponding:
+	case <-time.After(2 * timeout):
+		t.Fatal("subleader failure not reported in time")
+	}
+	select {
+	case err := <-p.dispatched:
+		require.Nil(t, err)
+	case <-time.After(2 * timeout):
+		t.Fatal("Dispatch didn't return")
+	}
+}
+
+// A second commitment from the same child is ignored by the subleader.
+func TestSubFtCosi_DuplicateCommitment(t *testing.T) {
+	local := onet.NewLocalTest(testSuite)
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(3, false)
+	tree, err := genSubtree(roster, []int{0, 1, 2})
+	require.Nil(t, err)
+
+	pi, err := local.CreateProtocol(DuplicateSubProtocolName, tree)
+	require.Nil(t, err)
+	p := pi.(*duplicateSubFtCosi)
+	p.Publics = roster.Publics()
+	p.Msg = []byte{0xFF}
+	p.Data = []byte{}
+	p.Timeout = defaultTimeout
+	p.Threshold = 2
+	require.Nil(t, p.Start())
+	defer p.HandleStop(StructStop{p.TreeNode(), Stop{}})
+
+	// The subleader only counts the refusal of the leaf, and its own
+	// commitment.
+	select {
+	case com := <-p.subCommitment:
+		require.Equal(t, 1, com.NRefusal)
+		mask, err := cosi.NewMask(testSuite, roster.Publics(), nil)
+		require.Nil(t, err)
+		require.Nil(t, mask.SetMask(com.Mask))
+		require.Equal(t, 1, mask.CountEnabled())
+	case <-time.After(defaultTimeout):
+		t.Fatal("didn't get the commitment of the subleader")
+	}
+}
+
+// Nodes with a verification function that is too slow refuse the proposal,
+// and the protocol terminates before the verification returns.
+func TestSubFtCosi_VerificationTimeout(t *testing.T) {
+	local := onet.NewLocalTest(testSuite)
+	defer local.CloseAll()
+	slowVerification = make(chan bool)
+	defer close(slowVerification)
+	_, roster, _ := local.GenTree(3, false)
+	tree, err := genSubtree(roster, []int{0, 1, 2})
+	require.Nil(t, err)
+
+	pi, err := local.CreateProtocol(SlowSubProtocolName, tree)
+	require.Nil(t, err)
+	p := pi.(*dispatchSubFtCosi)
+	p.Publics = roster.Publics()
+	p.Msg = []byte{0xFF}
+	p.Data = []byte{}
+	timeout := 400 * time.Millisecond
+	p.Timeout = timeout
+	p.Threshold = 2
+	require.Nil(t, p.Start())
+
+	select {
+	case com := <-p.subCommitment:
+		// The refusals come before the subleader stops waiting for commitments.
+		require.True(t, com.NRefusal > 0)
+	case <-time.After(timeout):
+		t.Fatal("didn't get the refusals in time")
+	}
+	p.HandleStop(StructStop{p.TreeNode(), Stop{}})
+	select {
+	case err := <-p.dispatched:
+		require.Nil(t, err)
+	case <-time.After(timeout):
+		t.Fatal("Dispatch didn't return")
+	}
+}
+
+// The root of the subprotocol records the duration of every phase of a round.
+func TestSubFtCosi_Metrics(t *testing.T) {
+	nNodes := 5
+	proposal := []byte{0xFF}
+	local := onet.NewLocalTest(testSuite)
+	defer local.CloseAll()
+	_, _, tree := local.GenTree(nNodes, false)
+
+	pi, err := local.CreateProtocol(DefaultProtocolName, tree)
+	require.Nil(t, err)
+	cosiProtocol := pi.(*FtCosi)
+	cosiProtocol.CreateProtocol = local.CreateProtocol
+	cosiProtocol.Msg = proposal
+	cosiProtocol.NSubtrees = 1
+	cosiProtocol.Timeout = defaultTimeout
+	cosiProtocol.Threshold = nNodes
+	require.Nil(t, cosiProtocol.Start())
+	_, err = getAndVerifySignature(cosiProtocol, tree.Roster.Publics(), proposal, cosi.CompletePolicy{})
+	require.Nil(t, err)
+
+	metrics := cosiProtocol.subProtocols[0].LastMetrics
+	require.True(t, metrics.CommitmentCollectionDuration > 0)
+	require.True(t, metrics.ChallengeDeliveryDuration > 0)
+	require.True(t, metrics.ResponseCollectionDuration > 0)
+	require.Equal(t, 1, metrics.NumCommitmentsReceived)
+}
+
+// The subleader only aggregates the commitments of the first MaxFanout
+// children.
+func TestSubFtCosi_MaxFanout(t *testing.T) {
+	local := onet.NewLocalTest(testSuite)
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(9, false)
+	tree, err := genSubtree(roster, []int{0, 1, 2, 3, 4, 5, 6, 7, 8})
+	require.Nil(t, err)
+	require.Equal(t, 7, len(tree.Root.Children[0].Children))
+
+	pi, err := local.CreateProtocol(DispatchSubProtocolName, tree)
+	require.Nil(t, err)
+	p := pi.(*dispatchSubFtCosi)
+	p.Publics = roster.Publics()
+	p.Msg = []byte{0xFF}
+	p.Data = []byte{}
+	p.Timeout = defaultTimeout
+	p.Threshold = 4
+	p.MaxFanout = 3
+	require.Nil(t, p.Start())
+	defer p.HandleStop(StructStop{p.TreeNode(), Stop{}})
+
+	select {
+	case com := <-p.subCommitment:
+		mask, err := cosi.NewMask(testSuite, roster.Publics(), nil)
+		require.Nil(t, err)
+		require.Nil(t, mask.SetMask(com.Mask))
+		// The three children and the subleader itself.
+		require.Equal(t, 4, mask.CountEnabled())
+	case <-time.After(defaultTimeout):
+		t.Fatal("didn't get the commitment of the subleader")
+	}
+}
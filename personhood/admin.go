@@ -0,0 +1,216 @@
+package personhood
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/skipchain"
+)
+
+// AdminDarcEnv is the environment variable holding the ByzCoin ID and the
+// admin darc ID of the service, both in hex and separated by a ':'.
+const AdminDarcEnv = "PERSONHOOD_ADMIN_DARC"
+
+// AdminAction is the rule of the admin darc that allows to call the protected
+// methods of the service.
+const AdminAction = darc.Action("invoke:personhood.admin")
+
+// AdminRequestWindow is how far the timestamp of a request to a protected
+// method may be from the time of the service. It is shorter than
+// NonceLifetime, so a request cannot be replayed once its nonce is removed.
+const AdminRequestWindow = 5 * time.Minute
+
+// ErrNotAdmin is returned by the protected methods if the caller is not
+// allowed by the admin darc.
+var ErrNotAdmin = errors.New("caller is not allowed by the admin darc")
+
+// ErrAdminDarcChanged is returned by the protected methods if the proof
+// doesn't show the latest version of the admin darc.
+var ErrAdminDarcChanged = errors.New("the proof doesn't hold the latest admin darc")
+
+// loadAdminDarc sets AdminByzCoinID and AdminDarcID from AdminDarcEnv.
+func (s *Service) loadAdminDarc() error {
+	env := os.Getenv(AdminDarcEnv)
+	if env == "" {
+		return nil
+	}
+	ids := strings.Split(env, ":")
+	if len(ids) != 2 {
+		return errors.New(AdminDarcEnv + " must be 'byzcoinID:darcID'")
+	}
+	bcID, err := hex.DecodeString(ids[0])
+	if err != nil {
+		return err
+	}
+	darcID, err := hex.DecodeString(ids[1])
+	if err != nil {
+		return err
+	}
+	s.AdminByzCoinID = skipchain.SkipBlockID(bcID)
+	s.AdminDarcID = darc.ID(darcID)
+	return nil
+}
+
+// AdminMessage returns the message that has to be signed to call the
+// protected method cmd with the given proof of the admin darc. The nonce
+// must be unique, and the timestamp, in unix seconds, must be within
+// AdminRequestWindow of the time of the service.
+func AdminMessage(cmd string, proof byzcoin.Proof, nonce []byte, timestamp int64) []byte {
+	h := sha256.New()
+	h.Write([]byte(cmd))
+	h.Write(proof.Latest.Hash)
+	h.Write(nonce)
+	tsBuf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(tsBuf, uint64(timestamp))
+	h.Write(tsBuf)
+	return h.Sum(nil)
+}
+
+// verifyAdminRequest returns nil if the request to the protected method cmd
+// is fresh, and signed by an identity allowed by the admin darc. The nonce of
+// the request is then marked as used.
+func (s *Service) verifyAdminRequest(cmd string, proof byzcoin.Proof, nonce []byte,
+	timestamp int64, sig darc.Signature) error {
+	if len(nonce) == 0 {
+		return errors.New("a nonce is required")
+	}
+	now := time.Now()
+	age := now.Sub(time.Unix(timestamp, 0))
+	if age > AdminRequestWindow || age < -AdminRequestWindow {
+		return errors.New("the timestamp of the request is too far from the time of the service")
+	}
+	err := s.verifyAdminAuth(proof, AdminMessage(cmd, proof, nonce, timestamp), sig)
+	if err != nil {
+		return err
+	}
+	return s.storage.useNonce(nonce, now)
+}
+
+// verifyAdminAuth returns nil if the proof shows the latest version of the
+// admin darc of the service, and the signer of msg is allowed by its
+// AdminAction rule.
+func (s *Service) verifyAdminAuth(proof byzcoin.Proof, msg []byte, sig darc.Signature) error {
+	if s.AdminDarcID == nil {
+		return errors.New("no admin darc configured")
+	}
+	if err := proof.Verify(s.AdminByzCoinID); err != nil {
+		return err
+	}
+	key, buf, cid, _, err := proof.KeyValue()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(key, s.AdminDarcID) || cid != byzcoin.ContractDarcID {
+		return errors.New("proof is not for the admin darc")
+	}
+	if err := s.verifyLatestAdminDarc(proof, buf); err != nil {
+		return err
+	}
+	d, err := darc.NewFromProtobuf(buf)
+	if err != nil {
+		return err
+	}
+	expr := d.Rules.Get(AdminAction)
+	if expr == nil {
+		return ErrNotAdmin
+	}
+	if err := sig.Signer.Verify(msg, sig.Signature); err != nil {
+		return ErrNotAdmin
+	}
+	getDarc := func(string, bool) *darc.Darc { return nil }
+	if err := darc.EvalExpr(expr, getDarc, sig.Signer.String()); err != nil {
+		return ErrNotAdmin
+	}
+	return nil
+}
+
+// verifyLatestAdminDarc fetches the admin darc from the ledger and returns
+// ErrAdminDarcChanged if it differs from the darc in the proof, so that an
+// admin removed from the darc cannot use an old proof.
+func (s *Service) verifyLatestAdminDarc(proof byzcoin.Proof, darcBuf []byte) error {
+	if proof.Latest.Roster == nil {
+		return errors.New("proof has no roster")
+	}
+	cl := s.NewByzCoinClient(s.AdminByzCoinID, *proof.Latest.Roster)
+	reply, err := cl.GetProof(s.AdminDarcID)
+	if err != nil {
+		return errors.New("couldn't get the admin darc: " + err.Error())
+	}
+	if err := reply.Proof.Verify(s.AdminByzCoinID); err != nil {
+		return err
+	}
+	_, buf, _, _, err := reply.Proof.KeyValue()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(buf, darcBuf) {
+		return ErrAdminDarcChanged
+	}
+	return nil
+}
+
+// WipeParties removes all parties linked to the service. It needs to be
+// signed by an identity allowed by the admin darc.
+func (s *Service) WipeParties(wp *WipeParties) (*StringReply, error) {
+	err := s.verifyAdminRequest("WipeParties", wp.Proof, wp.Nonce, wp.Timestamp, wp.Signature)
+	if err != nil {
+		return nil, err
+	}
+	s.storage.Lock()
+	s.storage.Parties = make(map[string]*Party)
+	s.storage.PartyNames = make(map[string]byzcoin.InstanceID)
+	s.storage.Unlock()
+	return &StringReply{}, s.save()
+}
+
+// WipeMessages removes all messages and their readers, except the messages
+// being read right now. It needs to be signed by an identity allowed by the
+// admin darc.
+func (s *Service) WipeMessages(wm *WipeMessages) (*StringReply, error) {
+	err := s.verifyAdminRequest("WipeMessages", wm.Proof, wm.Nonce, wm.Timestamp, wm.Signature)
+	if err != nil {
+		return nil, err
+	}
+	s.storage.deleteMessages(func(*Message) bool { return true })
+	return &StringReply{}, s.save()
+}
+
+// ReloadConfig reads the configuration again from the file in ConfigEnv,
+// without restarting the service. The admin darc is not reloaded, as it is
+// given in AdminDarcEnv. It needs to be signed by an identity allowed by the
+// admin darc.
+func (s *Service) ReloadConfig(rc *ReloadConfig) (*StringReply, error) {
+	err := s.verifyAdminRequest("ReloadConfig", rc.Proof, rc.Nonce, rc.Timestamp, rc.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv(ConfigEnv) == "" {
+		return nil, errors.New("no configuration file given in " + ConfigEnv)
+	}
+	if err := s.loadConfig(); err != nil {
+		return nil, errors.New("couldn't load configuration: " + err.Error())
+	}
+	return &StringReply{}, nil
+}
+
+// GetExpiredMessages returns the messages that expired, but are not removed
+// yet. It needs to be signed by an identity allowed by the admin darc.
+func (s *Service) GetExpiredMessages(gem *GetExpiredMessages) (*GetExpiredMessagesReply, error) {
+	err := s.verifyAdminRequest("GetExpiredMessages", gem.Proof, gem.Nonce, gem.Timestamp, gem.Signature)
+	if err != nil {
+		return nil, err
+	}
+	reply := &GetExpiredMessagesReply{}
+	for _, msg := range s.storage.expiredMessages(uint64(time.Now().Unix())) {
+		reply.Messages = append(reply.Messages, *msg)
+	}
+	return reply, nil
+}
@@ -4,9 +4,15 @@ package personhood
 // calls are made from javascript.
 
 import (
+	"time"
+
 	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/kyber/v3/util/random"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
 )
 
 // Client is a structure to communicate with the personhood
@@ -29,3 +35,235 @@ func (c *Client) LinkPoP(si *network.ServerIdentity, p Party) error {
 	}
 	return nil
 }
+
+// GetParty returns the party with the given instance ID. If it is not linked
+// to the service, it is fetched from the given ledger, which must hold another
+// linked party.
+func (c *Client) GetParty(si *network.ServerIdentity, gp *GetParty) (*GetPartyReply, error) {
+	reply := &GetPartyReply{}
+	err := c.SendProtobuf(si, gp, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// PartySearch returns the linked parties whose name contains the substring,
+// without case.
+func (c *Client) PartySearch(si *network.ServerIdentity, substring string) ([]Party, error) {
+	reply := &PartySearchReply{}
+	err := c.SendProtobuf(si, &PartySearch{NameSubstring: substring}, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Parties, nil
+}
+
+// GetMetrics returns the counters of the requests handled by the service.
+func (c *Client) GetMetrics(si *network.ServerIdentity) (*ServiceMetrics, error) {
+	reply := &ServiceMetrics{}
+	err := c.SendProtobuf(si, &GetMetrics{}, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// RegisterQuestionnaire stores a new questionnaire in the service.
+func (c *Client) RegisterQuestionnaire(si *network.ServerIdentity, q Questionnaire) error {
+	return c.SendProtobuf(si, &RegisterQuestionnaire{Questionnaire: q}, nil)
+}
+
+// ListQuestionnaires returns at most number questionnaires, starting with the
+// one at index start when sorted by balance.
+func (c *Client) ListQuestionnaires(si *network.ServerIdentity, start, number int) ([]Questionnaire, error) {
+	reply := &ListQuestionnairesReply{}
+	err := c.SendProtobuf(si, &ListQuestionnaires{Start: start, Number: number}, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Questionnaires, nil
+}
+
+// AnswerQuestionnaire sends the replies of one account to the questionnaire.
+func (c *Client) AnswerQuestionnaire(si *network.ServerIdentity, aq *AnswerQuestionnaire) error {
+	return c.SendProtobuf(si, aq, nil)
+}
+
+// DeleteQuestionnaire removes the questionnaire. The request must be signed
+// by the author of the questionnaire.
+func (c *Client) DeleteQuestionnaire(si *network.ServerIdentity, dq *DeleteQuestionnaire) error {
+	return c.SendProtobuf(si, dq, nil)
+}
+
+// GetQuestionnaireResults returns how many users chose each question of the
+// questionnaire, and how many users answered it.
+func (c *Client) GetQuestionnaireResults(si *network.ServerIdentity, questID []byte) (*QuestionnaireResultsReply, error) {
+	reply := &QuestionnaireResultsReply{}
+	err := c.SendProtobuf(si, &GetQuestionnaireResults{QuestID: questID}, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// TopupQuestionnaire adds coins to the balance of a questionnaire.
+func (c *Client) TopupQuestionnaire(si *network.ServerIdentity, questID []byte, topup uint64) error {
+	return c.SendProtobuf(si, &TopupQuestionnaire{QuestID: questID, Topup: topup}, nil)
+}
+
+// SendMessage stores a new message in the service.
+func (c *Client) SendMessage(si *network.ServerIdentity, msg Message) error {
+	return c.SendProtobuf(si, &SendMessage{Message: msg}, nil)
+}
+
+// SendEscrowedMessage stores a new message in a service with an escrow coin.
+// The latest block of the proof must hold the transfer of the balance of the
+// message to the escrow coin, with the argument "msgID" set to the message ID.
+func (c *Client) SendEscrowedMessage(si *network.ServerIdentity, msg Message, proof byzcoin.Proof) error {
+	buf, err := protobuf.Encode(&proof)
+	if err != nil {
+		return err
+	}
+	sm := &SendMessage{Message: msg}
+	sm.TransferProof = buf
+	return c.SendProtobuf(si, sm, nil)
+}
+
+// ListMessages returns the subjects and IDs of the most valuable messages.
+func (c *Client) ListMessages(si *network.ServerIdentity, lm *ListMessages) (*ListMessagesReply, error) {
+	reply := &ListMessagesReply{}
+	err := c.SendProtobuf(si, lm, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// ReadMessage returns the full message and sends the reward to the reader, if
+// it's the first time this reader reads the message.
+func (c *Client) ReadMessage(si *network.ServerIdentity, rm *ReadMessage) (*ReadMessageReply, error) {
+	reply := &ReadMessageReply{}
+	err := c.SendProtobuf(si, rm, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// GetThread returns the messages of the thread started by the message with
+// the ID threadID, sorted by date.
+func (c *Client) GetThread(si *network.ServerIdentity, threadID []byte) ([]Message, error) {
+	reply := &GetThreadReply{}
+	err := c.SendProtobuf(si, &GetThread{ThreadID: threadID}, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Messages, nil
+}
+
+// GetMessage returns the full message without reading it, so no reward is
+// sent.
+func (c *Client) GetMessage(si *network.ServerIdentity, msgID []byte) (*Message, error) {
+	reply := &GetMessageReply{}
+	err := c.SendProtobuf(si, &GetMessage{MsgID: msgID}, reply)
+	if err != nil {
+		return nil, err
+	}
+	return &reply.Message, nil
+}
+
+// TopupMessage adds coins to the balance of a message.
+func (c *Client) TopupMessage(si *network.ServerIdentity, msgID []byte, amount uint64) error {
+	return c.SendProtobuf(si, &TopupMessage{MsgID: msgID, Amount: amount}, nil)
+}
+
+// TopupEscrowedMessage adds coins to the balance of a message in a service
+// with an escrow coin. The latest block of the proof must hold the transfer of
+// the amount to the escrow coin, with the argument "msgID" set to msgID.
+func (c *Client) TopupEscrowedMessage(si *network.ServerIdentity, msgID []byte, amount uint64,
+	proof byzcoin.Proof) error {
+	buf, err := protobuf.Encode(&proof)
+	if err != nil {
+		return err
+	}
+	return c.SendProtobuf(si, &TopupMessage{MsgID: msgID, Amount: amount, TransferProof: buf}, nil)
+}
+
+// WipeParties removes all parties of the service. The proof must show the
+// admin darc of the service, and the signer must be allowed by its
+// AdminAction rule.
+func (c *Client) WipeParties(si *network.ServerIdentity, proof byzcoin.Proof, signer darc.Signer) error {
+	nonce, timestamp := adminNonce()
+	sig, err := signer.Sign(AdminMessage("WipeParties", proof, nonce, timestamp))
+	if err != nil {
+		return err
+	}
+	return c.SendProtobuf(si, &WipeParties{
+		Proof:     proof,
+		Signature: darc.Signature{Signature: sig, Signer: signer.Identity()},
+		Nonce:     nonce,
+		Timestamp: timestamp,
+	}, nil)
+}
+
+// WipeMessages removes all messages from the service. The proof must show the
+// admin darc of the service, and the signer must be allowed by its
+// AdminAction rule.
+func (c *Client) WipeMessages(si *network.ServerIdentity, proof byzcoin.Proof, signer darc.Signer) error {
+	nonce, timestamp := adminNonce()
+	sig, err := signer.Sign(AdminMessage("WipeMessages", proof, nonce, timestamp))
+	if err != nil {
+		return err
+	}
+	return c.SendProtobuf(si, &WipeMessages{
+		Proof:     proof,
+		Signature: darc.Signature{Signature: sig, Signer: signer.Identity()},
+		Nonce:     nonce,
+		Timestamp: timestamp,
+	}, nil)
+}
+
+// GetExpiredMessages returns the messages that expired, but are not removed
+// yet. The proof must show the admin darc of the service, and the signer must
+// be allowed by its AdminAction rule.
+func (c *Client) GetExpiredMessages(si *network.ServerIdentity, proof byzcoin.Proof, signer darc.Signer) ([]Message, error) {
+	nonce, timestamp := adminNonce()
+	sig, err := signer.Sign(AdminMessage("GetExpiredMessages", proof, nonce, timestamp))
+	if err != nil {
+		return nil, err
+	}
+	reply := &GetExpiredMessagesReply{}
+	err = c.SendProtobuf(si, &GetExpiredMessages{
+		Proof:     proof,
+		Signature: darc.Signature{Signature: sig, Signer: signer.Identity()},
+		Nonce:     nonce,
+		Timestamp: timestamp,
+	}, reply)
+	return reply.Messages, err
+}
+
+// ReloadConfig has the service read its configuration file again. The proof
+// must show the admin darc of the service, and the signer must be allowed by
+// its AdminAction rule.
+func (c *Client) ReloadConfig(si *network.ServerIdentity, proof byzcoin.Proof, signer darc.Signer) error {
+	nonce, timestamp := adminNonce()
+	sig, err := signer.Sign(AdminMessage("ReloadConfig", proof, nonce, timestamp))
+	if err != nil {
+		return err
+	}
+	return c.SendProtobuf(si, &ReloadConfig{
+		Proof:     proof,
+		Signature: darc.Signature{Signature: sig, Signer: signer.Identity()},
+		Nonce:     nonce,
+		Timestamp: timestamp,
+	}, nil)
+}
+
+// adminNonce returns a random nonce and the current time for a request to a
+// protected method.
+func adminNonce() ([]byte, int64) {
+	nonce := make([]byte, 32)
+	random.Bytes(nonce, random.New())
+	return nonce, time.Now().Unix()
+}
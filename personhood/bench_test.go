@@ -0,0 +1,91 @@
+package personhood
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"testing"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/sign/anon"
+	"go.dedis.ch/kyber/v3/util/key"
+	"go.dedis.ch/onet/v3"
+)
+
+// BenchmarkLRSVerify measures how many linkable ring signatures over the
+// attendees of a party can be verified per second, depending on the number
+// of attendees.
+func BenchmarkLRSVerify(b *testing.B) {
+	suite := cothority.Suite.(anon.Suite)
+	msg := []byte("mine")
+	scope := []byte("party-instance")
+	for _, size := range []int{10, 100, 1000, 10000} {
+		ring := make(anon.Set, size)
+		var priv kyber.Scalar
+		for i := range ring {
+			kp := key.NewKeyPair(cothority.Suite)
+			ring[i] = kp.Public
+			if i == size/2 {
+				priv = kp.Private
+			}
+		}
+		sig := anon.Sign(suite, msg, ring, scope, size/2, priv)
+
+		b.Run(fmt.Sprintf("Attendees_%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := anon.Verify(suite, msg, ring, scope, sig); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkListMessages compares listing the first page of messages using
+// the score index with sorting all messages by their score.
+func BenchmarkListMessages(b *testing.B) {
+	local := onet.NewLocalTest(cothority.Suite)
+	defer local.CloseAll()
+	servers, _, _ := local.GenTree(1, true)
+	s := local.GetServices(servers, templateID)[0].(*Service)
+	lm := &ListMessages{Number: 20}
+	for _, size := range []int{1000, 10000, 100000} {
+		for i := len(s.storage.Messages); i < size; i++ {
+			id := make([]byte, 8)
+			binary.LittleEndian.PutUint64(id, uint64(i))
+			s.storage.addMessage(&Message{
+				ID:      id,
+				Author:  byzcoin.NewInstanceID(id),
+				Balance: uint64(10 + i%1000),
+				Reward:  uint64(1 + i%10),
+			})
+		}
+
+		b.Run(fmt.Sprintf("Sort_%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var msgs []Message
+				for _, msg := range s.storage.Messages {
+					if msg.Balance >= msg.Reward {
+						msgs = append(msgs, *msg)
+					}
+				}
+				sort.Slice(msgs, func(i, j int) bool {
+					return msgs[i].Score() > msgs[j].Score()
+				})
+				msgs = msgs[:lm.Number]
+			}
+		})
+		b.Run(fmt.Sprintf("Index_%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.ListMessages(lm); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package personhood
+
+import (
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/onet/v3"
+)
+
+// ConfigEnv is the environment variable holding the path of the toml file
+// with the ServiceConfig of the service.
+const ConfigEnv = "PERSONHOOD_CONFIG"
+
+// ErrAccessDenied is returned to clients whose IP is refused by the
+// IPAllowList or IPDenyList of the service.
+var ErrAccessDenied = errors.New("access denied for this IP")
+
+// DefaultKeepAlive is the interval between two pings on the websockets of
+// the service, if ServiceConfig.KeepAlive is not set.
+const DefaultKeepAlive = 30 * time.Second
+
+// DefaultMessageLifetime is the time after which a message expires, if it is
+// sent without Message.ExpiresAt.
+const DefaultMessageLifetime = 30 * 24 * time.Hour
+
+// NonceLifetime is the time during which a nonce cannot be used again.
+const NonceLifetime = time.Hour
+
+// RequestWindow is how far the timestamp of a request with a nonce may be
+// from the time of the service. It is shorter than NonceLifetime, so a request
+// cannot be replayed once its nonce is removed.
+const RequestWindow = 5 * time.Minute
+
+// DefaultRootAuthorRewardFraction is the part of the reward of a reply that
+// goes to the author of the root message, if
+// ServiceConfig.RootAuthorRewardFraction is not set.
+const DefaultRootAuthorRewardFraction = 0.3
+
+// ServiceConfig holds the configuration of the personhood service.
+type ServiceConfig struct {
+	// IPAllowList, if not empty, holds the only networks that can call the
+	// service.
+	IPAllowList []net.IPNet
+	// IPDenyList holds the networks that cannot call the service. It takes
+	// precedence over IPAllowList.
+	IPDenyList []net.IPNet
+	// KeepAlive is the interval between two pings sent on the websockets of
+	// the service, so that idle connections are not dropped by firewalls
+	// and proxies. If it is 0, DefaultKeepAlive is used.
+	KeepAlive time.Duration
+	// RootAuthorRewardFraction is the part of the reward of a reply that is
+	// sent to the author of the root message, the rest going to the reader.
+	// If it is 0, DefaultRootAuthorRewardFraction is used.
+	RootAuthorRewardFraction float64
+	// EscrowCoinIID, if set, is the coin instance the authors transfer the
+	// balance of their messages to. SendMessage and TopupMessage then need a
+	// TransferProof of a transfer to the escrow for the message.
+	EscrowCoinIID byzcoin.InstanceID
+}
+
+// WithKeepAlive returns a copy of the configuration with the given interval
+// between two pings.
+func (sc ServiceConfig) WithKeepAlive(interval time.Duration) ServiceConfig {
+	sc.KeepAlive = interval
+	return sc
+}
+
+func (sc ServiceConfig) keepAlive() time.Duration {
+	if sc.KeepAlive <= 0 {
+		return DefaultKeepAlive
+	}
+	return sc.KeepAlive
+}
+
+// splitReward returns the part of the reward of a reply that goes to the
+// reader and the part that goes to the author of the root message.
+func (sc ServiceConfig) splitReward(reward uint64) (reader, root uint64) {
+	fraction := sc.RootAuthorRewardFraction
+	if fraction <= 0 {
+		fraction = DefaultRootAuthorRewardFraction
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	root = uint64(float64(reward) * fraction)
+	return reward - root, root
+}
+
+// LoadACLFromCIDRStrings returns a ServiceConfig with the allow- and deny-lists
+// given in CIDR notation, like "127.0.0.0/8".
+func LoadACLFromCIDRStrings(allow, deny []string) (ServiceConfig, error) {
+	var sc ServiceConfig
+	parse := func(cidrs []string) ([]net.IPNet, error) {
+		var nets []net.IPNet
+		for _, cidr := range cidrs {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, err
+			}
+			nets = append(nets, *n)
+		}
+		return nets, nil
+	}
+	var err error
+	if sc.IPAllowList, err = parse(allow); err != nil {
+		return sc, err
+	}
+	if sc.IPDenyList, err = parse(deny); err != nil {
+		return sc, err
+	}
+	return sc, nil
+}
+
+// The toml-structure of the configuration file.
+type serviceConfigToml struct {
+	IPAllowList              []string
+	IPDenyList               []string
+	KeepAlive                string
+	RootAuthorRewardFraction float64
+	EscrowCoinIID            string
+}
+
+// LoadConfigFromToml returns the ServiceConfig described by the toml in b.
+// The networks are given in CIDR notation, KeepAlive as a duration like
+// "30s" and EscrowCoinIID in hex.
+func LoadConfigFromToml(b []byte) (ServiceConfig, error) {
+	var ct serviceConfigToml
+	if _, err := toml.Decode(string(b), &ct); err != nil {
+		return ServiceConfig{}, err
+	}
+	sc, err := LoadACLFromCIDRStrings(ct.IPAllowList, ct.IPDenyList)
+	if err != nil {
+		return sc, err
+	}
+	if ct.KeepAlive != "" {
+		if sc.KeepAlive, err = time.ParseDuration(ct.KeepAlive); err != nil {
+			return sc, err
+		}
+	}
+	if ct.RootAuthorRewardFraction < 0 || ct.RootAuthorRewardFraction > 1 {
+		return sc, errors.New("RootAuthorRewardFraction must be between 0 and 1")
+	}
+	sc.RootAuthorRewardFraction = ct.RootAuthorRewardFraction
+	if ct.EscrowCoinIID != "" {
+		iid, err := hex.DecodeString(ct.EscrowCoinIID)
+		if err != nil {
+			return sc, err
+		}
+		if len(iid) != len(byzcoin.InstanceID{}) {
+			return sc, errors.New("EscrowCoinIID must be 32 bytes")
+		}
+		sc.EscrowCoinIID = byzcoin.NewInstanceID(iid)
+	}
+	return sc, nil
+}
+
+// loadConfig sets Config from the file given in ConfigEnv. If the file is
+// invalid, Config is not changed.
+func (s *Service) loadConfig() error {
+	path := os.Getenv(ConfigEnv)
+	if path == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sc, err := LoadConfigFromToml(b)
+	if err != nil {
+		return err
+	}
+	s.SetConfig(sc)
+	return nil
+}
+
+// config returns the current configuration of the service.
+func (s *Service) config() ServiceConfig {
+	s.configRWMutex.RLock()
+	defer s.configRWMutex.RUnlock()
+	return s.Config
+}
+
+// SetConfig replaces the configuration of the running service.
+func (s *Service) SetConfig(sc ServiceConfig) {
+	s.configRWMutex.Lock()
+	defer s.configRWMutex.Unlock()
+	s.Config = sc
+}
+
+// CheckRemoteAddr returns ErrAccessDenied if the address, in the form of
+// http.Request.RemoteAddr, is not allowed to call the service.
+func (s *Service) CheckRemoteAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ErrAccessDenied
+	}
+	sc := s.config()
+	for _, n := range sc.IPDenyList {
+		if n.Contains(ip) {
+			return ErrAccessDenied
+		}
+	}
+	if len(sc.IPAllowList) == 0 {
+		return nil
+	}
+	for _, n := range sc.IPAllowList {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	return ErrAccessDenied
+}
+
+// ProcessClientRequest checks the IP of the client before passing the request
+// to the registered handler.
+func (s *Service) ProcessClientRequest(req *http.Request, path string, buf []byte) ([]byte, *onet.StreamingTunnel, error) {
+	if err := s.CheckRemoteAddr(req.RemoteAddr); err != nil {
+		return nil, nil, err
+	}
+	return s.ServiceProcessor.ProcessClientRequest(req, path, buf)
+}
@@ -1,18 +1,56 @@
 package personhood
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/google/btree"
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
 	"go.dedis.ch/protobuf"
 )
 
-const dbVersion = 1
+const dbVersion = 2
 
 var storageKey = []byte("storage")
 
+// ErrQuestionnaireExpired is returned when answering a questionnaire after
+// its expiry date.
+var ErrQuestionnaireExpired = errors.New("the questionnaire expired")
+
+// ErrQuestionnaireNotFound is returned when deleting a questionnaire that
+// doesn't exist.
+var ErrQuestionnaireNotFound = errors.New("didn't find questionnaire")
+
+// ErrQuestionnaireExists is returned when registering a questionnaire with
+// the ID of a stored questionnaire.
+var ErrQuestionnaireExists = errors.New("a questionnaire with this ID already exists")
+
+// ErrInsufficientEscrow is returned when the transfer to the escrow coin
+// doesn't cover the balance of a new message, or the amount of a top-up.
+var ErrInsufficientEscrow = errors.New("the escrow doesn't cover the balance of the message")
+
+// ErrEscrowTransferUsed is returned if the transfer to the escrow coin already
+// paid for a message or a top-up.
+var ErrEscrowTransferUsed = errors.New("the transfer to the escrow has already been used")
+
+// ErrNonceUsed is returned if the nonce of a request has already been used.
+var ErrNonceUsed = errors.New("the nonce has already been used")
+
+// ErrVersionConflict is returned if an entity has been updated since the
+// version given in the update.
+var ErrVersionConflict = errors.New("the entity has been updated in the meantime")
+
 func init() {
 	network.RegisterMessage(&storage1{})
 }
@@ -32,19 +70,102 @@ func (s *Service) tryLoad() error {
 	if err != nil {
 		return err
 	}
-	if ver < dbVersion {
-		// There is no version 0. Save empty storage and update version number.
+	buf, err := s.LoadRaw(storageKey)
+	if err != nil {
+		return err
+	}
+	if len(buf) == 0 {
+		// Nothing stored yet. Save empty storage and update version number.
 		if err = s.save(); err != nil {
 			return err
 		}
 		return s.SaveVersion(dbVersion)
 	}
-	buf, err := s.LoadRaw(storageKey)
+	if len(buf) <= 16 {
+		return errors.New("stored data is too short")
+	}
+	err = protobuf.DecodeWithConstructors(buf[16:], s.storage,
+		network.DefaultConstructors(cothority.Suite))
 	if err != nil {
 		return err
 	}
-	return protobuf.DecodeWithConstructors(buf[16:], s.storage,
-		network.DefaultConstructors(cothority.Suite))
+	if ver < 1 {
+		if err = migrateV0ToV1(s.storage); err != nil {
+			return err
+		}
+	}
+	if ver < 2 {
+		if err = migrateV1ToV2(s.storage); err != nil {
+			return err
+		}
+	}
+	if s.storage.PartyNames == nil {
+		// Storage from before the name index: the parties weren't verified,
+		// so their names are only indexed when they are linked again.
+		s.storage.PartyNames = make(map[string]byzcoin.InstanceID)
+	}
+	if s.storage.EscrowTransfers == nil {
+		s.storage.EscrowTransfers = make(map[string]bool)
+	}
+	for key, pr := range s.storage.PendingReads {
+		// The service stopped while sending the reward, so it is not known
+		// whether the reader got it. Keep the balance of the message.
+		log.Warnf("dropping pending read of message %x by %x", pr.MsgID, pr.Reader)
+		delete(s.storage.PendingReads, key)
+	}
+	if ver < dbVersion {
+		if err = s.save(); err != nil {
+			return err
+		}
+		return s.SaveVersion(dbVersion)
+	}
+	return nil
+}
+
+// migrateV0ToV1 updates the storage written before the database had a
+// version. Maps that were not stored are created, and questionnaires without
+// replies get an empty one. The stored entries are kept.
+func migrateV0ToV1(s *storage1) error {
+	if s.Messages == nil {
+		s.Messages = make(map[string]*Message)
+	}
+	if s.Read == nil {
+		s.Read = make(map[string]*readMsg)
+	}
+	if s.Questionnaires == nil {
+		s.Questionnaires = make(map[string]*Questionnaire)
+	}
+	if s.Replies == nil {
+		s.Replies = make(map[string]*Reply)
+	}
+	if s.Parties == nil {
+		s.Parties = make(map[string]*Party)
+	}
+	if s.PendingReads == nil {
+		s.PendingReads = make(map[string]*PendingRead)
+	}
+	for key, msg := range s.Messages {
+		if msg == nil || key != string(msg.ID) {
+			return fmt.Errorf("invalid message stored under %x", key)
+		}
+	}
+	for key, q := range s.Questionnaires {
+		if q == nil || key != string(q.ID) {
+			return fmt.Errorf("invalid questionnaire stored under %x", key)
+		}
+		if s.Replies[key] == nil {
+			s.Replies[key] = &Reply{Sum: make([]int, len(q.Questions))}
+		}
+	}
+	return nil
+}
+
+// migrateV1ToV2 adds the nonces to the storage.
+func migrateV1ToV2(s *storage1) error {
+	if s.Nonces == nil {
+		s.Nonces = make(map[string]int64)
+	}
+	return nil
 }
 
 type storage1 struct {
@@ -53,10 +174,397 @@ type storage1 struct {
 	Questionnaires map[string]*Questionnaire
 	Replies        map[string]*Reply
 	Parties        map[string]*Party
+	PartyNames     map[string]byzcoin.InstanceID
+	PendingReads   map[string]*PendingRead
+	// Nonces maps the hex-encoded nonces of the requests to their expiry, in
+	// unix seconds.
+	Nonces map[string]int64
+	// EscrowTransfers holds the hex-encoded hashes of the transfer
+	// instructions to the escrow coin that paid for a message or a top-up.
+	EscrowTransfers map[string]bool
 
+	// messageScores orders the messages that can still pay their reward by
+	// their score. It is not stored, but built from Messages when loading.
+	messageScores *btree.BTree
 	sync.Mutex
 }
 
+// messageScore is an entry of storage1.messageScores. Entries with the same
+// score are ordered by their message ID.
+type messageScore struct {
+	score uint64
+	id    string
+}
+
+// Less implements btree.Item.
+func (ms messageScore) Less(than btree.Item) bool {
+	o := than.(messageScore)
+	if ms.score != o.score {
+		return ms.score < o.score
+	}
+	return ms.id < o.id
+}
+
+// cursor encodes the entry, so that a listing can continue after it.
+func (ms messageScore) cursor() []byte {
+	buf := make([]byte, 8, 8+len(ms.id))
+	binary.BigEndian.PutUint64(buf, ms.score)
+	return append(buf, ms.id...)
+}
+
+// newMessageScoreFromCursor decodes an entry encoded with cursor. The entry
+// doesn't need to be in the index anymore.
+func newMessageScoreFromCursor(cursor []byte) (messageScore, error) {
+	if len(cursor) < 8 {
+		return messageScore{}, errors.New("invalid cursor")
+	}
+	return messageScore{binary.BigEndian.Uint64(cursor), string(cursor[8:])}, nil
+}
+
+// buildMessageScores creates the score index from all stored messages.
+func (s *storage1) buildMessageScores() {
+	s.Lock()
+	defer s.Unlock()
+	s.messageScores = btree.New(32)
+	for _, msg := range s.Messages {
+		s.indexMessage(nil, msg)
+	}
+}
+
+// indexMessage replaces the entry of old with the entry of msg in the score
+// index. Messages whose balance doesn't cover the reward are not indexed.
+// The caller must hold the lock.
+func (s *storage1) indexMessage(old, msg *Message) {
+	if old != nil {
+		s.messageScores.Delete(messageScore{old.Score(), string(old.ID)})
+	}
+	if msg != nil && msg.Balance >= msg.Reward {
+		s.messageScores.ReplaceOrInsert(messageScore{msg.Score(), string(msg.ID)})
+	}
+}
+
+// addMessage stores a new message and returns false if a message with the
+// same ID already exists.
+func (s *storage1) addMessage(msg *Message) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.addMessageLocked(msg)
+}
+
+// addEscrowedMessage stores a new message paid by the transfer to the escrow
+// coin, given by the hash of its instruction. Every transfer pays only once.
+func (s *storage1) addEscrowedMessage(msg *Message, transfer []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	key := hex.EncodeToString(transfer)
+	if s.EscrowTransfers[key] {
+		return ErrEscrowTransferUsed
+	}
+	if !s.addMessageLocked(msg) {
+		return errors.New("this message-ID already exists")
+	}
+	s.EscrowTransfers[key] = true
+	return nil
+}
+
+// topupEscrowedMessage adds amount to the balance of the message, paid by the
+// transfer to the escrow coin, given by the hash of its instruction. Every
+// transfer pays only once.
+func (s *storage1) topupEscrowedMessage(msgID []byte, amount uint64, transfer []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	key := hex.EncodeToString(transfer)
+	if s.EscrowTransfers[key] {
+		return ErrEscrowTransferUsed
+	}
+	stored := s.Messages[string(msgID)]
+	if stored == nil {
+		return errors.New("this message doesn't exist")
+	}
+	msg := *stored
+	msg.Balance += amount
+	if err := s.compareAndSwapMessageLocked(&msg); err != nil {
+		return err
+	}
+	s.EscrowTransfers[key] = true
+	return nil
+}
+
+// addMessageLocked is addMessage for callers holding the lock.
+func (s *storage1) addMessageLocked(msg *Message) bool {
+	idStr := string(msg.ID)
+	if s.Messages[idStr] != nil {
+		return false
+	}
+	s.Messages[idStr] = msg
+	s.Read[idStr] = &readMsg{[]byzcoin.InstanceID{msg.Author}}
+	s.indexMessage(nil, msg)
+	return true
+}
+
 type readMsg struct {
 	Readers []byzcoin.InstanceID
 }
+
+// readBy returns true if the reader already read the message, or is its
+// author. An empty reader never read a message. The caller must hold the
+// lock.
+func (s *storage1) readBy(msgID []byte, reader byzcoin.InstanceID) bool {
+	read := s.Read[string(msgID)]
+	if read == nil || reader.Equal(byzcoin.InstanceID{}) {
+		return false
+	}
+	for _, r := range read.Readers {
+		if r.Equal(reader) {
+			return true
+		}
+	}
+	return false
+}
+
+// PendingRead is a read of a message whose reward is being sent to the reader.
+// The reward is taken from the balance of the message when the read starts.
+// Once the reward is on the ledger, the reader is added to the readers of the
+// message, else the reward is given back to the message.
+type PendingRead struct {
+	MsgID  []byte
+	Reader byzcoin.InstanceID
+	Reward uint64
+}
+
+// expiredMessages returns the messages that expired at now, in unix seconds.
+func (s *storage1) expiredMessages(now uint64) []*Message {
+	s.Lock()
+	defer s.Unlock()
+	var msgs []*Message
+	for _, msg := range s.Messages {
+		if msg.expired(now) {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// deleteExpiredMessages removes the messages that expired at now, in unix
+// seconds, and returns them. Messages with a pending read are kept until the
+// read is done.
+func (s *storage1) deleteExpiredMessages(now uint64) []*Message {
+	return s.deleteMessages(func(msg *Message) bool {
+		return msg.expired(now)
+	})
+}
+
+// deleteMessages removes the messages for which remove returns true, together
+// with their readers, and returns them. Messages with pending reads are kept,
+// so that their reads can be committed.
+func (s *storage1) deleteMessages(remove func(*Message) bool) []*Message {
+	s.Lock()
+	defer s.Unlock()
+	pending := make(map[string]bool)
+	for _, pr := range s.PendingReads {
+		pending[string(pr.MsgID)] = true
+	}
+	var msgs []*Message
+	for idStr, msg := range s.Messages {
+		if !remove(msg) || pending[idStr] {
+			continue
+		}
+		s.indexMessage(msg, nil)
+		delete(s.Messages, idStr)
+		delete(s.Read, idStr)
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// deleteExpiredQuestionnaires removes the questionnaires that expired at now,
+// in unix seconds, together with their replies, and returns them.
+func (s *storage1) deleteExpiredQuestionnaires(now uint64) []*Questionnaire {
+	s.Lock()
+	defer s.Unlock()
+	var qs []*Questionnaire
+	for idStr, q := range s.Questionnaires {
+		if !q.expired(now) {
+			continue
+		}
+		delete(s.Questionnaires, idStr)
+		delete(s.Replies, idStr)
+		qs = append(qs, q)
+	}
+	return qs
+}
+
+// deleteQuestionnaire removes the questionnaire and its replies.
+func (s *storage1) deleteQuestionnaire(id []byte) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.Questionnaires, string(id))
+	delete(s.Replies, string(id))
+}
+
+// useNonce stores the nonce until NonceLifetime after now, and returns
+// ErrNonceUsed if it is already stored. An empty nonce is refused.
+func (s *storage1) useNonce(nonce []byte, now time.Time) error {
+	if len(nonce) == 0 {
+		return errors.New("the request needs a nonce")
+	}
+	s.Lock()
+	defer s.Unlock()
+	key := hex.EncodeToString(nonce)
+	if _, ok := s.Nonces[key]; ok {
+		return ErrNonceUsed
+	}
+	s.Nonces[key] = now.Add(NonceLifetime).Unix()
+	return nil
+}
+
+// deleteExpiredNonces removes the nonces that expired at now, in unix
+// seconds, and returns how many have been removed.
+func (s *storage1) deleteExpiredNonces(now int64) int {
+	s.Lock()
+	defer s.Unlock()
+	removed := 0
+	for key, expiry := range s.Nonces {
+		if expiry <= now {
+			delete(s.Nonces, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// getMessage returns the stored message, or nil if it doesn't exist.
+func (s *storage1) getMessage(id []byte) *Message {
+	s.Lock()
+	defer s.Unlock()
+	return s.Messages[string(id)]
+}
+
+// getParty returns the linked party, or nil if it isn't linked.
+func (s *storage1) getParty(iid []byte) *Party {
+	s.Lock()
+	defer s.Unlock()
+	return s.Parties[string(iid)]
+}
+
+// ledgerRoster returns the roster of a linked party on the ledger with the
+// given ID, or nil if no linked party is on this ledger.
+func (s *storage1) ledgerRoster(byzcoinID skipchain.SkipBlockID) *onet.Roster {
+	s.Lock()
+	defer s.Unlock()
+	for _, p := range s.Parties {
+		if p.ByzCoinID.Equal(byzcoinID) && p.FinalStatement.Desc != nil &&
+			p.FinalStatement.Desc.Roster != nil {
+			return p.FinalStatement.Desc.Roster
+		}
+	}
+	return nil
+}
+
+// getQuestionnaire returns the stored questionnaire, or nil if it doesn't
+// exist.
+func (s *storage1) getQuestionnaire(id []byte) *Questionnaire {
+	s.Lock()
+	defer s.Unlock()
+	return s.Questionnaires[string(id)]
+}
+
+// addQuestionnaire stores the questionnaire with an empty reply. It returns
+// ErrQuestionnaireExists if a questionnaire with the same ID is stored, so
+// that neither its author nor its replies can be replaced.
+func (s *storage1) addQuestionnaire(q *Questionnaire) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.Questionnaires[string(q.ID)]; ok {
+		return ErrQuestionnaireExists
+	}
+	s.Questionnaires[string(q.ID)] = q
+	s.Replies[string(q.ID)] = &Reply{Sum: make([]int, len(q.Questions))}
+	return nil
+}
+
+// rootMessage follows the parents of the message up to the message that is
+// not a reply. As a parent must exist before its reply, this terminates.
+func (s *storage1) rootMessage(msg *Message) *Message {
+	s.Lock()
+	defer s.Unlock()
+	return s.rootMessageLocked(msg)
+}
+
+// rootMessageLocked is rootMessage for callers holding the lock.
+func (s *storage1) rootMessageLocked(msg *Message) *Message {
+	for len(msg.ParentMsgID) > 0 {
+		parent := s.Messages[string(msg.ParentMsgID)]
+		if parent == nil {
+			break
+		}
+		msg = parent
+	}
+	return msg
+}
+
+// threadMessages returns copies of the messages whose root message has the ID
+// threadID, sorted by date.
+func (s *storage1) threadMessages(threadID []byte) []Message {
+	s.Lock()
+	defer s.Unlock()
+	var msgs []Message
+	for _, msg := range s.Messages {
+		if bytes.Equal(s.rootMessageLocked(msg).ID, threadID) {
+			msgs = append(msgs, *msg)
+		}
+	}
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].Date < msgs[j].Date
+	})
+	return msgs
+}
+
+// compareAndSwapMessage replaces the stored message with msg, if the version
+// of msg is the stored version. The version of msg is then increased.
+func (s *storage1) compareAndSwapMessage(msg *Message) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.compareAndSwapMessageLocked(msg)
+}
+
+// compareAndSwapMessageLocked is compareAndSwapMessage for callers holding
+// the lock.
+func (s *storage1) compareAndSwapMessageLocked(msg *Message) error {
+	old := s.Messages[string(msg.ID)]
+	if old == nil {
+		return errors.New("this message doesn't exist")
+	}
+	if old.Version != msg.Version {
+		return ErrVersionConflict
+	}
+	msg.Version++
+	m := *msg
+	s.Messages[string(msg.ID)] = &m
+	s.indexMessage(old, &m)
+	return nil
+}
+
+// compareAndSwapQuestionnaire replaces the stored questionnaire with q, if the
+// version of q is the stored version. The version of q is then increased.
+func (s *storage1) compareAndSwapQuestionnaire(q *Questionnaire) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.compareAndSwapQuestionnaireLocked(q)
+}
+
+// compareAndSwapQuestionnaireLocked is compareAndSwapQuestionnaire for
+// callers holding the lock.
+func (s *storage1) compareAndSwapQuestionnaireLocked(q *Questionnaire) error {
+	old := s.Questionnaires[string(q.ID)]
+	if old == nil {
+		return errors.New("this questionnaire doesn't exist")
+	}
+	if old.Version != q.Version {
+		return ErrVersionConflict
+	}
+	q.Version++
+	nq := *q
+	s.Questionnaires[string(q.ID)] = &nq
+	return nil
+}
@@ -0,0 +1,115 @@
+package mock
+
+import (
+	"errors"
+	"sync"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+	"go.dedis.ch/cothority/v3/darc"
+	pop "go.dedis.ch/cothority/v3/pop/service"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/protobuf"
+)
+
+var _ pop.ByzCoinClient = (*MockByzCoinClient)(nil)
+
+// MockByzCoinClient is an in-memory pop.ByzCoinClient. The instances are
+// stored in a trie, so that GetProof returns proofs that can be used with
+// Proof.KeyValue, but that are not linked to any skipblock. The transactions
+// are not executed, only stored in Transactions.
+type MockByzCoinClient struct {
+	// Transactions holds all transactions sent with AddTransactionAndWait.
+	Transactions []byzcoin.ClientTransaction
+	// Counters holds the signer counters of the identities.
+	Counters map[string]uint64
+	// AddTxError, if not nil, is returned by AddTransactionAndWait.
+	AddTxError error
+
+	trie *trie.Trie
+	sync.Mutex
+}
+
+// NewMockByzCoinClient returns a MockByzCoinClient without any instances.
+func NewMockByzCoinClient() *MockByzCoinClient {
+	t, err := trie.NewTrie(trie.NewMemDB(), []byte("mock"))
+	if err != nil {
+		// Creating a trie in memory only fails for an empty nonce.
+		panic(err)
+	}
+	return &MockByzCoinClient{
+		Counters: make(map[string]uint64),
+		trie:     t,
+	}
+}
+
+// NewClient can be used as personhood.Service.NewByzCoinClient to have the
+// service use this mock.
+func (m *MockByzCoinClient) NewClient(skipchain.SkipBlockID, onet.Roster) pop.ByzCoinClient {
+	return m
+}
+
+// SetInstance stores the value of the instance.
+func (m *MockByzCoinClient) SetInstance(iid byzcoin.InstanceID, contractID string, value []byte, darcID darc.ID) error {
+	m.Lock()
+	defer m.Unlock()
+	buf, err := protobuf.Encode(&byzcoin.StateChangeBody{
+		StateAction: byzcoin.Update,
+		ContractID:  []byte(contractID),
+		Value:       value,
+		DarcID:      darcID,
+	})
+	if err != nil {
+		return err
+	}
+	return m.trie.Set(iid.Slice(), buf)
+}
+
+// GetProof returns a proof of the key, which only contains the trie part.
+func (m *MockByzCoinClient) GetProof(key []byte) (*byzcoin.GetProofResponse, error) {
+	m.Lock()
+	defer m.Unlock()
+	p, err := m.trie.GetProof(key)
+	if err != nil {
+		return nil, err
+	}
+	return &byzcoin.GetProofResponse{
+		Version: byzcoin.CurrentVersion,
+		Proof:   byzcoin.Proof{InclusionProof: *p},
+	}, nil
+}
+
+// AddTransactionAndWait stores the transaction and increases the counters of
+// its signers. If AddTxError is set, it is returned instead.
+func (m *MockByzCoinClient) AddTransactionAndWait(ctx byzcoin.ClientTransaction, wait int) (*byzcoin.AddTxResponse, error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.AddTxError != nil {
+		return nil, m.AddTxError
+	}
+	for _, inst := range ctx.Instructions {
+		if len(inst.SignerIdentities) != len(inst.SignerCounter) {
+			return nil, errors.New("instruction is not signed")
+		}
+		for i, id := range inst.SignerIdentities {
+			if inst.SignerCounter[i] != m.Counters[id.String()]+1 {
+				return nil, errors.New("wrong signer counter")
+			}
+			m.Counters[id.String()]++
+		}
+	}
+	m.Transactions = append(m.Transactions, ctx)
+	return &byzcoin.AddTxResponse{Version: byzcoin.CurrentVersion}, nil
+}
+
+// GetSignerCounters returns the counters of the identities.
+func (m *MockByzCoinClient) GetSignerCounters(ids ...string) (*byzcoin.GetSignerCountersResponse, error) {
+	m.Lock()
+	defer m.Unlock()
+	resp := &byzcoin.GetSignerCountersResponse{}
+	for _, id := range ids {
+		resp.Counters = append(resp.Counters, m.Counters[id])
+	}
+	return resp, nil
+}
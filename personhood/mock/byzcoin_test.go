@@ -0,0 +1,320 @@
+package mock
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/contracts"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/personhood"
+	pop "go.dedis.ch/cothority/v3/pop/service"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/util/key"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/protobuf"
+)
+
+// Reads the anchored results of a pop-party stored in the mock.
+func TestMockByzCoinClient_GetProof(t *testing.T) {
+	m := NewMockByzCoinClient()
+	popIID := byzcoin.NewInstanceID([]byte("party"))
+	_, err := pop.GetAnchoredResults(m, popIID)
+	require.NotNil(t, err)
+
+	hash := sha256.Sum256([]byte("result"))
+	results := []pop.AnchoredResult{{ResultHash: hash[:], ResultURL: "https://example.com"}}
+	buf, err := protobuf.Encode(&pop.PopPartyInstance{
+		State:           2,
+		FinalStatement:  &pop.FinalStatement{},
+		AnchoredResults: results,
+	})
+	require.Nil(t, err)
+	require.Nil(t, m.SetInstance(popIID, pop.ContractPopParty, buf, darc.ID(popIID.Slice())))
+	ar, err := pop.GetAnchoredResults(m, popIID)
+	require.Nil(t, err)
+	require.Equal(t, results, ar)
+
+	coinIID := byzcoin.NewInstanceID([]byte("coin"))
+	require.Nil(t, m.SetInstance(coinIID, contracts.ContractCoinID, nil, nil))
+	_, err = pop.GetAnchoredResults(m, coinIID)
+	require.NotNil(t, err)
+}
+
+// Fetches a party that is not linked from the ledger.
+func TestMockByzCoinClient_GetParty(t *testing.T) {
+	local := onet.NewLocalTest(cothority.Suite)
+	defer local.CloseAll()
+	servers, roster, _ := local.GenTree(1, true)
+	ph := local.GetServices(servers, onet.ServiceFactory.ServiceID(personhood.ServiceName))[0].(*personhood.Service)
+	m := NewMockByzCoinClient()
+	ph.NewByzCoinClient = m.NewClient
+
+	popIID := byzcoin.NewInstanceID([]byte("party"))
+	gp := &personhood.GetParty{InstanceID: popIID}
+	_, err := ph.GetParty(gp)
+	require.NotNil(t, err)
+	gp.ByzCoinID = []byte("byzcoin")
+	_, err = ph.GetParty(gp)
+	require.NotNil(t, err)
+
+	// Only the ledgers of linked parties are asked.
+	_, err = ph.LinkPoP(&personhood.LinkPoP{Party: personhood.Party{
+		ByzCoinID:  []byte("byzcoin"),
+		InstanceID: byzcoin.NewInstanceID([]byte("linked")),
+		FinalStatement: pop.FinalStatement{
+			Desc: &pop.PopDesc{Name: "linked", Roster: roster},
+		},
+		Signer: darc.NewSignerEd25519(nil, nil),
+	}})
+	require.Nil(t, err)
+	gp.ByzCoinID = []byte("other")
+	_, err = ph.GetParty(gp)
+	require.NotNil(t, err)
+	gp.ByzCoinID = []byte("byzcoin")
+	_, err = ph.GetParty(gp)
+	require.NotNil(t, err)
+
+	buf, err := protobuf.Encode(&pop.PopPartyInstance{
+		State:          1,
+		FinalStatement: &pop.FinalStatement{Desc: &pop.PopDesc{Name: "party"}},
+	})
+	require.Nil(t, err)
+	require.Nil(t, m.SetInstance(popIID, pop.ContractPopParty, buf, darc.ID(popIID.Slice())))
+	gpr, err := ph.GetParty(gp)
+	require.Nil(t, err)
+	require.False(t, gpr.Linked)
+	require.Equal(t, "party", gpr.Party.FinalStatement.Desc.Name)
+	require.Equal(t, popIID, gpr.Party.InstanceID)
+}
+
+// Has the personhood service send the reward for reading a message through
+// the mock.
+func TestMockByzCoinClient_ReadMessage(t *testing.T) {
+	local := onet.NewLocalTest(cothority.Suite)
+	defer local.CloseAll()
+	servers, roster, _ := local.GenTree(1, true)
+	ph := local.GetServices(servers, onet.ServiceFactory.ServiceID(personhood.ServiceName))[0].(*personhood.Service)
+	m := NewMockByzCoinClient()
+	ph.NewByzCoinClient = m.NewClient
+
+	party := personhood.Party{
+		InstanceID: byzcoin.NewInstanceID([]byte("party")),
+		FinalStatement: pop.FinalStatement{
+			Desc: &pop.PopDesc{Name: "party", Roster: roster},
+		},
+		Signer: darc.NewSignerEd25519(nil, nil),
+	}
+	_, err := ph.LinkPoP(&personhood.LinkPoP{Party: party})
+	require.Nil(t, err)
+	msg := personhood.Message{
+		Subject: "news",
+		Balance: 20,
+		Reward:  10,
+		ID:      []byte("msg"),
+	}
+	_, err = ph.SendMessage(&personhood.SendMessage{Message: msg})
+	require.Nil(t, err)
+
+	reader := byzcoin.NewInstanceID([]byte("reader"))
+	rm := &personhood.ReadMessage{
+		MsgID:    msg.ID,
+		PartyIID: party.InstanceID.Slice(),
+		Reader:   reader,
+	}
+	// If the reward cannot be sent, the message must stay untouched.
+	m.AddTxError = errors.New("ledger not available")
+	_, err = ph.ReadMessage(rm)
+	require.NotNil(t, err)
+	require.Equal(t, 0, len(m.Transactions))
+	lmr, err := ph.ListMessages(&personhood.ListMessages{Number: 1})
+	require.Nil(t, err)
+	require.Equal(t, []uint64{20}, lmr.Balances)
+
+	m.AddTxError = nil
+	rmr, err := ph.ReadMessage(rm)
+	require.Nil(t, err)
+	require.True(t, rmr.Rewarded)
+	require.Equal(t, 1, len(m.Transactions))
+	inst := m.Transactions[0].Instructions[0]
+	require.Equal(t, "transfer", inst.Invoke.Command)
+	require.Equal(t, reader.Slice(), inst.Invoke.Args.Search("destination"))
+	require.Equal(t, uint64(1), m.Counters[party.Signer.Identity().String()])
+	require.Equal(t, uint64(10), rmr.Message.Balance)
+
+	rmr, err = ph.ReadMessage(rm)
+	require.Nil(t, err)
+	require.False(t, rmr.Rewarded)
+	require.Equal(t, 1, len(m.Transactions))
+
+	// Concurrent reads by the same reader are only rewarded once.
+	rm.Reader = byzcoin.NewInstanceID([]byte("other reader"))
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ph.ReadMessage(rm)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 2, len(m.Transactions))
+	lmr, err = ph.ListMessages(&personhood.ListMessages{Number: 1})
+	require.Nil(t, err)
+	require.Equal(t, 0, len(lmr.MsgIDs))
+}
+
+// Reading a reply sends part of the reward to the author of the root message.
+func TestMockByzCoinClient_ReadReply(t *testing.T) {
+	local := onet.NewLocalTest(cothority.Suite)
+	defer local.CloseAll()
+	servers, roster, _ := local.GenTree(1, true)
+	ph := local.GetServices(servers, onet.ServiceFactory.ServiceID(personhood.ServiceName))[0].(*personhood.Service)
+	m := NewMockByzCoinClient()
+	ph.NewByzCoinClient = m.NewClient
+
+	party := personhood.Party{
+		InstanceID: byzcoin.NewInstanceID([]byte("party")),
+		FinalStatement: pop.FinalStatement{
+			Desc: &pop.PopDesc{Name: "party", Roster: roster},
+		},
+		Signer: darc.NewSignerEd25519(nil, nil),
+	}
+	_, err := ph.LinkPoP(&personhood.LinkPoP{Party: party})
+	require.Nil(t, err)
+	root := personhood.Message{
+		Subject: "news",
+		Author:  byzcoin.NewInstanceID([]byte("root author")),
+		Balance: 100,
+		Reward:  10,
+		ID:      []byte("root"),
+	}
+	_, err = ph.SendMessage(&personhood.SendMessage{Message: root})
+	require.Nil(t, err)
+	reply := personhood.Message{
+		Subject:     "re: news",
+		Author:      byzcoin.NewInstanceID([]byte("reply author")),
+		Balance:     100,
+		Reward:      10,
+		ID:          []byte("reply"),
+		ParentMsgID: []byte("unknown"),
+	}
+	_, err = ph.SendMessage(&personhood.SendMessage{Message: reply})
+	require.NotNil(t, err)
+	reply.ParentMsgID = root.ID
+	_, err = ph.SendMessage(&personhood.SendMessage{Message: reply})
+	require.Nil(t, err)
+	replyReply := reply
+	replyReply.ID = []byte("reply to reply")
+	replyReply.ParentMsgID = reply.ID
+	_, err = ph.SendMessage(&personhood.SendMessage{Message: replyReply})
+	require.Nil(t, err)
+
+	reader := byzcoin.NewInstanceID([]byte("reader"))
+	coins := func(inst byzcoin.Instruction) uint64 {
+		return binary.LittleEndian.Uint64(inst.Invoke.Args.Search("coins"))
+	}
+	for i, id := range [][]byte{reply.ID, replyReply.ID} {
+		rmr, err := ph.ReadMessage(&personhood.ReadMessage{
+			MsgID:    id,
+			PartyIID: party.InstanceID.Slice(),
+			Reader:   reader,
+		})
+		require.Nil(t, err)
+		require.True(t, rmr.Rewarded)
+		require.Equal(t, uint64(90), rmr.Message.Balance)
+		insts := m.Transactions[i].Instructions
+		require.Equal(t, 2, len(insts))
+		require.Equal(t, reader.Slice(), insts[0].Invoke.Args.Search("destination"))
+		require.Equal(t, uint64(7), coins(insts[0]))
+		require.Equal(t, root.Author.Slice(), insts[1].Invoke.Args.Search("destination"))
+		require.Equal(t, uint64(3), coins(insts[1]))
+	}
+
+	// Only the reader is rewarded for the root message.
+	ph.SetConfig(personhood.ServiceConfig{RootAuthorRewardFraction: 0.5})
+	rmr, err := ph.ReadMessage(&personhood.ReadMessage{
+		MsgID:    root.ID,
+		PartyIID: party.InstanceID.Slice(),
+		Reader:   reader,
+	})
+	require.Nil(t, err)
+	require.True(t, rmr.Rewarded)
+	insts := m.Transactions[2].Instructions
+	require.Equal(t, 1, len(insts))
+	require.Equal(t, uint64(10), coins(insts[0]))
+	require.Equal(t, uint64(5), m.Counters[party.Signer.Identity().String()])
+}
+
+// Answering a questionnaire sends the reward from its coin account. If the
+// transfer fails, the questionnaire can be answered again.
+func TestMockByzCoinClient_AnswerQuestionnaire(t *testing.T) {
+	local := onet.NewLocalTest(cothority.Suite)
+	defer local.CloseAll()
+	servers, roster, _ := local.GenTree(1, true)
+	ph := local.GetServices(servers, onet.ServiceFactory.ServiceID(personhood.ServiceName))[0].(*personhood.Service)
+	m := NewMockByzCoinClient()
+	ph.NewByzCoinClient = m.NewClient
+
+	attendee := key.NewKeyPair(cothority.Suite)
+	party := personhood.Party{
+		InstanceID: byzcoin.NewInstanceID([]byte("party")),
+		FinalStatement: pop.FinalStatement{
+			Desc:      &pop.PopDesc{Name: "party", Roster: roster},
+			Attendees: []kyber.Point{attendee.Public},
+		},
+	}
+	q := personhood.Questionnaire{
+		Title:     "poll",
+		Questions: []string{"yes", "no"},
+		Replies:   1,
+		Balance:   30,
+		Reward:    10,
+		ID:        []byte("poll"),
+		PartyIID:  party.InstanceID,
+		CoinIID:   byzcoin.NewInstanceID([]byte("poll coin")),
+		Signer:    darc.NewSignerEd25519(nil, nil),
+	}
+	_, err := ph.RegisterQuestionnaire(&personhood.RegisterQuestionnaire{Questionnaire: q})
+	require.NotNil(t, err)
+	_, err = ph.LinkPoP(&personhood.LinkPoP{Party: party})
+	require.Nil(t, err)
+	_, err = ph.RegisterQuestionnaire(&personhood.RegisterQuestionnaire{Questionnaire: q})
+	require.Nil(t, err)
+	lqr, err := ph.ListQuestionnaires(&personhood.ListQuestionnaires{Number: 1})
+	require.Nil(t, err)
+	require.Nil(t, lqr.Questionnaires[0].Signer.Ed25519)
+
+	account := byzcoin.NewInstanceID([]byte("account"))
+	aq := &personhood.AnswerQuestionnaire{QuestID: q.ID, Replies: []int{0}, Account: account,
+		PartyIID: party.InstanceID}
+	require.Nil(t, aq.Sign(party.FinalStatement.Attendees, *attendee))
+	m.AddTxError = errors.New("ledger not available")
+	_, err = ph.AnswerQuestionnaire(aq)
+	require.NotNil(t, err)
+	lqr, err = ph.ListQuestionnaires(&personhood.ListQuestionnaires{Number: 1})
+	require.Nil(t, err)
+	require.Equal(t, uint64(30), lqr.Questionnaires[0].Balance)
+
+	m.AddTxError = nil
+	_, err = ph.AnswerQuestionnaire(aq)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(m.Transactions))
+	inst := m.Transactions[0].Instructions[0]
+	require.Equal(t, q.CoinIID, inst.InstanceID)
+	require.Equal(t, "transfer", inst.Invoke.Command)
+	require.Equal(t, account.Slice(), inst.Invoke.Args.Search("destination"))
+	require.Equal(t, q.Reward, binary.LittleEndian.Uint64(inst.Invoke.Args.Search("coins")))
+	lqr, err = ph.ListQuestionnaires(&personhood.ListQuestionnaires{Number: 1})
+	require.Nil(t, err)
+	require.Equal(t, uint64(20), lqr.Questionnaires[0].Balance)
+
+	_, err = ph.AnswerQuestionnaire(aq)
+	require.NotNil(t, err)
+	require.Equal(t, 1, len(m.Transactions))
+}
@@ -0,0 +1,238 @@
+// Package mock holds an in-memory stub of the personhood client. It stores
+// what it is given, lists it in the order of the service and keeps track of
+// the rewards, so that code using the personhood client can be tested without
+// setting up a network.
+//
+// The stub does not enforce the rules of the personhood service: it doesn't
+// verify any signature, attendance proof, nonce, timestamp, scope or escrow
+// transfer, and doesn't look at ByzCoin. Tests of these rules must run against
+// the service.
+package mock
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/personhood"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// Client is the interface implemented by personhood.Client and
+// MockPersonhoodClient.
+type Client interface {
+	LinkPoP(si *network.ServerIdentity, p personhood.Party) error
+	RegisterQuestionnaire(si *network.ServerIdentity, q personhood.Questionnaire) error
+	ListQuestionnaires(si *network.ServerIdentity, start, number int) ([]personhood.Questionnaire, error)
+	AnswerQuestionnaire(si *network.ServerIdentity, aq *personhood.AnswerQuestionnaire) error
+	TopupQuestionnaire(si *network.ServerIdentity, questID []byte, topup uint64) error
+	SendMessage(si *network.ServerIdentity, msg personhood.Message) error
+	ListMessages(si *network.ServerIdentity, lm *personhood.ListMessages) (*personhood.ListMessagesReply, error)
+	ReadMessage(si *network.ServerIdentity, rm *personhood.ReadMessage) (*personhood.ReadMessageReply, error)
+	TopupMessage(si *network.ServerIdentity, msgID []byte, amount uint64) error
+}
+
+var _ Client = (*personhood.Client)(nil)
+var _ Client = (*MockPersonhoodClient)(nil)
+
+// MockPersonhoodClient keeps all data in memory. The ServerIdentity given to the methods is ignored. Instead of sending a
+// reward over ByzCoin, AnswerQuestionnaire and ReadMessage add it to the
+// Rewards map.
+type MockPersonhoodClient struct {
+	Parties        []personhood.Party
+	Questionnaires []personhood.Questionnaire
+	Messages       []personhood.Message
+	// Rewards holds the coins sent to each account.
+	Rewards map[string]uint64
+	// rewarded holds the accounts that got the reward of a questionnaire or
+	// a message, indexed by the ID of the questionnaire or message.
+	rewarded map[string][]byzcoin.InstanceID
+
+	sync.Mutex
+}
+
+// NewMockPersonhoodClient returns an empty MockPersonhoodClient.
+func NewMockPersonhoodClient() *MockPersonhoodClient {
+	return &MockPersonhoodClient{
+		Rewards:  make(map[string]uint64),
+		rewarded: make(map[string][]byzcoin.InstanceID),
+	}
+}
+
+// LinkPoP stores the party.
+func (m *MockPersonhoodClient) LinkPoP(si *network.ServerIdentity, p personhood.Party) error {
+	m.Lock()
+	defer m.Unlock()
+	m.Parties = append(m.Parties, p)
+	return nil
+}
+
+// RegisterQuestionnaire stores the questionnaire.
+func (m *MockPersonhoodClient) RegisterQuestionnaire(si *network.ServerIdentity, q personhood.Questionnaire) error {
+	m.Lock()
+	defer m.Unlock()
+	m.Questionnaires = append(m.Questionnaires, q)
+	return nil
+}
+
+// ListQuestionnaires returns at most number questionnaires with a balance,
+// starting with the one at index start when sorted by balance, like the
+// service.
+func (m *MockPersonhoodClient) ListQuestionnaires(si *network.ServerIdentity, start, number int) ([]personhood.Questionnaire, error) {
+	m.Lock()
+	qs := append([]personhood.Questionnaire{}, m.Questionnaires...)
+	m.Unlock()
+	sort.SliceStable(qs, func(i, j int) bool {
+		return qs[i].Balance > qs[j].Balance
+	})
+	if start >= len(qs) {
+		return nil, nil
+	}
+	qs = qs[start:]
+	if len(qs) > number {
+		qs = qs[:number]
+	}
+	for i, q := range qs {
+		if q.Balance == 0 {
+			return qs[:i], nil
+		}
+	}
+	return qs, nil
+}
+
+// AnswerQuestionnaire credits the reward of the questionnaire to the account,
+// if the account didn't get it yet and the balance is big enough.
+func (m *MockPersonhoodClient) AnswerQuestionnaire(si *network.ServerIdentity, aq *personhood.AnswerQuestionnaire) error {
+	m.Lock()
+	defer m.Unlock()
+	q := m.questionnaire(aq.QuestID)
+	if q == nil {
+		return errors.New("didn't find questionnaire")
+	}
+	if m.reward(q.ID, aq.Account, q.Reward, &q.Balance) {
+		return nil
+	}
+	return errors.New("questionnaire already answered or no reward left")
+}
+
+// TopupQuestionnaire adds the topup to the balance of the questionnaire.
+func (m *MockPersonhoodClient) TopupQuestionnaire(si *network.ServerIdentity, questID []byte, topup uint64) error {
+	m.Lock()
+	defer m.Unlock()
+	q := m.questionnaire(questID)
+	if q == nil {
+		return errors.New("this questionnaire doesn't exist")
+	}
+	q.Balance += topup
+	return nil
+}
+
+// SendMessage stores the message.
+func (m *MockPersonhoodClient) SendMessage(si *network.ServerIdentity, msg personhood.Message) error {
+	m.Lock()
+	defer m.Unlock()
+	m.Messages = append(m.Messages, msg)
+	return nil
+}
+
+// ListMessages returns at most lm.Number messages with a balance, starting
+// with the one at index lm.Start when sorted by score, like the service.
+func (m *MockPersonhoodClient) ListMessages(si *network.ServerIdentity, lm *personhood.ListMessages) (*personhood.ListMessagesReply, error) {
+	m.Lock()
+	msgs := append([]personhood.Message{}, m.Messages...)
+	m.Unlock()
+	sort.Slice(msgs, func(i, j int) bool {
+		if a, b := msgs[i].Score(), msgs[j].Score(); a != b {
+			return a > b
+		}
+		return string(msgs[i].ID) > string(msgs[j].ID)
+	})
+	for i := range msgs {
+		if msgs[i].Balance == 0 {
+			msgs = msgs[:i]
+			break
+		}
+	}
+	lmr := &personhood.ListMessagesReply{}
+	if lm.Start >= len(msgs) {
+		return lmr, nil
+	}
+	msgs = msgs[lm.Start:]
+	if len(msgs) > lm.Number {
+		msgs = msgs[:lm.Number]
+	}
+	for _, msg := range msgs {
+		lmr.MsgIDs = append(lmr.MsgIDs, msg.ID)
+		lmr.Subjects = append(lmr.Subjects, msg.Subject)
+		lmr.Balances = append(lmr.Balances, msg.Balance)
+		lmr.Rewards = append(lmr.Rewards, msg.Reward)
+		lmr.PartyIIDs = append(lmr.PartyIIDs, msg.PartyIID)
+	}
+	return lmr, nil
+}
+
+// ReadMessage returns the message and credits the reward to the reader, if
+// the reader didn't get it yet and the balance is big enough.
+func (m *MockPersonhoodClient) ReadMessage(si *network.ServerIdentity, rm *personhood.ReadMessage) (*personhood.ReadMessageReply, error) {
+	m.Lock()
+	defer m.Unlock()
+	msg := m.message(rm.MsgID)
+	if msg == nil {
+		return nil, errors.New("no such messageID")
+	}
+	rewarded := m.reward(msg.ID, rm.Reader, msg.Reward, &msg.Balance)
+	return &personhood.ReadMessageReply{Message: *msg, Rewarded: rewarded}, nil
+}
+
+// TopupMessage adds the amount to the balance of the message.
+func (m *MockPersonhoodClient) TopupMessage(si *network.ServerIdentity, msgID []byte, amount uint64) error {
+	m.Lock()
+	defer m.Unlock()
+	msg := m.message(msgID)
+	if msg == nil {
+		return errors.New("this message doesn't exist")
+	}
+	msg.Balance += amount
+	return nil
+}
+
+// reward takes the reward from the balance and credits it to the account, if
+// the account didn't get a reward for id yet. The caller must hold the lock.
+func (m *MockPersonhoodClient) reward(id []byte, account byzcoin.InstanceID, reward uint64, balance *uint64) bool {
+	if *balance < reward {
+		return false
+	}
+	for _, a := range m.rewarded[string(id)] {
+		if a.Equal(account) {
+			return false
+		}
+	}
+	*balance -= reward
+	m.rewarded[string(id)] = append(m.rewarded[string(id)], account)
+	m.Rewards[string(account.Slice())] += reward
+	return true
+}
+
+// questionnaire returns the stored questionnaire with the given ID. The
+// caller must hold the lock.
+func (m *MockPersonhoodClient) questionnaire(id []byte) *personhood.Questionnaire {
+	for i := range m.Questionnaires {
+		if bytes.Equal(m.Questionnaires[i].ID, id) {
+			return &m.Questionnaires[i]
+		}
+	}
+	return nil
+}
+
+// message returns the stored message with the given ID. The caller must hold
+// the lock.
+func (m *MockPersonhoodClient) message(id []byte) *personhood.Message {
+	for i := range m.Messages {
+		if bytes.Equal(m.Messages[i].ID, id) {
+			return &m.Messages[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package mock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/personhood"
+	"go.dedis.ch/kyber/v3/util/random"
+)
+
+// Registers questionnaires and answers them without any conode.
+func TestMockPersonhoodClient_Questionnaire(t *testing.T) {
+	var cl Client = NewMockPersonhoodClient()
+	quests := []personhood.Questionnaire{
+		{
+			Title:     "qn1",
+			Questions: []string{"q11", "q12"},
+			Replies:   1,
+			Balance:   10,
+			Reward:    10,
+			ID:        random.Bits(256, true, random.New()),
+		},
+		{
+			Title:     "qn2",
+			Questions: []string{"q21", "q22"},
+			Replies:   2,
+			Balance:   20,
+			Reward:    10,
+			ID:        random.Bits(256, true, random.New()),
+		},
+	}
+	for _, q := range quests {
+		require.Nil(t, cl.RegisterQuestionnaire(nil, q))
+	}
+
+	// The questionnaires are listed by balance, like in the service.
+	qs, err := cl.ListQuestionnaires(nil, 0, len(quests))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(qs))
+	require.Equal(t, quests[1].Title, qs[0].Title)
+	qs, err = cl.ListQuestionnaires(nil, 1, len(quests))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(qs))
+	require.Equal(t, quests[0].Title, qs[0].Title)
+
+	account := byzcoin.NewInstanceID([]byte("account"))
+	aq := &personhood.AnswerQuestionnaire{
+		QuestID: quests[0].ID,
+		Replies: []int{0},
+		Account: account,
+	}
+	require.Nil(t, cl.AnswerQuestionnaire(nil, aq))
+	require.NotNil(t, cl.AnswerQuestionnaire(nil, aq))
+	require.Equal(t, quests[0].Reward,
+		cl.(*MockPersonhoodClient).Rewards[string(account.Slice())])
+	aq.QuestID = []byte("unknown")
+	require.NotNil(t, cl.AnswerQuestionnaire(nil, aq))
+
+	// Questionnaires without balance are not listed.
+	qs, err = cl.ListQuestionnaires(nil, 0, len(quests))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(qs))
+	require.Nil(t, cl.TopupQuestionnaire(nil, quests[0].ID, 10))
+	qs, err = cl.ListQuestionnaires(nil, 0, len(quests))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(qs))
+	require.Equal(t, uint64(10), qs[1].Balance)
+}
+
+// Sends messages, lists and reads them without any conode.
+func TestMockPersonhoodClient_Messages(t *testing.T) {
+	var cl Client = NewMockPersonhoodClient()
+	msgs := []personhood.Message{
+		{
+			Subject: "test1",
+			Text:    "This is the 1st test message",
+			Balance: 10,
+			Reward:  10,
+			ID:      random.Bits(256, true, random.New()),
+		},
+		{
+			Subject: "test2",
+			Text:    "This is the 2nd test message",
+			Balance: 20,
+			Reward:  10,
+			ID:      random.Bits(256, true, random.New()),
+		},
+	}
+	for _, msg := range msgs {
+		require.Nil(t, cl.SendMessage(nil, msg))
+	}
+
+	lmr, err := cl.ListMessages(nil, &personhood.ListMessages{Start: 0, Number: len(msgs)})
+	require.Nil(t, err)
+	// The messages are listed by score, like in the service.
+	require.Equal(t, []string{"test2", "test1"}, lmr.Subjects)
+
+	reader := byzcoin.NewInstanceID([]byte("reader"))
+	rm := &personhood.ReadMessage{
+		MsgID:  msgs[1].ID,
+		Reader: reader,
+	}
+	rmr, err := cl.ReadMessage(nil, rm)
+	require.Nil(t, err)
+	require.True(t, rmr.Rewarded)
+	require.Equal(t, msgs[1].Balance-msgs[1].Reward, rmr.Message.Balance)
+	rmr, err = cl.ReadMessage(nil, rm)
+	require.Nil(t, err)
+	require.False(t, rmr.Rewarded)
+	require.Equal(t, msgs[1].Reward,
+		cl.(*MockPersonhoodClient).Rewards[string(reader.Slice())])
+
+	rm.MsgID = []byte("unknown")
+	_, err = cl.ReadMessage(nil, rm)
+	require.NotNil(t, err)
+
+	require.Nil(t, cl.TopupMessage(nil, msgs[1].ID, 10))
+	require.NotNil(t, cl.TopupMessage(nil, []byte("unknown"), 10))
+}
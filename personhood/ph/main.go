@@ -0,0 +1,435 @@
+// ph is a command line interface to the messages and questionnaires of the
+// personhood service.
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/personhood"
+	"go.dedis.ch/cothority/v3/personhood/mock"
+	pop "go.dedis.ch/cothority/v3/pop/service"
+	"go.dedis.ch/kyber/v3/util/encoding"
+	"go.dedis.ch/kyber/v3/util/key"
+	"go.dedis.ch/kyber/v3/util/random"
+	"go.dedis.ch/onet/v3/app"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var cmds = cli.Commands{
+	{
+		Name:    "message",
+		Usage:   "send, list and read messages",
+		Aliases: []string{"m"},
+		Subcommands: cli.Commands{
+			{
+				Name:  "send",
+				Usage: "send a new message",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "subject",
+						Usage: "the subject of the message",
+					},
+					cli.StringFlag{
+						Name:  "text",
+						Usage: "the text of the message",
+					},
+					cli.StringFlag{
+						Name:  "author",
+						Usage: "hex-encoded coin instance of the author",
+					},
+					cli.StringFlag{
+						Name:  "party",
+						Usage: "hex-encoded instance of the party the message belongs to",
+					},
+					cli.Uint64Flag{
+						Name:  "balance",
+						Usage: "coins attached to the message",
+					},
+					cli.Uint64Flag{
+						Name:  "reward",
+						Usage: "coins given to each reader",
+					},
+				},
+				Action: messageSend,
+			},
+			{
+				Name:  "list",
+				Usage: "list the most valuable messages",
+				Flags: []cli.Flag{
+					cli.IntFlag{
+						Name:  "start",
+						Usage: "index of the first message",
+					},
+					cli.IntFlag{
+						Name:  "number",
+						Usage: "maximum number of messages",
+						Value: 10,
+					},
+					cli.StringFlag{
+						Name:  "reader",
+						Usage: "hex-encoded coin instance of the reader",
+					},
+				},
+				Action: messageList,
+			},
+			{
+				Name:  "read",
+				Usage: "read a message and get the reward",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "id",
+						Usage: "hex-encoded ID of the message",
+					},
+					cli.StringFlag{
+						Name:  "party",
+						Usage: "hex-encoded instance of the party paying the reward",
+					},
+					cli.StringFlag{
+						Name:  "reader",
+						Usage: "hex-encoded coin instance receiving the reward",
+					},
+				},
+				Action: messageRead,
+			},
+		},
+	},
+	{
+		Name:    "questionnaire",
+		Usage:   "register, list and answer questionnaires",
+		Aliases: []string{"q"},
+		Subcommands: cli.Commands{
+			{
+				Name:  "register",
+				Usage: "register a new questionnaire",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "title",
+						Usage: "the title of the questionnaire",
+					},
+					cli.StringSliceFlag{
+						Name:  "question",
+						Usage: "a question to choose from, can be given multiple times",
+					},
+					cli.IntFlag{
+						Name:  "replies",
+						Usage: "how many questions can be chosen",
+						Value: 1,
+					},
+					cli.Uint64Flag{
+						Name:  "balance",
+						Usage: "coins attached to the questionnaire",
+					},
+					cli.Uint64Flag{
+						Name:  "reward",
+						Usage: "coins given to each participant",
+					},
+				},
+				Action: questionnaireRegister,
+			},
+			{
+				Name:  "list",
+				Usage: "list the questionnaires with the highest balance",
+				Flags: []cli.Flag{
+					cli.IntFlag{
+						Name:  "start",
+						Usage: "index of the first questionnaire",
+					},
+					cli.IntFlag{
+						Name:  "number",
+						Usage: "maximum number of questionnaires",
+						Value: 10,
+					},
+				},
+				Action: questionnaireList,
+			},
+			{
+				Name:  "answer",
+				Usage: "answer a questionnaire",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "id",
+						Usage: "hex-encoded ID of the questionnaire",
+					},
+					cli.IntSliceFlag{
+						Name:  "reply",
+						Usage: "index of a chosen question, can be given multiple times",
+					},
+					cli.StringFlag{
+						Name:  "account",
+						Usage: "hex-encoded coin instance receiving the reward",
+					},
+					cli.StringFlag{
+						Name:  "party",
+						Usage: "hex-encoded instance ID of the party the answer is signed for",
+					},
+					cli.StringFlag{
+						Name:  "final",
+						Usage: "final.toml of the party, holding the attendees",
+					},
+					cli.StringFlag{
+						Name:  "private",
+						Usage: "hex-encoded private key of the attendee",
+					},
+				},
+				Action: questionnaireAnswer,
+			},
+		},
+	},
+}
+
+var cliApp = cli.NewApp()
+
+// newClient returns the client used to talk to the conodes. The tests replace
+// it with a mock.
+var newClient = func() mock.Client {
+	return personhood.NewClient()
+}
+
+func init() {
+	cliApp.Name = "ph"
+	cliApp.Usage = "Work with the messages and questionnaires of personhood."
+	cliApp.Version = "0.1"
+	cliApp.Commands = cmds
+	cliApp.Flags = []cli.Flag{
+		cli.IntFlag{
+			Name:  "debug, d",
+			Value: 0,
+			Usage: "debug-level: 1 for terse, 5 for maximal",
+		},
+		cli.StringFlag{
+			Name:   "roster-file, r",
+			EnvVar: "ROSTER",
+			Usage:  "the group.toml of the conodes running the personhood service",
+		},
+	}
+	cliApp.Before = func(c *cli.Context) error {
+		log.SetDebugVisible(c.Int("debug"))
+		return nil
+	}
+}
+
+func main() {
+	log.ErrFatal(cliApp.Run(os.Args))
+}
+
+// getServer returns a client and the first conode of the roster given on the
+// command line.
+func getServer(c *cli.Context) (mock.Client, *network.ServerIdentity, error) {
+	name := c.GlobalString("roster-file")
+	if name == "" {
+		return nil, nil, errors.New("--roster-file is required")
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	g, err := app.ReadGroupDescToml(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(g.Roster.List) == 0 {
+		return nil, nil, errors.New("empty roster in " + name)
+	}
+	return newClient(), g.Roster.List[0], nil
+}
+
+// getInstanceID decodes the hex-encoded instance ID of the flag. If the flag
+// is empty, a zero instance ID is returned.
+func getInstanceID(c *cli.Context, flag string) (iid byzcoin.InstanceID, err error) {
+	str := c.String(flag)
+	if str == "" {
+		return
+	}
+	buf, err := hex.DecodeString(str)
+	if err != nil {
+		return iid, fmt.Errorf("--%s: %v", flag, err)
+	}
+	if len(buf) != len(iid) {
+		return iid, fmt.Errorf("--%s must be %d bytes", flag, len(iid))
+	}
+	return byzcoin.NewInstanceID(buf), nil
+}
+
+func messageSend(c *cli.Context) error {
+	cl, si, err := getServer(c)
+	if err != nil {
+		return err
+	}
+	msg := personhood.Message{
+		Subject: c.String("subject"),
+		Date:    uint64(time.Now().Unix()),
+		Text:    c.String("text"),
+		Balance: c.Uint64("balance"),
+		Reward:  c.Uint64("reward"),
+		ID:      random.Bits(256, true, random.New()),
+	}
+	if msg.Subject == "" {
+		return errors.New("--subject is required")
+	}
+	if msg.Reward == 0 {
+		return errors.New("--reward must be bigger than 0")
+	}
+	if msg.Author, err = getInstanceID(c, "author"); err != nil {
+		return err
+	}
+	if msg.PartyIID, err = getInstanceID(c, "party"); err != nil {
+		return err
+	}
+	if err = cl.SendMessage(si, msg); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "%x\n", msg.ID)
+	return nil
+}
+
+func messageList(c *cli.Context) error {
+	cl, si, err := getServer(c)
+	if err != nil {
+		return err
+	}
+	lm := &personhood.ListMessages{
+		Start:  c.Int("start"),
+		Number: c.Int("number"),
+	}
+	if lm.ReaderID, err = getInstanceID(c, "reader"); err != nil {
+		return err
+	}
+	lmr, err := cl.ListMessages(si, lm)
+	if err != nil {
+		return err
+	}
+	for i, id := range lmr.MsgIDs {
+		fmt.Fprintf(c.App.Writer, "%x\t%d\t%d\t%s\n", id, lmr.Balances[i],
+			lmr.Rewards[i], lmr.Subjects[i])
+	}
+	return nil
+}
+
+func messageRead(c *cli.Context) error {
+	cl, si, err := getServer(c)
+	if err != nil {
+		return err
+	}
+	id, err := hex.DecodeString(c.String("id"))
+	if err != nil || len(id) == 0 {
+		return errors.New("--id needs a hex-encoded message ID")
+	}
+	party, err := getInstanceID(c, "party")
+	if err != nil {
+		return err
+	}
+	rm := &personhood.ReadMessage{
+		MsgID:    id,
+		PartyIID: party.Slice(),
+	}
+	if rm.Reader, err = getInstanceID(c, "reader"); err != nil {
+		return err
+	}
+	rmr, err := cl.ReadMessage(si, rm)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "Subject: %s\n\n%s\n", rmr.Message.Subject, rmr.Message.Text)
+	if rmr.Rewarded {
+		fmt.Fprintf(c.App.Writer, "\nRewarded with %d coins\n", rmr.Message.Reward)
+	}
+	return nil
+}
+
+func questionnaireRegister(c *cli.Context) error {
+	cl, si, err := getServer(c)
+	if err != nil {
+		return err
+	}
+	q := personhood.Questionnaire{
+		Title:     c.String("title"),
+		Questions: c.StringSlice("question"),
+		Replies:   c.Int("replies"),
+		Balance:   c.Uint64("balance"),
+		Reward:    c.Uint64("reward"),
+		ID:        random.Bits(256, true, random.New()),
+	}
+	if q.Title == "" {
+		return errors.New("--title is required")
+	}
+	if len(q.Questions) == 0 {
+		return errors.New("need at least one --question")
+	}
+	if err = cl.RegisterQuestionnaire(si, q); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "%x\n", q.ID)
+	return nil
+}
+
+func questionnaireList(c *cli.Context) error {
+	cl, si, err := getServer(c)
+	if err != nil {
+		return err
+	}
+	qs, err := cl.ListQuestionnaires(si, c.Int("start"), c.Int("number"))
+	if err != nil {
+		return err
+	}
+	for _, q := range qs {
+		fmt.Fprintf(c.App.Writer, "%x\t%d\t%d\t%s\n", q.ID, q.Balance, q.Reward, q.Title)
+		for i, question := range q.Questions {
+			fmt.Fprintf(c.App.Writer, "\t%d: %s\n", i, question)
+		}
+	}
+	return nil
+}
+
+func questionnaireAnswer(c *cli.Context) error {
+	cl, si, err := getServer(c)
+	if err != nil {
+		return err
+	}
+	id, err := hex.DecodeString(c.String("id"))
+	if err != nil || len(id) == 0 {
+		return errors.New("--id needs a hex-encoded questionnaire ID")
+	}
+	aq := &personhood.AnswerQuestionnaire{
+		QuestID: id,
+		Replies: c.IntSlice("reply"),
+	}
+	if aq.Account, err = getInstanceID(c, "account"); err != nil {
+		return err
+	}
+	if aq.PartyIID, err = getInstanceID(c, "party"); err != nil {
+		return err
+	}
+	if c.String("final") == "" || c.String("private") == "" {
+		return errors.New("--final and --private are required to sign the answer")
+	}
+	buf, err := ioutil.ReadFile(c.String("final"))
+	if err != nil {
+		return err
+	}
+	fs, err := pop.NewFinalStatementFromToml(buf)
+	if err != nil {
+		return err
+	}
+	priv, err := encoding.StringHexToScalar(cothority.Suite, c.String("private"))
+	if err != nil {
+		return fmt.Errorf("--private: %v", err)
+	}
+	kp := key.Pair{
+		Public:  cothority.Suite.Point().Mul(priv, nil),
+		Private: priv,
+	}
+	if err = aq.Sign(fs.Attendees, kp); err != nil {
+		return err
+	}
+	return cl.AnswerQuestionnaire(si, aq)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/personhood"
+	"go.dedis.ch/cothority/v3/personhood/mock"
+	pop "go.dedis.ch/cothority/v3/pop/service"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/util/encoding"
+	"go.dedis.ch/kyber/v3/util/key"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/app"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// This is required; without it onet/log/testuitl.go:interestingGoroutines will
+// call main.main() interesting.
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+// Runs the commands against the mock client, without any conode.
+func TestCli(t *testing.T) {
+	cl := mock.NewMockPersonhoodClient()
+	newClient = func() mock.Client { return cl }
+	defer func() { newClient = func() mock.Client { return personhood.NewClient() } }()
+	// The roster file is still needed, but no conode is started.
+	roster := onet.NewRoster([]*network.ServerIdentity{network.NewServerIdentity(
+		key.NewKeyPair(cothority.Suite).Public, network.NewAddress(network.TLS, "127.0.0.1:7770"))})
+
+	dir, err := ioutil.TempDir("", "ph")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	group := filepath.Join(dir, "public.toml")
+	require.Nil(t, (&app.Group{Roster: roster}).Save(cothority.Suite, group))
+
+	run := func(args ...string) string {
+		b := &bytes.Buffer{}
+		cliApp.Writer = b
+		cliApp.ErrWriter = b
+		require.Nil(t, cliApp.Run(append([]string{"ph", "-r", group}, args...)))
+		return b.String()
+	}
+
+	log.Lvl1("Messages")
+	run("message", "send", "-subject", "first", "-text", "hello", "-balance", "10", "-reward", "10")
+	run("message", "send", "-subject", "second", "-text", "world", "-balance", "20", "-reward", "10")
+	out := run("message", "list")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Equal(t, 2, len(lines))
+	require.Contains(t, lines[0], "second")
+	require.Contains(t, lines[1], "first")
+	out = run("message", "list", "-number", "1")
+	require.NotContains(t, out, "first")
+
+	log.Lvl1("Questionnaires")
+	id := strings.TrimSpace(run("questionnaire", "register", "-title", "poll",
+		"-question", "yes", "-question", "no", "-balance", "10", "-reward", "10"))
+	out = run("questionnaire", "list")
+	require.Contains(t, out, id)
+	require.Contains(t, out, "1: no")
+	attendee := key.NewKeyPair(cothority.Suite)
+	fs := &pop.FinalStatement{
+		Desc:      &pop.PopDesc{Name: "party", Roster: roster},
+		Attendees: []kyber.Point{attendee.Public},
+	}
+	final := filepath.Join(dir, "final.toml")
+	buf, err := fs.ToToml()
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(final, buf, 0600))
+	partyIID := byzcoin.NewInstanceID([]byte("party"))
+	require.Nil(t, cl.LinkPoP(roster.List[0],
+		personhood.Party{InstanceID: partyIID, FinalStatement: *fs}))
+	account := byzcoin.NewInstanceID([]byte("account"))
+	private, err := encoding.ScalarToStringHex(cothority.Suite, attendee.Private)
+	require.Nil(t, err)
+	run("questionnaire", "answer", "-id", id, "-reply", "1", "-account", hex.EncodeToString(account.Slice()),
+		"-party", hex.EncodeToString(partyIID.Slice()), "-final", final, "-private", private)
+	require.Equal(t, uint64(10), cl.Rewards[string(account.Slice())])
+	// The balance is used up, so the questionnaire is not listed anymore.
+	out = run("questionnaire", "list")
+	require.NotContains(t, out, id)
+
+	require.NotNil(t, cliApp.Run([]string{"ph", "message", "list"}))
+}
@@ -14,6 +14,7 @@ import (
 // type :byzcoin.InstanceID:bytes
 // package personhood;
 //
+// import "byzcoin.proto";
 // import "darc.proto";
 // import "pop.proto";
 //
@@ -38,6 +39,57 @@ type Party struct {
 	Darc darc.Darc
 	// Signer can call Invoke on the PartyInstance.
 	Signer darc.Signer
+	// Version is increased with every update of the party, starting at 1.
+	// Linking an already linked party must give the current version, or 0
+	// to overwrite it whatever its version.
+	Version uint64
+}
+
+// GetParty requests a party by its instance ID.
+type GetParty struct {
+	// InstanceID of the party.
+	InstanceID byzcoin.InstanceID
+	// ByzCoinID of the ledger to fetch the party from, if it is not linked.
+	// Another party of this ledger must be linked.
+	ByzCoinID skipchain.SkipBlockID
+}
+
+// GetPartyReply holds the requested party. Its Signer is never sent.
+type GetPartyReply struct {
+	// Party as linked to the service, or as fetched from the ledger.
+	Party Party
+	// Linked is true if the party is linked to the service.
+	Linked bool
+}
+
+// PartySearch requests the linked parties by their name.
+type PartySearch struct {
+	// NameSubstring is searched in the names of the parties, without case.
+	NameSubstring string
+}
+
+// PartySearchReply holds the parties found by PartySearch, the parties in
+// configuration before the finalized ones. Their Signers are never sent.
+type PartySearchReply struct {
+	// Parties found.
+	Parties []Party
+}
+
+// GetMetrics requests the counters of the service.
+type GetMetrics struct {
+}
+
+// ServiceMetrics counts the successful requests handled by the service since
+// it started.
+type ServiceMetrics struct {
+	// TotalPartiesListed counts the listings of the linked parties.
+	TotalPartiesListed uint64
+	// TotalMessagesRead counts the messages returned by ReadMessage.
+	TotalMessagesRead uint64
+	// TotalQuestionnairesAnswered counts the answers to questionnaires.
+	TotalQuestionnairesAnswered uint64
+	// TotalMessagesSent counts the messages stored by SendMessage.
+	TotalMessagesSent uint64
 }
 
 // StringReply can be used by all calls that need a string to be returned
@@ -64,6 +116,27 @@ type Questionnaire struct {
 	Reward uint64
 	// ID is a random identifier of that questionnaire
 	ID []byte
+	// Version is increased by the service with every update of the
+	// questionnaire.
+	Version uint64
+	// PartyIID is the linked party whose ledger holds CoinIID.
+	PartyIID byzcoin.InstanceID
+	// CoinIID is the coin account the rewards are paid from. If it is not
+	// set, no rewards are sent.
+	CoinIID byzcoin.InstanceID
+	// Signer can invoke transfer on CoinIID. It is never sent back by
+	// ListQuestionnaires.
+	Signer darc.Signer
+	// ExpiresAt, as unix-encoded seconds since 1970, after which the
+	// questionnaire cannot be answered anymore and is removed. If it is 0,
+	// the questionnaire doesn't expire.
+	ExpiresAt uint64
+	// Tags are the categories of the questionnaire, like "health".
+	Tags []string
+	// AuthorTag is set by the service to the linkable ring signature tag of
+	// RegisterQuestionnaire.AuthorProof. It is never sent back by
+	// ListQuestionnaires.
+	AuthorTag []byte
 }
 
 // Reply holds the results of the questionnaire together with a slice of users
@@ -71,8 +144,11 @@ type Questionnaire struct {
 type Reply struct {
 	// Sum is the sum of all replies for a given index of the questions.
 	Sum []int
-	// TODO: replace this with a linkable ring signature
+	// Users are the accounts that got a reward for their answer.
 	Users []byzcoin.InstanceID
+	// Tags are the linkable ring signature tags of the answers, to prevent
+	// an attendee from answering more than once.
+	Tags [][]byte
 }
 
 // RegisterQuestionnaire creates a questionnaire with a number of questions to
@@ -80,6 +156,17 @@ type Reply struct {
 type RegisterQuestionnaire struct {
 	// Questionnaire is the questionnaire to be stored.
 	Questionnaire Questionnaire
+	// AuthorProof is an optional linkable ring signature by one of the
+	// attendees of Questionnaire.PartyIID. Only the same attendee can delete
+	// the questionnaire. It also signs the Nonce and the Timestamp.
+	AuthorProof []byte
+	// Nonce is a random value that must not have been used before. It is
+	// needed if AuthorProof is set.
+	Nonce []byte
+	// Timestamp of the request in unix seconds. It is needed if AuthorProof
+	// is set, and must then be within RequestWindow of the time of the
+	// service.
+	Timestamp int64
 }
 
 // ListQuestionnaires requests all questionnaires from Start, but not more than
@@ -89,6 +176,9 @@ type ListQuestionnaires struct {
 	Start int
 	// Number is the maximum of questionnaires that will be returned.
 	Number int
+	// FilterTags, if not empty, only returns the questionnaires having at
+	// least one of these tags. Tags are compared without case.
+	FilterTags []string
 }
 
 // ListQuestionnairesReply is a slice of all questionnaires, starting with the
@@ -106,6 +196,17 @@ type AnswerQuestionnaire struct {
 	Replies []int
 	// Account where to put the reward to.
 	Account byzcoin.InstanceID
+	// PartyIID is the party the answering attendee took part in.
+	PartyIID byzcoin.InstanceID
+	// Proof is a linkable ring signature by one of the attendees of the
+	// party on the QuestID, the Account, the Nonce and the Timestamp. Its
+	// tag is unique per attendee and questionnaire.
+	Proof []byte
+	// Nonce is a random value that must not have been used before.
+	Nonce []byte
+	// Timestamp of the request in unix seconds. It must be within
+	// RequestWindow of the time of the service.
+	Timestamp int64
 }
 
 // TopupQuestionnaire can be used to add new balance to a questionnaire.
@@ -116,6 +217,36 @@ type TopupQuestionnaire struct {
 	Topup uint64
 }
 
+// DeleteQuestionnaire removes a questionnaire and its replies.
+type DeleteQuestionnaire struct {
+	// QuestID indicates which questionnaire
+	QuestID []byte
+	// Proof is a linkable ring signature by the attendee that signed the
+	// AuthorProof of the questionnaire. It also signs the Nonce and the
+	// Timestamp.
+	Proof []byte
+	// Nonce is a random value that must not have been used before.
+	Nonce []byte
+	// Timestamp of the request in unix seconds. It must be within
+	// RequestWindow of the time of the service.
+	Timestamp int64
+}
+
+// GetQuestionnaireResults requests how many users chose each question of a
+// questionnaire.
+type GetQuestionnaireResults struct {
+	// QuestID indicates which questionnaire
+	QuestID []byte
+}
+
+// QuestionnaireResultsReply holds the results of a questionnaire.
+type QuestionnaireResultsReply struct {
+	// VoteCounts holds, for every question, how many users chose it.
+	VoteCounts []int
+	// Respondents is the number of users that answered.
+	Respondents int
+}
+
 //
 // * Popper
 //
@@ -139,12 +270,40 @@ type Message struct {
 	ID []byte
 	// PartyIID - the instance ID of the party this message belongs to
 	PartyIID byzcoin.InstanceID
+	// Version is increased by the service with every update of the message.
+	Version uint64
+	// ParentMsgID, if set, is the ID of the message this message replies to.
+	ParentMsgID []byte
+	// ExpiresAt, as unix-encoded seconds since 1970, after which the message
+	// is not listed anymore and removed. If it is 0 when sending the message,
+	// it is set to DefaultMessageLifetime after sending.
+	ExpiresAt uint64
+	// Scope, if set, is the instance ID of the party whose attendees can
+	// list the message. Messages without a scope are listed to everybody.
+	Scope byzcoin.InstanceID
 }
 
 // SendMessage stores the message in the system.
 type SendMessage struct {
 	// Message to store.
 	Message Message
+	// TransferProof is a protobuf-encoded byzcoin.Proof on the ledger of the
+	// party of the message, whose latest block holds a transfer of at least
+	// the balance of the message to the escrow coin of the service, with the
+	// argument "msgID" set to the ID of the message. It is only needed if
+	// the service has an escrow coin.
+	TransferProof []byte
+	// Nonce is a random value that must not have been used before. It is
+	// needed if Message.Scope is set.
+	Nonce []byte
+	// Timestamp of the request in unix seconds. It is needed if
+	// Message.Scope is set, and must then be within RequestWindow of the
+	// time of the service.
+	Timestamp int64
+	// ScopeProof is a ring signature by one of the attendees of the
+	// Message.Scope party on the message ID, Nonce and Timestamp. It is
+	// needed if Message.Scope is set.
+	ScopeProof []byte
 }
 
 // ListMessages sorts all messages by balance and sends back the messages from
@@ -154,8 +313,30 @@ type ListMessages struct {
 	Start int
 	// Number of maximum messages returned
 	Number int
-	// ReaderID of the reading account, to skip messages created by this reader
+	// ReaderID of the reading account, to skip messages created or already
+	// read by this reader
 	ReaderID byzcoin.InstanceID
+	// ThreadID, if set, restricts the messages to the thread started by the
+	// message with this ID.
+	ThreadID []byte
+	// ScopeFilter, if set, lists only the messages scoped to this party,
+	// else only the messages without a scope are listed.
+	ScopeFilter byzcoin.InstanceID
+	// ScopeProof is a ring signature by one of the attendees of the
+	// ScopeFilter party on the Nonce and Timestamp. It is needed if
+	// ScopeFilter is set.
+	ScopeProof []byte
+	// Cursor is the NextCursor of the previous page. If it is set, the
+	// messages following the last message of that page are returned, even
+	// if new messages have been sent in the meantime. Start is counted from
+	// the cursor.
+	Cursor []byte
+	// Nonce is a random value that must not have been used before. It is
+	// needed if ScopeFilter is set.
+	Nonce []byte
+	// Timestamp of the request in unix seconds. It is needed if ScopeFilter
+	// is set.
+	Timestamp int64
 }
 
 // ListMessagesReply returns the subjects, IDs, balances and rewards of the top
@@ -171,6 +352,9 @@ type ListMessagesReply struct {
 	Rewards []uint64
 	// PartyIIDs
 	PartyIIDs []byzcoin.InstanceID
+	// NextCursor points to the last returned message. It is empty if no
+	// more messages follow.
+	NextCursor []byte
 }
 
 // ReadMessage requests the full message and the reward for that message.
@@ -181,6 +365,63 @@ type ReadMessage struct {
 	PartyIID []byte
 	// Reader that will receive the reward
 	Reader byzcoin.InstanceID
+	// ScopeProof is a ring signature by one of the attendees of the
+	// Message.Scope party on the MsgID, Nonce and Timestamp. It is needed if
+	// the message has a scope.
+	ScopeProof []byte
+	// Nonce is a random value that must not have been used before. It is
+	// needed if the message has a scope.
+	Nonce []byte
+	// Timestamp of the request in unix seconds. It is needed if the message
+	// has a scope.
+	Timestamp int64
+}
+
+// GetThread requests all messages of a thread.
+type GetThread struct {
+	// ThreadID is the ID of the message starting the thread.
+	ThreadID []byte
+	// Scope, if set, returns only the messages of the thread scoped to this
+	// party, else only the messages without a scope are returned.
+	Scope byzcoin.InstanceID
+	// ScopeProof is a ring signature by one of the attendees of the Scope
+	// party on the ThreadID, Nonce and Timestamp. It is needed if Scope is
+	// set.
+	ScopeProof []byte
+	// Nonce is a random value that must not have been used before. It is
+	// needed if Scope is set.
+	Nonce []byte
+	// Timestamp of the request in unix seconds. It is needed if Scope is set.
+	Timestamp int64
+}
+
+// GetThreadReply holds the messages of a thread.
+type GetThreadReply struct {
+	// Messages of the thread, sorted by date.
+	Messages []Message
+}
+
+// GetMessage requests the full message without reading it, so no reward is
+// sent.
+type GetMessage struct {
+	// MsgID of the message to return.
+	MsgID []byte
+	// ScopeProof is a ring signature by one of the attendees of the
+	// Message.Scope party on the MsgID, Nonce and Timestamp. It is needed if
+	// the message has a scope.
+	ScopeProof []byte
+	// Nonce is a random value that must not have been used before. It is
+	// needed if the message has a scope.
+	Nonce []byte
+	// Timestamp of the request in unix seconds. It is needed if the message
+	// has a scope.
+	Timestamp int64
+}
+
+// GetMessageReply holds the message requested by GetMessage.
+type GetMessageReply struct {
+	// Message as stored in the service.
+	Message Message
 }
 
 // ReadMessageReply if the message is still active (balance >= reward)
@@ -198,4 +439,69 @@ type TopupMessage struct {
 	MsgID []byte
 	// Amount to coins to put in the message
 	Amount uint64
+	// TransferProof is like SendMessage.TransferProof, with a transfer of at
+	// least Amount. It is only needed if the service has an escrow coin.
+	TransferProof []byte
+}
+
+// WipeParties removes all parties from the service. It can only be called by
+// an identity allowed by the admin darc of the service.
+type WipeParties struct {
+	// Proof of the admin darc.
+	Proof byzcoin.Proof
+	// Signature on personhood.AdminMessage("WipeParties", Proof, Nonce,
+	// Timestamp).
+	Signature darc.Signature
+	// Nonce is a random value that must not have been used before.
+	Nonce []byte
+	// Timestamp of the request in unix seconds.
+	Timestamp int64
+}
+
+// WipeMessages removes all messages from the service. It can only be called
+// by an identity allowed by the admin darc of the service.
+type WipeMessages struct {
+	// Proof of the admin darc.
+	Proof byzcoin.Proof
+	// Signature on personhood.AdminMessage("WipeMessages", Proof, Nonce,
+	// Timestamp).
+	Signature darc.Signature
+	// Nonce is a random value that must not have been used before.
+	Nonce []byte
+	// Timestamp of the request in unix seconds.
+	Timestamp int64
+}
+
+// GetExpiredMessages returns the messages that expired, but are not removed
+// yet. It can only be called by an identity allowed by the admin darc of the
+// service.
+type GetExpiredMessages struct {
+	// Proof of the admin darc.
+	Proof byzcoin.Proof
+	// Signature on personhood.AdminMessage("GetExpiredMessages", Proof, Nonce,
+	// Timestamp).
+	Signature darc.Signature
+	// Nonce is a random value that must not have been used before.
+	Nonce []byte
+	// Timestamp of the request in unix seconds.
+	Timestamp int64
+}
+
+// GetExpiredMessagesReply holds the expired messages.
+type GetExpiredMessagesReply struct {
+	Messages []Message
+}
+
+// ReloadConfig reads the configuration of the service again from its file. It
+// can only be called by an identity allowed by the admin darc of the service.
+type ReloadConfig struct {
+	// Proof of the admin darc.
+	Proof byzcoin.Proof
+	// Signature on personhood.AdminMessage("ReloadConfig", Proof, Nonce,
+	// Timestamp).
+	Signature darc.Signature
+	// Nonce is a random value that must not have been used before.
+	Nonce []byte
+	// Timestamp of the request in unix seconds.
+	Timestamp int64
 }
@@ -0,0 +1,147 @@
+package rest
+
+import (
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/personhood"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// maxReconnectDelay is the longest time the WatchClient waits before
+// reconnecting.
+const maxReconnectDelay = time.Second
+
+// WatchClient follows the new messages of a reader over the websocket of the
+// REST interface. If the connection is lost, or no ping is received from the
+// server for two keep-alive intervals, it reconnects.
+type WatchClient struct {
+	url           string
+	keepAlive     time.Duration
+	notifications chan personhood.MessageNotification
+	closing       chan struct{}
+	connected     bool
+	ws            *websocket.Conn
+	sync.Mutex
+}
+
+// NewWatchClient connects to the REST interface at url, e.g.
+// "http://localhost:7771", and starts following the new messages for the
+// reader. keepAlive must be the ServiceConfig.KeepAlive of the service.
+func NewWatchClient(url string, reader byzcoin.InstanceID, keepAlive time.Duration) *WatchClient {
+	wc := &WatchClient{
+		url: "ws" + strings.TrimPrefix(url, "http") + "/messages/watch?reader=" +
+			hex.EncodeToString(reader.Slice()),
+		keepAlive:     keepAlive,
+		notifications: make(chan personhood.MessageNotification),
+		closing:       make(chan struct{}),
+	}
+	go wc.run()
+	return wc
+}
+
+// Notifications returns the channel of the new messages. It is closed once
+// the client is closed.
+func (wc *WatchClient) Notifications() <-chan personhood.MessageNotification {
+	return wc.notifications
+}
+
+// IsConnected returns true if the client currently has a connection to the
+// REST interface.
+func (wc *WatchClient) IsConnected() bool {
+	wc.Lock()
+	defer wc.Unlock()
+	return wc.connected
+}
+
+// Close stops the client and closes the connection. Further calls do
+// nothing.
+func (wc *WatchClient) Close() {
+	wc.Lock()
+	defer wc.Unlock()
+	select {
+	case <-wc.closing:
+		return
+	default:
+	}
+	close(wc.closing)
+	wc.connected = false
+	if wc.ws != nil {
+		wc.ws.Close()
+	}
+}
+
+func (wc *WatchClient) run() {
+	defer close(wc.notifications)
+	delay := wc.keepAlive
+	if delay > maxReconnectDelay {
+		delay = maxReconnectDelay
+	}
+	for {
+		ws, _, err := websocket.DefaultDialer.Dial(wc.url, nil)
+		if err == nil {
+			if !wc.setConnection(ws) {
+				ws.Close()
+				return
+			}
+			wc.follow(ws)
+			wc.setConnection(nil)
+			ws.Close()
+		} else {
+			log.Lvl2("couldn't connect:", err)
+		}
+		select {
+		case <-wc.closing:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// setConnection stores the connection and returns false if the client is
+// closing.
+func (wc *WatchClient) setConnection(ws *websocket.Conn) bool {
+	wc.Lock()
+	defer wc.Unlock()
+	select {
+	case <-wc.closing:
+		return false
+	default:
+	}
+	wc.ws = ws
+	wc.connected = ws != nil
+	return true
+}
+
+// follow passes the notifications from the connection until it fails.
+func (wc *WatchClient) follow(ws *websocket.Conn) {
+	extend := func() error {
+		return ws.SetReadDeadline(time.Now().Add(2 * wc.keepAlive))
+	}
+	ws.SetPingHandler(func(data string) error {
+		if err := extend(); err != nil {
+			return err
+		}
+		return ws.WriteControl(websocket.PongMessage, []byte(data),
+			time.Now().Add(wc.keepAlive))
+	})
+	for {
+		if err := extend(); err != nil {
+			return
+		}
+		var mn personhood.MessageNotification
+		if err := ws.ReadJSON(&mn); err != nil {
+			log.Lvl2("lost connection:", err)
+			return
+		}
+		select {
+		case wc.notifications <- mn:
+		case <-wc.closing:
+			return
+		}
+	}
+}
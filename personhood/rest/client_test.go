@@ -0,0 +1,80 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/personhood"
+)
+
+// The server closes every connection after 100ms, so the client has to
+// reconnect to get more than one notification.
+func TestWatchClient_Reconnect(t *testing.T) {
+	var connections int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := websocket.Upgrader{}
+		ws, err := u.Upgrade(w, r, http.Header{})
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		n := atomic.AddInt32(&connections, 1)
+		ws.WriteJSON(personhood.MessageNotification{Balance: uint64(n)})
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	wc := NewWatchClient(ts.URL, byzcoin.NewInstanceID(nil), 50*time.Millisecond)
+	for i := uint64(1); i <= 3; i++ {
+		select {
+		case mn := <-wc.Notifications():
+			require.Equal(t, i, mn.Balance)
+		case <-time.After(time.Second):
+			require.Fail(t, "no notification")
+		}
+	}
+	require.True(t, atomic.LoadInt32(&connections) >= 3)
+	wc.Close()
+	_, ok := <-wc.Notifications()
+	require.False(t, ok)
+	require.False(t, wc.IsConnected())
+	wc.Close()
+}
+
+// Without the pings of the service, the client would drop the connection
+// after two keep-alive intervals.
+func TestWatchClient_KeepAlive(t *testing.T) {
+	local, ph, ts := newTestServer(t)
+	defer local.CloseAll()
+	defer ts.Close()
+	keepAlive := 50 * time.Millisecond
+	ph.SetConfig(ph.Config.WithKeepAlive(keepAlive))
+
+	wc := NewWatchClient(ts.URL, byzcoin.NewInstanceID([]byte("reader")), keepAlive)
+	defer wc.Close()
+	for !wc.IsConnected() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(5 * keepAlive)
+	require.True(t, wc.IsConnected())
+
+	_, err := ph.SendMessage(&personhood.SendMessage{Message: personhood.Message{
+		ID:      []byte("msg"),
+		Subject: "news",
+		Balance: 10,
+		Reward:  10,
+	}})
+	require.Nil(t, err)
+	select {
+	case mn := <-wc.Notifications():
+		require.Equal(t, "news", mn.Subject)
+	case <-time.After(time.Second):
+		require.Fail(t, "no notification")
+	}
+}
@@ -0,0 +1,120 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+var hexBytesType = reflect.TypeOf(HexBytes{})
+
+// GenerateOpenAPISpec returns the OpenAPI 3.0 specification of the REST
+// interface in JSON. It is generated from the request and reply types of the
+// endpoints.
+func GenerateOpenAPISpec() []byte {
+	paths := make(map[string]map[string]interface{})
+	for _, rt := range routes {
+		op := map[string]interface{}{
+			"summary": rt.summary,
+			"responses": map[string]interface{}{
+				"default": map[string]interface{}{
+					"description": "error",
+					"content":     jsonContent(Error{}),
+				},
+			},
+		}
+		responses := op["responses"].(map[string]interface{})
+		if rt.reply == nil {
+			responses["204"] = map[string]interface{}{
+				"description": "success",
+			}
+		} else {
+			responses["200"] = map[string]interface{}{
+				"description": "success",
+				"content":     jsonContent(rt.reply),
+			}
+		}
+		if rt.request != nil {
+			if rt.method == http.MethodGet {
+				op["parameters"] = queryParameters(rt.request)
+			} else {
+				op["requestBody"] = map[string]interface{}{
+					"required": true,
+					"content":  jsonContent(rt.request),
+				}
+			}
+		}
+		if paths[rt.path] == nil {
+			paths[rt.path] = make(map[string]interface{})
+		}
+		paths[rt.path][strings.ToLower(rt.method)] = op
+	}
+	buf, err := json.MarshalIndent(map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":   "Personhood",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+	}, "", "  ")
+	if err != nil {
+		// Only maps, slices and strings are marshalled.
+		panic(err)
+	}
+	return buf
+}
+
+func jsonContent(v interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{
+			"schema": schema(reflect.TypeOf(v)),
+		},
+	}
+}
+
+// queryParameters returns one query parameter for every field of v, using
+// the lowercase name of the field.
+func queryParameters(v interface{}) []interface{} {
+	var params []interface{}
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		params = append(params, map[string]interface{}{
+			"name":   strings.ToLower(f.Name),
+			"in":     "query",
+			"schema": schema(f.Type),
+		})
+	}
+	return params
+}
+
+// schema returns the JSON schema of the type as it is encoded by
+// encoding/json.
+func schema(t reflect.Type) map[string]interface{} {
+	if t == hexBytesType {
+		return map[string]interface{}{"type": "string", "format": "hex"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64", "minimum": 0}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": schema(t.Elem())}
+	case reflect.Struct:
+		props := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			props[t.Field(i).Name] = schema(t.Field(i).Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": props}
+	}
+	return map[string]interface{}{}
+}
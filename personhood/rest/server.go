@@ -0,0 +1,455 @@
+// Package rest offers the messages, questionnaires and parties of the
+// personhood service over HTTP with JSON encoding, so that they can be used
+// from a web browser without the onet framing.
+//
+// Importing this package sets personhood.NewRESTHandler, so that every
+// personhood service listens on the port given in the PERSONHOOD_REST_PORT
+// environment variable.
+package rest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/personhood"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+)
+
+func init() {
+	personhood.NewRESTHandler = func(s *personhood.Service) http.Handler {
+		return NewServer(s)
+	}
+}
+
+// Service is the part of the personhood service used by the REST interface.
+type Service interface {
+	Parties() []personhood.Party
+	LinkPoP(lp *personhood.LinkPoP) (*personhood.StringReply, error)
+	ListMessages(lm *personhood.ListMessages) (*personhood.ListMessagesReply, error)
+	SendMessage(sm *personhood.SendMessage) (*personhood.StringReply, error)
+	ListQuestionnaires(lq *personhood.ListQuestionnaires) (*personhood.ListQuestionnairesReply, error)
+	AnswerQuestionnaire(aq *personhood.AnswerQuestionnaire) (*personhood.StringReply, error)
+	WatchMessages(readerID byzcoin.InstanceID) http.Handler
+	CheckRemoteAddr(addr string) error
+}
+
+var _ Service = (*personhood.Service)(nil)
+
+// HexBytes is a byte slice that is hex-encoded in JSON.
+type HexBytes []byte
+
+// MarshalJSON implements json.Marshaler.
+func (h HexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (h *HexBytes) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
+	}
+	buf, err := hex.DecodeString(str)
+	if err != nil {
+		return err
+	}
+	*h = buf
+	return nil
+}
+
+// PartyInfo describes a linked party.
+type PartyInfo struct {
+	InstanceID HexBytes
+	ByzCoinID  HexBytes
+	Name       string
+	DateTime   string
+	Location   string
+	Attendees  int
+}
+
+// LinkPartyRequest links a new party. As the party holds cryptographic keys,
+// it is given as the protobuf encoding of personhood.Party.
+type LinkPartyRequest struct {
+	Party []byte
+}
+
+// MessageInfo is an entry in the list of messages.
+type MessageInfo struct {
+	ID       HexBytes
+	Subject  string
+	Balance  uint64
+	Reward   uint64
+	PartyIID HexBytes
+}
+
+// Message is a new message to be stored by the service. TransferProof is the
+// protobuf encoding of a byzcoin.Proof of the transfer to the escrow coin, and
+// is only needed if the service has one. Nonce, Timestamp, in unix seconds,
+// and ScopeProof are only needed if the message has a Scope.
+type Message struct {
+	ID            HexBytes
+	Subject       string
+	Date          uint64
+	Text          string
+	Author        HexBytes
+	Balance       uint64
+	Reward        uint64
+	PartyIID      HexBytes
+	ParentMsgID   HexBytes
+	Scope         HexBytes
+	TransferProof HexBytes
+	Nonce         HexBytes
+	Timestamp     int64
+	ScopeProof    HexBytes
+}
+
+// Questionnaire is an entry in the list of questionnaires.
+type Questionnaire struct {
+	ID        HexBytes
+	Title     string
+	Questions []string
+	Replies   int
+	Balance   uint64
+	Reward    uint64
+}
+
+// Answer replies to a questionnaire. The Proof signs the Nonce and the
+// Timestamp, in unix seconds.
+type Answer struct {
+	QuestID   HexBytes
+	Replies   []int
+	Account   HexBytes
+	PartyIID  HexBytes
+	Proof     HexBytes
+	Nonce     HexBytes
+	Timestamp int64
+}
+
+// ListQuery holds the query parameters of the listing endpoints.
+type ListQuery struct {
+	Start  int
+	Number int
+	// Reader is only used when listing messages.
+	Reader HexBytes
+}
+
+// Error is returned with every failed request.
+type Error struct {
+	Error string
+}
+
+// Server maps the REST endpoints to the handlers of the personhood service.
+type Server struct {
+	service Service
+	mux     *http.ServeMux
+}
+
+// NewServer returns a Server calling the handlers of s.
+func NewServer(s Service) *Server {
+	srv := &Server{service: s, mux: http.NewServeMux()}
+	byPath := make(map[string][]route)
+	for _, r := range routes {
+		byPath[r.path] = append(byPath[r.path], r)
+	}
+	for path, rts := range byPath {
+		srv.handle(path, rts)
+	}
+	srv.mux.HandleFunc("/messages/watch", srv.watchMessages)
+	srv.mux.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(GenerateOpenAPISpec())
+	})
+	return srv
+}
+
+// ServeHTTP implements http.Handler. Requests from IPs refused by the
+// service are answered with http.StatusForbidden.
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := srv.service.CheckRemoteAddr(r.RemoteAddr); err != nil {
+		writeJSON(w, http.StatusForbidden, Error{err.Error()})
+		return
+	}
+	srv.mux.ServeHTTP(w, r)
+}
+
+// route describes one endpoint. The request and reply types are used to
+// generate the OpenAPI specification.
+type route struct {
+	method  string
+	path    string
+	summary string
+	request interface{}
+	reply   interface{}
+	call    func(srv *Server, r *http.Request) (interface{}, error)
+}
+
+// errBadRequest wraps errors caused by a malformed request.
+type errBadRequest struct {
+	error
+}
+
+var routes = []route{
+	{http.MethodGet, "/parties", "list the linked parties",
+		nil, []PartyInfo{}, (*Server).listParties},
+	{http.MethodPost, "/parties", "link a party",
+		LinkPartyRequest{}, nil, (*Server).linkParty},
+	{http.MethodGet, "/messages", "list the most valuable messages",
+		ListQuery{}, []MessageInfo{}, (*Server).listMessages},
+	{http.MethodPost, "/messages", "send a message",
+		Message{}, nil, (*Server).sendMessage},
+	{http.MethodGet, "/questionnaires", "list the questionnaires with the highest balance",
+		ListQuery{}, []Questionnaire{}, (*Server).listQuestionnaires},
+	{http.MethodPost, "/questionnaires/answers", "answer a questionnaire",
+		Answer{}, nil, (*Server).answerQuestionnaire},
+}
+
+// handle registers the routes sharing the same path and dispatches the
+// requests by their method.
+func (srv *Server) handle(path string, rts []route) {
+	srv.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		for _, rt := range rts {
+			if rt.method == r.Method {
+				reply, err := rt.call(srv, r)
+				srv.reply(w, reply, err)
+				return
+			}
+		}
+		writeJSON(w, http.StatusMethodNotAllowed, Error{"method not allowed"})
+	})
+}
+
+func (srv *Server) reply(w http.ResponseWriter, reply interface{}, err error) {
+	switch err.(type) {
+	case nil:
+		if reply == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, reply)
+	case errBadRequest:
+		writeJSON(w, http.StatusBadRequest, Error{err.Error()})
+	default:
+		writeJSON(w, http.StatusUnprocessableEntity, Error{err.Error()})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func decodeBody(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return errBadRequest{errors.New("couldn't decode body: " + err.Error())}
+	}
+	return nil
+}
+
+func decodeQuery(r *http.Request) (lq ListQuery, err error) {
+	q := r.URL.Query()
+	lq.Number = 10
+	if v := q.Get("start"); v != "" {
+		if lq.Start, err = strconv.Atoi(v); err != nil {
+			return lq, errBadRequest{errors.New("start: " + err.Error())}
+		}
+	}
+	if v := q.Get("number"); v != "" {
+		if lq.Number, err = strconv.Atoi(v); err != nil {
+			return lq, errBadRequest{errors.New("number: " + err.Error())}
+		}
+	}
+	if lq.Start < 0 || lq.Number < 0 {
+		return lq, errBadRequest{errors.New("start and number must be positive")}
+	}
+	if v := q.Get("reader"); v != "" {
+		if lq.Reader, err = hex.DecodeString(v); err != nil {
+			return lq, errBadRequest{errors.New("reader: " + err.Error())}
+		}
+	}
+	return
+}
+
+func instanceID(name string, h HexBytes) (iid byzcoin.InstanceID, err error) {
+	if len(h) == 0 {
+		return
+	}
+	if len(h) != len(iid) {
+		return iid, errBadRequest{errors.New(name + " must be a hex-encoded instance ID")}
+	}
+	return byzcoin.NewInstanceID(h), nil
+}
+
+func (srv *Server) listParties(r *http.Request) (interface{}, error) {
+	infos := []PartyInfo{}
+	for _, p := range srv.service.Parties() {
+		info := PartyInfo{
+			InstanceID: p.InstanceID.Slice(),
+			ByzCoinID:  HexBytes(p.ByzCoinID),
+			Attendees:  len(p.FinalStatement.Attendees),
+		}
+		if desc := p.FinalStatement.Desc; desc != nil {
+			info.Name = desc.Name
+			info.DateTime = desc.DateTime
+			info.Location = desc.Location
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (srv *Server) linkParty(r *http.Request) (interface{}, error) {
+	var lpr LinkPartyRequest
+	if err := decodeBody(r, &lpr); err != nil {
+		return nil, err
+	}
+	var lp personhood.LinkPoP
+	err := protobuf.DecodeWithConstructors(lpr.Party, &lp.Party,
+		network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return nil, errBadRequest{errors.New("couldn't decode party: " + err.Error())}
+	}
+	_, err = srv.service.LinkPoP(&lp)
+	return nil, err
+}
+
+func (srv *Server) listMessages(r *http.Request) (interface{}, error) {
+	lq, err := decodeQuery(r)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := instanceID("reader", lq.Reader)
+	if err != nil {
+		return nil, err
+	}
+	lmr, err := srv.service.ListMessages(&personhood.ListMessages{
+		Start:    lq.Start,
+		Number:   lq.Number,
+		ReaderID: reader,
+	})
+	if err != nil {
+		return nil, err
+	}
+	infos := []MessageInfo{}
+	for i, id := range lmr.MsgIDs {
+		infos = append(infos, MessageInfo{
+			ID:       id,
+			Subject:  lmr.Subjects[i],
+			Balance:  lmr.Balances[i],
+			Reward:   lmr.Rewards[i],
+			PartyIID: lmr.PartyIIDs[i].Slice(),
+		})
+	}
+	return infos, nil
+}
+
+// watchMessages upgrades the connection to a websocket sending the new
+// messages for the reader given in the query. As it isn't a JSON endpoint,
+// it is not part of the OpenAPI specification.
+func (srv *Server) watchMessages(w http.ResponseWriter, r *http.Request) {
+	lq, err := decodeQuery(r)
+	if err != nil {
+		srv.reply(w, nil, err)
+		return
+	}
+	reader, err := instanceID("reader", lq.Reader)
+	if err != nil {
+		srv.reply(w, nil, err)
+		return
+	}
+	srv.service.WatchMessages(reader).ServeHTTP(w, r)
+}
+
+func (srv *Server) sendMessage(r *http.Request) (interface{}, error) {
+	var m Message
+	if err := decodeBody(r, &m); err != nil {
+		return nil, err
+	}
+	if len(m.ID) == 0 {
+		return nil, errBadRequest{errors.New("missing message ID")}
+	}
+	msg := personhood.Message{
+		ID:          m.ID,
+		Subject:     m.Subject,
+		Date:        m.Date,
+		Text:        m.Text,
+		Balance:     m.Balance,
+		Reward:      m.Reward,
+		ParentMsgID: m.ParentMsgID,
+	}
+	var err error
+	if msg.Author, err = instanceID("Author", m.Author); err != nil {
+		return nil, err
+	}
+	if msg.PartyIID, err = instanceID("PartyIID", m.PartyIID); err != nil {
+		return nil, err
+	}
+	if msg.Scope, err = instanceID("Scope", m.Scope); err != nil {
+		return nil, err
+	}
+	_, err = srv.service.SendMessage(&personhood.SendMessage{
+		Message:       msg,
+		TransferProof: m.TransferProof,
+		Nonce:         m.Nonce,
+		Timestamp:     m.Timestamp,
+		ScopeProof:    m.ScopeProof,
+	})
+	return nil, err
+}
+
+func (srv *Server) listQuestionnaires(r *http.Request) (interface{}, error) {
+	lq, err := decodeQuery(r)
+	if err != nil {
+		return nil, err
+	}
+	lqr, err := srv.service.ListQuestionnaires(&personhood.ListQuestionnaires{
+		Start:  lq.Start,
+		Number: lq.Number,
+	})
+	if err != nil {
+		return nil, err
+	}
+	qs := []Questionnaire{}
+	for _, q := range lqr.Questionnaires {
+		qs = append(qs, Questionnaire{
+			ID:        q.ID,
+			Title:     q.Title,
+			Questions: q.Questions,
+			Replies:   q.Replies,
+			Balance:   q.Balance,
+			Reward:    q.Reward,
+		})
+	}
+	return qs, nil
+}
+
+func (srv *Server) answerQuestionnaire(r *http.Request) (interface{}, error) {
+	var a Answer
+	if err := decodeBody(r, &a); err != nil {
+		return nil, err
+	}
+	account, err := instanceID("Account", a.Account)
+	if err != nil {
+		return nil, err
+	}
+	partyIID, err := instanceID("PartyIID", a.PartyIID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = srv.service.AnswerQuestionnaire(&personhood.AnswerQuestionnaire{
+		QuestID:   a.QuestID,
+		Replies:   a.Replies,
+		Account:   account,
+		PartyIID:  partyIID,
+		Proof:     a.Proof,
+		Nonce:     a.Nonce,
+		Timestamp: a.Timestamp,
+	})
+	return nil, err
+}
@@ -0,0 +1,307 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/personhood"
+	pop "go.dedis.ch/cothority/v3/pop/service"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/util/key"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+func newTestServer(t *testing.T) (*onet.LocalTest, *personhood.Service, *httptest.Server) {
+	local := onet.NewLocalTest(cothority.Suite)
+	servers, _, _ := local.GenTree(1, true)
+	s := local.GetServices(servers, onet.ServiceFactory.ServiceID(personhood.ServiceName))[0]
+	ph := s.(*personhood.Service)
+	return local, ph, httptest.NewServer(NewServer(ph))
+}
+
+func do(t *testing.T, method, url string, in, out interface{}) int {
+	var body bytes.Buffer
+	if in != nil {
+		require.Nil(t, json.NewEncoder(&body).Encode(in))
+	}
+	req, err := http.NewRequest(method, url, &body)
+	require.Nil(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode == http.StatusOK {
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp.StatusCode
+}
+
+func TestServer_Messages(t *testing.T) {
+	local, _, ts := newTestServer(t)
+	defer local.CloseAll()
+	defer ts.Close()
+
+	author := byzcoin.NewInstanceID([]byte("author"))
+	for i, subject := range []string{"first", "second"} {
+		msg := Message{
+			ID:      []byte{byte(i + 1)},
+			Subject: subject,
+			Text:    "text of " + subject,
+			Author:  author.Slice(),
+			Balance: uint64(10 * (i + 1)),
+			Reward:  10,
+		}
+		require.Equal(t, http.StatusNoContent, do(t, http.MethodPost, ts.URL+"/messages", msg, nil))
+	}
+	require.Equal(t, http.StatusUnprocessableEntity,
+		do(t, http.MethodPost, ts.URL+"/messages", Message{ID: []byte{1}}, nil))
+	require.Equal(t, http.StatusBadRequest,
+		do(t, http.MethodPost, ts.URL+"/messages", Message{ID: []byte{3}, Author: []byte{1}}, nil))
+	require.Equal(t, http.StatusUnprocessableEntity,
+		do(t, http.MethodPost, ts.URL+"/messages", Message{ID: []byte{3}, ParentMsgID: []byte{4}}, nil))
+	require.Equal(t, http.StatusNoContent, do(t, http.MethodPost, ts.URL+"/messages",
+		Message{ID: []byte{3}, Subject: "reply", Balance: 1, Reward: 1, ParentMsgID: []byte{1}}, nil))
+
+	var msgs []MessageInfo
+	require.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/messages", nil, &msgs))
+	require.Equal(t, 3, len(msgs))
+	require.Equal(t, "second", msgs[0].Subject)
+	require.Equal(t, "first", msgs[1].Subject)
+
+	require.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/messages?start=1&number=1", nil, &msgs))
+	require.Equal(t, 1, len(msgs))
+	require.Equal(t, "first", msgs[0].Subject)
+	require.Equal(t, http.StatusBadRequest, do(t, http.MethodGet, ts.URL+"/messages?number=x", nil, nil))
+	require.Equal(t, http.StatusMethodNotAllowed, do(t, http.MethodDelete, ts.URL+"/messages", nil, nil))
+}
+
+// Sends a scoped message, and messages to a service with an escrow coin.
+func TestServer_MessagesScopeAndEscrow(t *testing.T) {
+	local, ph, ts := newTestServer(t)
+	defer local.CloseAll()
+	defer ts.Close()
+
+	attendee := key.NewKeyPair(cothority.Suite)
+	atts := []kyber.Point{attendee.Public}
+	partyIID := byzcoin.NewInstanceID([]byte("party"))
+	_, err := ph.LinkPoP(&personhood.LinkPoP{Party: personhood.Party{
+		ByzCoinID:      []byte("byzcoin"),
+		InstanceID:     partyIID,
+		FinalStatement: pop.FinalStatement{Attendees: atts},
+	}})
+	require.Nil(t, err)
+
+	sm := &personhood.SendMessage{Message: personhood.Message{ID: []byte("scoped"), Scope: partyIID}}
+	require.Nil(t, sm.SignScope(atts, *attendee))
+	scoped := Message{ID: sm.Message.ID, Subject: "scoped", Balance: 1, Reward: 1,
+		Scope: partyIID.Slice(), Nonce: sm.Nonce, Timestamp: sm.Timestamp}
+	require.Equal(t, http.StatusUnprocessableEntity,
+		do(t, http.MethodPost, ts.URL+"/messages", scoped, nil))
+	scoped.ScopeProof = sm.ScopeProof
+	require.Equal(t, http.StatusNoContent, do(t, http.MethodPost, ts.URL+"/messages", scoped, nil))
+	// The nonce can only be used once.
+	require.Equal(t, http.StatusUnprocessableEntity,
+		do(t, http.MethodPost, ts.URL+"/messages", scoped, nil))
+
+	// With an escrow coin, the transfer proof is given to the service.
+	ph.SetConfig(personhood.ServiceConfig{
+		EscrowCoinIID: byzcoin.NewInstanceID([]byte("escrow"))})
+	escrowError := func(msg Message) string {
+		var body bytes.Buffer
+		require.Nil(t, json.NewEncoder(&body).Encode(msg))
+		resp, err := http.Post(ts.URL+"/messages", "application/json", &body)
+		require.Nil(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+		var e Error
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&e))
+		return e.Error
+	}
+	escrowed := Message{ID: []byte("escrowed"), Subject: "escrowed", Balance: 1, Reward: 1,
+		PartyIID: partyIID.Slice()}
+	require.Contains(t, escrowError(escrowed), "transfer proof is missing")
+	escrowed.TransferProof = []byte{0xff}
+	require.Contains(t, escrowError(escrowed), "couldn't decode transfer proof")
+}
+
+func TestServer_WatchMessages(t *testing.T) {
+	local, ph, ts := newTestServer(t)
+	defer local.CloseAll()
+	defer ts.Close()
+
+	reader := byzcoin.NewInstanceID([]byte("reader"))
+	require.Equal(t, http.StatusBadRequest,
+		do(t, http.MethodGet, ts.URL+"/messages/watch?reader=1234", nil, nil))
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/messages/watch?reader=" +
+		hex.EncodeToString(reader.Slice())
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.Nil(t, err)
+	defer ws.Close()
+
+	// The watcher is registered after the upgrade, so messages are sent
+	// until the first one arrives.
+	received := make(chan personhood.MessageNotification)
+	go func() {
+		var mn personhood.MessageNotification
+		if ws.ReadJSON(&mn) == nil {
+			received <- mn
+		}
+	}()
+	for i := byte(1); ; i++ {
+		_, err := ph.SendMessage(&personhood.SendMessage{Message: personhood.Message{
+			ID:      []byte{i},
+			Subject: "news",
+			Balance: 10,
+			Reward:  10,
+		}})
+		require.Nil(t, err)
+		select {
+		case mn := <-received:
+			require.Equal(t, "news", mn.Subject)
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func TestServer_Questionnaires(t *testing.T) {
+	local, ph, ts := newTestServer(t)
+	defer local.CloseAll()
+	defer ts.Close()
+
+	// Questionnaires are registered through onet only.
+	_, err := ph.RegisterQuestionnaire(&personhood.RegisterQuestionnaire{Questionnaire: personhood.Questionnaire{
+		Title:     "poll",
+		Questions: []string{"yes", "no"},
+		Replies:   1,
+		Balance:   10,
+		Reward:    10,
+		ID:        []byte("quest"),
+	}})
+	require.Nil(t, err)
+
+	var qs []Questionnaire
+	require.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/questionnaires", nil, &qs))
+	require.Equal(t, 1, len(qs))
+	require.Equal(t, "poll", qs[0].Title)
+	require.Equal(t, []string{"yes", "no"}, qs[0].Questions)
+
+	attendee := key.NewKeyPair(cothority.Suite)
+	partyIID := byzcoin.NewInstanceID([]byte("party"))
+	_, err = ph.LinkPoP(&personhood.LinkPoP{Party: personhood.Party{
+		InstanceID:     partyIID,
+		FinalStatement: pop.FinalStatement{Attendees: []kyber.Point{attendee.Public}},
+	}})
+	require.Nil(t, err)
+	account := byzcoin.NewInstanceID([]byte("account"))
+	aq := &personhood.AnswerQuestionnaire{QuestID: []byte("quest"), Account: account,
+		PartyIID: partyIID}
+	require.Nil(t, aq.Sign([]kyber.Point{attendee.Public}, *attendee))
+	answer := Answer{QuestID: aq.QuestID, Replies: []int{2}, Account: account.Slice(),
+		PartyIID: partyIID.Slice(), Proof: aq.Proof, Nonce: aq.Nonce, Timestamp: aq.Timestamp}
+	require.Equal(t, http.StatusUnprocessableEntity,
+		do(t, http.MethodPost, ts.URL+"/questionnaires/answers", answer, nil))
+	answer.Replies = []int{1}
+	require.Equal(t, http.StatusNoContent,
+		do(t, http.MethodPost, ts.URL+"/questionnaires/answers", answer, nil))
+
+	// No balance left.
+	require.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/questionnaires", nil, &qs))
+	require.Equal(t, 0, len(qs))
+}
+
+func TestServer_Parties(t *testing.T) {
+	local, _, ts := newTestServer(t)
+	defer local.CloseAll()
+	defer ts.Close()
+
+	var parties []PartyInfo
+	require.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/parties", nil, &parties))
+	require.Equal(t, 0, len(parties))
+
+	party := personhood.Party{
+		ByzCoinID:  []byte("byzcoin"),
+		InstanceID: byzcoin.NewInstanceID([]byte("party")),
+		FinalStatement: pop.FinalStatement{
+			Desc: &pop.PopDesc{
+				Name:     "test-party",
+				DateTime: "2018-08-28 08:08",
+				Location: "BC208",
+				Roster:   local.GenRosterFromHost(local.GenServers(1)...),
+			},
+		},
+	}
+	buf, err := protobuf.Encode(&party)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusNoContent,
+		do(t, http.MethodPost, ts.URL+"/parties", LinkPartyRequest{buf}, nil))
+	require.Equal(t, http.StatusBadRequest,
+		do(t, http.MethodPost, ts.URL+"/parties", LinkPartyRequest{[]byte("garbage")}, nil))
+
+	require.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/parties", nil, &parties))
+	require.Equal(t, 1, len(parties))
+	require.Equal(t, "test-party", parties[0].Name)
+	require.Equal(t, "BC208", parties[0].Location)
+	require.Equal(t, hex.EncodeToString(party.InstanceID.Slice()),
+		hex.EncodeToString(parties[0].InstanceID))
+}
+
+func TestGenerateOpenAPISpec(t *testing.T) {
+	var spec struct {
+		OpenAPI string
+		Paths   map[string]map[string]struct {
+			Summary     string
+			Parameters  []struct{ Name string }
+			RequestBody *struct{}
+		}
+	}
+	require.Nil(t, json.Unmarshal(GenerateOpenAPISpec(), &spec))
+	require.Equal(t, "3.0.0", spec.OpenAPI)
+	for _, rt := range routes {
+		op, ok := spec.Paths[rt.path][map[string]string{
+			http.MethodGet:  "get",
+			http.MethodPost: "post",
+		}[rt.method]]
+		require.True(t, ok, rt.method+" "+rt.path)
+		require.Equal(t, rt.summary, op.Summary)
+	}
+	require.Equal(t, 3, len(spec.Paths["/messages"]["get"].Parameters))
+	require.NotNil(t, spec.Paths["/messages"]["post"].RequestBody)
+	var body struct {
+		Paths map[string]map[string]struct {
+			RequestBody struct {
+				Content map[string]struct {
+					Schema struct{ Properties map[string]interface{} }
+				}
+			}
+		}
+	}
+	require.Nil(t, json.Unmarshal(GenerateOpenAPISpec(), &body))
+	props := body.Paths["/messages"]["post"].RequestBody.Content["application/json"].Schema.Properties
+	for _, field := range []string{"ParentMsgID", "Scope", "TransferProof", "Nonce", "Timestamp", "ScopeProof"} {
+		require.Contains(t, props, field)
+	}
+
+	local, _, ts := newTestServer(t)
+	defer local.CloseAll()
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/openapi.json")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -6,15 +6,30 @@ runs on the node.
 */
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"net"
+	"net/http"
+	"os"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/google/btree"
+	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/byzcoin"
 	"go.dedis.ch/cothority/v3/byzcoin/contracts"
+	"go.dedis.ch/cothority/v3/darc"
+	pop "go.dedis.ch/cothority/v3/pop/service"
+	"go.dedis.ch/cothority/v3/skipchain"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
 )
 
 // Used for tests
@@ -23,37 +38,231 @@ var templateID onet.ServiceID
 // ServiceName of the personhood service
 var ServiceName = "Personhood"
 
+// sweepInterval is the time between two removals of the expired messages and
+// questionnaires.
+const sweepInterval = time.Minute
+
 func init() {
 	var err error
 	templateID, err = onet.RegisterNewService(ServiceName, newService)
 	log.ErrFatal(err)
 }
 
+// RESTPortEnv is the environment variable holding the port of the REST
+// interface of the service. If it is empty, no REST interface is started.
+const RESTPortEnv = "PERSONHOOD_REST_PORT"
+
+// NewRESTHandler returns the http.Handler of the REST interface. It is set by
+// the personhood/rest package, which needs to be imported for the REST
+// interface to be available.
+var NewRESTHandler func(s *Service) http.Handler
+
+// errRewardNotDue is returned by addPendingRead if the reader already got the
+// reward of the message.
+var errRewardNotDue = errors.New("the reward of this message is not due")
+
+// ErrDuplicatePartyName is returned by LinkPoP if another party with the same
+// name is already linked and finalized on its ledger.
+var ErrDuplicatePartyName = errors.New("a party with this name already exists")
+
 // Service is our template-service
 type Service struct {
 	// We need to embed the ServiceProcessor, so that incoming messages
 	// are correctly handled.
 	*onet.ServiceProcessor
 
+	// AllowDuplicateNames lets LinkPoP accept parties with the name of an
+	// already linked party.
+	AllowDuplicateNames bool
+	// Config holds the configuration of the service. It is loaded from the
+	// file in ConfigEnv. Once the service runs, it must only be changed with
+	// SetConfig or ReloadConfig.
+	Config        ServiceConfig
+	configRWMutex sync.RWMutex
+	// NewByzCoinClient returns the client used to send the rewards. It can
+	// be replaced by a mock in tests.
+	NewByzCoinClient func(id skipchain.SkipBlockID, roster onet.Roster) pop.ByzCoinClient
+	// AdminByzCoinID is the ledger holding the admin darc.
+	AdminByzCoinID skipchain.SkipBlockID
+	// AdminDarcID is the darc whose AdminAction rule allows to call the
+	// protected methods, like WipeParties. If it is nil, the protected
+	// methods cannot be called.
+	AdminDarcID darc.ID
+
 	storage *storage1
+	metrics ServiceMetrics
+
+	// messageWatchers holds the channels of the clients waiting for new
+	// messages, keyed by the instance ID of the reader.
+	messageWatchers map[string][]chan Message
+	watchersLock    sync.Mutex
+
+	// closing is closed by Shutdown to stop the background goroutines, which
+	// are tracked by sweeper.
+	closing   chan struct{}
+	closeOnce sync.Once
+	sweeper   sync.WaitGroup
+	// restServer serves the REST interface on restListener, if RESTPortEnv
+	// is set. Both are closed by Shutdown.
+	restServer   *http.Server
+	restListener net.Listener
+}
+
+// GetMetrics returns the counters of the requests handled by the service.
+func (s *Service) GetMetrics(*GetMetrics) (*ServiceMetrics, error) {
+	return &ServiceMetrics{
+		TotalPartiesListed:          atomic.LoadUint64(&s.metrics.TotalPartiesListed),
+		TotalMessagesRead:           atomic.LoadUint64(&s.metrics.TotalMessagesRead),
+		TotalQuestionnairesAnswered: atomic.LoadUint64(&s.metrics.TotalQuestionnairesAnswered),
+		TotalMessagesSent:           atomic.LoadUint64(&s.metrics.TotalMessagesSent),
+	}, nil
 }
 
 // LinkPoP stores a link to a pop-party to accept this configuration. It will
 // try to create an account to receive payments from clients.
 func (s *Service) LinkPoP(lp *LinkPoP) (*StringReply, error) {
 	log.Lvlf2("%s: Linking pop: %+v", s.ServerIdentity(), lp)
+	name := lp.Party.name()
+	// Only the names of parties finalized on their ledger are indexed, so
+	// that nobody can take the name of another party by linking a fake one.
+	indexed := name != "" && s.partyOnLedger(&lp.Party)
+	s.storage.Lock()
+	if iid, ok := s.storage.PartyNames[name]; indexed && ok &&
+		!iid.Equal(lp.Party.InstanceID) && !s.AllowDuplicateNames {
+		s.storage.Unlock()
+		return nil, ErrDuplicatePartyName
+	}
+	if old := s.storage.Parties[string(lp.Party.InstanceID.Slice())]; old != nil {
+		// Clients that don't know about versions send 0 and overwrite
+		// the party, like before versions were introduced.
+		if lp.Party.Version != 0 && old.Version != lp.Party.Version {
+			s.storage.Unlock()
+			return nil, ErrVersionConflict
+		}
+		lp.Party.Version = old.Version + 1
+		// The old name must not block other parties after a rename.
+		if oldName := old.name(); (oldName != name || !indexed) &&
+			s.storage.PartyNames[oldName].Equal(lp.Party.InstanceID) {
+			delete(s.storage.PartyNames, oldName)
+		}
+	} else {
+		lp.Party.Version = 1
+	}
 	s.storage.Parties[string(lp.Party.InstanceID.Slice())] = &lp.Party
+	if indexed {
+		s.storage.PartyNames[name] = lp.Party.InstanceID
+	}
+	s.storage.Unlock()
 	s.save()
-	log.Lvlf2("%s: parties: %+v", s.ServerIdentity(), s.storage.Parties)
+	log.Lvlf2("%s: linked party %x", s.ServerIdentity(), lp.Party.InstanceID.Slice())
 	return &StringReply{}, nil
 }
 
+// partyOnLedger returns whether the party is finalized on its ledger with the
+// same name.
+func (s *Service) partyOnLedger(p *Party) bool {
+	if p.ByzCoinID == nil || p.FinalStatement.Desc == nil || p.FinalStatement.Desc.Roster == nil {
+		return false
+	}
+	ppi, err := pop.PopPartyGetState(s.NewByzCoinClient(p.ByzCoinID, *p.FinalStatement.Desc.Roster), p.InstanceID)
+	if err != nil {
+		log.Lvlf2("%s: couldn't verify party %x: %s", s.ServerIdentity(), p.InstanceID.Slice(), err)
+		return false
+	}
+	return ppi.State == 2 && ppi.FinalStatement != nil && ppi.FinalStatement.Desc != nil &&
+		ppi.FinalStatement.Desc.Name == p.name()
+}
+
+// Parties returns all linked parties.
+func (s *Service) Parties() []Party {
+	s.storage.Lock()
+	defer s.storage.Unlock()
+	var parties []Party
+	for _, p := range s.storage.Parties {
+		parties = append(parties, *p)
+	}
+	sort.Slice(parties, func(i, j int) bool {
+		return parties[i].name() < parties[j].name()
+	})
+	atomic.AddUint64(&s.metrics.TotalPartiesListed, 1)
+	return parties
+}
+
+// GetParty returns the linked party with the given instance ID. If the party is
+// not linked, it is fetched from the ledger given in the request, which must
+// hold another linked party.
+func (s *Service) GetParty(gp *GetParty) (*GetPartyReply, error) {
+	s.storage.Lock()
+	p := s.storage.Parties[string(gp.InstanceID.Slice())]
+	s.storage.Unlock()
+	if p != nil {
+		party := *p
+		party.Signer = darc.Signer{}
+		return &GetPartyReply{Party: party, Linked: true}, nil
+	}
+	if gp.ByzCoinID == nil {
+		return nil, errors.New("party is not linked and no ledger given")
+	}
+	// Only connect to the ledgers of the linked parties, with their roster.
+	roster := s.storage.ledgerRoster(gp.ByzCoinID)
+	if roster == nil {
+		return nil, errors.New("no linked party is on this ledger")
+	}
+	ppi, err := pop.PopPartyGetState(s.NewByzCoinClient(gp.ByzCoinID, *roster), gp.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	party := Party{ByzCoinID: gp.ByzCoinID, InstanceID: gp.InstanceID}
+	if ppi.FinalStatement != nil {
+		party.FinalStatement = *ppi.FinalStatement
+	}
+	return &GetPartyReply{Party: party}, nil
+}
+
+// PartySearch returns the linked parties whose name contains the substring,
+// without case. The parties in configuration are returned first, each group
+// sorted by name.
+func (s *Service) PartySearch(ps *PartySearch) (*PartySearchReply, error) {
+	sub := strings.ToLower(ps.NameSubstring)
+	reply := &PartySearchReply{}
+	for _, p := range s.Parties() {
+		if strings.Contains(strings.ToLower(p.name()), sub) {
+			p.Signer = darc.Signer{}
+			reply.Parties = append(reply.Parties, p)
+		}
+	}
+	sort.SliceStable(reply.Parties, func(i, j int) bool {
+		return !reply.Parties[i].finalized() && reply.Parties[j].finalized()
+	})
+	return reply, nil
+}
+
 // RegisterQuestionnaire creates a questionnaire with a number of questions to
 // chose from and how much each replier gets rewarded.
 func (s *Service) RegisterQuestionnaire(rq *RegisterQuestionnaire) (*StringReply, error) {
-	idStr := string(rq.Questionnaire.ID)
-	s.storage.Questionnaires[idStr] = &rq.Questionnaire
-	s.storage.Replies[idStr] = &Reply{}
+	party := s.storage.getParty(rq.Questionnaire.PartyIID.Slice())
+	if (rq.Questionnaire.paysRewards() || len(rq.AuthorProof) > 0) && party == nil {
+		return nil, errors.New("no such partyIID")
+	}
+	rq.Questionnaire.AuthorTag = nil
+	if len(rq.AuthorProof) > 0 {
+		// The nonce and the timestamp are only checked if the author
+		// proof signs them.
+		if err := checkChallenge(rq.Nonce, rq.Timestamp, time.Now()); err != nil {
+			return nil, err
+		}
+		tag, err := rq.Verify(party.FinalStatement.Attendees)
+		if err != nil {
+			return nil, errors.New("invalid author proof: " + err.Error())
+		}
+		if err := s.storage.useNonce(rq.Nonce, time.Now()); err != nil {
+			return nil, err
+		}
+		rq.Questionnaire.AuthorTag = tag
+	}
+	if err := s.storage.addQuestionnaire(&rq.Questionnaire); err != nil {
+		return nil, err
+	}
 	return &StringReply{}, s.save()
 }
 
@@ -61,9 +270,18 @@ func (s *Service) RegisterQuestionnaire(rq *RegisterQuestionnaire) (*StringReply
 // Number.
 func (s *Service) ListQuestionnaires(lq *ListQuestionnaires) (*ListQuestionnairesReply, error) {
 	var qreply []Questionnaire
+	now := uint64(time.Now().Unix())
+	s.storage.Lock()
 	for _, q := range s.storage.Questionnaires {
-		qreply = append(qreply, *q)
+		if q.expired(now) || !q.hasAnyTag(lq.FilterTags) {
+			continue
+		}
+		qCopy := *q
+		qCopy.Signer = darc.Signer{}
+		qCopy.AuthorTag = nil
+		qreply = append(qreply, qCopy)
 	}
+	s.storage.Unlock()
 	sort.Slice(qreply, func(i, j int) bool {
 		return qreply[i].Balance > qreply[j].Balance
 	})
@@ -85,128 +303,575 @@ func (s *Service) ListQuestionnaires(lq *ListQuestionnaires) (*ListQuestionnaire
 
 // AnswerQuestionnaire sends the answer from one client.
 func (s *Service) AnswerQuestionnaire(aq *AnswerQuestionnaire) (*StringReply, error) {
-	q := s.storage.Questionnaires[string(aq.QuestID)]
+	if err := checkChallenge(aq.Nonce, aq.Timestamp, time.Now()); err != nil {
+		return nil, err
+	}
+	q := s.storage.getQuestionnaire(aq.QuestID)
 	if q == nil {
 		return nil, errors.New("didn't find questionnaire")
 	}
+	qCopy := *q
+	q = &qCopy
+	if q.expired(uint64(time.Now().Unix())) {
+		return nil, ErrQuestionnaireExpired
+	}
 	if len(aq.Replies) > q.Replies {
 		return nil, errors.New("too many replies")
 	}
+	chosen := make(map[int]bool)
 	for _, r := range aq.Replies {
 		if r >= len(q.Questions) || r < 0 {
 			return nil, errors.New("reply out of bound")
 		}
+		if chosen[r] {
+			return nil, errors.New("reply given twice")
+		}
+		chosen[r] = true
+	}
+	party := s.storage.getParty(aq.PartyIID.Slice())
+	if party == nil {
+		return nil, errors.New("no such partyIID")
+	}
+	tag, err := aq.Verify(party.FinalStatement.Attendees)
+	if err != nil {
+		return nil, errors.New("invalid proof: " + err.Error())
+	}
+	if err := s.storage.useNonce(aq.Nonce, time.Now()); err != nil {
+		return nil, err
+	}
+	q, r, err := s.addAnswer(aq.QuestID, aq.Account, tag)
+	if err != nil {
+		return nil, err
+	}
+	if q.paysRewards() {
+		if err := s.sendQuestionnaireReward(q, aq.Account); err != nil {
+			// Give the reward back, so that the questionnaire can be
+			// answered again.
+			s.abortAnswer(q, r, aq.Account, tag)
+			return nil, err
+		}
+	}
+	s.storage.Lock()
+	for len(r.Sum) < len(q.Questions) {
+		r.Sum = append(r.Sum, 0)
+	}
+	for _, i := range aq.Replies {
+		r.Sum[i]++
+	}
+	s.storage.Unlock()
+	atomic.AddUint64(&s.metrics.TotalQuestionnairesAnswered, 1)
+
+	return &StringReply{}, s.save()
+}
+
+// DeleteQuestionnaire removes the questionnaire and its replies. Only the
+// author who registered the questionnaire with an AuthorProof can delete it.
+func (s *Service) DeleteQuestionnaire(dq *DeleteQuestionnaire) (*StringReply, error) {
+	if err := checkChallenge(dq.Nonce, dq.Timestamp, time.Now()); err != nil {
+		return nil, err
+	}
+	q := s.storage.getQuestionnaire(dq.QuestID)
+	if q == nil {
+		return nil, ErrQuestionnaireNotFound
+	}
+	if len(q.AuthorTag) == 0 {
+		return nil, errors.New("questionnaire has no author")
+	}
+	party := s.storage.getParty(q.PartyIID.Slice())
+	if party == nil {
+		return nil, errors.New("no such partyIID")
+	}
+	tag, err := dq.Verify(party.FinalStatement.Attendees)
+	if err != nil {
+		return nil, errors.New("invalid proof: " + err.Error())
+	}
+	if !bytes.Equal(tag, q.AuthorTag) {
+		return nil, errors.New("only the author can delete the questionnaire")
+	}
+	if err := s.storage.useNonce(dq.Nonce, time.Now()); err != nil {
+		return nil, err
+	}
+	s.storage.deleteQuestionnaire(dq.QuestID)
+	return &StringReply{}, s.save()
+}
+
+// GetQuestionnaireResults returns how many users chose each question of the
+// questionnaire, and how many users answered it.
+func (s *Service) GetQuestionnaireResults(gqr *GetQuestionnaireResults) (*QuestionnaireResultsReply, error) {
+	s.storage.Lock()
+	defer s.storage.Unlock()
+	q := s.storage.Questionnaires[string(gqr.QuestID)]
+	if q == nil {
+		return nil, errors.New("didn't find questionnaire")
+	}
+	reply := &QuestionnaireResultsReply{VoteCounts: make([]int, len(q.Questions))}
+	if r := s.storage.Replies[string(gqr.QuestID)]; r != nil {
+		copy(reply.VoteCounts, r.Sum)
+		reply.Respondents = len(r.Users)
+	}
+	return reply, nil
+}
+
+// abortAnswer returns the reward of an answer whose reward couldn't be sent,
+// and removes the account and the tag from the reply of the questionnaire.
+func (s *Service) abortAnswer(q *Questionnaire, r *Reply, account byzcoin.InstanceID, tag []byte) {
+	err := s.updateQuestionnaire(q.ID, func(q *Questionnaire) {
+		q.Balance += q.Reward
+	})
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't give back reward:", err)
+	}
+	s.storage.Lock()
+	defer s.storage.Unlock()
+	for i, u := range r.Users {
+		if u.Equal(account) {
+			r.Users = append(r.Users[:i], r.Users[i+1:]...)
+			break
+		}
+	}
+	for i, t := range r.Tags {
+		if bytes.Equal(t, tag) {
+			r.Tags = append(r.Tags[:i], r.Tags[i+1:]...)
+			break
+		}
 	}
-	if q.Balance < q.Reward {
-		return nil, errors.New("no reward left")
+}
+
+// addAnswer checks that the attendee with the tag didn't answer the
+// questionnaire yet, takes the reward from its balance and records the
+// answer, all in one step. It returns the updated questionnaire and its
+// replies.
+func (s *Service) addAnswer(questID []byte, account byzcoin.InstanceID, tag []byte) (*Questionnaire, *Reply, error) {
+	s.storage.Lock()
+	defer s.storage.Unlock()
+	stored := s.storage.Questionnaires[string(questID)]
+	if stored == nil {
+		return nil, nil, errors.New("didn't find questionnaire")
 	}
-	r := s.storage.Replies[string(q.ID)]
+	r := s.storage.Replies[string(questID)]
 	if r == nil {
 		r = &Reply{}
-		s.storage.Replies[string(q.ID)] = r
-	} else {
-		for _, u := range r.Users {
-			if u.Equal(aq.Account) {
-				return nil, errors.New("cannot answer more than once")
-			}
+		s.storage.Replies[string(questID)] = r
+	}
+	for _, t := range r.Tags {
+		if bytes.Equal(t, tag) {
+			return nil, nil, errors.New("cannot answer more than once")
 		}
 	}
+	if stored.Balance < stored.Reward {
+		return nil, nil, errors.New("no reward left")
+	}
+	q := *stored
 	q.Balance -= q.Reward
-	r.Users = append(r.Users, aq.Account)
-	// TODO: send reward to account
+	if err := s.storage.compareAndSwapQuestionnaireLocked(&q); err != nil {
+		return nil, nil, err
+	}
+	r.Users = append(r.Users, account)
+	r.Tags = append(r.Tags, tag)
+	return &q, r, nil
+}
 
-	return &StringReply{}, s.save()
+// sendQuestionnaireReward sends the reward for answering the questionnaire
+// from its coin account to the account of the user.
+func (s *Service) sendQuestionnaireReward(q *Questionnaire, account byzcoin.InstanceID) error {
+	party := s.storage.getParty(q.PartyIID.Slice())
+	if party == nil {
+		return errors.New("no such partyIID")
+	}
+	cl := s.NewByzCoinClient(party.ByzCoinID, *party.FinalStatement.Desc.Roster)
+	signerCtrs, err := cl.GetSignerCounters(q.Signer.Identity().String())
+	if err != nil {
+		return err
+	}
+	if len(signerCtrs.Counters) != 1 {
+		return errors.New("incorrect version in signer counter")
+	}
+	cBuf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(cBuf, q.Reward)
+	ctx := byzcoin.ClientTransaction{
+		Instructions: []byzcoin.Instruction{{
+			InstanceID: q.CoinIID,
+			Invoke: &byzcoin.Invoke{
+				ContractID: contracts.ContractCoinID,
+				Command:    "transfer",
+				Args: []byzcoin.Argument{{
+					Name:  "coins",
+					Value: cBuf,
+				},
+					{
+						Name:  "destination",
+						Value: account.Slice(),
+					}},
+			},
+			SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
+		}},
+	}
+	if err = ctx.FillSignersAndSignWith(q.Signer); err != nil {
+		return errors.New("couldn't sign: " + err.Error())
+	}
+	if _, err = cl.AddTransactionAndWait(ctx, 10); err != nil {
+		return errors.New("couldn't send reward: " + err.Error())
+	}
+	return nil
 }
 
 // TopupQuestionnaire can be used to add new balance to a questionnaire.
 func (s *Service) TopupQuestionnaire(tq *TopupQuestionnaire) (*StringReply, error) {
-	quest := s.storage.Questionnaires[string(tq.QuestID)]
-	if quest == nil {
-		return nil, errors.New("this questionnaire doesn't exist")
+	err := s.updateQuestionnaire(tq.QuestID, func(q *Questionnaire) {
+		q.Balance += tq.Topup
+	})
+	if err != nil {
+		return nil, err
 	}
-	quest.Balance += tq.Topup
-	return &StringReply{}, nil
+	return &StringReply{}, s.save()
 }
 
 // SendMessage stores the message in the system.
 func (s *Service) SendMessage(sm *SendMessage) (*StringReply, error) {
 	log.Lvl2(s.ServerIdentity(), sm.Message)
-	idStr := string(sm.Message.ID)
-	if msg := s.storage.Messages[idStr]; msg != nil {
-		return nil, errors.New("this message-ID already exists")
+	if sm.Message.ExpiresAt == 0 {
+		sm.Message.ExpiresAt = uint64(time.Now().Add(DefaultMessageLifetime).Unix())
+	}
+	if !sm.Message.Scope.Equal(byzcoin.InstanceID{}) {
+		party := s.storage.getParty(sm.Message.Scope.Slice())
+		if party == nil {
+			return nil, errors.New("scope is not a linked party")
+		}
+		if err := sm.VerifyScope(party.FinalStatement.Attendees, time.Now()); err != nil {
+			return nil, errors.New("invalid scope proof: " + err.Error())
+		}
+	}
+	if len(sm.Message.ParentMsgID) > 0 &&
+		s.storage.getMessage(sm.Message.ParentMsgID) == nil {
+		return nil, errors.New("parent message doesn't exist")
+	}
+	// The nonce is only used if the scope proof signs it.
+	if !sm.Message.Scope.Equal(byzcoin.InstanceID{}) {
+		if err := s.storage.useNonce(sm.Nonce, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	if s.config().EscrowCoinIID.Equal(byzcoin.InstanceID{}) {
+		if !s.storage.addMessage(&sm.Message) {
+			return nil, errors.New("this message-ID already exists")
+		}
+	} else {
+		amount, transfer, err := s.escrowTransfer(sm.Message.PartyIID, sm.Message.ID, sm.TransferProof)
+		if err != nil {
+			return nil, err
+		}
+		if amount < sm.Message.Balance {
+			return nil, ErrInsufficientEscrow
+		}
+		if err := s.storage.addEscrowedMessage(&sm.Message, transfer); err != nil {
+			return nil, err
+		}
 	}
-	s.storage.Messages[idStr] = &sm.Message
-	s.storage.Read[idStr] = &readMsg{[]byzcoin.InstanceID{sm.Message.Author}}
+	s.notifyWatchers(sm.Message)
+	atomic.AddUint64(&s.metrics.TotalMessagesSent, 1)
 
 	return &StringReply{}, s.save()
 }
 
-// ListMessages sorts all messages by balance and sends back the messages from
-// Start, but not more than Number.
-func (s *Service) ListMessages(lm *ListMessages) (*ListMessagesReply, error) {
-	log.Lvl2(s.ServerIdentity(), lm)
-	var mreply []Message
-	for _, q := range s.storage.Messages {
-		for _, r := range s.storage.Read[string(q.ID)].Readers {
-			if r.Equal(lm.ReaderID) {
+// escrowTransfer verifies that the transfer proof holds a block of the ledger
+// of the party with an accepted transfer to the escrow coin, whose argument
+// "msgID" is msgID. It returns the amount of the transfer and the hash of its
+// instruction.
+func (s *Service) escrowTransfer(partyIID byzcoin.InstanceID, msgID []byte,
+	transferProof []byte) (uint64, []byte, error) {
+	party := s.storage.getParty(partyIID.Slice())
+	if party == nil {
+		return 0, nil, errors.New("no such partyIID")
+	}
+	var proof byzcoin.Proof
+	err := protobuf.DecodeWithConstructors(transferProof, &proof,
+		network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return 0, nil, errors.New("couldn't decode transfer proof: " + err.Error())
+	}
+	if proof.Latest.SkipBlockFix == nil {
+		return 0, nil, errors.New("transfer proof is missing")
+	}
+	if err := proof.Verify(party.ByzCoinID); err != nil {
+		return 0, nil, errors.New("invalid transfer proof: " + err.Error())
+	}
+	var header byzcoin.DataHeader
+	err = protobuf.DecodeWithConstructors(proof.Latest.Data, &header,
+		network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return 0, nil, errors.New("couldn't decode the header of the block: " + err.Error())
+	}
+	var body byzcoin.DataBody
+	err = protobuf.DecodeWithConstructors(proof.Latest.Payload, &body,
+		network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return 0, nil, errors.New("couldn't decode the body of the block: " + err.Error())
+	}
+	if !bytes.Equal(body.TxResults.Hash(), header.ClientTransactionHash) {
+		return 0, nil, errors.New("the transactions don't match the block")
+	}
+	for _, tx := range body.TxResults {
+		if !tx.Accepted {
+			continue
+		}
+		for _, inst := range tx.ClientTransaction.Instructions {
+			if inst.Invoke == nil || inst.Invoke.ContractID != contracts.ContractCoinID ||
+				inst.Invoke.Command != "transfer" {
 				continue
 			}
-		}
-		if q.Balance >= q.Reward {
-			mreply = append(mreply, *q)
+			args := inst.Invoke.Args
+			coins := args.Search("coins")
+			if len(coins) != 8 || !bytes.Equal(args.Search("msgID"), msgID) ||
+				!bytes.Equal(args.Search("destination"), s.config().EscrowCoinIID.Slice()) {
+				continue
+			}
+			return binary.LittleEndian.Uint64(coins), inst.Hash(), nil
 		}
 	}
-	sort.Slice(mreply, func(i, j int) bool {
-		return mreply[i].score() > mreply[j].score()
-	})
-	if len(mreply) < lm.Start {
-		return &ListMessagesReply{}, nil
-	}
-	mreply = mreply[lm.Start:]
-	if len(mreply) > lm.Number {
-		mreply = mreply[:lm.Number]
+	return 0, nil, errors.New("the block holds no transfer to the escrow for this message")
+}
+
+// ListMessages goes through the messages by descending score and sends back
+// the messages from Start, but not more than Number. If a Cursor is given,
+// the messages after it are returned. Expired messages are left out.
+func (s *Service) ListMessages(lm *ListMessages) (*ListMessagesReply, error) {
+	log.Lvl2(s.ServerIdentity(), lm)
+	var pivot messageScore
+	if len(lm.Cursor) > 0 {
+		var err error
+		if pivot, err = newMessageScoreFromCursor(lm.Cursor); err != nil {
+			return nil, err
+		}
 	}
-	for i, q := range mreply {
-		if q.Balance == 0 {
-			mreply = mreply[:i]
-			break
+	if !lm.ScopeFilter.Equal(byzcoin.InstanceID{}) {
+		party := s.storage.getParty(lm.ScopeFilter.Slice())
+		if party == nil {
+			return nil, errors.New("scope is not a linked party")
+		}
+		if err := lm.VerifyScope(party.FinalStatement.Attendees, time.Now()); err != nil {
+			return nil, errors.New("invalid scope proof: " + err.Error())
+		}
+		if err := s.storage.useNonce(lm.Nonce, time.Now()); err != nil {
+			return nil, err
 		}
 	}
+	now := uint64(time.Now().Unix())
 	lmr := &ListMessagesReply{}
-	for _, msg := range mreply {
+	skip := lm.Start
+	var last messageScore
+	iterate := func(i btree.Item) bool {
+		ms := i.(messageScore)
+		if len(lm.Cursor) > 0 && ms == pivot {
+			return true
+		}
+		msg := s.storage.Messages[ms.id]
+		if msg.Balance == 0 {
+			return false
+		}
+		if msg.expired(now) || s.storage.readBy(msg.ID, lm.ReaderID) {
+			return true
+		}
+		if !msg.Scope.Equal(lm.ScopeFilter) {
+			return true
+		}
+		if len(lm.ThreadID) > 0 &&
+			!bytes.Equal(s.storage.rootMessageLocked(msg).ID, lm.ThreadID) {
+			return true
+		}
+		if skip > 0 {
+			skip--
+			return true
+		}
+		if len(lmr.MsgIDs) >= lm.Number {
+			// There is at least one more message to list.
+			if len(lmr.MsgIDs) > 0 {
+				lmr.NextCursor = last.cursor()
+			}
+			return false
+		}
+		last = ms
 		lmr.MsgIDs = append(lmr.MsgIDs, msg.ID)
 		lmr.Subjects = append(lmr.Subjects, msg.Subject)
 		lmr.Balances = append(lmr.Balances, msg.Balance)
 		lmr.Rewards = append(lmr.Rewards, msg.Reward)
 		lmr.PartyIIDs = append(lmr.PartyIIDs, msg.PartyIID)
+		return true
+	}
+	s.storage.Lock()
+	defer s.storage.Unlock()
+	if len(lm.Cursor) == 0 {
+		s.storage.messageScores.Descend(iterate)
+	} else {
+		s.storage.messageScores.DescendLessOrEqual(pivot, iterate)
 	}
 	return lmr, nil
 }
 
+// GetThread returns the messages of the thread, sorted by date.
+func (s *Service) GetThread(gt *GetThread) (*GetThreadReply, error) {
+	err := s.checkScopeAccess(gt.Scope, gt.scopeMessage(), gt.Nonce, gt.Timestamp, gt.ScopeProof)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []Message
+	for _, msg := range s.storage.threadMessages(gt.ThreadID) {
+		if msg.Scope.Equal(gt.Scope) {
+			msgs = append(msgs, msg)
+		}
+	}
+	if len(msgs) == 0 {
+		return nil, errors.New("no such thread")
+	}
+	return &GetThreadReply{Messages: msgs}, nil
+}
+
+// GetMessage returns the full message, without sending a reward or recording
+// the reader.
+func (s *Service) GetMessage(gm *GetMessage) (*GetMessageReply, error) {
+	msg := s.storage.getMessage(gm.MsgID)
+	if msg == nil {
+		return nil, errors.New("no such messageID")
+	}
+	err := s.checkScopeAccess(msg.Scope, gm.scopeMessage(msg.Scope), gm.Nonce, gm.Timestamp, gm.ScopeProof)
+	if err != nil {
+		return nil, err
+	}
+	return &GetMessageReply{Message: *msg}, nil
+}
+
+// checkScopeAccess verifies the scope proof of a request for the messages of
+// the scope party on msg, and uses its nonce. Messages without a scope need
+// no proof.
+func (s *Service) checkScopeAccess(scope byzcoin.InstanceID, msg, nonce []byte, timestamp int64,
+	proof []byte) error {
+	if scope.Equal(byzcoin.InstanceID{}) {
+		return nil
+	}
+	party := s.storage.getParty(scope.Slice())
+	if party == nil {
+		return errors.New("scope is not a linked party")
+	}
+	if err := checkChallenge(nonce, timestamp, time.Now()); err != nil {
+		return err
+	}
+	if _, err := verifyLRS(msg, nil, party.FinalStatement.Attendees, proof); err != nil {
+		return errors.New("invalid scope proof: " + err.Error())
+	}
+	return s.storage.useNonce(nonce, time.Now())
+}
+
 // ReadMessage requests the full message and the reward for that message.
 func (s *Service) ReadMessage(rm *ReadMessage) (*ReadMessageReply, error) {
-	msg := s.storage.Messages[string(rm.MsgID)]
+	msg := s.storage.getMessage(rm.MsgID)
 	if msg == nil {
 		return nil, errors.New("no such messageID")
 	}
-	party := s.storage.Parties[string(rm.PartyIID)]
+	err := s.checkScopeAccess(msg.Scope, rm.scopeMessage(msg.Scope), rm.Nonce, rm.Timestamp, rm.ScopeProof)
+	if err != nil {
+		return nil, err
+	}
+	party := s.storage.getParty(rm.PartyIID)
 	if party == nil {
 		return nil, errors.New("no such partyIID")
 	}
-	if msg.Balance < msg.Reward ||
-		msg.Author.Equal(rm.Reader) {
+	s.storage.Lock()
+	rewardDue := msg.Balance >= msg.Reward && !msg.Author.Equal(rm.Reader) &&
+		!s.storage.readBy(msg.ID, rm.Reader)
+	s.storage.Unlock()
+	if !rewardDue {
+		atomic.AddUint64(&s.metrics.TotalMessagesRead, 1)
 		return &ReadMessageReply{*msg, false}, nil
 	}
-	read := s.storage.Read[string(msg.ID)]
-	for _, reader := range read.Readers {
-		if reader.Equal(rm.Reader) {
-			return &ReadMessageReply{*msg, false}, nil
-		}
+
+	// Phase 1: reserve the reward by decreasing the balance of the
+	// message, so that concurrent reads cannot spend it twice.
+	prKey, err := s.addPendingRead(msg.ID, rm.Reader)
+	if err == errRewardNotDue {
+		atomic.AddUint64(&s.metrics.TotalMessagesRead, 1)
+		return &ReadMessageReply{*s.storage.getMessage(msg.ID), false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	reply, err := s.sendReadReward(party, msg, rm)
+	if err != nil {
+		s.abortPendingRead(prKey)
+		return nil, err
+	}
+	// Phase 2: the reward is sent, add the reader to the message.
+	s.commitPendingRead(prKey)
+	reply.Message = *s.storage.getMessage(msg.ID)
+	atomic.AddUint64(&s.metrics.TotalMessagesRead, 1)
+	return reply, s.save()
+}
+
+// addPendingRead reserves the reward of the message for the reader by
+// decreasing its balance. It returns errRewardNotDue if the reader already
+// got the reward of the message, and an error if the reader is already
+// reading the message, or if the balance doesn't cover the reward. The check
+// and the decrease are done in one locked step, so that concurrent reads
+// cannot both pass the check.
+func (s *Service) addPendingRead(msgID []byte, reader byzcoin.InstanceID) (string, error) {
+	s.storage.Lock()
+	defer s.storage.Unlock()
+	stored := s.storage.Messages[string(msgID)]
+	if stored == nil {
+		return "", errors.New("no such messageID")
+	}
+	if stored.Author.Equal(reader) || s.storage.readBy(msgID, reader) {
+		return "", errRewardNotDue
+	}
+	key := string(msgID) + string(reader.Slice())
+	if s.storage.PendingReads[key] != nil {
+		return "", errors.New("this reader is already reading this message")
+	}
+	if stored.Balance < stored.Reward {
+		return "", errors.New("the balance of this message doesn't cover the reward")
 	}
+	msg := *stored
 	msg.Balance -= msg.Reward
-	read.Readers = append(read.Readers, rm.Reader)
+	if err := s.storage.compareAndSwapMessageLocked(&msg); err != nil {
+		return "", err
+	}
+	s.storage.PendingReads[key] = &PendingRead{
+		MsgID:  msgID,
+		Reader: reader,
+		Reward: msg.Reward,
+	}
+	return key, nil
+}
+
+// commitPendingRead adds the reader to the readers of the message, whose
+// balance has been decreased by addPendingRead.
+func (s *Service) commitPendingRead(key string) {
+	s.storage.Lock()
+	defer s.storage.Unlock()
+	pr := s.storage.PendingReads[key]
+	delete(s.storage.PendingReads, key)
+	idStr := string(pr.MsgID)
+	s.storage.Read[idStr].Readers = append(s.storage.Read[idStr].Readers, pr.Reader)
+}
 
-	cl := byzcoin.NewClient(party.ByzCoinID, *party.FinalStatement.Desc.Roster)
+// abortPendingRead gives the reward reserved for the reader back to the
+// message.
+func (s *Service) abortPendingRead(key string) {
+	s.storage.Lock()
+	pr := s.storage.PendingReads[key]
+	delete(s.storage.PendingReads, key)
+	s.storage.Unlock()
+	err := s.updateMessage(pr.MsgID, func(msg *Message) {
+		msg.Balance += pr.Reward
+	})
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't give back reward:", err)
+	}
+}
+
+// sendReadReward sends the reward for reading the message from the coin
+// account of the party to the reader. For a reply, the reward is split
+// between the reader and the author of the root message.
+func (s *Service) sendReadReward(party *Party, msg *Message, rm *ReadMessage) (*ReadMessageReply, error) {
+	cl := s.NewByzCoinClient(party.ByzCoinID, *party.FinalStatement.Desc.Roster)
 	signerCtrs, err := cl.GetSignerCounters(party.Signer.Identity().String())
 	if err != nil {
 		return nil, err
@@ -215,8 +880,6 @@ func (s *Service) ReadMessage(rm *ReadMessage) (*ReadMessageReply, error) {
 		return nil, errors.New("incorrect version in signer counter")
 	}
 
-	cBuf := make([]byte, 8)
-	binary.LittleEndian.PutUint64(cBuf, msg.Reward)
 	partyCoin := sha256.New()
 	partyCoin.Write(rm.PartyIID)
 	pubBuf, err := party.Signer.Ed25519.Point.MarshalBinary()
@@ -224,9 +887,13 @@ func (s *Service) ReadMessage(rm *ReadMessage) (*ReadMessageReply, error) {
 		return nil, errors.New("couldn't marshal party public key: " + err.Error())
 	}
 	partyCoin.Write(pubBuf)
-	ctx := byzcoin.ClientTransaction{
-		Instructions: []byzcoin.Instruction{{
-			InstanceID: byzcoin.NewInstanceID(partyCoin.Sum(nil)),
+	coinIID := byzcoin.NewInstanceID(partyCoin.Sum(nil))
+	transfer := func(coins uint64, dest byzcoin.InstanceID) byzcoin.Instruction {
+		cBuf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(cBuf, coins)
+		signerCtrs.Counters[0]++
+		return byzcoin.Instruction{
+			InstanceID: coinIID,
 			Invoke: &byzcoin.Invoke{
 				ContractID: contracts.ContractCoinID,
 				Command:    "transfer",
@@ -236,11 +903,25 @@ func (s *Service) ReadMessage(rm *ReadMessage) (*ReadMessageReply, error) {
 				},
 					{
 						Name:  "destination",
-						Value: rm.Reader.Slice(),
+						Value: dest.Slice(),
 					}},
 			},
-			SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
-		}},
+			SignerCounter: []uint64{signerCtrs.Counters[0]},
+		}
+	}
+
+	ctx := byzcoin.ClientTransaction{}
+	if len(msg.ParentMsgID) == 0 {
+		ctx.Instructions = append(ctx.Instructions, transfer(msg.Reward, rm.Reader))
+	} else {
+		// For a reply, part of the reward goes to the author of the root
+		// message.
+		root := s.storage.rootMessage(msg)
+		readerReward, rootReward := s.config().splitReward(msg.Reward)
+		ctx.Instructions = append(ctx.Instructions, transfer(readerReward, rm.Reader))
+		if rootReward > 0 {
+			ctx.Instructions = append(ctx.Instructions, transfer(rootReward, root.Author))
+		}
 	}
 
 	err = ctx.FillSignersAndSignWith(party.Signer)
@@ -251,33 +932,162 @@ func (s *Service) ReadMessage(rm *ReadMessage) (*ReadMessageReply, error) {
 	if err != nil {
 		return nil, errors.New("couldn't send reward: " + err.Error())
 	}
+	return &ReadMessageReply{Rewarded: true}, nil
+}
 
-	return &ReadMessageReply{*msg, true}, s.save()
+// updateMessage applies update to a copy of the message and stores the copy.
+// If the message has been changed in the meantime, update is applied again
+// to the new version.
+func (s *Service) updateMessage(id []byte, update func(msg *Message)) error {
+	for {
+		s.storage.Lock()
+		stored := s.storage.Messages[string(id)]
+		s.storage.Unlock()
+		if stored == nil {
+			return errors.New("this message doesn't exist")
+		}
+		msg := *stored
+		update(&msg)
+		err := s.storage.compareAndSwapMessage(&msg)
+		if err != ErrVersionConflict {
+			return err
+		}
+	}
+}
+
+// updateQuestionnaire applies update to a copy of the questionnaire and
+// stores the copy. If the questionnaire has been changed in the meantime,
+// update is applied again to the new version.
+func (s *Service) updateQuestionnaire(id []byte, update func(q *Questionnaire)) error {
+	for {
+		s.storage.Lock()
+		stored := s.storage.Questionnaires[string(id)]
+		s.storage.Unlock()
+		if stored == nil {
+			return errors.New("this questionnaire doesn't exist")
+		}
+		q := *stored
+		update(&q)
+		err := s.storage.compareAndSwapQuestionnaire(&q)
+		if err != ErrVersionConflict {
+			return err
+		}
+	}
+}
+
+// sweep removes the expired messages and questionnaires every sweepInterval,
+// until the service is shut down.
+func (s *Service) sweep() {
+	defer s.sweeper.Done()
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-ticker.C:
+			s.removeExpired()
+		}
+	}
 }
 
-// TopupMessage to fill up the balance of a message
+// removeExpired removes the expired messages, questionnaires and nonces.
+func (s *Service) removeExpired() {
+	now := uint64(time.Now().Unix())
+	msgs := s.storage.deleteExpiredMessages(now)
+	qs := s.storage.deleteExpiredQuestionnaires(now)
+	nonces := s.storage.deleteExpiredNonces(int64(now))
+	if len(msgs) == 0 && len(qs) == 0 && nonces == 0 {
+		return
+	}
+	log.Lvl2(s.ServerIdentity(), "removed", len(msgs), "expired messages,",
+		len(qs), "expired questionnaires and", nonces, "expired nonces")
+	if err := s.save(); err != nil {
+		log.Error(s.ServerIdentity(), "couldn't save:", err)
+	}
+}
+
+// Shutdown stops the background goroutines and the REST interface of the
+// service, waits for them to return, and saves the storage. It can be called
+// more than once.
+func (s *Service) Shutdown() error {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+		if s.restServer != nil {
+			if err := s.restServer.Close(); err != nil {
+				log.Error(s.ServerIdentity(), "couldn't close REST interface:", err)
+			}
+			// In case Serve didn't start yet.
+			s.restListener.Close()
+		}
+	})
+	s.sweeper.Wait()
+	return s.save()
+}
+
+// TestClose is called by onet when closing a LocalTest.
+func (s *Service) TestClose() {
+	if err := s.Shutdown(); err != nil {
+		log.Error(s.ServerIdentity(), "couldn't shut down:", err)
+	}
+}
+
+// TopupMessage to fill up the balance of a message. With an escrow coin, the
+// TransferProof must hold a transfer of at least Amount for the message.
 func (s *Service) TopupMessage(tm *TopupMessage) (*StringReply, error) {
-	msg := s.storage.Messages[string(tm.MsgID)]
+	if s.config().EscrowCoinIID.Equal(byzcoin.InstanceID{}) {
+		err := s.updateMessage(tm.MsgID, func(msg *Message) {
+			msg.Balance += tm.Amount
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &StringReply{}, s.save()
+	}
+	msg := s.storage.getMessage(tm.MsgID)
 	if msg == nil {
 		return nil, errors.New("this message doesn't exist")
 	}
-	msg.Balance += tm.Amount
-	return &StringReply{}, nil
+	amount, transfer, err := s.escrowTransfer(msg.PartyIID, tm.MsgID, tm.TransferProof)
+	if err != nil {
+		return nil, err
+	}
+	if amount < tm.Amount {
+		return nil, ErrInsufficientEscrow
+	}
+	if err := s.storage.topupEscrowedMessage(tm.MsgID, tm.Amount, transfer); err != nil {
+		return nil, err
+	}
+	return &StringReply{}, s.save()
 }
 
 func newService(c *onet.Context) (onet.Service, error) {
 	s := &Service{
 		ServiceProcessor: onet.NewServiceProcessor(c),
+		messageWatchers:  make(map[string][]chan Message),
+		closing:          make(chan struct{}),
+		NewByzCoinClient: func(id skipchain.SkipBlockID, roster onet.Roster) pop.ByzCoinClient {
+			return byzcoin.NewClient(id, roster)
+		},
 	}
 	if err := s.RegisterHandlers(s.AnswerQuestionnaire, s.LinkPoP, s.ListMessages,
 		s.ListQuestionnaires, s.ReadMessage, s.RegisterQuestionnaire, s.SendMessage,
-		s.TopupQuestionnaire, s.TopupMessage); err != nil {
+		s.TopupQuestionnaire, s.TopupMessage, s.WipeParties, s.GetExpiredMessages,
+		s.GetQuestionnaireResults, s.DeleteQuestionnaire, s.GetMessage,
+		s.GetThread, s.WipeMessages, s.GetParty, s.PartySearch,
+		s.GetMetrics, s.ReloadConfig); err != nil {
 		return nil, errors.New("Couldn't register messages")
 	}
 	if err := s.tryLoad(); err != nil {
 		log.Error(err)
 		return nil, err
 	}
+	if err := s.loadAdminDarc(); err != nil {
+		return nil, err
+	}
+	if err := s.loadConfig(); err != nil {
+		return nil, err
+	}
 	if len(s.storage.Messages) == 0 {
 		s.storage.Messages = make(map[string]*Message)
 	}
@@ -293,5 +1103,47 @@ func newService(c *onet.Context) (onet.Service, error) {
 	if len(s.storage.Read) == 0 {
 		s.storage.Read = make(map[string]*readMsg)
 	}
+	if len(s.storage.PartyNames) == 0 {
+		s.storage.PartyNames = make(map[string]byzcoin.InstanceID)
+	}
+	if len(s.storage.PendingReads) == 0 {
+		s.storage.PendingReads = make(map[string]*PendingRead)
+	}
+	if len(s.storage.Nonces) == 0 {
+		s.storage.Nonces = make(map[string]int64)
+	}
+	if len(s.storage.EscrowTransfers) == 0 {
+		s.storage.EscrowTransfers = make(map[string]bool)
+	}
+	s.storage.buildMessageScores()
+	s.sweeper.Add(1)
+	go s.sweep()
+	if port := os.Getenv(RESTPortEnv); port != "" && NewRESTHandler != nil {
+		log.Lvl2(s.ServerIdentity(), "starting REST interface on port", port)
+		if err := s.startREST(":"+port, NewRESTHandler(s)); err != nil {
+			log.Error(s.ServerIdentity(), "couldn't start REST interface:", err)
+		}
+	}
 	return s, nil
 }
+
+// startREST serves the handler on addr until Shutdown is called.
+func (s *Service) startREST(addr string, handler http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.restListener = ln
+	s.restServer = &http.Server{Handler: handler}
+	s.sweeper.Add(1)
+	go func() {
+		defer s.sweeper.Done()
+		err := s.restServer.Serve(ln)
+		select {
+		case <-s.closing:
+		default:
+			log.Error(s.ServerIdentity(), "REST interface stopped:", err)
+		}
+	}()
+	return nil
+}
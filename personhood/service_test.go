@@ -3,13 +3,23 @@ package personhood
 import (
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/byzcoin"
 	"go.dedis.ch/cothority/v3/byzcoin/contracts"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
 	"go.dedis.ch/cothority/v3/darc"
 	pop "go.dedis.ch/cothority/v3/pop/service"
 	"go.dedis.ch/cothority/v3/skipchain"
@@ -48,6 +58,1172 @@ func TestService_LinkPoP(t *testing.T) {
 	require.Equal(t, "coin", cid)
 }
 
+// Goes through all states of a party: spawning with 3 organizers and 5
+// attendees, finalizing, and checking that every attendee got its reward.
+func TestFullPartyLifecycle(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	s.createParty(t, 3, 5)
+	s.checkPartyLifecycle(t)
+}
+
+// Measures the time needed for the full lifecycle of a party.
+func BenchmarkFullPartyLifecycle(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		s := newS(b)
+		s.createParty(b, 3, 5)
+		s.checkPartyLifecycle(b)
+		s.Close()
+	}
+}
+
+// checkPartyLifecycle verifies that the party is finalized, that every
+// attendee received the reward, that the gas has been paid, and that there is no account for a
+// non-attendee.
+func (s *sStruct) checkPartyLifecycle(t testing.TB) {
+	gpr, err := s.ols.GetProof(&byzcoin.GetProof{
+		Version: byzcoin.CurrentVersion,
+		Key:     s.popI.Slice(),
+		ID:      s.olID,
+	})
+	require.Nil(t, err)
+	_, v0, _, _, err := gpr.Proof.KeyValue()
+	require.Nil(t, err)
+	var ppi pop.PopPartyInstance
+	err = protobuf.DecodeWithConstructors(v0, &ppi, network.DefaultConstructors(cothority.Suite))
+	require.Nil(t, err)
+	require.Equal(t, 2, ppi.State)
+	require.Equal(t, len(s.attendees), len(ppi.FinalStatement.Attendees))
+
+	require.Equal(t, len(s.attendees), len(s.attCoin))
+	for _, coin := range s.attCoin {
+		require.Equal(t, uint64(pop.AttendeeReward), s.coinGet(t, coin).Value)
+	}
+	require.Equal(t, 1000000-pop.FinalizeGas(len(s.attendees)), s.coinGet(t, s.gasCoin).Value)
+
+	stranger := key.NewKeyPair(tSuite)
+	buf, err := stranger.Public.MarshalBinary()
+	require.Nil(t, err)
+	inst := sha256.New()
+	inst.Write(s.popI.Slice())
+	inst.Write(buf)
+	strangerCoin := byzcoin.NewInstanceID(inst.Sum(nil))
+	gpr, err = s.ols.GetProof(&byzcoin.GetProof{
+		Version: byzcoin.CurrentVersion,
+		Key:     strangerCoin.Slice(),
+		ID:      s.olID,
+	})
+	require.Nil(t, err)
+	require.False(t, gpr.Proof.InclusionProof.Match(strangerCoin.Slice()))
+}
+
+// Sends two finalize instructions for the same party in one transaction.
+// The second instruction must be refused because the party is already
+// finalized by the first one, so the whole transaction is rejected and the
+// party stays in its configuration state.
+func TestService_DoubleFinalize(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	s.finalizeParty(t, len(s.servers), 3)
+
+	signerCtrs, err := s.ols.GetSignerCounters(&byzcoin.GetSignerCounters{
+		SignerIDs:   []string{s.signer.Identity().String()},
+		SkipchainID: s.olID,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(signerCtrs.Counters))
+
+	fsBuf, err := protobuf.Encode(&s.party)
+	require.Nil(t, err)
+	// Fetch enough gas for both finalizations.
+	gas := make([]byte, 8)
+	binary.LittleEndian.PutUint64(gas, 2*pop.FinalizeGas(len(s.party.Attendees)))
+	instrs := byzcoin.Instructions{{
+		InstanceID: s.gasCoin,
+		Invoke: &byzcoin.Invoke{
+			ContractID: contracts.ContractCoinID,
+			Command:    "fetch",
+			Args:       byzcoin.Arguments{{Name: "coins", Value: gas}},
+		},
+		SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
+	}}
+	for i := uint64(2); i <= 3; i++ {
+		instrs = append(instrs, byzcoin.Instruction{
+			InstanceID: s.popI,
+			Invoke: &byzcoin.Invoke{
+				ContractID: pop.ContractPopParty,
+				Command:    "Finalize",
+				Args: byzcoin.Arguments{{
+					Name:  "FinalStatement",
+					Value: fsBuf,
+				}},
+			},
+			SignerCounter: []uint64{signerCtrs.Counters[0] + i},
+		})
+	}
+	ctx := byzcoin.ClientTransaction{Instructions: instrs}
+	require.Nil(t, ctx.FillSignersAndSignWith(s.signer))
+	_, err = s.ols.AddTransaction(&byzcoin.AddTxRequest{
+		Version:       byzcoin.CurrentVersion,
+		SkipchainID:   s.olID,
+		Transaction:   ctx,
+		InclusionWait: 10,
+	})
+	require.NotNil(t, err)
+
+	gpr, err := s.ols.GetProof(&byzcoin.GetProof{
+		Version: byzcoin.CurrentVersion,
+		Key:     s.popI.Slice(),
+		ID:      s.olID,
+	})
+	require.Nil(t, err)
+	_, v0, _, _, err := gpr.Proof.KeyValue()
+	require.Nil(t, err)
+	var ppi pop.PopPartyInstance
+	err = protobuf.DecodeWithConstructors(v0, &ppi, network.DefaultConstructors(cothority.Suite))
+	require.Nil(t, err)
+	require.Equal(t, 1, ppi.State)
+
+	// A single finalization still works afterwards.
+	s.invokePoPFinalize(t)
+}
+
+// Links parties with the same and with different names.
+func TestService_LinkPoPUniqueName(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+	ledger := newPartyLedger(t)
+	ph.NewByzCoinClient = func(skipchain.SkipBlockID, onet.Roster) pop.ByzCoinClient {
+		return ledger
+	}
+
+	unverified := func(name string, iid string) *LinkPoP {
+		return &LinkPoP{Party: Party{
+			ByzCoinID:  s.olID,
+			InstanceID: byzcoin.NewInstanceID([]byte(iid)),
+			FinalStatement: pop.FinalStatement{
+				Desc: &pop.PopDesc{Name: name, Roster: s.roster},
+			},
+		}}
+	}
+	party := func(name string, iid string) *LinkPoP {
+		lp := unverified(name, iid)
+		ledger.finalize(t, lp.Party)
+		return lp
+	}
+	_, err := ph.LinkPoP(party("party1", "iid1"))
+	require.Nil(t, err)
+	_, err = ph.LinkPoP(party("party2", "iid2"))
+	require.Nil(t, err)
+	// Linking the same party again is OK.
+	_, err = ph.LinkPoP(party("party1", "iid1"))
+	require.Nil(t, err)
+	_, err = ph.LinkPoP(party("party1", "iid3"))
+	require.Equal(t, ErrDuplicatePartyName, err)
+
+	ph.AllowDuplicateNames = true
+	_, err = ph.LinkPoP(party("party1", "iid3"))
+	require.Nil(t, err)
+	ph.AllowDuplicateNames = false
+
+	// Parties that are not on their ledger and parties without a name
+	// don't take the name.
+	_, err = ph.LinkPoP(unverified("party2", "iid5"))
+	require.Nil(t, err)
+	_, err = ph.LinkPoP(unverified("unverified", "iid6"))
+	require.Nil(t, err)
+	_, err = ph.LinkPoP(party("unverified", "iid7"))
+	require.Nil(t, err)
+	_, err = ph.LinkPoP(party("", "iid8"))
+	require.Nil(t, err)
+	_, err = ph.LinkPoP(party("", "iid9"))
+	require.Nil(t, err)
+	_, err = ph.LinkPoP(&LinkPoP{Party: Party{InstanceID: byzcoin.NewInstanceID([]byte("iid10"))}})
+	require.Nil(t, err)
+	_, err = ph.LinkPoP(&LinkPoP{Party: Party{InstanceID: byzcoin.NewInstanceID([]byte("iid11"))}})
+	require.Nil(t, err)
+
+	// Older data has no index, and the names are indexed again when the
+	// parties are linked again.
+	ph.storage.PartyNames = nil
+	require.Nil(t, ph.save())
+	require.Nil(t, ph.tryLoad())
+	require.Equal(t, 0, len(ph.storage.PartyNames))
+	_, err = ph.LinkPoP(party("party2", "iid2"))
+	require.Nil(t, err)
+	_, err = ph.LinkPoP(party("party2", "iid4"))
+	require.Equal(t, ErrDuplicatePartyName, err)
+
+	// After a rename, the old name is free again.
+	_, err = ph.LinkPoP(party("renamed", "iid2"))
+	require.Nil(t, err)
+	_, err = ph.LinkPoP(party("party2", "iid4"))
+	require.Nil(t, err)
+
+	// Only one of concurrent links with the same name succeeds.
+	var wg sync.WaitGroup
+	lps := make([]*LinkPoP, 10)
+	for i := range lps {
+		lps[i] = party("concurrent", fmt.Sprintf("iid-c%d", i))
+	}
+	errs := make([]error, len(lps))
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = ph.LinkPoP(lps[i])
+		}(i)
+	}
+	wg.Wait()
+	linked := 0
+	for _, err := range errs {
+		if err == nil {
+			linked++
+		} else {
+			require.Equal(t, ErrDuplicatePartyName, err)
+		}
+	}
+	require.Equal(t, 1, linked)
+}
+
+// Calls the service with different access control lists.
+func TestService_IPACL(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	cl := NewClient()
+	si := s.servers[0].ServerIdentity
+	list := func() error {
+		_, err := cl.ListQuestionnaires(si, 0, 10)
+		return err
+	}
+
+	for _, test := range []struct {
+		allow, deny []string
+		ok          bool
+	}{
+		{nil, nil, true},
+		{[]string{"127.0.0.0/8"}, nil, true},
+		{[]string{"10.0.0.0/8"}, nil, false},
+		{nil, []string{"127.0.0.1/32"}, false},
+		{[]string{"127.0.0.0/8"}, []string{"127.0.0.0/24"}, false},
+		{nil, []string{"10.0.0.0/8"}, true},
+	} {
+		log.Lvl2("allow:", test.allow, "deny:", test.deny)
+		sc, err := LoadACLFromCIDRStrings(test.allow, test.deny)
+		require.Nil(t, err)
+		s.phs[0].SetConfig(sc)
+		if test.ok {
+			require.Nil(t, list())
+		} else {
+			require.NotNil(t, list())
+		}
+	}
+	s.phs[0].SetConfig(ServiceConfig{})
+
+	_, err := LoadACLFromCIDRStrings([]string{"127.0.0.1"}, nil)
+	require.NotNil(t, err)
+	require.Equal(t, ErrAccessDenied, s.phs[0].CheckRemoteAddr("not an IP"))
+	require.Nil(t, s.phs[0].CheckRemoteAddr("[::1]:1234"))
+}
+
+// The configuration is read by newService from the file in ConfigEnv.
+func TestService_LoadConfig(t *testing.T) {
+	escrow := byzcoin.NewInstanceID([]byte("escrow"))
+	f, err := ioutil.TempFile("", "personhood")
+	require.Nil(t, err)
+	defer os.Remove(f.Name())
+	_, err = fmt.Fprintf(f, `IPAllowList = ["10.0.0.0/8"]
+KeepAlive = "10s"
+RootAuthorRewardFraction = 0.5
+EscrowCoinIID = "%x"
+`, escrow[:])
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+	require.Nil(t, os.Setenv(ConfigEnv, f.Name()))
+	defer os.Unsetenv(ConfigEnv)
+
+	local := onet.NewTCPTest(tSuite)
+	defer local.CloseAll()
+	servers, _, _ := local.GenTree(1, true)
+	ph := local.GetServices(servers, templateID)[0].(*Service)
+	require.Equal(t, 1, len(ph.Config.IPAllowList))
+	require.Equal(t, "10.0.0.0/8", ph.Config.IPAllowList[0].String())
+	require.Equal(t, 10*time.Second, ph.Config.keepAlive())
+	reader, root := ph.Config.splitReward(100)
+	require.Equal(t, uint64(50), reader)
+	require.Equal(t, uint64(50), root)
+	require.True(t, ph.Config.EscrowCoinIID.Equal(escrow))
+
+	for _, bad := range []string{`IPDenyList = ["10.0.0.1"]`, `KeepAlive = "10"`,
+		`RootAuthorRewardFraction = 2.0`, `EscrowCoinIID = "1234"`, `KeepAlive = `} {
+		_, err = LoadConfigFromToml([]byte(bad))
+		require.NotNil(t, err, bad)
+	}
+}
+
+// Wipes the parties with and without the rights of the admin darc.
+func TestService_WipeParties(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+	cl := NewClient()
+	si := s.servers[0].ServerIdentity
+	_, err := ph.LinkPoP(&LinkPoP{Party: Party{
+		InstanceID: byzcoin.NewInstanceID([]byte("party")),
+		FinalStatement: pop.FinalStatement{
+			Desc: &pop.PopDesc{Name: "party"},
+		},
+	}})
+	require.Nil(t, err)
+
+	adminID := s.gMsg.GenesisDarc.GetBaseID()
+	getProof := func(key []byte) byzcoin.Proof {
+		reply, err := s.ols.GetProof(&byzcoin.GetProof{
+			Version: byzcoin.CurrentVersion,
+			Key:     key,
+			ID:      s.olID,
+		})
+		require.Nil(t, err)
+		return reply.Proof
+	}
+	proof := getProof(adminID)
+
+	// No admin darc configured.
+	require.NotNil(t, cl.WipeParties(si, proof, s.signer))
+
+	ph.AdminByzCoinID = s.olID
+	ph.AdminDarcID = adminID
+	require.NotNil(t, cl.WipeParties(si, proof, darc.NewSignerEd25519(nil, nil)))
+	require.NotNil(t, cl.WipeParties(si, getProof([]byte("not the admin darc")), s.signer))
+	_, err = ph.WipeParties(&WipeParties{Proof: proof, Signature: darc.Signature{
+		Signature: []byte("invalid"),
+		Signer:    s.signer.Identity(),
+	}, Nonce: []byte("nonce"), Timestamp: time.Now().Unix()})
+	require.Equal(t, ErrNotAdmin, err)
+	require.Equal(t, 1, len(ph.Parties()))
+
+	require.Nil(t, cl.WipeParties(si, proof, s.signer))
+	require.Equal(t, 0, len(ph.Parties()))
+}
+
+// The configuration file is read again on a ReloadConfig from the admin, and
+// an invalid file leaves the configuration unchanged.
+func TestService_ReloadConfig(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+	cl := NewClient()
+	si := s.servers[0].ServerIdentity
+	reply, err := s.ols.GetProof(&byzcoin.GetProof{
+		Version: byzcoin.CurrentVersion,
+		Key:     s.gMsg.GenesisDarc.GetBaseID(),
+		ID:      s.olID,
+	})
+	require.Nil(t, err)
+	proof := reply.Proof
+	ph.AdminByzCoinID = s.olID
+	ph.AdminDarcID = s.gMsg.GenesisDarc.GetBaseID()
+
+	// No configuration file given.
+	require.NotNil(t, cl.ReloadConfig(si, proof, s.signer))
+
+	f, err := ioutil.TempFile("", "personhood")
+	require.Nil(t, err)
+	defer os.Remove(f.Name())
+	require.Nil(t, f.Close())
+	require.Nil(t, os.Setenv(ConfigEnv, f.Name()))
+	defer os.Unsetenv(ConfigEnv)
+
+	require.Nil(t, ioutil.WriteFile(f.Name(), []byte(`KeepAlive = "10s"`), 0600))
+	require.NotNil(t, cl.ReloadConfig(si, proof, darc.NewSignerEd25519(nil, nil)))
+	require.Equal(t, DefaultKeepAlive, ph.config().keepAlive())
+	require.Nil(t, cl.ReloadConfig(si, proof, s.signer))
+	require.Equal(t, 10*time.Second, ph.config().keepAlive())
+
+	require.Nil(t, ioutil.WriteFile(f.Name(), []byte(`KeepAlive = "10"`), 0600))
+	require.NotNil(t, cl.ReloadConfig(si, proof, s.signer))
+	require.Equal(t, 10*time.Second, ph.config().keepAlive())
+}
+
+// Requests to the protected methods cannot be replayed, and need a proof of
+// the latest admin darc.
+func TestService_AdminReplay(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+	ph.AdminByzCoinID = s.olID
+	ph.AdminDarcID = s.gMsg.GenesisDarc.GetBaseID()
+	getProof := func() byzcoin.Proof {
+		reply, err := s.ols.GetProof(&byzcoin.GetProof{
+			Version: byzcoin.CurrentVersion,
+			Key:     ph.AdminDarcID,
+			ID:      s.olID,
+		})
+		require.Nil(t, err)
+		return reply.Proof
+	}
+	request := func(proof byzcoin.Proof, nonce []byte, timestamp int64) *WipeParties {
+		sig, err := s.signer.Sign(AdminMessage("WipeParties", proof, nonce, timestamp))
+		require.Nil(t, err)
+		return &WipeParties{Proof: proof, Nonce: nonce, Timestamp: timestamp,
+			Signature: darc.Signature{Signature: sig, Signer: s.signer.Identity()}}
+	}
+	proof := getProof()
+	now := time.Now().Unix()
+
+	wp := request(proof, []byte("first"), now)
+	_, err := ph.WipeParties(wp)
+	require.Nil(t, err)
+	_, err = ph.WipeParties(wp)
+	require.Equal(t, ErrNonceUsed, err)
+	_, err = ph.WipeParties(request(proof, nil, now))
+	require.NotNil(t, err)
+	old := now - int64((AdminRequestWindow + time.Minute).Seconds())
+	_, err = ph.WipeParties(request(proof, []byte("old"), old))
+	require.NotNil(t, err)
+
+	// Once the admin darc evolved, the old proof is refused.
+	d := s.gMsg.GenesisDarc.Copy()
+	require.Nil(t, d.EvolveFrom(&s.gMsg.GenesisDarc))
+	d.Description = []byte("evolved admin darc")
+	dBuf, err := d.ToProto()
+	require.Nil(t, err)
+	cl := byzcoin.NewClient(s.olID, *s.roster)
+	ctx := byzcoin.ClientTransaction{
+		Instructions: []byzcoin.Instruction{{
+			InstanceID: byzcoin.NewInstanceID(ph.AdminDarcID),
+			Invoke: &byzcoin.Invoke{
+				ContractID: byzcoin.ContractDarcID,
+				Command:    "evolve",
+				Args:       byzcoin.Arguments{{Name: "darc", Value: dBuf}},
+			},
+			SignerCounter: []uint64{1},
+		}},
+	}
+	require.Nil(t, ctx.FillSignersAndSignWith(s.signer))
+	_, err = cl.AddTransactionAndWait(ctx, 10)
+	require.Nil(t, err)
+	_, err = ph.WipeParties(request(proof, []byte("second"), now))
+	require.Equal(t, ErrAdminDarcChanged, err)
+	_, err = ph.WipeParties(request(getProof(), []byte("third"), now))
+	require.Nil(t, err)
+}
+
+func TestService_WipeMessages(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+	cl := NewClient()
+	si := s.servers[0].ServerIdentity
+	for _, id := range []string{"first", "second"} {
+		_, err := ph.SendMessage(&SendMessage{Message: Message{ID: []byte(id),
+			Balance: 10, Reward: 1}})
+		require.Nil(t, err)
+	}
+
+	ph.AdminByzCoinID = s.olID
+	ph.AdminDarcID = s.gMsg.GenesisDarc.GetBaseID()
+	reply, err := s.ols.GetProof(&byzcoin.GetProof{
+		Version: byzcoin.CurrentVersion,
+		Key:     ph.AdminDarcID,
+		ID:      s.olID,
+	})
+	require.Nil(t, err)
+
+	require.NotNil(t, cl.WipeMessages(si, reply.Proof, darc.NewSignerEd25519(nil, nil)))
+	lmr, err := ph.ListMessages(&ListMessages{Number: 10})
+	require.Nil(t, err)
+	require.Equal(t, 2, len(lmr.MsgIDs))
+
+	require.Nil(t, cl.WipeMessages(si, reply.Proof, s.signer))
+	lmr, err = ph.ListMessages(&ListMessages{Number: 10})
+	require.Nil(t, err)
+	require.Equal(t, 0, len(lmr.MsgIDs))
+	ph.storage.Lock()
+	require.Equal(t, 0, len(ph.storage.Messages))
+	require.Equal(t, 0, len(ph.storage.Read))
+	ph.storage.Unlock()
+}
+
+func TestService_PartySearch(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+	cl := NewClient()
+	si := s.servers[0].ServerIdentity
+	for i, p := range []struct {
+		name      string
+		finalized bool
+	}{
+		{"Lausanne Meetup", true},
+		{"lausanne workshop", false},
+		{"Geneva", true},
+	} {
+		party := Party{
+			InstanceID:     byzcoin.NewInstanceID([]byte{byte(i)}),
+			FinalStatement: pop.FinalStatement{Desc: &pop.PopDesc{Name: p.name}},
+			Signer:         darc.NewSignerEd25519(nil, nil),
+		}
+		if p.finalized {
+			party.FinalStatement.Signature = []byte("signature")
+		}
+		_, err := ph.LinkPoP(&LinkPoP{Party: party})
+		require.Nil(t, err)
+	}
+
+	_, err := cl.GetParty(si, &GetParty{InstanceID: byzcoin.NewInstanceID([]byte("missing"))})
+	require.NotNil(t, err)
+	gpr, err := cl.GetParty(si, &GetParty{InstanceID: byzcoin.NewInstanceID([]byte{2})})
+	require.Nil(t, err)
+	require.True(t, gpr.Linked)
+	require.Equal(t, "Geneva", gpr.Party.name())
+	require.Nil(t, gpr.Party.Signer.Ed25519)
+
+	names := func(substring string) (ns []string) {
+		parties, err := cl.PartySearch(si, substring)
+		require.Nil(t, err)
+		for _, p := range parties {
+			require.Nil(t, p.Signer.Ed25519)
+			ns = append(ns, p.name())
+		}
+		return
+	}
+	require.Equal(t, []string{"Lausanne Meetup"}, names("Lausanne Meetup"))
+	require.Equal(t, []string{"lausanne workshop", "Lausanne Meetup"}, names("LAUSANNE"))
+	require.Equal(t, []string{"Geneva"}, names("nev"))
+	require.Nil(t, names("Zurich"))
+}
+
+func TestService_Metrics(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+	cl := NewClient()
+	si := s.servers[0].ServerIdentity
+	s.linkAttendees(t, 1)
+
+	metrics, err := cl.GetMetrics(si)
+	require.Nil(t, err)
+	require.Equal(t, ServiceMetrics{}, *metrics)
+
+	ph.Parties()
+	_, err = ph.SendMessage(&SendMessage{Message: Message{ID: []byte("msg"),
+		Balance: 10, Reward: 1}})
+	require.Nil(t, err)
+	// The author doesn't get a reward, so no ledger is needed.
+	_, err = ph.ReadMessage(&ReadMessage{MsgID: []byte("msg"), PartyIID: s.popI.Slice()})
+	require.Nil(t, err)
+	q := Questionnaire{ID: []byte("poll"), Questions: []string{"a"}, Replies: 1,
+		Balance: 10, Reward: 1}
+	_, err = ph.RegisterQuestionnaire(&RegisterQuestionnaire{Questionnaire: q})
+	require.Nil(t, err)
+	_, err = ph.AnswerQuestionnaire(s.answer(t, q.ID, []int{0}, byzcoin.InstanceID{}, 0))
+	require.Nil(t, err)
+	// Failed requests are not counted.
+	_, err = ph.AnswerQuestionnaire(s.answer(t, q.ID, []int{0}, byzcoin.InstanceID{}, 0))
+	require.NotNil(t, err)
+
+	metrics, err = cl.GetMetrics(si)
+	require.Nil(t, err)
+	require.Equal(t, ServiceMetrics{
+		TotalPartiesListed:          1,
+		TotalMessagesRead:           1,
+		TotalQuestionnairesAnswered: 1,
+		TotalMessagesSent:           1,
+	}, *metrics)
+}
+
+// Expired messages are not listed, and removed from the storage.
+func TestService_ExpiredMessages(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+	cl := NewClient()
+	si := s.servers[0].ServerIdentity
+	ph.AdminByzCoinID = s.olID
+	ph.AdminDarcID = s.gMsg.GenesisDarc.GetBaseID()
+	reply, err := s.ols.GetProof(&byzcoin.GetProof{
+		Version: byzcoin.CurrentVersion,
+		Key:     ph.AdminDarcID,
+		ID:      s.olID,
+	})
+	require.Nil(t, err)
+
+	_, err = ph.SendMessage(&SendMessage{Message: Message{
+		ID: []byte("current"), Balance: 10, Reward: 1}})
+	require.Nil(t, err)
+	_, err = ph.SendMessage(&SendMessage{Message: Message{
+		ID: []byte("expired"), Balance: 20, Reward: 1, ExpiresAt: 1}})
+	require.Nil(t, err)
+	expires := ph.storage.getMessage([]byte("current")).ExpiresAt
+	require.True(t, expires > uint64(time.Now().Add(DefaultMessageLifetime-time.Minute).Unix()))
+
+	lmr, err := ph.ListMessages(&ListMessages{Number: 10})
+	require.Nil(t, err)
+	require.Equal(t, [][]byte{[]byte("current")}, lmr.MsgIDs)
+	_, err = cl.GetExpiredMessages(si, reply.Proof, darc.NewSignerEd25519(nil, nil))
+	require.NotNil(t, err)
+	msgs, err := cl.GetExpiredMessages(si, reply.Proof, s.signer)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(msgs))
+	require.Equal(t, []byte("expired"), msgs[0].ID)
+
+	ph.removeExpired()
+	require.Nil(t, ph.storage.getMessage([]byte("expired")))
+	require.NotNil(t, ph.storage.getMessage([]byte("current")))
+	msgs, err = cl.GetExpiredMessages(si, reply.Proof, s.signer)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(msgs))
+}
+
+// Expired questionnaires cannot be answered, are not listed, and removed
+// from the storage.
+func TestService_ExpiredQuestionnaires(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+
+	for _, q := range []Questionnaire{
+		{ID: []byte("open"), Questions: []string{"a"}, Replies: 1, Balance: 10, Reward: 1},
+		{ID: []byte("later"), Questions: []string{"a"}, Replies: 1, Balance: 20, Reward: 1,
+			ExpiresAt: uint64(time.Now().Add(time.Hour).Unix())},
+		{ID: []byte("closed"), Questions: []string{"a"}, Replies: 1, Balance: 30, Reward: 1,
+			ExpiresAt: uint64(time.Now().Add(-time.Second).Unix())},
+	} {
+		_, err := ph.RegisterQuestionnaire(&RegisterQuestionnaire{Questionnaire: q})
+		require.Nil(t, err)
+	}
+	lqr, err := ph.ListQuestionnaires(&ListQuestionnaires{Number: 10})
+	require.Nil(t, err)
+	require.Equal(t, 2, len(lqr.Questionnaires))
+	require.Equal(t, []byte("later"), lqr.Questionnaires[0].ID)
+	require.Equal(t, []byte("open"), lqr.Questionnaires[1].ID)
+
+	s.linkAttendees(t, 1)
+	account := byzcoin.NewInstanceID([]byte("account"))
+	_, err = ph.AnswerQuestionnaire(s.answer(t, []byte("closed"), []int{0}, account, 0))
+	require.Equal(t, ErrQuestionnaireExpired, err)
+	_, err = ph.AnswerQuestionnaire(s.answer(t, []byte("later"), []int{0}, account, 0))
+	require.Nil(t, err)
+
+	ph.removeExpired()
+	ph.storage.Lock()
+	require.Equal(t, 2, len(ph.storage.Questionnaires))
+	require.Nil(t, ph.storage.Questionnaires["closed"])
+	require.Nil(t, ph.storage.Replies["closed"])
+	ph.storage.Unlock()
+}
+
+// Removing the expired entries runs concurrently with the handlers, which
+// must all lock the storage. Run with -race to catch the data races.
+func TestService_SweepConcurrentHandlers(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+	s.linkAttendees(t, 1)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				ph.removeExpired()
+			}
+		}
+	}()
+
+	expired := uint64(time.Now().Add(-time.Second).Unix())
+	for i := 0; i < 10; i++ {
+		id := []byte(fmt.Sprintf("q%d", i))
+		_, err := ph.RegisterQuestionnaire(&RegisterQuestionnaire{Questionnaire: Questionnaire{
+			ID: id, Questions: []string{"a"}, Replies: 1, Balance: 10, Reward: 1}})
+		require.Nil(t, err)
+		_, err = ph.RegisterQuestionnaire(&RegisterQuestionnaire{Questionnaire: Questionnaire{
+			ID: append(id, []byte("expired")...), Questions: []string{"a"}, Replies: 1,
+			Balance: 10, Reward: 1, ExpiresAt: expired}})
+		require.Nil(t, err)
+		_, err = ph.ListQuestionnaires(&ListQuestionnaires{Number: 10})
+		require.Nil(t, err)
+		account := byzcoin.NewInstanceID(id)
+		_, err = ph.AnswerQuestionnaire(s.answer(t, id, []int{0}, account, 0))
+		require.Nil(t, err)
+
+		msgID := []byte(fmt.Sprintf("msg%d", i))
+		_, err = ph.SendMessage(&SendMessage{Message: Message{
+			ID: msgID, Balance: 0, Reward: 1, PartyIID: s.popI}})
+		require.Nil(t, err)
+		_, err = ph.SendMessage(&SendMessage{Message: Message{
+			ID: append(msgID, []byte("expired")...), Balance: 10, Reward: 1, ExpiresAt: 1}})
+		require.Nil(t, err)
+		_, err = ph.ReadMessage(&ReadMessage{MsgID: msgID, PartyIID: s.popI.Slice(), Reader: account})
+		require.Nil(t, err)
+		_, err = ph.ListMessages(&ListMessages{Number: 10})
+		require.Nil(t, err)
+	}
+	close(done)
+	wg.Wait()
+}
+
+// The reward of a message is reserved in one locked step, so that a reader
+// cannot get it twice and the balance cannot be spent twice.
+func TestService_PendingRead(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+	msgID := []byte("msg")
+	_, err := ph.SendMessage(&SendMessage{Message: Message{ID: msgID,
+		Balance: 20, Reward: 10}})
+	require.Nil(t, err)
+	reader := byzcoin.NewInstanceID([]byte("reader"))
+	balance := func() uint64 { return ph.storage.getMessage(msgID).Balance }
+
+	key, err := ph.addPendingRead(msgID, reader)
+	require.Nil(t, err)
+	require.Equal(t, uint64(10), balance())
+	_, err = ph.addPendingRead(msgID, reader)
+	require.NotNil(t, err)
+	ph.commitPendingRead(key)
+	_, err = ph.addPendingRead(msgID, reader)
+	require.Equal(t, errRewardNotDue, err)
+	_, err = ph.addPendingRead(msgID, byzcoin.InstanceID{})
+	require.Equal(t, errRewardNotDue, err)
+
+	other, err := ph.addPendingRead(msgID, byzcoin.NewInstanceID([]byte("other")))
+	require.Nil(t, err)
+	require.Equal(t, uint64(0), balance())
+	_, err = ph.addPendingRead(msgID, byzcoin.NewInstanceID([]byte("third")))
+	require.NotNil(t, err)
+	ph.abortPendingRead(other)
+	require.Equal(t, uint64(10), balance())
+}
+
+// Shutting down stops the background goroutines and saves the storage.
+func TestService_Shutdown(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+
+	ph.storage.Lock()
+	ph.storage.Nonces["unsaved"] = time.Now().Add(time.Hour).Unix()
+	ph.storage.Unlock()
+	require.Nil(t, ph.startREST("127.0.0.1:0", http.NotFoundHandler()))
+	addr := ph.restListener.Addr().String()
+	resp, err := http.Get("http://" + addr)
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Nil(t, ph.Shutdown())
+	_, err = http.Get("http://" + addr)
+	require.NotNil(t, err)
+
+	stopped := make(chan struct{})
+	go func() {
+		ph.sweeper.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		require.Fail(t, "background goroutines are still running")
+	}
+
+	require.Nil(t, ph.tryLoad())
+	_, ok := ph.storage.Nonces["unsaved"]
+	require.True(t, ok)
+	// It can be done twice.
+	require.Nil(t, ph.Shutdown())
+}
+
+func TestListQuestionnaires_TagFilter(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+
+	for i, tags := range [][]string{{"Health"}, {"economics", "social"}, nil} {
+		_, err := ph.RegisterQuestionnaire(&RegisterQuestionnaire{Questionnaire: Questionnaire{
+			ID:      []byte{byte(i)},
+			Balance: uint64(30 - i*10),
+			Reward:  1,
+			Tags:    tags,
+		}})
+		require.Nil(t, err)
+	}
+	for _, tc := range []struct {
+		filter []string
+		ids    []byte
+	}{
+		{nil, []byte{0, 1, 2}},
+		{[]string{"health"}, []byte{0}},
+		{[]string{"SOCIAL"}, []byte{1}},
+		{[]string{"sports"}, nil},
+		{[]string{"health", "economics"}, []byte{0, 1}},
+	} {
+		lqr, err := ph.ListQuestionnaires(&ListQuestionnaires{Number: 10, FilterTags: tc.filter})
+		require.Nil(t, err)
+		var ids []byte
+		for _, q := range lqr.Questionnaires {
+			ids = append(ids, q.ID[0])
+		}
+		require.Equal(t, tc.ids, ids, "filter %v", tc.filter)
+	}
+}
+
+func TestService_QuestionnaireResults(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	cl := NewClient()
+	si := s.servers[0].ServerIdentity
+
+	q := Questionnaire{ID: []byte("poll"), Questions: []string{"a", "b", "c"},
+		Replies: 2, Balance: 10, Reward: 1}
+	require.Nil(t, cl.RegisterQuestionnaire(si, q))
+	_, err := cl.GetQuestionnaireResults(si, []byte("unknown"))
+	require.NotNil(t, err)
+	results, err := cl.GetQuestionnaireResults(si, q.ID)
+	require.Nil(t, err)
+	require.Equal(t, []int{0, 0, 0}, results.VoteCounts)
+	require.Equal(t, 0, results.Respondents)
+
+	s.linkAttendees(t, 4)
+	for i, replies := range [][]int{{0}, {0, 2}, {2, 0}} {
+		account := byzcoin.NewInstanceID([]byte{byte(i)})
+		require.Nil(t, cl.AnswerQuestionnaire(si, s.answer(t, q.ID, replies, account, i)))
+	}
+	require.NotNil(t, cl.AnswerQuestionnaire(si, s.answer(t, q.ID, []int{1, 1},
+		byzcoin.NewInstanceID([]byte("twice")), 3)))
+	results, err = cl.GetQuestionnaireResults(si, q.ID)
+	require.Nil(t, err)
+	require.Equal(t, []int{3, 0, 2}, results.VoteCounts)
+	require.Equal(t, 3, results.Respondents)
+}
+
+// Only the author of a questionnaire can delete it, after which it is not
+// listed anymore.
+func TestService_DeleteQuestionnaire(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+	s.linkAttendees(t, 2)
+
+	rq := &RegisterQuestionnaire{Questionnaire: Questionnaire{ID: []byte("poll"),
+		Questions: []string{"a"}, Replies: 1, Balance: 10, Reward: 1, PartyIID: s.popI}}
+	require.Nil(t, rq.Sign(s.party.Attendees, *s.attendees[0]))
+	_, err := ph.RegisterQuestionnaire(rq)
+	require.Nil(t, err)
+	lqr, err := ph.ListQuestionnaires(&ListQuestionnaires{Number: 10})
+	require.Nil(t, err)
+	require.Equal(t, 1, len(lqr.Questionnaires))
+	require.Nil(t, lqr.Questionnaires[0].AuthorTag)
+
+	// Re-registering the ID can neither change the author nor the replies.
+	again := &RegisterQuestionnaire{Questionnaire: rq.Questionnaire}
+	require.Nil(t, again.Sign(s.party.Attendees, *s.attendees[1]))
+	_, err = ph.RegisterQuestionnaire(again)
+	require.Equal(t, ErrQuestionnaireExists, err)
+	_, err = ph.RegisterQuestionnaire(&RegisterQuestionnaire{Questionnaire: Questionnaire{ID: rq.Questionnaire.ID,
+		Questions: []string{"a", "b"}, Replies: 1}})
+	require.Equal(t, ErrQuestionnaireExists, err)
+
+	dq := &DeleteQuestionnaire{QuestID: []byte("unknown")}
+	require.Nil(t, dq.Sign(s.party.Attendees, *s.attendees[0]))
+	_, err = ph.DeleteQuestionnaire(dq)
+	require.Equal(t, ErrQuestionnaireNotFound, err)
+	dq = &DeleteQuestionnaire{QuestID: rq.Questionnaire.ID}
+	require.Nil(t, dq.Sign(s.party.Attendees, *s.attendees[1]))
+	_, err = ph.DeleteQuestionnaire(dq)
+	require.NotNil(t, err)
+	// The author proof of the registration cannot be replayed.
+	_, err = ph.DeleteQuestionnaire(&DeleteQuestionnaire{QuestID: rq.Questionnaire.ID,
+		Proof: rq.AuthorProof})
+	require.NotNil(t, err)
+
+	require.Nil(t, dq.Sign(s.party.Attendees, *s.attendees[0]))
+	_, err = ph.DeleteQuestionnaire(dq)
+	require.Nil(t, err)
+	lqr, err = ph.ListQuestionnaires(&ListQuestionnaires{Number: 10})
+	require.Nil(t, err)
+	require.Equal(t, 0, len(lqr.Questionnaires))
+	ph.storage.Lock()
+	require.Nil(t, ph.storage.Replies[string(rq.Questionnaire.ID)])
+	ph.storage.Unlock()
+	_, err = ph.DeleteQuestionnaire(dq)
+	require.Equal(t, ErrQuestionnaireNotFound, err)
+
+	// The deletion cannot be replayed on a new questionnaire with the same
+	// ID, nor be used after RequestWindow.
+	rq = &RegisterQuestionnaire{Questionnaire: rq.Questionnaire}
+	require.Nil(t, rq.Sign(s.party.Attendees, *s.attendees[0]))
+	_, err = ph.RegisterQuestionnaire(rq)
+	require.Nil(t, err)
+	_, err = ph.DeleteQuestionnaire(dq)
+	require.Equal(t, ErrNonceUsed, err)
+	require.Nil(t, dq.Sign(s.party.Attendees, *s.attendees[0]))
+	dq.Timestamp -= int64((RequestWindow + time.Minute).Seconds())
+	_, err = ph.DeleteQuestionnaire(dq)
+	require.NotNil(t, err)
+}
+
+// The linkable ring signature of an answer lets every attendee answer only
+// once, whatever account is given.
+func TestAnswerQuestionnaire_LRSDoubleAnswer(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+
+	q := Questionnaire{ID: []byte("poll"), Questions: []string{"a", "b"},
+		Replies: 1, Balance: 10, Reward: 1}
+	_, err := ph.RegisterQuestionnaire(&RegisterQuestionnaire{Questionnaire: q})
+	require.Nil(t, err)
+	s.linkAttendees(t, 2)
+
+	// Only attendees can sign, and the proof covers the account.
+	aq := &AnswerQuestionnaire{QuestID: q.ID, Replies: []int{0}, PartyIID: s.popI}
+	require.NotNil(t, aq.Sign(s.party.Attendees, *key.NewKeyPair(tSuite)))
+	aq = s.answer(t, q.ID, []int{0}, byzcoin.NewInstanceID([]byte("first")), 0)
+	aq.Account = byzcoin.NewInstanceID([]byte("thief"))
+	_, err = ph.AnswerQuestionnaire(aq)
+	require.NotNil(t, err)
+	aq = s.answer(t, q.ID, []int{0}, byzcoin.NewInstanceID([]byte("first")), 0)
+	aq.PartyIID = byzcoin.NewInstanceID([]byte("unknown"))
+	_, err = ph.AnswerQuestionnaire(aq)
+	require.NotNil(t, err)
+
+	_, err = ph.AnswerQuestionnaire(s.answer(t, q.ID, []int{0},
+		byzcoin.NewInstanceID([]byte("first")), 0))
+	require.Nil(t, err)
+	_, err = ph.AnswerQuestionnaire(s.answer(t, q.ID, []int{1},
+		byzcoin.NewInstanceID([]byte("second")), 0))
+	require.NotNil(t, err)
+	_, err = ph.AnswerQuestionnaire(s.answer(t, q.ID, []int{1},
+		byzcoin.NewInstanceID([]byte("second")), 1))
+	require.Nil(t, err)
+
+	ph.storage.Lock()
+	r := ph.storage.Replies[string(q.ID)]
+	require.Equal(t, 2, len(r.Tags))
+	require.Equal(t, []int{1, 1}, r.Sum)
+	ph.storage.Unlock()
+}
+
+// Concurrent answers of the same attendee are only accepted and paid once.
+func TestAnswerQuestionnaire_ConcurrentDoubleAnswer(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+
+	q := Questionnaire{ID: []byte("poll"), Questions: []string{"a", "b"},
+		Replies: 1, Balance: 10, Reward: 1}
+	_, err := ph.RegisterQuestionnaire(&RegisterQuestionnaire{Questionnaire: q})
+	require.Nil(t, err)
+	s.linkAttendees(t, 1)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		aq := s.answer(t, q.ID, []int{0}, byzcoin.NewInstanceID([]byte{byte(i)}), 0)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = ph.AnswerQuestionnaire(aq)
+		}(i)
+	}
+	wg.Wait()
+	answered := 0
+	for _, err := range errs {
+		if err == nil {
+			answered++
+		}
+	}
+	require.Equal(t, 1, answered)
+	require.Equal(t, uint64(9), ph.storage.getQuestionnaire(q.ID).Balance)
+	ph.storage.Lock()
+	require.Equal(t, 1, len(ph.storage.Replies[string(q.ID)].Tags))
+	ph.storage.Unlock()
+}
+
+// Requests with a nonce cannot be replayed until the nonce expires, also
+// after a restart of the service.
+func TestService_NonceReplay(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+	s.linkAttendees(t, 2)
+
+	// Unscoped messages are not signed, so they don't need a nonce.
+	_, err := ph.SendMessage(&SendMessage{Message: Message{ID: []byte("msg1"),
+		Balance: 10}})
+	require.Nil(t, err)
+	_, err = ph.SendMessage(&SendMessage{Message: Message{ID: []byte("msg2"),
+		Balance: 10}})
+	require.Nil(t, err)
+
+	// The nonce of a scoped message is covered by the scope proof.
+	sm := &SendMessage{Message: Message{ID: []byte("scoped1"), Balance: 10,
+		Scope: s.popI}}
+	require.Nil(t, sm.SignScope(s.party.Attendees, *s.attendees[0]))
+	sendNonce := sm.Nonce
+	_, err = ph.SendMessage(sm)
+	require.Nil(t, err)
+	sm.Message.ID = []byte("scoped2")
+	sm.ScopeProof, err = signLRS(sm.scopeMessage(), nil, s.party.Attendees, *s.attendees[0])
+	require.Nil(t, err)
+	_, err = ph.SendMessage(sm)
+	require.Equal(t, ErrNonceUsed, err)
+	sm.Nonce = nil
+	sm.ScopeProof, err = signLRS(sm.scopeMessage(), nil, s.party.Attendees, *s.attendees[0])
+	require.Nil(t, err)
+	_, err = ph.SendMessage(sm)
+	require.NotNil(t, err)
+	require.Nil(t, sm.SignScope(s.party.Attendees, *s.attendees[0]))
+	sm.Timestamp -= int64((RequestWindow + time.Minute).Seconds())
+	sm.ScopeProof, err = signLRS(sm.scopeMessage(), nil, s.party.Attendees, *s.attendees[0])
+	require.Nil(t, err)
+	_, err = ph.SendMessage(sm)
+	require.NotNil(t, err)
+
+	rq := &RegisterQuestionnaire{Questionnaire: Questionnaire{ID: []byte("poll"),
+		Questions: []string{"a"}, Replies: 1, Balance: 10, Reward: 1,
+		PartyIID: s.popI}}
+	require.Nil(t, rq.Sign(s.party.Attendees, *s.attendees[0]))
+	_, err = ph.RegisterQuestionnaire(rq)
+	require.Nil(t, err)
+	_, err = ph.RegisterQuestionnaire(rq)
+	require.Equal(t, ErrNonceUsed, err)
+	// The nonce is covered by the author proof, and not needed without one.
+	rq.Nonce = []byte("other")
+	_, err = ph.RegisterQuestionnaire(rq)
+	require.NotNil(t, err)
+	_, err = ph.RegisterQuestionnaire(&RegisterQuestionnaire{
+		Questionnaire: Questionnaire{ID: []byte("unsigned"),
+			Questions: []string{"a"}, Replies: 1}})
+	require.Nil(t, err)
+
+	// The nonce is covered by the proof of the answer.
+	aq := s.answer(t, rq.Questionnaire.ID, []int{0}, byzcoin.InstanceID{}, 0)
+	nonce := aq.Nonce
+	aq.Nonce = []byte("answer")
+	_, err = ph.AnswerQuestionnaire(aq)
+	require.NotNil(t, err)
+	aq.Nonce = nonce
+	_, err = ph.AnswerQuestionnaire(aq)
+	require.Nil(t, err)
+	_, err = ph.AnswerQuestionnaire(aq)
+	require.Equal(t, ErrNonceUsed, err)
+	replay := s.answer(t, rq.Questionnaire.ID, []int{0}, byzcoin.InstanceID{}, 1)
+	replay.Nonce = nonce
+	replay.Proof, err = signLRS(replay.message(), replay.QuestID, s.party.Attendees, *s.attendees[1])
+	require.Nil(t, err)
+	_, err = ph.AnswerQuestionnaire(replay)
+	require.Equal(t, ErrNonceUsed, err)
+	stale := s.answer(t, rq.Questionnaire.ID, []int{0}, byzcoin.InstanceID{}, 1)
+	stale.Timestamp -= int64((RequestWindow + time.Minute).Seconds())
+	stale.Proof, err = signLRS(stale.message(), stale.QuestID, s.party.Attendees, *s.attendees[1])
+	require.Nil(t, err)
+	_, err = ph.AnswerQuestionnaire(stale)
+	require.NotNil(t, err)
+
+	// The nonces are kept over a restart.
+	require.Nil(t, ph.save())
+	require.Nil(t, ph.tryLoad())
+	ph.storage.buildMessageScores()
+	require.Equal(t, ErrNonceUsed, ph.storage.useNonce(sendNonce, time.Now()))
+
+	// Once expired, the nonces are removed and can be used again.
+	ph.storage.Lock()
+	require.Equal(t, 3, len(ph.storage.Nonces))
+	for key := range ph.storage.Nonces {
+		ph.storage.Nonces[key] = time.Now().Unix() - 1
+	}
+	ph.storage.Unlock()
+	ph.removeExpired()
+	ph.storage.Lock()
+	require.Equal(t, 0, len(ph.storage.Nonces))
+	ph.storage.Unlock()
+	require.Nil(t, sm.SignScope(s.party.Attendees, *s.attendees[0]))
+	sm.Nonce = sendNonce
+	sm.ScopeProof, err = signLRS(sm.scopeMessage(), nil, s.party.Attendees, *s.attendees[0])
+	require.Nil(t, err)
+	_, err = ph.SendMessage(sm)
+	require.Nil(t, err)
+}
+
+// Two concurrent updates of the same version: only one of them may succeed.
+func TestService_VersionConflict(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+
+	_, err := ph.SendMessage(&SendMessage{Message: Message{ID: []byte("msg"), Balance: 10}})
+	require.Nil(t, err)
+	_, err = ph.RegisterQuestionnaire(&RegisterQuestionnaire{Questionnaire: Questionnaire{ID: []byte("quest"), Balance: 10}})
+	require.Nil(t, err)
+
+	for _, cas := range []func(balance uint64) error{
+		func(balance uint64) error {
+			msg := Message{ID: []byte("msg"), Balance: balance}
+			return ph.storage.compareAndSwapMessage(&msg)
+		},
+		func(balance uint64) error {
+			q := Questionnaire{ID: []byte("quest"), Balance: balance}
+			return ph.storage.compareAndSwapQuestionnaire(&q)
+		},
+	} {
+		errs := make(chan error)
+		for i := uint64(0); i < 2; i++ {
+			go func(balance uint64) {
+				errs <- cas(balance)
+			}(20 + i)
+		}
+		var conflicts int
+		for i := 0; i < 2; i++ {
+			if err := <-errs; err != nil {
+				require.Equal(t, ErrVersionConflict, err)
+				conflicts++
+			}
+		}
+		require.Equal(t, 1, conflicts)
+	}
+	require.Equal(t, uint64(1), ph.storage.Messages["msg"].Version)
+	require.Equal(t, uint64(1), ph.storage.Questionnaires["quest"].Version)
+
+	// Updates from the service increase the version.
+	_, err = ph.TopupMessage(&TopupMessage{MsgID: []byte("msg"), Amount: 5})
+	require.Nil(t, err)
+	require.Equal(t, uint64(2), ph.storage.Messages["msg"].Version)
+	_, err = ph.TopupQuestionnaire(&TopupQuestionnaire{QuestID: []byte("quest"), Topup: 5})
+	require.Nil(t, err)
+	require.Equal(t, uint64(2), ph.storage.Questionnaires["quest"].Version)
+
+	// Re-linking a party needs the current version, or no version at all.
+	party := Party{
+		InstanceID: byzcoin.NewInstanceID([]byte("party")),
+		FinalStatement: pop.FinalStatement{
+			Desc: &pop.PopDesc{Name: "party"},
+		},
+	}
+	partyVersion := func() uint64 {
+		return ph.storage.getParty(party.InstanceID.Slice()).Version
+	}
+	_, err = ph.LinkPoP(&LinkPoP{Party: party})
+	require.Nil(t, err)
+	require.Equal(t, uint64(1), partyVersion())
+	_, err = ph.LinkPoP(&LinkPoP{Party: party})
+	require.Nil(t, err)
+	require.Equal(t, uint64(2), partyVersion())
+	party.Version = 1
+	_, err = ph.LinkPoP(&LinkPoP{Party: party})
+	require.Equal(t, ErrVersionConflict, err)
+	party.Version = 2
+	_, err = ph.LinkPoP(&LinkPoP{Party: party})
+	require.Nil(t, err)
+	require.Equal(t, uint64(3), partyVersion())
+}
+
 // Stores and loads a personhood data.
 func TestService_SaveLoad(t *testing.T) {
 	// Creates a party and links it, then verifies the account exists.
@@ -59,6 +1235,49 @@ func TestService_SaveLoad(t *testing.T) {
 	require.Nil(t, s.phs[0].tryLoad())
 }
 
+// Storage from before the database had a version is migrated without losing
+// any entries.
+func TestService_MigrationPreservesData(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+
+	partyID := byzcoin.NewInstanceID([]byte("party"))
+	old := &storage1{
+		Messages: map[string]*Message{
+			"msg": {ID: []byte("msg"), Subject: "subject", Balance: 10},
+		},
+		Questionnaires: map[string]*Questionnaire{
+			"quest": {ID: []byte("quest"), Questions: []string{"a", "b"}, Balance: 10},
+		},
+		Parties: map[string]*Party{
+			string(partyID.Slice()): {InstanceID: partyID,
+				FinalStatement: pop.FinalStatement{Desc: &pop.PopDesc{Name: "party"}}},
+		},
+	}
+	require.Nil(t, ph.Save(storageKey, old))
+	require.Nil(t, ph.SaveVersion(0))
+
+	require.Nil(t, ph.tryLoad())
+	require.Equal(t, "subject", ph.storage.Messages["msg"].Subject)
+	require.Equal(t, uint64(10), ph.storage.Questionnaires["quest"].Balance)
+	require.Equal(t, []int{0, 0}, ph.storage.Replies["quest"].Sum)
+	require.Equal(t, partyID, ph.storage.Parties[string(partyID.Slice())].InstanceID)
+	// The party was never verified on its ledger, so its name is not indexed.
+	require.Equal(t, 0, len(ph.storage.PartyNames))
+	require.NotNil(t, ph.storage.Read)
+	require.NotNil(t, ph.storage.PendingReads)
+	require.NotNil(t, ph.storage.Nonces)
+	ver, err := ph.LoadVersion()
+	require.Nil(t, err)
+	require.Equal(t, dbVersion, ver)
+
+	// Loading again keeps the migrated data.
+	require.Nil(t, ph.tryLoad())
+	require.Equal(t, "subject", ph.storage.Messages["msg"].Subject)
+	ph.storage.buildMessageScores()
+}
+
 // Post a couple of questionnaires, get the list, and reply to some.
 func TestService_Questionnaire(t *testing.T) {
 	s := newS(t)
@@ -121,11 +1340,8 @@ func TestService_Questionnaire(t *testing.T) {
 	}
 
 	// Fill in some questionnaires
-	aq := &AnswerQuestionnaire{
-		QuestID: quests[0].ID,
-		Replies: []int{-1},
-		Account: byzcoin.InstanceID{},
-	}
+	s.linkAttendees(t, 2)
+	aq := s.answer(t, quests[0].ID, []int{-1}, byzcoin.InstanceID{}, 0)
 	_, err := s.phs[0].AnswerQuestionnaire(aq)
 	require.NotNil(t, err)
 	aq.Replies = []int{0, 1}
@@ -149,16 +1365,12 @@ func TestService_Questionnaire(t *testing.T) {
 	require.Nil(t, err)
 	require.Equal(t, len(quests)-1, len(lqr.Questionnaires))
 
-	// Try to take the questionnaire by twice the same account
-	// TODO: probably need a linkable ring signature here, because
-	// later on the user might add an additional account.
-	aq = &AnswerQuestionnaire{
-		QuestID: quests[1].ID,
-		Replies: []int{0, 1},
-		Account: byzcoin.InstanceID{},
-	}
+	// Try to take the questionnaire twice by the same attendee, even with
+	// another account.
+	aq = s.answer(t, quests[1].ID, []int{0, 1}, byzcoin.InstanceID{}, 0)
 	_, err = s.phs[0].AnswerQuestionnaire(aq)
 	require.Nil(t, err)
+	aq = s.answer(t, quests[1].ID, []int{0, 1}, byzcoin.NewInstanceID([]byte("other")), 0)
 	_, err = s.phs[0].AnswerQuestionnaire(aq)
 	require.NotNil(t, err)
 
@@ -195,7 +1407,7 @@ func TestService_Messages(t *testing.T) {
 	for _, msg := range msgs {
 		log.Lvl1("Registering message", msg.Subject)
 		s.coinTransfer(t, s.attCoin[0], s.serCoin, msg.Balance, s.attDarc[0], s.attSig[0])
-		_, err := s.phs[0].SendMessage(&SendMessage{msg})
+		_, err := s.phs[0].SendMessage(&SendMessage{Message: msg})
 		require.Nil(t, err)
 	}
 
@@ -272,6 +1484,388 @@ func TestService_Messages(t *testing.T) {
 	require.Equal(t, len(msgs), len(lmr.MsgIDs))
 }
 
+// Pages through the messages with a cursor, while new messages are sent and
+// old ones deleted.
+func TestService_ListMessagesCursor(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+
+	gone := uint64(time.Now().Add(time.Hour).Unix())
+	send := func(id string, balance, expires uint64) {
+		_, err := ph.SendMessage(&SendMessage{Message: Message{ID: []byte(id),
+			Balance: balance, Reward: 1, ExpiresAt: expires}})
+		require.Nil(t, err)
+	}
+	send("m1", 1, 0)
+	send("m2", 2, 0)
+	send("m4", 4, gone)
+	send("m8", 8, 0)
+	send("m16", 16, 0)
+	list := func(cursor []byte, number int) ([]string, []byte) {
+		lmr, err := ph.ListMessages(&ListMessages{Cursor: cursor, Number: number})
+		require.Nil(t, err)
+		var ids []string
+		for _, id := range lmr.MsgIDs {
+			ids = append(ids, string(id))
+		}
+		return ids, lmr.NextCursor
+	}
+
+	// First page
+	ids, first := list(nil, 2)
+	require.Equal(t, []string{"m16", "m8"}, ids)
+	require.NotNil(t, first)
+
+	// A new message doesn't shift the following pages.
+	send("m32", 32, 0)
+	ids, cursor := list(first, 2)
+	require.Equal(t, []string{"m4", "m2"}, ids)
+	ids, cursor = list(cursor, 2)
+	require.Equal(t, []string{"m1"}, ids)
+	require.Nil(t, cursor)
+
+	// The cursor still works if its message is deleted.
+	ids, cursor = list(first, 1)
+	require.Equal(t, []string{"m4"}, ids)
+	require.Equal(t, 1, len(ph.storage.deleteExpiredMessages(gone)))
+	ids, cursor = list(cursor, 10)
+	require.Equal(t, []string{"m2", "m1"}, ids)
+	require.Nil(t, cursor)
+
+	_, err := ph.ListMessages(&ListMessages{Cursor: []byte{1}, Number: 1})
+	require.NotNil(t, err)
+}
+
+// Replies form a thread that can be requested in chronological order, or
+// used to filter the listed messages.
+func TestService_GetThread(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+	cl := NewClient()
+	si := s.servers[0].ServerIdentity
+
+	for _, msg := range []Message{
+		{ID: []byte("root"), Date: 100, Balance: 10, Reward: 1},
+		{ID: []byte("other"), Date: 150, Balance: 40, Reward: 1},
+		{ID: []byte("reply"), Date: 200, Balance: 20, Reward: 1, ParentMsgID: []byte("root")},
+		{ID: []byte("reply2"), Date: 300, Balance: 30, Reward: 1, ParentMsgID: []byte("reply")},
+	} {
+		_, err := ph.SendMessage(&SendMessage{Message: msg})
+		require.Nil(t, err)
+	}
+	_, err := ph.SendMessage(&SendMessage{Message: Message{ID: []byte("orphan"),
+		ParentMsgID: []byte("unknown")}})
+	require.NotNil(t, err)
+
+	msgs, err := cl.GetThread(si, []byte("root"))
+	require.Nil(t, err)
+	var ids []string
+	for _, msg := range msgs {
+		ids = append(ids, string(msg.ID))
+	}
+	require.Equal(t, []string{"root", "reply", "reply2"}, ids)
+	_, err = cl.GetThread(si, []byte("unknown"))
+	require.NotNil(t, err)
+
+	lmr, err := ph.ListMessages(&ListMessages{Number: 10, ThreadID: []byte("root")})
+	require.Nil(t, err)
+	require.Equal(t, [][]byte{[]byte("reply2"), []byte("reply"), []byte("root")}, lmr.MsgIDs)
+}
+
+// Messages scoped to a party are only listed to its attendees, and not in the
+// global feed.
+func TestService_ScopedMessages(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+	s.linkAttendees(t, 2)
+
+	_, err := ph.SendMessage(&SendMessage{Message: Message{ID: []byte("lost"),
+		Balance: 10, Reward: 1, Scope: byzcoin.NewInstanceID([]byte("unknown"))}})
+	require.NotNil(t, err)
+	_, err = ph.SendMessage(&SendMessage{Message: Message{ID: []byte("global"),
+		Balance: 10, Reward: 1}})
+	require.Nil(t, err)
+
+	// Only attendees can send scoped messages.
+	outsider := key.NewKeyPair(tSuite)
+	sm := &SendMessage{Message: Message{ID: []byte("event"), Balance: 20,
+		Reward: 1, Scope: s.popI}}
+	_, err = ph.SendMessage(sm)
+	require.NotNil(t, err)
+	require.NotNil(t, sm.SignScope(s.party.Attendees, *outsider))
+	require.Nil(t, sm.SignScope([]kyber.Point{outsider.Public}, *outsider))
+	_, err = ph.SendMessage(sm)
+	require.NotNil(t, err)
+	require.Nil(t, sm.SignScope(s.party.Attendees, *s.attendees[0]))
+	sm.Message.ID = []byte("other")
+	_, err = ph.SendMessage(sm)
+	require.NotNil(t, err)
+	sm.Message.ID = []byte("event")
+	_, err = ph.SendMessage(sm)
+	require.Nil(t, err)
+
+	lmr, err := ph.ListMessages(&ListMessages{Number: 10})
+	require.Nil(t, err)
+	require.Equal(t, [][]byte{[]byte("global")}, lmr.MsgIDs)
+
+	lm := &ListMessages{Number: 10, ScopeFilter: s.popI}
+	_, err = ph.ListMessages(lm)
+	require.NotNil(t, err)
+	require.NotNil(t, lm.SignScope(s.party.Attendees, *outsider))
+	require.Nil(t, lm.SignScope([]kyber.Point{outsider.Public}, *outsider))
+	_, err = ph.ListMessages(lm)
+	require.NotNil(t, err)
+
+	require.Nil(t, lm.SignScope(s.party.Attendees, *s.attendees[1]))
+	lmr, err = ph.ListMessages(lm)
+	require.Nil(t, err)
+	require.Equal(t, [][]byte{[]byte("event")}, lmr.MsgIDs)
+
+	// The scope proof cannot be replayed, nor used after RequestWindow.
+	_, err = ph.ListMessages(lm)
+	require.Equal(t, ErrNonceUsed, err)
+	require.Nil(t, lm.SignScope(s.party.Attendees, *s.attendees[1]))
+	lm.Timestamp -= int64((RequestWindow + time.Minute).Seconds())
+	_, err = ph.ListMessages(lm)
+	require.NotNil(t, err)
+	lm.Nonce = nil
+	lm.Timestamp = time.Now().Unix()
+	_, err = ph.ListMessages(lm)
+	require.NotNil(t, err)
+
+	// The scoped message cannot be got or read by its ID without a proof.
+	gm := &GetMessage{MsgID: []byte("event")}
+	_, err = ph.GetMessage(gm)
+	require.NotNil(t, err)
+	require.Nil(t, gm.SignScope(s.popI, []kyber.Point{outsider.Public}, *outsider))
+	_, err = ph.GetMessage(gm)
+	require.NotNil(t, err)
+	require.Nil(t, gm.SignScope(s.popI, s.party.Attendees, *s.attendees[1]))
+	gmr, err := ph.GetMessage(gm)
+	require.Nil(t, err)
+	require.Equal(t, []byte("event"), gmr.Message.ID)
+	_, err = ph.GetMessage(gm)
+	require.Equal(t, ErrNonceUsed, err)
+
+	_, err = ph.ReadMessage(&ReadMessage{MsgID: []byte("event"), PartyIID: s.popI.Slice()})
+	require.NotNil(t, err)
+	rm := &ReadMessage{MsgID: []byte("event"), PartyIID: s.popI.Slice()}
+	require.Nil(t, rm.SignScope(s.popI, []kyber.Point{outsider.Public}, *outsider))
+	_, err = ph.ReadMessage(rm)
+	require.NotNil(t, err)
+
+	_, err = ph.GetThread(&GetThread{ThreadID: []byte("event")})
+	require.NotNil(t, err)
+	gt := &GetThread{ThreadID: []byte("event"), Scope: s.popI}
+	_, err = ph.GetThread(gt)
+	require.NotNil(t, err)
+	require.Nil(t, gt.SignScope(s.party.Attendees, *s.attendees[1]))
+	gtr, err := ph.GetThread(gt)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(gtr.Messages))
+}
+
+// Getting a message neither changes its balance nor records a reader.
+func TestService_GetMessage(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+	cl := NewClient()
+	si := s.servers[0].ServerIdentity
+
+	msg := Message{ID: []byte("preview"), Subject: "news", Text: "full text",
+		Balance: 20, Reward: 10}
+	_, err := ph.SendMessage(&SendMessage{Message: msg})
+	require.Nil(t, err)
+	_, err = cl.GetMessage(si, []byte("unknown"))
+	require.NotNil(t, err)
+	for i := 0; i < 3; i++ {
+		got, err := cl.GetMessage(si, msg.ID)
+		require.Nil(t, err)
+		require.Equal(t, msg.Text, got.Text)
+		require.Equal(t, msg.Balance, got.Balance)
+	}
+	ph.storage.Lock()
+	require.Equal(t, msg.Balance, ph.storage.Messages[string(msg.ID)].Balance)
+	require.Equal(t, 1, len(ph.storage.Read[string(msg.ID)].Readers))
+	ph.storage.Unlock()
+}
+
+// With an escrow coin, messages and top-ups are only accepted with a transfer
+// to the escrow for the message, and every transfer pays only once.
+func TestService_EscrowedMessage(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	s.createParty(t, len(s.servers), 2)
+	ph := s.phs[0]
+	ph.SetConfig(ServiceConfig{EscrowCoinIID: s.serCoin})
+	cl := NewClient()
+	si := s.servers[0].ServerIdentity
+
+	proof := func(iid byzcoin.InstanceID) []byte {
+		gpr, err := s.ols.GetProof(&byzcoin.GetProof{
+			Version: byzcoin.CurrentVersion,
+			Key:     iid.Slice(),
+			ID:      s.olID,
+		})
+		require.Nil(t, err)
+		buf, err := protobuf.Encode(&gpr.Proof)
+		require.Nil(t, err)
+		return buf
+	}
+	transfer := func(to byzcoin.InstanceID, msgID string, coins uint64) []byte {
+		s.coinTransfer(t, s.attCoin[0], to, coins, s.attDarc[0], s.attSig[0],
+			byzcoin.Argument{Name: "msgID", Value: []byte(msgID)})
+		return proof(to)
+	}
+	msg := func(id string, balance uint64) Message {
+		return Message{ID: []byte(id), Balance: balance, Reward: 1, PartyIID: s.popI}
+	}
+	escrowed := func(msg Message, proof []byte) *SendMessage {
+		sm := &SendMessage{Message: msg}
+		sm.TransferProof = proof
+		return sm
+	}
+
+	_, err := ph.SendMessage(&SendMessage{Message: msg("no proof", 1)})
+	require.NotNil(t, err)
+	_, err = ph.SendMessage(escrowed(msg("covered", 10), transfer(s.serCoin, "other", 10)))
+	require.NotNil(t, err)
+	_, err = ph.SendMessage(escrowed(msg("covered", 10), transfer(s.attCoin[1], "covered", 10)))
+	require.NotNil(t, err)
+	valid := transfer(s.serCoin, "covered", 10)
+	_, err = ph.SendMessage(escrowed(msg("covered", 11), valid))
+	require.Equal(t, ErrInsufficientEscrow, err)
+
+	var validProof byzcoin.Proof
+	require.Nil(t, protobuf.DecodeWithConstructors(valid, &validProof,
+		network.DefaultConstructors(cothority.Suite)))
+	require.Nil(t, cl.SendEscrowedMessage(si, msg("covered", 10), validProof))
+	// The transfer of the message cannot pay for a top-up.
+	require.NotNil(t, cl.TopupEscrowedMessage(si, []byte("covered"), 10, validProof))
+	_, err = ph.TopupMessage(&TopupMessage{MsgID: []byte("covered"), Amount: 10})
+	require.NotNil(t, err)
+
+	topup := transfer(s.serCoin, "covered", 5)
+	_, err = ph.TopupMessage(&TopupMessage{MsgID: []byte("covered"), Amount: 6,
+		TransferProof: topup})
+	require.Equal(t, ErrInsufficientEscrow, err)
+	_, err = ph.TopupMessage(&TopupMessage{MsgID: []byte("covered"), Amount: 5,
+		TransferProof: topup})
+	require.Nil(t, err)
+	_, err = ph.TopupMessage(&TopupMessage{MsgID: []byte("covered"), Amount: 5,
+		TransferProof: topup})
+	require.Equal(t, ErrEscrowTransferUsed, err)
+	require.Equal(t, uint64(15), ph.storage.getMessage([]byte("covered")).Balance)
+}
+
+// A message read by a reader is not listed anymore for that reader, but still
+// for the others.
+func TestService_Messages_AlreadyReadFiltered(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	s.createParty(t, len(s.servers), 2)
+
+	msg := Message{
+		Subject: "news",
+		Text:    "read me once",
+		Author:  s.attCoin[0],
+		Balance: 20,
+		Reward:  10,
+		ID:      random.Bits(256, true, random.New()),
+	}
+	s.coinTransfer(t, s.attCoin[0], s.serCoin, msg.Balance, s.attDarc[0], s.attSig[0])
+	_, err := s.phs[0].SendMessage(&SendMessage{Message: msg})
+	require.Nil(t, err)
+
+	list := func(reader byzcoin.InstanceID) [][]byte {
+		lmr, err := s.phs[0].ListMessages(&ListMessages{Number: 10, ReaderID: reader})
+		require.Nil(t, err)
+		return lmr.MsgIDs
+	}
+	require.Equal(t, [][]byte{msg.ID}, list(s.attCoin[1]))
+	require.Equal(t, 0, len(list(s.attCoin[0])))
+
+	_, err = s.phs[0].ReadMessage(&ReadMessage{
+		MsgID:    msg.ID,
+		Reader:   s.attCoin[1],
+		PartyIID: s.popI.Slice(),
+	})
+	require.Nil(t, err)
+	require.Equal(t, 0, len(list(s.attCoin[1])))
+	require.Equal(t, [][]byte{msg.ID}, list(byzcoin.InstanceID{}))
+}
+
+// Watches new messages over a websocket.
+func TestService_WatchMessages(t *testing.T) {
+	s := newS(t)
+	defer s.Close()
+	ph := s.phs[0]
+
+	author := byzcoin.NewInstanceID([]byte("author"))
+	reader := byzcoin.NewInstanceID([]byte("reader"))
+	dial := func(iid byzcoin.InstanceID) (*websocket.Conn, func()) {
+		ts := httptest.NewServer(ph.WatchMessages(iid))
+		ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+		require.Nil(t, err)
+		return ws, func() {
+			ws.Close()
+			ts.Close()
+		}
+	}
+	wsReader, closeReader := dial(reader)
+	defer closeReader()
+	wsAuthor, closeAuthor := dial(author)
+	defer closeAuthor()
+
+	// Wait for both watchers to be registered.
+	for {
+		ph.watchersLock.Lock()
+		n := len(ph.messageWatchers)
+		ph.watchersLock.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	msg := Message{
+		Subject: "news",
+		Author:  author,
+		Balance: 20,
+		Reward:  10,
+		ID:      random.Bits(256, true, random.New()),
+	}
+	_, err := ph.SendMessage(&SendMessage{Message: msg})
+	require.Nil(t, err)
+
+	var mn MessageNotification
+	wsReader.SetReadDeadline(time.Now().Add(5 * time.Second))
+	require.Nil(t, wsReader.ReadJSON(&mn))
+	require.Equal(t, msg.ID, mn.MsgID)
+	require.Equal(t, msg.Subject, mn.Subject)
+	require.Equal(t, msg.Balance, mn.Balance)
+
+	// The author already read the message.
+	wsAuthor.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	require.NotNil(t, wsAuthor.ReadJSON(&mn))
+
+	// Closing the connection removes the watcher.
+	closeReader()
+	for {
+		ph.watchersLock.Lock()
+		n := len(ph.messageWatchers[string(reader.Slice())])
+		ph.watchersLock.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 type sStruct struct {
 	local     *onet.LocalTest
 	servers   []*onet.Server
@@ -294,9 +1888,10 @@ type sStruct struct {
 	signer    darc.Signer
 	gMsg      *byzcoin.CreateGenesisBlock
 	popI      byzcoin.InstanceID
+	gasCoin   byzcoin.InstanceID
 }
 
-func newS(t *testing.T) (s *sStruct) {
+func newS(t testing.TB) (s *sStruct) {
 	s = &sStruct{}
 	s.local = onet.NewTCPTest(tSuite)
 	s.servers, s.roster, _ = s.local.GenTree(5, true)
@@ -315,7 +1910,9 @@ func newS(t *testing.T) (s *sStruct) {
 	s.signer = darc.NewSignerEd25519(nil, nil)
 	var err error
 	s.gMsg, err = byzcoin.DefaultGenesisMsg(byzcoin.CurrentVersion, s.roster,
-		[]string{"spawn:dummy", "spawn:" + pop.ContractPopParty, "invoke:" + pop.ContractPopParty + ".Finalize"}, s.signer.Identity())
+		[]string{"spawn:dummy", "spawn:" + pop.ContractPopParty, "invoke:" + pop.ContractPopParty + ".Finalize",
+			"spawn:" + contracts.ContractCoinID, "invoke:" + contracts.ContractCoinID + ".mint",
+			"invoke:" + contracts.ContractCoinID + ".fetch", string(AdminAction)}, s.signer.Identity())
 	require.Nil(t, err)
 	s.gMsg.BlockInterval = 500 * time.Millisecond
 
@@ -331,7 +1928,107 @@ func (s *sStruct) Close() {
 
 // Create a party with orgs organizers and attendees. It will store the party
 // in the ledger and finalize it.
-func (s *sStruct) createParty(t *testing.T, orgs, attendees int) {
+func (s *sStruct) createParty(t testing.TB, orgs, attendees int) {
+	s.finalizeParty(t, orgs, attendees)
+
+	// Store the finalized party in the ledger
+	s.invokePoPFinalize(t)
+
+	_, err := s.phs[0].LinkPoP(&LinkPoP{
+		Party: Party{
+			ByzCoinID:      s.olID,
+			InstanceID:     s.popI,
+			FinalStatement: s.party,
+			Darc:           *s.serDarc,
+			Signer:         s.serSig,
+		},
+	})
+	require.Nil(t, err)
+}
+
+// linkAttendees links a party with n new attendees to the first service,
+// without creating it in the pop-service and the ledger.
+func (s *sStruct) linkAttendees(t testing.TB, n int) {
+	s.attendees = nil
+	s.party = pop.FinalStatement{}
+	for i := 0; i < n; i++ {
+		kp := key.NewKeyPair(tSuite)
+		s.attendees = append(s.attendees, kp)
+		s.party.Attendees = append(s.party.Attendees, kp.Public)
+	}
+	s.popI = byzcoin.NewInstanceID([]byte("attendees"))
+	_, err := s.phs[0].LinkPoP(&LinkPoP{
+		Party: Party{InstanceID: s.popI, FinalStatement: s.party},
+	})
+	require.Nil(t, err)
+}
+
+// partyLedger is a pop.ByzCoinClient that only holds finalized pop-parties.
+// Its proofs are not linked to any skipblock.
+type partyLedger struct {
+	trie *trie.Trie
+	sync.Mutex
+}
+
+func newPartyLedger(t testing.TB) *partyLedger {
+	tr, err := trie.NewTrie(trie.NewMemDB(), []byte("parties"))
+	require.Nil(t, err)
+	return &partyLedger{trie: tr}
+}
+
+// finalize stores the party as a finalized pop-party instance.
+func (pl *partyLedger) finalize(t testing.TB, p Party) {
+	buf, err := protobuf.Encode(&pop.PopPartyInstance{State: 2, FinalStatement: &p.FinalStatement})
+	require.Nil(t, err)
+	buf, err = protobuf.Encode(&byzcoin.StateChangeBody{
+		StateAction: byzcoin.Update,
+		ContractID:  []byte(pop.ContractPopParty),
+		Value:       buf,
+	})
+	require.Nil(t, err)
+	pl.Lock()
+	defer pl.Unlock()
+	require.Nil(t, pl.trie.Set(p.InstanceID.Slice(), buf))
+}
+
+func (pl *partyLedger) GetProof(key []byte) (*byzcoin.GetProofResponse, error) {
+	pl.Lock()
+	defer pl.Unlock()
+	p, err := pl.trie.GetProof(key)
+	if err != nil {
+		return nil, err
+	}
+	return &byzcoin.GetProofResponse{
+		Version: byzcoin.CurrentVersion,
+		Proof:   byzcoin.Proof{InclusionProof: *p},
+	}, nil
+}
+
+func (pl *partyLedger) AddTransactionAndWait(byzcoin.ClientTransaction, int) (*byzcoin.AddTxResponse, error) {
+	return nil, errors.New("the party ledger doesn't take transactions")
+}
+
+func (pl *partyLedger) GetSignerCounters(...string) (*byzcoin.GetSignerCountersResponse, error) {
+	return nil, errors.New("the party ledger has no signers")
+}
+
+// answer returns an answer to the questionnaire signed by the attendee at
+// index att of the linked party.
+func (s *sStruct) answer(t testing.TB, questID []byte, replies []int,
+	account byzcoin.InstanceID, att int) *AnswerQuestionnaire {
+	aq := &AnswerQuestionnaire{
+		QuestID:  questID,
+		Replies:  replies,
+		Account:  account,
+		PartyIID: s.popI,
+	}
+	require.Nil(t, aq.Sign(s.party.Attendees, *s.attendees[att]))
+	return aq
+}
+
+// Creates a party with orgs organizers and attendees, stores it in the
+// ledger and finalizes it in the pop-service, but not yet in the ledger.
+func (s *sStruct) finalizeParty(t testing.TB, orgs, attendees int) {
 	if orgs > len(s.pops) {
 		t.Fatal("cannot have more organizers than conodes")
 	}
@@ -348,7 +2045,7 @@ func (s *sStruct) createParty(t *testing.T, orgs, attendees int) {
 			Name:     "test-party",
 			DateTime: "2018-08-28 08:08",
 			Location: "BC208",
-			Roster:   s.roster,
+			Roster:   onet.NewRoster(s.roster.List[:orgs]),
 		},
 	}
 
@@ -390,23 +2087,9 @@ func (s *sStruct) createParty(t *testing.T, orgs, attendees int) {
 			s.party = *fr.Final
 		}
 	}
-
-	// Store the finalized party in the ledger
-	s.invokePoPFinalize(t)
-
-	_, err := s.phs[0].LinkPoP(&LinkPoP{
-		Party: Party{
-			ByzCoinID:      s.olID,
-			InstanceID:     s.popI,
-			FinalStatement: s.party,
-			Darc:           *s.serDarc,
-			Signer:         s.serSig,
-		},
-	})
-	require.Nil(t, err)
 }
 
-func (s *sStruct) createPoPSpawn(t *testing.T) {
+func (s *sStruct) createPoPSpawn(t testing.TB) {
 	log.Lvl2("Publishing the party to the ledger")
 
 	signerCtrs, err := s.ols.GetSignerCounters(&byzcoin.GetSignerCounters{
@@ -419,18 +2102,52 @@ func (s *sStruct) createPoPSpawn(t *testing.T) {
 	fsBuf, err := protobuf.Encode(&s.party)
 	require.Nil(t, err)
 	dID := s.gMsg.GenesisDarc.GetBaseID()
+	// Also create a coin with enough coins to pay the gas for finalizing.
+	pubBuf, err := s.signer.Ed25519.Point.MarshalBinary()
+	require.Nil(t, err)
+	gasCoin := sha256.New()
+	gasCoin.Write([]byte(contracts.ContractCoinID))
+	gasCoin.Write(pubBuf)
+	s.gasCoin = byzcoin.NewInstanceID(gasCoin.Sum(nil))
+	coins := make([]byte, 8)
+	binary.LittleEndian.PutUint64(coins, 1000000)
 	ctx := byzcoin.ClientTransaction{
-		Instructions: byzcoin.Instructions{byzcoin.Instruction{
-			InstanceID: byzcoin.NewInstanceID(dID),
-			Spawn: &byzcoin.Spawn{
-				ContractID: pop.ContractPopParty,
-				Args: byzcoin.Arguments{{
-					Name:  "FinalStatement",
-					Value: fsBuf,
-				}},
+		Instructions: byzcoin.Instructions{
+			{
+				InstanceID: byzcoin.NewInstanceID(dID),
+				Spawn: &byzcoin.Spawn{
+					ContractID: pop.ContractPopParty,
+					Args: byzcoin.Arguments{{
+						Name:  "FinalStatement",
+						Value: fsBuf,
+					}},
+				},
+				SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
 			},
-			SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
-		}},
+			{
+				InstanceID: byzcoin.NewInstanceID(dID),
+				Spawn: &byzcoin.Spawn{
+					ContractID: contracts.ContractCoinID,
+					Args: byzcoin.Arguments{{
+						Name:  "public",
+						Value: pubBuf,
+					}},
+				},
+				SignerCounter: []uint64{signerCtrs.Counters[0] + 2},
+			},
+			{
+				InstanceID: s.gasCoin,
+				Invoke: &byzcoin.Invoke{
+					ContractID: contracts.ContractCoinID,
+					Command:    "mint",
+					Args: byzcoin.Arguments{{
+						Name:  "coins",
+						Value: coins,
+					}},
+				},
+				SignerCounter: []uint64{signerCtrs.Counters[0] + 3},
+			},
+		},
 	}
 	err = ctx.FillSignersAndSignWith(s.signer)
 	require.Nil(t, err)
@@ -444,53 +2161,19 @@ func (s *sStruct) createPoPSpawn(t *testing.T) {
 	s.popI = ctx.Instructions[0].DeriveID("")
 }
 
-func (s *sStruct) invokePoPFinalize(t *testing.T) {
+func (s *sStruct) invokePoPFinalize(t testing.TB) {
 	log.Lvl2("finalizing the party in the ledger")
 
-	signerCtrs, err := s.ols.GetSignerCounters(&byzcoin.GetSignerCounters{
-		SignerIDs:   []string{s.signer.Identity().String()},
-		SkipchainID: s.olID,
-	})
-	require.NoError(t, err)
-	require.Equal(t, 1, len(signerCtrs.Counters))
-
-	fsBuf, err := protobuf.Encode(&s.party)
-	require.Nil(t, err)
 	s.service = key.NewKeyPair(tSuite)
 	sBuf, err := s.service.Public.MarshalBinary()
 	require.Nil(t, err)
-	ctx := byzcoin.ClientTransaction{
-		Instructions: byzcoin.Instructions{byzcoin.Instruction{
-			InstanceID: s.popI,
-			Invoke: &byzcoin.Invoke{
-				ContractID: pop.ContractPopParty,
-				Command:    "Finalize",
-				Args: byzcoin.Arguments{
-					{
-						Name:  "FinalStatement",
-						Value: fsBuf,
-					},
-					{
-						Name:  "Service",
-						Value: sBuf,
-					},
-				},
-			},
-			SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
-		}},
-	}
-	dID := s.gMsg.GenesisDarc.GetBaseID()
-	err = ctx.FillSignersAndSignWith(s.signer)
-	require.Nil(t, err)
-	_, err = s.ols.AddTransaction(&byzcoin.AddTxRequest{
-		Version:       byzcoin.CurrentVersion,
-		SkipchainID:   s.olID,
-		Transaction:   ctx,
-		InclusionWait: 10,
-	})
+	cl := byzcoin.NewClient(s.olID, *s.roster)
+	_, err = pop.PopPartyFinalizeWithGas(cl, s.popI, &s.party, s.service.Public,
+		s.gasCoin, s.signer)
 	require.Nil(t, err)
+	var dID darc.ID
 	serCoinID := sha256.New()
-	serCoinID.Write(ctx.Instructions[0].InstanceID.Slice())
+	serCoinID.Write(s.popI.Slice())
 	serCoinID.Write(sBuf)
 	s.serCoin = byzcoin.NewInstanceID(serCoinID.Sum(nil))
 	gpr, err := s.ols.GetProof(&byzcoin.GetProof{
@@ -549,7 +2232,7 @@ func (s *sStruct) invokePoPFinalize(t *testing.T) {
 	}
 }
 
-func (s *sStruct) coinGet(t *testing.T, inst byzcoin.InstanceID) (ci byzcoin.Coin) {
+func (s *sStruct) coinGet(t testing.TB, inst byzcoin.InstanceID) (ci byzcoin.Coin) {
 	gpr, err := s.ols.GetProof(&byzcoin.GetProof{
 		Version: byzcoin.CurrentVersion,
 		Key:     inst.Slice(),
@@ -565,7 +2248,8 @@ func (s *sStruct) coinGet(t *testing.T, inst byzcoin.InstanceID) (ci byzcoin.Coi
 	return
 }
 
-func (s *sStruct) coinTransfer(t *testing.T, from, to byzcoin.InstanceID, coins uint64, d *darc.Darc, sig darc.Signer) {
+func (s *sStruct) coinTransfer(t *testing.T, from, to byzcoin.InstanceID, coins uint64, d *darc.Darc, sig darc.Signer,
+	extra ...byzcoin.Argument) {
 	signerCtrs, err := s.ols.GetSignerCounters(&byzcoin.GetSignerCounters{
 		SignerIDs:   []string{sig.Identity().String()},
 		SkipchainID: s.olID,
@@ -593,6 +2277,7 @@ func (s *sStruct) coinTransfer(t *testing.T, from, to byzcoin.InstanceID, coins
 			SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
 		}},
 	}
+	ctx.Instructions[0].Invoke.Args = append(ctx.Instructions[0].Invoke.Args, extra...)
 	require.Nil(t, ctx.FillSignersAndSignWith(sig))
 	_, err = s.ols.AddTransaction(&byzcoin.AddTxRequest{
 		Version:       byzcoin.CurrentVersion,
@@ -1,9 +1,302 @@
 package personhood
 
-import "math"
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"time"
 
-// score returns a value that can be used to sort the messages.
-func (msg *Message) score() uint64 {
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/sign/anon"
+	"go.dedis.ch/kyber/v3/util/key"
+	"go.dedis.ch/kyber/v3/util/random"
+)
+
+// Score returns the value the messages are listed by, highest first. Messages
+// with the same score are listed by decreasing ID.
+func (msg *Message) Score() uint64 {
 	return msg.Reward *
 		uint64(1+math.Log2(float64(msg.Balance)/float64(msg.Reward)))
 }
+
+// expired returns true if the message expired at now, in unix seconds.
+func (msg *Message) expired(now uint64) bool {
+	return msg.ExpiresAt > 0 && msg.ExpiresAt <= now
+}
+
+// expired returns true if the questionnaire expired at now, in unix seconds.
+func (q *Questionnaire) expired(now uint64) bool {
+	return q.ExpiresAt > 0 && q.ExpiresAt <= now
+}
+
+// hasAnyTag returns true if the questionnaire has one of the tags, compared
+// without case, or if no tags are given.
+func (q *Questionnaire) hasAnyTag(tags []string) bool {
+	if len(tags) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		for _, qTag := range q.Tags {
+			if strings.EqualFold(tag, qTag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// paysRewards returns true if the rewards of the questionnaire are sent to the
+// accounts of the users.
+func (q *Questionnaire) paysRewards() bool {
+	return !q.CoinIID.Equal(byzcoin.InstanceID{})
+}
+
+// finalized returns true if the organizers signed the final statement of the
+// party.
+func (p *Party) finalized() bool {
+	return len(p.FinalStatement.Signature) > 0
+}
+
+// name returns the name of the party, or an empty string if the party has no
+// description.
+func (p *Party) name() string {
+	if p.FinalStatement.Desc == nil {
+		return ""
+	}
+	return p.FinalStatement.Desc.Name
+}
+
+// signLRS returns a linkable ring signature on msg by kp, which must be one
+// of the attendees. All signatures of kp with the same scope have the same
+// tag.
+func signLRS(msg, scope []byte, atts []kyber.Point, kp key.Pair) ([]byte, error) {
+	for i, att := range atts {
+		if att.Equal(kp.Public) {
+			return anon.Sign(cothority.Suite.(anon.Suite), msg, anon.Set(atts),
+				scope, i, kp.Private), nil
+		}
+	}
+	return nil, errors.New("key pair is not part of the attendees")
+}
+
+// verifyLRS checks a signature created by signLRS and returns its tag.
+func verifyLRS(msg, scope []byte, atts []kyber.Point, proof []byte) ([]byte, error) {
+	if len(atts) == 0 {
+		return nil, errors.New("no attendees to verify the proof")
+	}
+	return anon.Verify(cothority.Suite.(anon.Suite), msg, anon.Set(atts), scope, proof)
+}
+
+// message returns the message signed by the proof of the answer. The account
+// is included, so that the reward can't be redirected.
+func (aq *AnswerQuestionnaire) message() []byte {
+	msg := append(append([]byte{}, aq.QuestID...), aq.Account.Slice()...)
+	msg = append(msg, aq.Nonce...)
+	return append(msg, timestampBytes(aq.Timestamp)...)
+}
+
+// Sign sets a new Nonce and the current Timestamp of the answer and creates
+// its proof with the key pair of one of the attendees. The proof is linked to
+// the questionnaire, so that the same attendee always gets the same tag for a
+// given questionnaire.
+func (aq *AnswerQuestionnaire) Sign(atts []kyber.Point, kp key.Pair) (err error) {
+	aq.Nonce = random.Bits(256, true, random.New())
+	aq.Timestamp = time.Now().Unix()
+	aq.Proof, err = signLRS(aq.message(), aq.QuestID, atts, kp)
+	return
+}
+
+// Verify checks the proof of the answer against the attendees and returns
+// the tag of the signer.
+func (aq *AnswerQuestionnaire) Verify(atts []kyber.Point) ([]byte, error) {
+	return verifyLRS(aq.message(), aq.QuestID, atts, aq.Proof)
+}
+
+// scopeMessage returns the message signed by ListMessages.ScopeProof.
+func (lm *ListMessages) scopeMessage() []byte {
+	msg := append([]byte("scope"), lm.ScopeFilter.Slice()...)
+	msg = append(msg, lm.Nonce...)
+	return append(msg, timestampBytes(lm.Timestamp)...)
+}
+
+// SignScope sets a new Nonce and the current Timestamp of the listing and
+// creates the ScopeProof with the key pair of one of the attendees of the
+// ScopeFilter party. The proof is not linkable, so the listings of an
+// attendee cannot be linked together.
+func (lm *ListMessages) SignScope(atts []kyber.Point, kp key.Pair) (err error) {
+	lm.Nonce = random.Bits(256, true, random.New())
+	lm.Timestamp = time.Now().Unix()
+	lm.ScopeProof, err = signLRS(lm.scopeMessage(), nil, atts, kp)
+	return
+}
+
+// VerifyScope checks that the Timestamp is within RequestWindow of now
+// and the ScopeProof against the attendees. The caller has to make sure the
+// Nonce is not used twice.
+func (lm *ListMessages) VerifyScope(atts []kyber.Point, now time.Time) error {
+	if err := checkChallenge(lm.Nonce, lm.Timestamp, now); err != nil {
+		return err
+	}
+	_, err := verifyLRS(lm.scopeMessage(), nil, atts, lm.ScopeProof)
+	return err
+}
+
+// scopeMessage returns the message signed by SendMessage.ScopeProof.
+func (sm *SendMessage) scopeMessage() []byte {
+	msg := append([]byte("scope"), sm.Message.Scope.Slice()...)
+	msg = append(msg, sm.Message.ID...)
+	msg = append(msg, sm.Nonce...)
+	return append(msg, timestampBytes(sm.Timestamp)...)
+}
+
+// SignScope sets a new Nonce and the current Timestamp of the request and
+// creates the ScopeProof with the key pair of one of the attendees of the
+// Message.Scope party.
+func (sm *SendMessage) SignScope(atts []kyber.Point, kp key.Pair) (err error) {
+	sm.Nonce = random.Bits(256, true, random.New())
+	sm.Timestamp = time.Now().Unix()
+	sm.ScopeProof, err = signLRS(sm.scopeMessage(), nil, atts, kp)
+	return
+}
+
+// VerifyScope checks that the Timestamp is within RequestWindow of now
+// and the ScopeProof against the attendees. The caller has to make sure the
+// Nonce is not used twice.
+func (sm *SendMessage) VerifyScope(atts []kyber.Point, now time.Time) error {
+	if err := checkChallenge(sm.Nonce, sm.Timestamp, now); err != nil {
+		return err
+	}
+	_, err := verifyLRS(sm.scopeMessage(), nil, atts, sm.ScopeProof)
+	return err
+}
+
+// scopeAccessMessage returns the message signed by the scope proof of a
+// request of the given kind for the message or thread id of the scope party.
+func scopeAccessMessage(kind string, scope byzcoin.InstanceID, id, nonce []byte, timestamp int64) []byte {
+	msg := append([]byte(kind), scope.Slice()...)
+	msg = append(msg, id...)
+	msg = append(msg, nonce...)
+	return append(msg, timestampBytes(timestamp)...)
+}
+
+// scopeMessage returns the message signed by GetThread.ScopeProof.
+func (gt *GetThread) scopeMessage() []byte {
+	return scopeAccessMessage("thread", gt.Scope, gt.ThreadID, gt.Nonce, gt.Timestamp)
+}
+
+// SignScope sets a new Nonce and the current Timestamp of the request and
+// creates the ScopeProof with the key pair of one of the attendees of the
+// Scope party.
+func (gt *GetThread) SignScope(atts []kyber.Point, kp key.Pair) (err error) {
+	gt.Nonce = random.Bits(256, true, random.New())
+	gt.Timestamp = time.Now().Unix()
+	gt.ScopeProof, err = signLRS(gt.scopeMessage(), nil, atts, kp)
+	return
+}
+
+// scopeMessage returns the message signed by GetMessage.ScopeProof for a
+// message of the scope party.
+func (gm *GetMessage) scopeMessage(scope byzcoin.InstanceID) []byte {
+	return scopeAccessMessage("get", scope, gm.MsgID, gm.Nonce, gm.Timestamp)
+}
+
+// SignScope sets a new Nonce and the current Timestamp of the request and
+// creates the ScopeProof with the key pair of one of the attendees of the
+// scope party of the message.
+func (gm *GetMessage) SignScope(scope byzcoin.InstanceID, atts []kyber.Point, kp key.Pair) (err error) {
+	gm.Nonce = random.Bits(256, true, random.New())
+	gm.Timestamp = time.Now().Unix()
+	gm.ScopeProof, err = signLRS(gm.scopeMessage(scope), nil, atts, kp)
+	return
+}
+
+// scopeMessage returns the message signed by ReadMessage.ScopeProof for a
+// message of the scope party.
+func (rm *ReadMessage) scopeMessage(scope byzcoin.InstanceID) []byte {
+	return scopeAccessMessage("read", scope, rm.MsgID, rm.Nonce, rm.Timestamp)
+}
+
+// SignScope sets a new Nonce and the current Timestamp of the request and
+// creates the ScopeProof with the key pair of one of the attendees of the
+// scope party of the message.
+func (rm *ReadMessage) SignScope(scope byzcoin.InstanceID, atts []kyber.Point, kp key.Pair) (err error) {
+	rm.Nonce = random.Bits(256, true, random.New())
+	rm.Timestamp = time.Now().Unix()
+	rm.ScopeProof, err = signLRS(rm.scopeMessage(scope), nil, atts, kp)
+	return
+}
+
+// checkChallenge returns an error if the nonce is missing or the
+// timestamp, in unix seconds, is not within RequestWindow of now.
+func checkChallenge(nonce []byte, timestamp int64, now time.Time) error {
+	if len(nonce) == 0 {
+		return errors.New("the request needs a nonce")
+	}
+	diff := now.Sub(time.Unix(timestamp, 0))
+	if diff > RequestWindow || diff < -RequestWindow {
+		return errors.New("the timestamp of the request is too far from now")
+	}
+	return nil
+}
+
+// timestampBytes returns the timestamp in little endian.
+func timestampBytes(timestamp int64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(timestamp))
+	return buf
+}
+
+// authorScope returns the scope of the author proofs of a questionnaire. It
+// differs from the scope of the answers, so that the author can also answer
+// the questionnaire.
+func authorScope(questID []byte) []byte {
+	return append([]byte("author"), questID...)
+}
+
+// Sign sets a new Nonce and the current Timestamp of the request and creates
+// the author proof of the questionnaire with the key pair of one of the
+// attendees of its party.
+func (rq *RegisterQuestionnaire) Sign(atts []kyber.Point, kp key.Pair) (err error) {
+	rq.Nonce = random.Bits(256, true, random.New())
+	rq.Timestamp = time.Now().Unix()
+	rq.AuthorProof, err = signLRS(rq.message(), authorScope(rq.Questionnaire.ID), atts, kp)
+	return
+}
+
+// Verify checks the author proof against the attendees and returns the tag
+// of the author.
+func (rq *RegisterQuestionnaire) Verify(atts []kyber.Point) ([]byte, error) {
+	return verifyLRS(rq.message(), authorScope(rq.Questionnaire.ID), atts, rq.AuthorProof)
+}
+
+// message returns the message signed by the author proof.
+func (rq *RegisterQuestionnaire) message() []byte {
+	msg := append([]byte("register"), rq.Questionnaire.ID...)
+	msg = append(msg, rq.Nonce...)
+	return append(msg, timestampBytes(rq.Timestamp)...)
+}
+
+// Sign sets a new Nonce and the current Timestamp of the deletion and creates
+// its proof with the key pair of the author of the questionnaire.
+func (dq *DeleteQuestionnaire) Sign(atts []kyber.Point, kp key.Pair) (err error) {
+	dq.Nonce = random.Bits(256, true, random.New())
+	dq.Timestamp = time.Now().Unix()
+	dq.Proof, err = signLRS(dq.message(), authorScope(dq.QuestID), atts, kp)
+	return
+}
+
+// Verify checks the proof of the deletion against the attendees and returns
+// the tag of the signer.
+func (dq *DeleteQuestionnaire) Verify(atts []kyber.Point) ([]byte, error) {
+	return verifyLRS(dq.message(), authorScope(dq.QuestID), atts, dq.Proof)
+}
+
+// message returns the message signed by the proof of the deletion.
+func (dq *DeleteQuestionnaire) message() []byte {
+	msg := append([]byte("delete"), dq.QuestID...)
+	msg = append(msg, dq.Nonce...)
+	return append(msg, timestampBytes(dq.Timestamp)...)
+}
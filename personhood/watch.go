@@ -0,0 +1,132 @@
+package personhood
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// watchBuffer is the number of messages that are kept for a slow watcher.
+// If a watcher doesn't keep up, newer messages are dropped.
+const watchBuffer = 16
+
+// MessageNotification is sent as JSON to the watchers of new messages.
+type MessageNotification struct {
+	MsgID   []byte
+	Subject string
+	Balance uint64
+}
+
+// WatchMessages returns a handler that upgrades the connection to a
+// websocket and sends a MessageNotification for every new message the reader
+// didn't read yet. The connection stays open until the client closes it, and
+// a ping is sent every ServiceConfig.KeepAlive to keep it from being dropped.
+func (s *Service) WatchMessages(readerID byzcoin.InstanceID) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := websocket.Upgrader{
+			// As the website will not be served from ourselves, we
+			// need to accept _all_ origins.
+			CheckOrigin: func(*http.Request) bool {
+				return true
+			},
+		}
+		ws, err := u.Upgrade(w, r, http.Header{})
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		defer ws.Close()
+
+		msgs := s.addWatcher(readerID)
+		defer s.removeWatcher(readerID, msgs)
+
+		// The client doesn't send anything, but reading is needed to
+		// notice when the connection is closed.
+		closed := make(chan struct{})
+		go func() {
+			for {
+				if _, _, err := ws.ReadMessage(); err != nil {
+					close(closed)
+					return
+				}
+			}
+		}()
+
+		interval := s.config().keepAlive()
+		keepAlive := time.NewTicker(interval)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-keepAlive.C:
+				deadline := time.Now().Add(interval)
+				if err := ws.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+					log.Lvl2("couldn't send ping:", err)
+					return
+				}
+			case msg := <-msgs:
+				err := ws.WriteJSON(MessageNotification{
+					MsgID:   msg.ID,
+					Subject: msg.Subject,
+					Balance: msg.Balance,
+				})
+				if err != nil {
+					log.Lvl2("couldn't send notification:", err)
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	})
+}
+
+func (s *Service) addWatcher(readerID byzcoin.InstanceID) chan Message {
+	s.watchersLock.Lock()
+	defer s.watchersLock.Unlock()
+	msgs := make(chan Message, watchBuffer)
+	key := string(readerID.Slice())
+	s.messageWatchers[key] = append(s.messageWatchers[key], msgs)
+	return msgs
+}
+
+func (s *Service) removeWatcher(readerID byzcoin.InstanceID, msgs chan Message) {
+	s.watchersLock.Lock()
+	defer s.watchersLock.Unlock()
+	key := string(readerID.Slice())
+	watchers := s.messageWatchers[key]
+	for i, w := range watchers {
+		if w == msgs {
+			s.messageWatchers[key] = append(watchers[:i], watchers[i+1:]...)
+			break
+		}
+	}
+	if len(s.messageWatchers[key]) == 0 {
+		delete(s.messageWatchers, key)
+	}
+}
+
+// notifyWatchers sends the new message to all watchers, except the author,
+// who already read it. Scoped messages are not sent, as the watchers don't
+// prove that they attended the party.
+func (s *Service) notifyWatchers(msg Message) {
+	if !msg.Scope.Equal(byzcoin.InstanceID{}) {
+		return
+	}
+	s.watchersLock.Lock()
+	defer s.watchersLock.Unlock()
+	for key, watchers := range s.messageWatchers {
+		if key == string(msg.Author.Slice()) {
+			continue
+		}
+		for _, w := range watchers {
+			select {
+			case w <- msg:
+			default:
+				log.Warn("dropping message notification for slow watcher")
+			}
+		}
+	}
+}
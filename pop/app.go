@@ -832,12 +832,6 @@ func bcFinalize(c *cli.Context) error {
 		return err
 	}
 
-	// Load signer counter
-	signerCtrs, err := ocl.GetSignerCounters(signer.Identity().String())
-	if err != nil {
-		return err
-	}
-
 	// Get the party-id
 	partyID, err := hex.DecodeString(c.Args().Get(2))
 	if err != nil {
@@ -861,11 +855,6 @@ func bcFinalize(c *cli.Context) error {
 		log.Infof("%+v", fs)
 		return errors.New("proposed configuration not finalized")
 	}
-	fsBuf, err := protobuf.Encode(fs)
-	if err != nil {
-		return errors.New("couldn't encode final statement: " + err.Error())
-	}
-
 	partyInstance, _, err := cl.GetInstanceID(cfg.Roster.List[0].Address, partyID)
 	if err != nil {
 		return errors.New("couldn't get instanceID: " + err.Error())
@@ -874,47 +863,27 @@ func bcFinalize(c *cli.Context) error {
 		return errors.New("no instanceID stored")
 	}
 
-	sigBuf, err := signer.Ed25519.Point.MarshalBinary()
-	if err != nil {
-		return errors.New("Couldn't get point: " + err.Error())
-	}
-
-	log.Info("Sending finalize-instruction")
-	ctx := byzcoin.ClientTransaction{
-		Instructions: byzcoin.Instructions{byzcoin.Instruction{
-			InstanceID: partyInstance,
-			Invoke: &byzcoin.Invoke{
-				ContractID: service.ContractPopParty,
-				Command:    "Finalize",
-				Args: byzcoin.Arguments{
-					byzcoin.Argument{
-						Name:  "FinalStatement",
-						Value: fsBuf,
-					},
-					{
-						Name:  "Service",
-						Value: sigBuf,
-					}},
-			},
-			SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
-		}},
-	}
-	err = ctx.FillSignersAndSignWith(*signer)
+	// The gas is paid from the coin account of the signer, created with
+	// "bcadmin mint".
+	pubBuf, err := signer.Ed25519.Point.MarshalBinary()
 	if err != nil {
-		return errors.New("couldn't sign instruction: " + err.Error())
+		return errors.New("couldn't marshal public key: " + err.Error())
 	}
+	h := sha256.New()
+	h.Write([]byte(contracts.ContractCoinID))
+	h.Write(pubBuf)
+	gasCoin := byzcoin.NewInstanceID(h.Sum(nil))
 
-	_, err = ocl.AddTransactionAndWait(ctx, 10)
+	log.Infof("Sending finalize-instruction, paying %d coins of gas",
+		service.FinalizeGas(len(fs.Attendees)))
+	_, err = service.PopPartyFinalizeWithGas(ocl, partyInstance, fs, signer.Ed25519.Point,
+		gasCoin, *signer)
 	if err != nil {
 		return errors.New("error while sending transaction: " + err.Error())
 	}
 
 	iid := sha256.New()
-	iid.Write(ctx.Instructions[0].InstanceID.Slice())
-	pubBuf, err := signer.Ed25519.Point.MarshalBinary()
-	if err != nil {
-		return errors.New("couldn't marshal public key: " + err.Error())
-	}
+	iid.Write(partyInstance.Slice())
 	iid.Write(pubBuf)
 	p, err := ocl.GetProof(iid.Sum(nil))
 	if err != nil {
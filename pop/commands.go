@@ -141,7 +141,7 @@ func init() {
 			{
 				Name:      "finalize",
 				Aliases:   []string{"f"},
-				Usage:     "store a finalized pop-party in the ledger",
+				Usage:     "store a finalized pop-party in the ledger, paying the gas with the coins of the key",
 				ArgsUsage: "bc.cfg key-xxx.cfg partyId",
 				Action:    bcFinalize,
 			},
@@ -2,20 +2,30 @@ package service
 
 import (
 	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"sort"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/blscosi/protocol"
 	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/contracts"
 	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/sign/anon"
 	"go.dedis.ch/kyber/v3/sign/schnorr"
 	"go.dedis.ch/kyber/v3/util/encoding"
+	"go.dedis.ch/kyber/v3/util/key"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
 	"gopkg.in/satori/go.uuid.v1"
 )
 
@@ -222,6 +232,499 @@ func (c *Client) GetSigner(dst network.Address, partyID []byte) (darc.Signer, er
 	return ret.Signer, err
 }
 
+// ByzCoinClient is the part of byzcoin.Client used by the pop-party helpers,
+// so that it can be replaced by a mock in tests.
+type ByzCoinClient interface {
+	GetProof(key []byte) (*byzcoin.GetProofResponse, error)
+	AddTransactionAndWait(ctx byzcoin.ClientTransaction, wait int) (*byzcoin.AddTxResponse, error)
+	GetSignerCounters(ids ...string) (*byzcoin.GetSignerCountersResponse, error)
+}
+
+var _ ByzCoinClient = (*byzcoin.Client)(nil)
+
+// GetAnchoredResults returns all results anchored in the pop-party instance.
+func GetAnchoredResults(cl ByzCoinClient, popIID byzcoin.InstanceID) ([]AnchoredResult, error) {
+	ppi, err := getPopParty(cl, popIID)
+	if err != nil {
+		return nil, err
+	}
+	return ppi.AnchoredResults, nil
+}
+
+// PopPartyGetState returns the current value of the pop-party instance.
+func PopPartyGetState(cl ByzCoinClient, popIID byzcoin.InstanceID) (*PopPartyInstance, error) {
+	return getPopParty(cl, popIID)
+}
+
+// PopPartyListAttendees returns the attendees of the pop-party instance. If
+// the party is not finalized, ErrPartyNotFinalized is returned.
+func PopPartyListAttendees(cl ByzCoinClient, popIID byzcoin.InstanceID) ([]kyber.Point, error) {
+	ppi, err := getPopParty(cl, popIID)
+	if err != nil {
+		return nil, err
+	}
+	if ppi.State != 2 || ppi.FinalStatement == nil {
+		return nil, ErrPartyNotFinalized
+	}
+	return ppi.FinalStatement.Attendees, nil
+}
+
+// waitFinalizedStart and waitFinalizedMax are the first and the longest
+// interval between two polls of PopPartyWaitFinalized.
+const (
+	waitFinalizedStart = 500 * time.Millisecond
+	waitFinalizedMax   = 30 * time.Second
+)
+
+// timeAfter is used by PopPartyWaitFinalized to wait between two polls. Tests
+// replace it to not wait.
+var timeAfter = time.After
+
+// PopPartyWaitFinalized polls the pop-party instance until it is finalized,
+// and returns it. The interval between two polls starts at 500ms and doubles
+// up to 30s. If the party is cancelled, ErrPartyCancelled is returned. If ctx
+// is done before, its error is returned.
+func PopPartyWaitFinalized(ctx context.Context, cl ByzCoinClient, popIID byzcoin.InstanceID) (*PopPartyInstance, error) {
+	wait := waitFinalizedStart
+	for {
+		ppi, err := getPopParty(cl, popIID)
+		if err != nil {
+			return nil, err
+		}
+		switch ppi.State {
+		case 2:
+			return ppi, nil
+		case 4:
+			return nil, ErrPartyCancelled
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timeAfter(wait):
+		}
+		wait *= 2
+		if wait > waitFinalizedMax {
+			wait = waitFinalizedMax
+		}
+	}
+}
+
+// getPopParty returns the current value of the pop-party instance.
+func getPopParty(cl ByzCoinClient, popIID byzcoin.InstanceID) (*PopPartyInstance, error) {
+	reply, err := cl.GetProof(popIID.Slice())
+	if err != nil {
+		return nil, err
+	}
+	if !reply.Proof.InclusionProof.Match(popIID.Slice()) {
+		return nil, errors.New("pop-party instance doesn't exist")
+	}
+	_, buf, cid, _, err := reply.Proof.KeyValue()
+	if err != nil {
+		return nil, err
+	}
+	if cid != ContractPopParty {
+		return nil, errors.New("instance is not a pop-party but a " + cid)
+	}
+	var ppi PopPartyInstance
+	err = protobuf.DecodeWithConstructors(buf, &ppi, network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return nil, errors.New("couldn't decode pop-party instance: " + err.Error())
+	}
+	return &ppi, nil
+}
+
+// PopPartySpawnWithCap spawns a pop-party instance with the configuration of
+// the final statement, which accepts at most maxAttendees attendees when it
+// is finalized. If maxAttendees is 0, the number of attendees is not limited.
+// The signer must be allowed to spawn a pop-party on the darc.
+func PopPartySpawnWithCap(cl ByzCoinClient, darcID darc.ID, fs *FinalStatement, maxAttendees uint64,
+	signer darc.Signer) (byzcoin.InstanceID, error) {
+	fsBuf, err := protobuf.Encode(fs)
+	if err != nil {
+		return byzcoin.InstanceID{}, errors.New("couldn't encode final statement: " + err.Error())
+	}
+	maBuf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(maBuf, maxAttendees)
+	signerCtrs, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	if len(signerCtrs.Counters) != 1 {
+		return byzcoin.InstanceID{}, errors.New("incorrect signer counters")
+	}
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: byzcoin.NewInstanceID(darcID),
+			Spawn: &byzcoin.Spawn{
+				ContractID: ContractPopParty,
+				Args: byzcoin.Arguments{
+					{Name: "FinalStatement", Value: fsBuf},
+					{Name: "MaxAttendees", Value: maBuf},
+				},
+			},
+			SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
+		}},
+	}
+	if err = ctx.FillSignersAndSignWith(signer); err != nil {
+		return byzcoin.InstanceID{}, errors.New("couldn't sign instruction: " + err.Error())
+	}
+	if _, err = cl.AddTransactionAndWait(ctx, 10); err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	return ctx.Instructions[0].DeriveID(""), nil
+}
+
+// PopPartyFinalizeWithGas finalizes the pop-party instance with the final
+// statement. The gas for the attendees is fetched from coinIID, so the signer
+// must be allowed to invoke fetch on this coin. If service is not nil, a
+// coin account is created for it.
+func PopPartyFinalizeWithGas(cl ByzCoinClient, popIID byzcoin.InstanceID, fs *FinalStatement,
+	service kyber.Point, coinIID byzcoin.InstanceID, signer darc.Signer) (*byzcoin.AddTxResponse, error) {
+	fsBuf, err := protobuf.Encode(fs)
+	if err != nil {
+		return nil, errors.New("couldn't encode final statement: " + err.Error())
+	}
+	args := byzcoin.Arguments{{Name: "FinalStatement", Value: fsBuf}}
+	if service != nil {
+		sBuf, err := service.MarshalBinary()
+		if err != nil {
+			return nil, errors.New("couldn't marshal service key: " + err.Error())
+		}
+		args = append(args, byzcoin.Argument{Name: "Service", Value: sBuf})
+	}
+	gas := make([]byte, 8)
+	binary.LittleEndian.PutUint64(gas, FinalizeGas(len(fs.Attendees)))
+
+	signerCtrs, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return nil, err
+	}
+	if len(signerCtrs.Counters) != 1 {
+		return nil, errors.New("incorrect signer counters")
+	}
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{
+			{
+				InstanceID: coinIID,
+				Invoke: &byzcoin.Invoke{
+					ContractID: contracts.ContractCoinID,
+					Command:    "fetch",
+					Args:       byzcoin.Arguments{{Name: "coins", Value: gas}},
+				},
+				SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
+			},
+			{
+				InstanceID: popIID,
+				Invoke: &byzcoin.Invoke{
+					ContractID: ContractPopParty,
+					Command:    "Finalize",
+					Args:       args,
+				},
+				SignerCounter: []uint64{signerCtrs.Counters[0] + 2},
+			},
+		},
+	}
+	if err = ctx.FillSignersAndSignWith(signer); err != nil {
+		return nil, errors.New("couldn't sign instructions: " + err.Error())
+	}
+	return cl.AddTransactionAndWait(ctx, 10)
+}
+
+// PopPartyAddSubEvent adds the sub-event to the pop-party instance. The
+// signer must be allowed to invoke addSubEvent on the party.
+func PopPartyAddSubEvent(cl ByzCoinClient, popIID byzcoin.InstanceID, se *SubEvent,
+	signer darc.Signer) (*byzcoin.AddTxResponse, error) {
+	seBuf, err := protobuf.Encode(se)
+	if err != nil {
+		return nil, errors.New("couldn't encode sub-event: " + err.Error())
+	}
+	signerCtrs, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return nil, err
+	}
+	if len(signerCtrs.Counters) != 1 {
+		return nil, errors.New("incorrect signer counters")
+	}
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: popIID,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractPopParty,
+				Command:    "addSubEvent",
+				Args:       byzcoin.Arguments{{Name: "SubEvent", Value: seBuf}},
+			},
+			SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
+		}},
+	}
+	if err = ctx.FillSignersAndSignWith(signer); err != nil {
+		return nil, errors.New("couldn't sign instruction: " + err.Error())
+	}
+	return cl.AddTransactionAndWait(ctx, 10)
+}
+
+// PopPartyUpdateDescription replaces the description of the pop-party
+// instance, which must not be finalized yet. The signer must be allowed to
+// invoke UpdateDescription on the party.
+func PopPartyUpdateDescription(cl ByzCoinClient, popIID byzcoin.InstanceID, desc *PopDesc,
+	signer darc.Signer) (*byzcoin.AddTxResponse, error) {
+	descBuf, err := protobuf.Encode(desc)
+	if err != nil {
+		return nil, errors.New("couldn't encode description: " + err.Error())
+	}
+	signerCtrs, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return nil, err
+	}
+	if len(signerCtrs.Counters) != 1 {
+		return nil, errors.New("incorrect signer counters")
+	}
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: popIID,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractPopParty,
+				Command:    "UpdateDescription",
+				Args:       byzcoin.Arguments{{Name: "Description", Value: descBuf}},
+			},
+			SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
+		}},
+	}
+	if err = ctx.FillSignersAndSignWith(signer); err != nil {
+		return nil, errors.New("couldn't sign instruction: " + err.Error())
+	}
+	return cl.AddTransactionAndWait(ctx, 10)
+}
+
+// PopPartyCancel cancels the pop-party instance. More than half of the
+// organizers, which are the identities of the Finalize rule of the darc of
+// the party, must sign. The first signer must be allowed to invoke Cancel on
+// the party.
+func PopPartyCancel(cl ByzCoinClient, popIID byzcoin.InstanceID, signers ...darc.Signer) (*byzcoin.AddTxResponse, error) {
+	var ids []string
+	for _, signer := range signers {
+		ids = append(ids, signer.Identity().String())
+	}
+	signerCtrs, err := cl.GetSignerCounters(ids...)
+	if err != nil {
+		return nil, err
+	}
+	if len(signerCtrs.Counters) != len(signers) {
+		return nil, errors.New("incorrect signer counters")
+	}
+	inst := byzcoin.Instruction{
+		InstanceID: popIID,
+		Invoke: &byzcoin.Invoke{
+			ContractID: ContractPopParty,
+			Command:    "Cancel",
+		},
+	}
+	for _, ctr := range signerCtrs.Counters {
+		inst.SignerCounter = append(inst.SignerCounter, ctr+1)
+	}
+	ctx := byzcoin.ClientTransaction{Instructions: byzcoin.Instructions{inst}}
+	if err = ctx.FillSignersAndSignWith(signers...); err != nil {
+		return nil, errors.New("couldn't sign instruction: " + err.Error())
+	}
+	return cl.AddTransactionAndWait(ctx, 10)
+}
+
+// PollSpawn proposes a poll with the question and the options to the
+// attendees of the finalized pop-party. The signer must be allowed to spawn
+// a poll on the darc.
+func PollSpawn(cl ByzCoinClient, darcID darc.ID, partyIID byzcoin.InstanceID, question string,
+	options []string, signer darc.Signer) (byzcoin.InstanceID, error) {
+	pollBuf, err := protobuf.Encode(&Poll{
+		PartyIID: partyIID,
+		Question: question,
+		Options:  options,
+	})
+	if err != nil {
+		return byzcoin.InstanceID{}, errors.New("couldn't encode poll: " + err.Error())
+	}
+	signerCtrs, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	if len(signerCtrs.Counters) != 1 {
+		return byzcoin.InstanceID{}, errors.New("incorrect signer counters")
+	}
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: byzcoin.NewInstanceID(darcID),
+			Spawn: &byzcoin.Spawn{
+				ContractID: ContractPoll,
+				Args:       byzcoin.Arguments{{Name: "Poll", Value: pollBuf}},
+			},
+			SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
+		}},
+	}
+	if err = ctx.FillSignersAndSignWith(signer); err != nil {
+		return byzcoin.InstanceID{}, errors.New("couldn't sign instruction: " + err.Error())
+	}
+	if _, err = cl.AddTransactionAndWait(ctx, 10); err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	return ctx.Instructions[0].DeriveID(""), nil
+}
+
+// PollVote votes for the option of the poll with a ring signature of the
+// attendee holding priv. The signer only sends the vote, so it must be
+// allowed to invoke vote on the poll, but it doesn't need to be the attendee.
+func PollVote(cl ByzCoinClient, pollIID byzcoin.InstanceID, option uint32, priv kyber.Scalar,
+	signer darc.Signer) (*byzcoin.AddTxResponse, error) {
+	poll, err := getPoll(cl, pollIID)
+	if err != nil {
+		return nil, err
+	}
+	atts, err := PopPartyListAttendees(cl, poll.PartyIID)
+	if err != nil {
+		return nil, err
+	}
+	pub := cothority.Suite.Point().Mul(priv, nil)
+	mine := -1
+	for i, att := range atts {
+		if att.Equal(pub) {
+			mine = i
+			break
+		}
+	}
+	if mine < 0 {
+		return nil, errors.New("the key is not an attendee of the party")
+	}
+	scope := PollVoteScope(pollIID, option)
+	sig := anon.Sign(cothority.Suite.(anon.Suite), scope, anon.Set(atts), scope, mine, priv)
+	optBuf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(optBuf, option)
+
+	signerCtrs, err := cl.GetSignerCounters(signer.Identity().String())
+	if err != nil {
+		return nil, err
+	}
+	if len(signerCtrs.Counters) != 1 {
+		return nil, errors.New("incorrect signer counters")
+	}
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: pollIID,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractPoll,
+				Command:    "vote",
+				Args: byzcoin.Arguments{
+					{Name: "Option", Value: optBuf},
+					{Name: "Signature", Value: sig},
+				},
+			},
+			SignerCounter: []uint64{signerCtrs.Counters[0] + 1},
+		}},
+	}
+	if err = ctx.FillSignersAndSignWith(signer); err != nil {
+		return nil, errors.New("couldn't sign instruction: " + err.Error())
+	}
+	return cl.AddTransactionAndWait(ctx, 10)
+}
+
+// PollTally returns the number of votes for every option of the poll.
+func PollTally(cl ByzCoinClient, pollIID byzcoin.InstanceID) ([]uint64, error) {
+	poll, err := getPoll(cl, pollIID)
+	if err != nil {
+		return nil, err
+	}
+	return poll.Tally(), nil
+}
+
+// getPoll returns the current value of the poll instance.
+func getPoll(cl ByzCoinClient, pollIID byzcoin.InstanceID) (*Poll, error) {
+	reply, err := cl.GetProof(pollIID.Slice())
+	if err != nil {
+		return nil, err
+	}
+	if !reply.Proof.InclusionProof.Match(pollIID.Slice()) {
+		return nil, errors.New("poll instance doesn't exist")
+	}
+	_, buf, cid, _, err := reply.Proof.KeyValue()
+	if err != nil {
+		return nil, err
+	}
+	if cid != ContractPoll {
+		return nil, errors.New("instance is not a poll but a " + cid)
+	}
+	var poll Poll
+	if err = protobuf.Decode(buf, &poll); err != nil {
+		return nil, errors.New("couldn't decode poll: " + err.Error())
+	}
+	return &poll, nil
+}
+
+// zkAttendanceMessage returns the message signed by an attendance proof for
+// the given party.
+func zkAttendanceMessage(partyIID byzcoin.InstanceID) []byte {
+	return append([]byte("pop-attendance"), partyIID.Slice()...)
+}
+
+// GenerateZKAttendanceProof returns a proof that the owner of kp attended the
+// party, without revealing which of the attendees signed. The proof is a
+// Schnorr ring signature over all attendees without a linkage tag, so two
+// proofs of the same attendee cannot be linked.
+func GenerateZKAttendanceProof(kp key.Pair, partyIID byzcoin.InstanceID, atts []kyber.Point,
+	suite anon.Suite) ([]byte, error) {
+	for i, att := range atts {
+		if att.Equal(kp.Public) {
+			return anon.Sign(suite, zkAttendanceMessage(partyIID), anon.Set(atts),
+				nil, i, kp.Private), nil
+		}
+	}
+	return nil, errors.New("key pair is not part of the attendees")
+}
+
+// VerifyZKAttendanceProof returns true if the proof has been created by one of
+// the attendees for the given party.
+func VerifyZKAttendanceProof(proof []byte, partyIID byzcoin.InstanceID, atts []kyber.Point,
+	suite anon.Suite) bool {
+	if len(atts) == 0 {
+		return false
+	}
+	_, err := anon.Verify(suite, zkAttendanceMessage(partyIID), anon.Set(atts), nil, proof)
+	return err == nil
+}
+
+// deterministicSuite replaces the random stream of the suite with a fixed
+// stream.
+type deterministicSuite struct {
+	anon.Suite
+	stream cipher.Stream
+}
+
+func (ds deterministicSuite) RandomStream() cipher.Stream {
+	return ds.stream
+}
+
+// SignDeterministic returns the same signature as anon.Sign, but all the
+// random scalars are derived from the nonce, the private key and all the
+// other inputs, so that the same inputs always give the same signature. This
+// is useful for tests. Signing different inputs with the same nonce is safe,
+// as every input changes the derived scalars.
+func SignDeterministic(suite anon.Suite, msg []byte, ring []kyber.Point, context []byte,
+	myIndex int, priv kyber.Scalar, nonce []byte) ([]byte, error) {
+	xof := suite.XOF(nonce)
+	privBuf, err := priv.MarshalBinary()
+	if err != nil {
+		return nil, errors.New("couldn't marshal private key: " + err.Error())
+	}
+	for _, buf := range [][]byte{privBuf, msg, context} {
+		// Prefix the lengths, so that the inputs can't be shifted.
+		l := make([]byte, 8)
+		binary.LittleEndian.PutUint64(l, uint64(len(buf)))
+		xof.Write(l)
+		xof.Write(buf)
+	}
+	for _, p := range ring {
+		if _, err := p.MarshalTo(xof); err != nil {
+			return nil, errors.New("couldn't marshal ring: " + err.Error())
+		}
+	}
+	return anon.Sign(deterministicSuite{suite, xof}, msg, anon.Set(ring), context, myIndex, priv), nil
+}
+
 // The toml-structure for (un)marshaling with toml
 type finalStatementToml struct {
 	Desc      *popDescToml
@@ -475,6 +978,29 @@ func (fs *FinalStatement) Hash() ([]byte, error) {
 	return h.Sum(nil), nil
 }
 
+// HashAttendees returns a hash of the keys that doesn't depend on their order,
+// so that two lists of attendees can be compared by their hashes. The keys
+// are sorted by their binary encoding before being hashed with SHA-256. If a
+// key cannot be marshalled, it returns nil.
+func HashAttendees(keys []kyber.Point) []byte {
+	bufs := make([][]byte, len(keys))
+	for i, k := range keys {
+		buf, err := k.MarshalBinary()
+		if err != nil {
+			return nil
+		}
+		bufs[i] = buf
+	}
+	sort.Slice(bufs, func(i, j int) bool {
+		return bytes.Compare(bufs[i], bufs[j]) < 0
+	})
+	h := sha256.New()
+	for _, buf := range bufs {
+		h.Write(buf)
+	}
+	return h.Sum(nil)
+}
+
 // Verify checks if the collective signature is correct and has been created
 // by the roster. On success, this returns nil.
 func (fs *FinalStatement) Verify() error {
@@ -1,19 +1,27 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
 	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/sign/anon"
 	"go.dedis.ch/kyber/v3/sign/bls"
 	"go.dedis.ch/kyber/v3/util/key"
 	"go.dedis.ch/kyber/v3/util/random"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
 )
 
 var tSuite = cothority.Suite
@@ -208,3 +216,231 @@ func newTSer(t *testing.T) (ts tSer) {
 func (ts *tSer) Close() {
 	ts.local.CloseAll()
 }
+
+func TestZKAttendanceProof(t *testing.T) {
+	suite := tSuite.(anon.Suite)
+	var kps []*key.Pair
+	var atts []kyber.Point
+	for i := 0; i < 4; i++ {
+		kp := key.NewKeyPair(tSuite)
+		kps = append(kps, kp)
+		atts = append(atts, kp.Public)
+	}
+	party := byzcoin.NewInstanceID([]byte("party"))
+
+	proof, err := GenerateZKAttendanceProof(*kps[2], party, atts, suite)
+	require.Nil(t, err)
+	require.True(t, VerifyZKAttendanceProof(proof, party, atts, suite))
+	// Two proofs of the same attendee differ.
+	proof2, err := GenerateZKAttendanceProof(*kps[2], party, atts, suite)
+	require.Nil(t, err)
+	require.NotEqual(t, proof, proof2)
+	require.True(t, VerifyZKAttendanceProof(proof2, party, atts, suite))
+
+	// Wrong party, wrong attendees or a tampered proof.
+	require.False(t, VerifyZKAttendanceProof(proof, byzcoin.NewInstanceID([]byte("other")), atts, suite))
+	require.False(t, VerifyZKAttendanceProof(proof, party, atts[:3], suite))
+	require.False(t, VerifyZKAttendanceProof(proof, party, nil, suite))
+	proof[len(proof)-1] ^= 1
+	require.False(t, VerifyZKAttendanceProof(proof, party, atts, suite))
+	require.False(t, VerifyZKAttendanceProof([]byte("garbage"), party, atts, suite))
+
+	_, err = GenerateZKAttendanceProof(*key.NewKeyPair(tSuite), party, atts, suite)
+	require.NotNil(t, err)
+}
+
+func TestSignDeterministic(t *testing.T) {
+	suite := tSuite.(anon.Suite)
+	var ring []kyber.Point
+	var priv kyber.Scalar
+	for i := 0; i < 3; i++ {
+		kp := key.NewKeyPair(tSuite)
+		ring = append(ring, kp.Public)
+		if i == 1 {
+			priv = kp.Private
+		}
+	}
+	msg := []byte("message")
+	scope := []byte("scope")
+
+	sign := func(msg []byte, nonce string) []byte {
+		sig, err := SignDeterministic(suite, msg, ring, scope, 1, priv, []byte(nonce))
+		require.Nil(t, err)
+		return sig
+	}
+	sig1 := sign(msg, "nonce 1")
+	require.Equal(t, sig1, sign(msg, "nonce 1"))
+	sig2 := sign(msg, "nonce 2")
+	require.NotEqual(t, sig1, sig2)
+	require.NotEqual(t, sig1, sign([]byte("other"), "nonce 1"))
+
+	// Both are valid signatures with the same linkage tag.
+	tag1, err := anon.Verify(suite, msg, anon.Set(ring), scope, sig1)
+	require.Nil(t, err)
+	tag2, err := anon.Verify(suite, msg, anon.Set(ring), scope, sig2)
+	require.Nil(t, err)
+	require.Equal(t, tag1, tag2)
+}
+
+func TestHashAttendees_Deterministic(t *testing.T) {
+	var atts []kyber.Point
+	for i := 0; i < 10; i++ {
+		atts = append(atts, key.NewKeyPair(tSuite).Public)
+	}
+	shuffled := make([]kyber.Point, len(atts))
+	for i, j := range rand.Perm(len(atts)) {
+		shuffled[i] = atts[j]
+	}
+	require.Equal(t, HashAttendees(atts), HashAttendees(shuffled))
+	require.NotEqual(t, HashAttendees(atts), HashAttendees(atts[1:]))
+	require.NotEqual(t, HashAttendees(atts), HashAttendees(append(atts[1:], atts[1])))
+}
+
+// BenchmarkHashAttendees compares checking two lists of 1000 attendees key by
+// key with comparing their hashes.
+func BenchmarkHashAttendees(b *testing.B) {
+	var atts []kyber.Point
+	for i := 0; i < 1000; i++ {
+		atts = append(atts, key.NewKeyPair(tSuite).Public)
+	}
+	other := make([]kyber.Point, len(atts))
+	copy(other, atts)
+	hash := HashAttendees(atts)
+
+	b.Run("Keys", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for j := range atts {
+				if !atts[j].Equal(other[j]) {
+					b.Fatal("lists differ")
+				}
+			}
+		}
+	})
+	b.Run("Hash", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if !bytes.Equal(hash, HashAttendees(other)) {
+				b.Fatal("lists differ")
+			}
+		}
+	})
+}
+
+func TestPopPartyWaitFinalized(t *testing.T) {
+	var waits []time.Duration
+	timeAfter = func(d time.Duration) <-chan time.Time {
+		waits = append(waits, d)
+		c := make(chan time.Time, 1)
+		c <- time.Now()
+		return c
+	}
+	defer func() { timeAfter = time.After }()
+	popIID := byzcoin.NewInstanceID([]byte("party"))
+
+	cl := newPopClientMock(t, popIID, 1, 1, 1, 1, 1, 1, 1, 1, 2)
+	ppi, err := PopPartyWaitFinalized(context.Background(), cl, popIID)
+	require.Nil(t, err)
+	require.Equal(t, 2, ppi.State)
+	require.Equal(t, []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second,
+		4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second}, waits)
+
+	waits = nil
+	cl = newPopClientMock(t, popIID, 1, 4)
+	_, err = PopPartyWaitFinalized(context.Background(), cl, popIID)
+	require.Equal(t, ErrPartyCancelled, err)
+	require.Equal(t, 1, len(waits))
+
+	// The context is cancelled while waiting.
+	timeAfter = func(d time.Duration) <-chan time.Time {
+		return make(chan time.Time)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cl = newPopClientMock(t, popIID, 1)
+	_, err = PopPartyWaitFinalized(ctx, cl, popIID)
+	require.Equal(t, context.Canceled, err)
+
+	_, err = PopPartyWaitFinalized(context.Background(), cl, byzcoin.NewInstanceID([]byte("unknown")))
+	require.NotNil(t, err)
+}
+
+func TestPopPartyGetState(t *testing.T) {
+	popIID := byzcoin.NewInstanceID([]byte("party"))
+	cl := newPopClientMock(t, popIID, 1)
+	ppi, err := PopPartyGetState(cl, popIID)
+	require.Nil(t, err)
+	require.Equal(t, 1, ppi.State)
+	require.Equal(t, "test-party", ppi.FinalStatement.Desc.Name)
+	_, err = PopPartyListAttendees(cl, popIID)
+	require.Equal(t, ErrPartyNotFinalized, err)
+
+	_, err = PopPartyGetState(cl, byzcoin.NewInstanceID([]byte("unknown")))
+	require.NotNil(t, err)
+	_, err = PopPartyListAttendees(cl, byzcoin.NewInstanceID([]byte("unknown")))
+	require.NotNil(t, err)
+
+	ppis := testPopPartyInstances()
+	buf, err := protobuf.Encode(ppis[1])
+	require.Nil(t, err)
+	cl.set(ContractPopParty, buf)
+	atts, err := PopPartyListAttendees(cl, popIID)
+	require.Nil(t, err)
+	require.Equal(t, len(ppis[1].FinalStatement.Attendees), len(atts))
+	for i, att := range atts {
+		require.True(t, att.Equal(ppis[1].FinalStatement.Attendees[i]))
+	}
+
+	cl.set("coin", nil)
+	_, err = PopPartyGetState(cl, popIID)
+	require.NotNil(t, err)
+}
+
+// popClientMock is a ByzCoinClient holding a single pop-party instance, whose
+// state changes to the next of states every time its proof is requested.
+type popClientMock struct {
+	t      *testing.T
+	popIID byzcoin.InstanceID
+	states []int
+	trie   *trie.Trie
+}
+
+func newPopClientMock(t *testing.T, popIID byzcoin.InstanceID, states ...int) *popClientMock {
+	tr, err := trie.NewTrie(trie.NewMemDB(), []byte("nonce"))
+	require.Nil(t, err)
+	return &popClientMock{t: t, popIID: popIID, states: states, trie: tr}
+}
+
+func (m *popClientMock) GetProof(key []byte) (*byzcoin.GetProofResponse, error) {
+	if len(m.states) > 0 {
+		ppi := testPopPartyInstances()[0]
+		ppi.State = m.states[0]
+		m.states = m.states[1:]
+		buf, err := protobuf.Encode(ppi)
+		require.Nil(m.t, err)
+		m.set(ContractPopParty, buf)
+	}
+	p, err := m.trie.GetProof(key)
+	if err != nil {
+		return nil, err
+	}
+	return &byzcoin.GetProofResponse{Proof: byzcoin.Proof{InclusionProof: *p}}, nil
+}
+
+// set stores the value of the instance.
+func (m *popClientMock) set(contractID string, value []byte) {
+	scb, err := protobuf.Encode(&byzcoin.StateChangeBody{
+		StateAction: byzcoin.Update,
+		ContractID:  []byte(contractID),
+		Value:       value,
+		DarcID:      darc.ID(m.popIID.Slice()),
+	})
+	require.Nil(m.t, err)
+	require.Nil(m.t, m.trie.Set(m.popIID.Slice(), scb))
+}
+
+func (m *popClientMock) AddTransactionAndWait(byzcoin.ClientTransaction, int) (*byzcoin.AddTxResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *popClientMock) GetSignerCounters(...string) (*byzcoin.GetSignerCountersResponse, error) {
+	return nil, errors.New("not implemented")
+}
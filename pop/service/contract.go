@@ -2,14 +2,19 @@ package service
 
 import (
 	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
 
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/byzcoin"
 	"go.dedis.ch/cothority/v3/byzcoin/contracts"
 	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/cothority/v3/darc/expression"
+	"go.dedis.ch/cothority/v3/skipchain"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
@@ -25,6 +30,42 @@ import (
 // or a final statement.
 const ContractPopParty = "popParty"
 
+// finalizeAction is the darc action needed to finalize a party.
+const finalizeAction = darc.Action("invoke:" + ContractPopParty + ".Finalize")
+
+// AttendeeReward is the number of popcoins every attendee receives when the
+// party is finalized.
+const AttendeeReward = 1000000
+
+// MaxPartyAttendanceScore is the highest PartyAttendanceScore a party can add
+// to the reputation of its attendees, so that the spawner of a party cannot
+// mint reputation at will.
+const MaxPartyAttendanceScore = 10
+
+// GasCostPerAttendee is the number of byzCoins needed per attendee to
+// finalize a party. The coins must be passed to the Finalize instruction by a
+// fetch instruction on a coin instance in the same transaction.
+const GasCostPerAttendee uint64 = 10
+
+// ErrInsufficientGas is returned by Finalize if the coins passed to the
+// instruction don't cover the gas for all attendees.
+var ErrInsufficientGas = errors.New("not enough coins to pay the gas for finalizing")
+
+// ErrPartyCancelled is returned for all instructions on a cancelled party.
+var ErrPartyCancelled = errors.New("the party has been cancelled")
+
+// ErrFinalizeRuleExpired is returned by Spawn if the Finalize rule of the
+// darc of the party expired, so the party could never be finalized.
+var ErrFinalizeRuleExpired = errors.New("the Finalize rule of the darc has expired")
+
+// ErrPartyNotFinalized is returned when the attendees of a party that is not
+// finalized are requested.
+var ErrPartyNotFinalized = errors.New("the party is not finalized")
+
+// CurrentSchemaVersion is the version of PopPartyInstance written by this
+// contract. Instances of an older version are migrated when they are loaded.
+const CurrentSchemaVersion uint32 = 1
+
 // PoPCoinName is the identifier of the popcoins.
 var PoPCoinName byzcoin.InstanceID
 
@@ -39,15 +80,52 @@ type contract struct {
 	PopPartyInstance
 }
 
+var _ byzcoin.Migrator = (*contract)(nil)
+
 func contractPopPartyFromBytes(in []byte) (byzcoin.Contract, error) {
 	c := &contract{}
 	err := protobuf.DecodeWithConstructors(in, &c.PopPartyInstance, network.DefaultConstructors(cothority.Suite))
 	if err != nil {
 		return nil, errors.New("couldn't unmarshal existing PopPartyInstance: " + err.Error())
 	}
+	if c.SchemaVersion < CurrentSchemaVersion {
+		in, err = c.Migrate(c.SchemaVersion, in)
+		if err != nil {
+			return nil, errors.New("couldn't migrate PopPartyInstance: " + err.Error())
+		}
+		c.PopPartyInstance = PopPartyInstance{}
+		err = protobuf.DecodeWithConstructors(in, &c.PopPartyInstance, network.DefaultConstructors(cothority.Suite))
+		if err != nil {
+			return nil, errors.New("couldn't unmarshal migrated PopPartyInstance: " + err.Error())
+		}
+	}
 	return c, nil
 }
 
+// Migrate converts a PopPartyInstance of an older schema to
+// CurrentSchemaVersion, one version at a time.
+func (c *contract) Migrate(oldVersion uint32, data []byte) ([]byte, error) {
+	for v := oldVersion; v < CurrentSchemaVersion; v++ {
+		switch v {
+		case 0:
+			// Version 1 only adds the SchemaVersion.
+			var ppi PopPartyInstance
+			err := protobuf.DecodeWithConstructors(data, &ppi, network.DefaultConstructors(cothority.Suite))
+			if err != nil {
+				return nil, err
+			}
+			ppi.SchemaVersion = 1
+			data, err = protobuf.Encode(&ppi)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown schema version %d", v)
+		}
+	}
+	return data, nil
+}
+
 func (c *contract) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (scs []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
 	cout = coins
 
@@ -56,6 +134,7 @@ func (c *contract) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction
 		return nil, nil, errors.New("need FinalStatement argument")
 	}
 	c.State = 1
+	c.SchemaVersion = CurrentSchemaVersion
 
 	var fs FinalStatement
 	err = protobuf.DecodeWithConstructors(fsBuf, &fs, network.DefaultConstructors(cothority.Suite))
@@ -64,6 +143,34 @@ func (c *contract) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction
 	}
 	c.FinalStatement = &fs
 
+	if lcBuf := inst.Spawn.Args.Search("LinkedChains"); lcBuf != nil {
+		var lc LinkedChains
+		err = protobuf.DecodeWithConstructors(lcBuf, &lc, network.DefaultConstructors(cothority.Suite))
+		if err != nil {
+			return nil, nil, errors.New("couldn't unmarshal the linked chains: " + err.Error())
+		}
+		c.LinkedChains = lc.Chains
+	}
+	if maBuf := inst.Spawn.Args.Search("MaxAttendees"); maBuf != nil {
+		if len(maBuf) != 8 {
+			return nil, nil, errors.New("MaxAttendees must be 8 bytes")
+		}
+		c.MaxAttendees = binary.LittleEndian.Uint64(maBuf)
+	}
+	if pasBuf := inst.Spawn.Args.Search("PartyAttendanceScore"); pasBuf != nil {
+		if len(pasBuf) != 8 {
+			return nil, nil, errors.New("PartyAttendanceScore must be 8 bytes")
+		}
+		c.PartyAttendanceScore = binary.LittleEndian.Uint64(pasBuf)
+		if c.PartyAttendanceScore > MaxPartyAttendanceScore {
+			return nil, nil, fmt.Errorf("PartyAttendanceScore must not be higher than %d",
+				MaxPartyAttendanceScore)
+		}
+	}
+	if err = verifyFinalizeRule(rst, darc.ID(inst.InstanceID[:])); err != nil {
+		return nil, nil, err
+	}
+
 	ppiBuf, err := protobuf.Encode(&c.PopPartyInstance)
 	if err != nil {
 		return nil, nil, errors.New("couldn't marshal PopPartyInstance: " + err.Error())
@@ -83,6 +190,9 @@ func (c *contract) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instructio
 	if err != nil {
 		return nil, nil, errors.New("couldn't get instance data: " + err.Error())
 	}
+	if c.State == 4 {
+		return nil, nil, ErrPartyCancelled
+	}
 
 	switch inst.Invoke.Command {
 	case "Finalize":
@@ -99,12 +209,42 @@ func (c *contract) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instructio
 		if err != nil {
 			return nil, nil, errors.New("argument is not a valid FinalStatement")
 		}
+		if c.MaxAttendees > 0 && uint64(len(fs.Attendees)) > c.MaxAttendees {
+			return nil, nil, fmt.Errorf("party is limited to %d attendees, but got %d",
+				c.MaxAttendees, len(fs.Attendees))
+		}
+		cout, err = payGas(coins, FinalizeGas(len(fs.Attendees)))
+		if err != nil {
+			return nil, nil, err
+		}
+		// An attendee listed twice would get two coin accounts.
+		seen := make(map[string]bool, len(fs.Attendees))
+		for _, att := range fs.Attendees {
+			attBuf, err := att.MarshalBinary()
+			if err != nil {
+				return nil, nil, errors.New("couldn't marshal attendee: " + err.Error())
+			}
+			if seen[string(attBuf)] {
+				return nil, nil, fmt.Errorf("attendee %s is listed twice", att)
+			}
+			seen[string(attBuf)] = true
+		}
+		// Sub-events added before the party was finalized must only have
+		// attendees of the party.
+		for _, se := range c.SubEvents {
+			for _, att := range se.Attendees {
+				if !fs.isAttendee(att) {
+					return nil, nil, fmt.Errorf("attendee %s of sub-event %s is not an attendee of the party",
+						att, se.Name)
+				}
+			}
+		}
 
 		// TODO: check for aggregate signature of all organizers
-		ppi := PopPartyInstance{
-			State:          2,
-			FinalStatement: &fs,
-		}
+		// Keep the linked chains and sub-events of the configuration.
+		ppi := c.PopPartyInstance
+		ppi.State = 2
+		ppi.FinalStatement = &fs
 
 		for i, pub := range fs.Attendees {
 			log.Lvlf3("Creating darc for attendee %d %s", i, pub)
@@ -114,11 +254,21 @@ func (c *contract) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instructio
 			}
 			scs = append(scs, sc)
 
-			sc, err = createCoin(inst, d, pub, 1000000)
+			sc, err = createCoin(inst, d, pub, AttendeeReward)
 			if err != nil {
 				return nil, nil, err
 			}
 			scs = append(scs, sc)
+
+			if c.PartyAttendanceScore > 0 {
+				sc, ok, err := addAttendanceScore(rst, inst.InstanceID, darcID, pub, c.PartyAttendanceScore)
+				if err != nil {
+					return nil, nil, err
+				}
+				if ok {
+					scs = append(scs, sc)
+				}
+			}
 		}
 
 		// And add a service if the argument is given
@@ -158,12 +308,329 @@ func (c *contract) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instructio
 		// Update existing final statement
 		scs = append(scs, byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID, ContractPopParty, ppiBuf, darcID))
 
+		return scs, cout, nil
+	case "AnchorResult":
+		if c.State != 2 {
+			return nil, nil, fmt.Errorf("can only anchor results in a party with state 2, but current state is %d",
+				c.State)
+		}
+		hash := inst.Invoke.Args.Search("ResultHash")
+		if len(hash) != sha256.Size {
+			return nil, nil, fmt.Errorf("ResultHash must be %d bytes", sha256.Size)
+		}
+		c.AnchoredResults = append(c.AnchoredResults, AnchoredResult{
+			ResultHash: hash,
+			ResultURL:  string(inst.Invoke.Args.Search("ResultURL")),
+		})
+		ppiBuf, err := protobuf.Encode(&c.PopPartyInstance)
+		if err != nil {
+			return nil, nil, errors.New("couldn't marshal PopPartyInstance: " + err.Error())
+		}
+		scs = append(scs, byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID, ContractPopParty, ppiBuf, darcID))
+		return scs, coins, nil
+	case "addSubEvent":
+		if c.State != 1 && c.State != 2 {
+			return nil, nil, fmt.Errorf("can only add sub-events to a party with state 1 or 2, but current state is %d",
+				c.State)
+		}
+		var se SubEvent
+		err = protobuf.DecodeWithConstructors(inst.Invoke.Args.Search("SubEvent"), &se,
+			network.DefaultConstructors(cothority.Suite))
+		if err != nil {
+			return nil, nil, errors.New("couldn't unmarshal sub-event: " + err.Error())
+		}
+		if se.Name == "" {
+			return nil, nil, errors.New("sub-event needs a name")
+		}
+		if se.RewardMultiplier < 0 || math.IsNaN(se.RewardMultiplier) ||
+			math.IsInf(se.RewardMultiplier, 0) {
+			return nil, nil, errors.New("reward multiplier must be a positive number")
+		}
+		for _, other := range c.SubEvents {
+			if other.Name == se.Name {
+				return nil, nil, errors.New("a sub-event with this name already exists")
+			}
+		}
+		if c.State == 2 {
+			for _, att := range se.Attendees {
+				if !c.isAttendee(att) {
+					return nil, nil, errors.New("sub-event attendee is not an attendee of the party")
+				}
+			}
+		}
+		c.SubEvents = append(c.SubEvents, se)
+		ppiBuf, err := protobuf.Encode(&c.PopPartyInstance)
+		if err != nil {
+			return nil, nil, errors.New("couldn't marshal PopPartyInstance: " + err.Error())
+		}
+		scs = append(scs, byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID, ContractPopParty, ppiBuf, darcID))
+		return scs, coins, nil
+	case "CrossChainProof":
+		if c.State != 2 {
+			return nil, nil, fmt.Errorf("can only add cross-chain attendees to a party with state 2, but current state is %d",
+				c.State)
+		}
+		att, err := c.verifyCrossChainProof(inst.Invoke.Args)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, a := range c.CrossChainAttendees {
+			if a.Equal(att) {
+				return nil, coins, nil
+			}
+		}
+		c.CrossChainAttendees = append(c.CrossChainAttendees, att)
+		ppiBuf, err := protobuf.Encode(&c.PopPartyInstance)
+		if err != nil {
+			return nil, nil, errors.New("couldn't marshal PopPartyInstance: " + err.Error())
+		}
+		scs = append(scs, byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID, ContractPopParty, ppiBuf, darcID))
+		return scs, coins, nil
+	case "UpdateDescription":
+		if c.State != 1 {
+			return nil, nil, fmt.Errorf("can only update the description of a party with state 1, but current state is %d",
+				c.State)
+		}
+		descBuf := inst.Invoke.Args.Search("Description")
+		if descBuf == nil {
+			return nil, nil, errors.New("missing argument: Description")
+		}
+		var desc PopDesc
+		err = protobuf.DecodeWithConstructors(descBuf, &desc, network.DefaultConstructors(cothority.Suite))
+		if err != nil {
+			return nil, nil, errors.New("couldn't unmarshal description: " + err.Error())
+		}
+		if desc.Name == "" {
+			return nil, nil, errors.New("description needs a name")
+		}
+		fs := *c.FinalStatement
+		fs.Desc = &desc
+		c.FinalStatement = &fs
+		ppiBuf, err := protobuf.Encode(&c.PopPartyInstance)
+		if err != nil {
+			return nil, nil, errors.New("couldn't marshal PopPartyInstance: " + err.Error())
+		}
+		scs = append(scs, byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID, ContractPopParty, ppiBuf, darcID))
+		return scs, coins, nil
+	case "Cancel":
+		if c.State != 1 && c.State != 2 {
+			return nil, nil, fmt.Errorf("can only cancel a party with state 1 or 2, but current state is %d",
+				c.State)
+		}
+		if err = verifyOrganizerQuorum(rst, darcID, inst.SignerIdentities); err != nil {
+			return nil, nil, err
+		}
+		c.State = 4
+		ppiBuf, err := protobuf.Encode(&c.PopPartyInstance)
+		if err != nil {
+			return nil, nil, errors.New("couldn't marshal PopPartyInstance: " + err.Error())
+		}
+		scs = append(scs, byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID, ContractPopParty, ppiBuf, darcID))
 		return scs, coins, nil
 	case "AddParty":
 		return nil, nil, errors.New("not yet implemented")
 	default:
-		return nil, nil, errors.New("can only finalize Pop-party contract")
+		return nil, nil, errors.New("unknown command for Pop-party contract: " + inst.Invoke.Command)
+	}
+}
+
+// verifyOrganizerQuorum returns nil if more than half of the organizers signed
+// the instruction. The organizers are the identities of the Finalize rule of
+// the darc of the party. An organizer given as a darc signed if the signers
+// fulfill the sign rule of that darc. The signatures are verified by byzcoin
+// before the contract is called.
+func verifyOrganizerQuorum(rst byzcoin.ReadOnlyStateTrie, darcID darc.ID, signers []darc.Identity) error {
+	d, err := getPartyDarc(rst, darcID)
+	if err != nil {
+		return err
+	}
+	expr := d.Rules.Get(finalizeAction)
+	if expr == nil {
+		return errors.New("darc of the party has no Finalize rule")
+	}
+	organizers := make(map[string]bool)
+	_, err = expression.Evaluate(expression.InitParser(func(id string) bool {
+		organizers[id] = true
+		return true
+	}), expr)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(signers))
+	for i, id := range signers {
+		ids[i] = id.String()
+	}
+	blockIndex := int64(rst.GetIndex() + 1)
+	getDarc := func(id string, latest bool) *darc.Darc {
+		if !strings.HasPrefix(id, "darc:") {
+			return nil
+		}
+		key, err := hex.DecodeString(id[len("darc:"):])
+		if err != nil {
+			return nil
+		}
+		d, err := getPartyDarc(rst, key)
+		if err != nil {
+			return nil
+		}
+		return darc.CleanExpiredRules(d, blockIndex)
+	}
+	var signed int
+	for org := range organizers {
+		if darc.EvalExpr(expression.Expr(org), getDarc, ids...) == nil {
+			signed++
+		}
+	}
+	if signed <= len(organizers)/2 {
+		return fmt.Errorf("cancelling needs signatures of %d organizers, got %d",
+			len(organizers)/2+1, signed)
+	}
+	return nil
+}
+
+// verifyFinalizeRule returns ErrFinalizeRuleExpired if the Finalize rule of
+// the darc of the party has an expiry that passed at the current block. Darcs
+// without a Finalize rule or without an expiry are accepted.
+func verifyFinalizeRule(rst byzcoin.ReadOnlyStateTrie, darcID darc.ID) error {
+	d, err := getPartyDarc(rst, darcID)
+	if err != nil {
+		return err
+	}
+	for _, rule := range d.Rules.List {
+		if rule.Action == finalizeAction && rule.Expired(int64(rst.GetIndex()+1)) {
+			return ErrFinalizeRuleExpired
+		}
+	}
+	return nil
+}
+
+// getPartyDarc returns the darc protecting the party, or a darc an
+// organizer delegated to.
+func getPartyDarc(rst byzcoin.ReadOnlyStateTrie, darcID darc.ID) (*darc.Darc, error) {
+	buf, _, cid, _, err := rst.GetValues(darcID)
+	if err != nil {
+		return nil, errors.New("couldn't get darc of the party: " + err.Error())
+	}
+	if cid != byzcoin.ContractDarcID {
+		return nil, errors.New("the party is not protected by a darc")
+	}
+	return darc.NewFromProtobuf(buf)
+}
+
+// verifyCrossChainProof checks the arguments of the CrossChainProof command
+// and returns the attendee. The arguments are:
+//   - SourceByzCoinID - the ID of one of the LinkedChains
+//   - Proof - a byzcoin.Proof of a finalized party on the source chain. It
+//     holds the block header and the merkle proof of the party.
+//   - Attendee - the public key of an attendee of that party
+func (c *contract) verifyCrossChainProof(args byzcoin.Arguments) (kyber.Point, error) {
+	srcID := skipchain.SkipBlockID(args.Search("SourceByzCoinID"))
+	var chain *ChainInfo
+	for i := range c.LinkedChains {
+		if srcID.Equal(c.LinkedChains[i].ByzCoinID) {
+			chain = &c.LinkedChains[i]
+		}
+	}
+	if chain == nil || chain.Roster == nil {
+		return nil, errors.New("source chain is not linked to this party")
+	}
+
+	var proof byzcoin.Proof
+	err := protobuf.DecodeWithConstructors(args.Search("Proof"), &proof,
+		network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return nil, errors.New("couldn't unmarshal proof: " + err.Error())
+	}
+	// The proof trusts the roster of its first link, so it must be the
+	// roster of the linked chain.
+	if len(proof.Links) == 0 || proof.Links[0].NewRoster == nil {
+		return nil, errors.New("proof has no genesis roster")
+	}
+	got := proof.Links[0].NewRoster.ServicePublics(skipchain.ServiceName)
+	want := chain.Roster.ServicePublics(skipchain.ServiceName)
+	if len(got) != len(want) {
+		return nil, errors.New("proof is not from the roster of the linked chain")
+	}
+	for i := range got {
+		if !got[i].Equal(want[i]) {
+			return nil, errors.New("proof is not from the roster of the linked chain")
+		}
+	}
+	if err = proof.Verify(srcID); err != nil {
+		return nil, err
+	}
+	if proof.Latest.SkipBlockFix == nil ||
+		!proof.Latest.CalculateHash().Equal(proof.Links[len(proof.Links)-1].To) {
+		return nil, errors.New("block header is not the last block of the proof")
 	}
+
+	var ppi PopPartyInstance
+	if err = proof.VerifyAndDecode(cothority.Suite, ContractPopParty, &ppi); err != nil {
+		return nil, errors.New("proof is not for a pop-party: " + err.Error())
+	}
+	if ppi.State != 2 || ppi.FinalStatement == nil {
+		return nil, errors.New("party on the source chain is not finalized")
+	}
+	att := cothority.Suite.Point()
+	if err = att.UnmarshalBinary(args.Search("Attendee")); err != nil {
+		return nil, errors.New("couldn't unmarshal attendee: " + err.Error())
+	}
+	if !ppi.FinalStatement.isAttendee(att) {
+		return nil, errors.New("attendee is not part of the party on the source chain")
+	}
+	return att, nil
+}
+
+// isAttendee returns true if the point is one of the attendees.
+func (fs *FinalStatement) isAttendee(pub kyber.Point) bool {
+	for _, a := range fs.Attendees {
+		if a.Equal(pub) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAttendee returns true if the point is one of the attendees of the final
+// statement or one of the attendees proven with CrossChainProof.
+func (ppi *PopPartyInstance) isAttendee(pub kyber.Point) bool {
+	if ppi.FinalStatement != nil && ppi.FinalStatement.isAttendee(pub) {
+		return true
+	}
+	for _, a := range ppi.CrossChainAttendees {
+		if a.Equal(pub) {
+			return true
+		}
+	}
+	return false
+}
+
+// FinalizeGas returns the number of byzCoins needed to finalize a party with
+// the given number of attendees.
+func FinalizeGas(attendees int) uint64 {
+	return GasCostPerAttendee * uint64(attendees)
+}
+
+// payGas takes the gas from the byzCoins passed to the instruction and
+// returns the remaining coins.
+func payGas(coins []byzcoin.Coin, gas uint64) (cout []byzcoin.Coin, err error) {
+	for _, co := range coins {
+		if co.Name.Equal(contracts.CoinName) {
+			if co.SafeSub(gas) == nil {
+				gas = 0
+			} else {
+				gas -= co.Value
+				co.Value = 0
+			}
+		}
+		if co.Value > 0 {
+			cout = append(cout, co)
+		}
+	}
+	if gas > 0 {
+		return nil, ErrInsufficientGas
+	}
+	return cout, nil
 }
 
 func createDarc(darcID darc.ID, pub kyber.Point) (d *darc.Darc, sc byzcoin.StateChange, err error) {
@@ -182,6 +649,31 @@ func createDarc(darcID darc.ID, pub kyber.Point) (d *darc.Darc, sc byzcoin.State
 	return
 }
 
+// addAttendanceScore returns the state change that adds the score to the
+// reputation of the attendee. It returns false if the attendee has no
+// reputation, or if its darc doesn't whitelist partyDarc, the darc of the
+// party.
+func addAttendanceScore(rst byzcoin.ReadOnlyStateTrie, party byzcoin.InstanceID, partyDarc darc.ID,
+	pub kyber.Point, score uint64) (sc byzcoin.StateChange, ok bool, err error) {
+	pubBuf, err := pub.MarshalBinary()
+	if err != nil {
+		err = errors.New("couldn't marshal public key: " + err.Error())
+		return
+	}
+	rid := contracts.ReputationInstanceID(pubBuf)
+	if _, _, _, _, err = rst.GetValues(rid.Slice()); err != nil {
+		log.Lvlf3("attendee %s has no reputation", pub)
+		return sc, false, nil
+	}
+	sc, err = contracts.AddReputationScore(rst, rid, ContractPopParty, partyDarc,
+		contracts.ReputationEvent{Source: party, Score: score})
+	if err == contracts.ErrReputationNotWhitelisted {
+		log.Lvlf2("reputation of attendee %s doesn't accept this pop-party", pub)
+		return sc, false, nil
+	}
+	return sc, err == nil, err
+}
+
 func createCoin(inst byzcoin.Instruction, d *darc.Darc, pub kyber.Point, balance uint64) (sc byzcoin.StateChange, err error) {
 	iid := sha256.New()
 	iid.Write(inst.InstanceID.Slice())
@@ -0,0 +1,1097 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/contracts"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/util/key"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+)
+
+// TestContract_PopPartyFromBytes makes sure that untrusted data from the
+// ledger never makes the decoding of the contract panic, and that every
+// decoded instance can be encoded again.
+func TestContract_PopPartyFromBytes(t *testing.T) {
+	var inputs [][]byte
+	for _, ppi := range testPopPartyInstances() {
+		buf, err := protobuf.Encode(ppi)
+		require.Nil(t, err)
+		c, err := contractPopPartyFromBytes(buf)
+		require.Nil(t, err)
+		require.NotNil(t, c)
+		for i := 0; i < len(buf); i++ {
+			inputs = append(inputs, buf[:i])
+		}
+		garbage := append([]byte{}, buf...)
+		for i := range garbage {
+			garbage[i] ^= 0x5a
+		}
+		inputs = append(inputs, garbage)
+	}
+	inputs = append(inputs, nil, []byte{0xff}, []byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0x0f},
+		bytes.Repeat([]byte{0xff}, 64))
+
+	for _, in := range inputs {
+		c, err := contractPopPartyFromBytes(in)
+		if err != nil {
+			require.Nil(t, c)
+			continue
+		}
+		buf, err := protobuf.Encode(&c.(*contract).PopPartyInstance)
+		require.Nil(t, err)
+		c2, err := contractPopPartyFromBytes(buf)
+		require.Nil(t, err)
+		buf2, err := protobuf.Encode(&c2.(*contract).PopPartyInstance)
+		require.Nil(t, err)
+		require.Equal(t, buf, buf2)
+	}
+}
+
+// Parties written before the schema was versioned decode as the current
+// version, with all other fields unchanged.
+func TestContract_MigrateSchema(t *testing.T) {
+	for _, v0 := range testPopPartyInstances() {
+		require.Equal(t, uint32(0), v0.SchemaVersion)
+		buf, err := protobuf.Encode(v0)
+		require.Nil(t, err)
+		c, err := contractPopPartyFromBytes(buf)
+		require.Nil(t, err)
+		ppi := c.(*contract).PopPartyInstance
+		require.Equal(t, CurrentSchemaVersion, ppi.SchemaVersion)
+
+		v0.SchemaVersion = CurrentSchemaVersion
+		v1Buf, err := protobuf.Encode(v0)
+		require.Nil(t, err)
+		ppiBuf, err := protobuf.Encode(&ppi)
+		require.Nil(t, err)
+		require.Equal(t, v1Buf, ppiBuf)
+	}
+}
+
+// testPopPartyInstances returns a configured and a finalized party.
+func testPopPartyInstances() []*PopPartyInstance {
+	var sis []*network.ServerIdentity
+	for i := 0; i < 3; i++ {
+		kp := key.NewKeyPair(cothority.Suite)
+		sis = append(sis, network.NewServerIdentity(kp.Public,
+			network.NewAddress(network.TLS, "localhost:2000")))
+	}
+	roster := onet.NewRoster(sis)
+	desc := &PopDesc{
+		Name:     "test-party",
+		DateTime: "2018-08-28 08:08",
+		Location: "BC208",
+		Roster:   roster,
+	}
+	var atts []kyber.Point
+	for i := 0; i < 3; i++ {
+		atts = append(atts, key.NewKeyPair(cothority.Suite).Public)
+	}
+	return []*PopPartyInstance{
+		{
+			State:          1,
+			FinalStatement: &FinalStatement{Desc: desc},
+		},
+		{
+			State: 2,
+			FinalStatement: &FinalStatement{
+				Desc:      desc,
+				Attendees: atts,
+				Signature: []byte("signature"),
+			},
+			Previous: byzcoin.NewInstanceID([]byte("previous")),
+			Service:  key.NewKeyPair(cothority.Suite).Public,
+		},
+	}
+}
+
+// Anchors two results in a finalized party and reads them back.
+func TestContract_AnchorResult(t *testing.T) {
+	ct := newCT()
+	ppis := testPopPartyInstances()
+	popIID := byzcoin.NewInstanceID([]byte("party"))
+	ct.storePPI(t, popIID, ppis[0])
+
+	anchor := func(hash []byte, url string) error {
+		inst := byzcoin.Instruction{
+			InstanceID: popIID,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractPopParty,
+				Command:    "AnchorResult",
+				Args: byzcoin.Arguments{
+					{Name: "ResultHash", Value: hash},
+					{Name: "ResultURL", Value: []byte(url)},
+				},
+			},
+		}
+		return ct.invoke(inst)
+	}
+	hash1 := sha256.Sum256([]byte("result1"))
+	hash2 := sha256.Sum256([]byte("result2"))
+
+	// Only finalized parties can anchor results.
+	require.NotNil(t, anchor(hash1[:], "https://example.com/1"))
+
+	ct.storePPI(t, popIID, ppis[1])
+	require.NotNil(t, anchor([]byte("too short"), "https://example.com/1"))
+	require.Nil(t, anchor(hash1[:], "https://example.com/1"))
+	require.Nil(t, anchor(hash2[:], "https://example.com/2"))
+
+	ppi := ct.getPPI(t, popIID)
+	require.Equal(t, 2, ppi.State)
+	require.Equal(t, []AnchoredResult{
+		{ResultHash: hash1[:], ResultURL: "https://example.com/1"},
+		{ResultHash: hash2[:], ResultURL: "https://example.com/2"},
+	}, ppi.AnchoredResults)
+}
+
+// Finalizes a party with too few, exactly enough, and too many coins for the
+// gas.
+func TestContract_FinalizeGas(t *testing.T) {
+	ppis := testPopPartyInstances()
+	fsBuf, err := protobuf.Encode(ppis[1].FinalStatement)
+	require.Nil(t, err)
+	popIID := byzcoin.NewInstanceID([]byte("party"))
+	inst := byzcoin.Instruction{
+		InstanceID: popIID,
+		Invoke: &byzcoin.Invoke{
+			ContractID: ContractPopParty,
+			Command:    "Finalize",
+			Args:       byzcoin.Arguments{{Name: "FinalStatement", Value: fsBuf}},
+		},
+	}
+	gas := FinalizeGas(len(ppis[1].FinalStatement.Attendees))
+	require.Equal(t, 3*GasCostPerAttendee, gas)
+	popCoins := byzcoin.Coin{Name: PoPCoinName, Value: 1000}
+
+	for _, test := range []struct {
+		coins []byzcoin.Coin
+		cout  []byzcoin.Coin
+		err   error
+	}{
+		{nil, nil, ErrInsufficientGas},
+		{[]byzcoin.Coin{popCoins}, nil, ErrInsufficientGas},
+		{[]byzcoin.Coin{{Name: contracts.CoinName, Value: gas - 1}}, nil, ErrInsufficientGas},
+		{[]byzcoin.Coin{{Name: contracts.CoinName, Value: gas}}, nil, nil},
+		{[]byzcoin.Coin{{Name: contracts.CoinName, Value: gas - 1}, {Name: contracts.CoinName, Value: 1}},
+			nil, nil},
+		{[]byzcoin.Coin{popCoins, {Name: contracts.CoinName, Value: gas + 5}},
+			[]byzcoin.Coin{popCoins, {Name: contracts.CoinName, Value: 5}}, nil},
+	} {
+		ct := newCT()
+		ct.storePPI(t, popIID, ppis[0])
+		c, err := contractPopPartyFromBytes(ct.values[string(popIID.Slice())])
+		require.Nil(t, err)
+		scs, cout, err := c.Invoke(ct, inst, test.coins)
+		require.Equal(t, test.err, err)
+		require.Equal(t, test.cout, cout)
+		if err == nil {
+			// A darc and a coin for every attendee, and the update of
+			// the party.
+			require.Equal(t, 2*len(ppis[1].FinalStatement.Attendees)+1, len(scs))
+		}
+	}
+}
+
+// Adds sub-events to a configured party, finalizes it, and adds more
+// sub-events.
+func TestContract_AddSubEvent(t *testing.T) {
+	ct := newCT()
+	ppis := testPopPartyInstances()
+	popIID := byzcoin.NewInstanceID([]byte("party"))
+	ct.storePPI(t, popIID, ppis[0])
+	atts := ppis[1].FinalStatement.Attendees
+
+	addSubEvent := func(se SubEvent) error {
+		seBuf, err := protobuf.Encode(&se)
+		require.Nil(t, err)
+		return ct.invoke(byzcoin.Instruction{
+			InstanceID: popIID,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractPopParty,
+				Command:    "addSubEvent",
+				Args:       byzcoin.Arguments{{Name: "SubEvent", Value: seBuf}},
+			},
+		})
+	}
+	keynote := SubEvent{Name: "keynote", StartTime: 1000, RewardMultiplier: 2}
+	require.Nil(t, addSubEvent(keynote))
+	require.NotNil(t, addSubEvent(keynote))
+	require.NotNil(t, addSubEvent(SubEvent{}))
+	require.NotNil(t, addSubEvent(SubEvent{Name: "negative", RewardMultiplier: -1}))
+	require.NotNil(t, addSubEvent(SubEvent{Name: "nan", RewardMultiplier: math.NaN()}))
+	require.NotNil(t, addSubEvent(SubEvent{Name: "inf", RewardMultiplier: math.Inf(1)}))
+
+	fsBuf, err := protobuf.Encode(ppis[1].FinalStatement)
+	require.Nil(t, err)
+	c, err := contractPopPartyFromBytes(ct.values[string(popIID.Slice())])
+	require.Nil(t, err)
+	scs, _, err := c.Invoke(ct, byzcoin.Instruction{
+		InstanceID: popIID,
+		Invoke: &byzcoin.Invoke{
+			ContractID: ContractPopParty,
+			Command:    "Finalize",
+			Args:       byzcoin.Arguments{{Name: "FinalStatement", Value: fsBuf}},
+		},
+	}, []byzcoin.Coin{{Name: contracts.CoinName, Value: FinalizeGas(len(atts))}})
+	require.Nil(t, err)
+	for _, sc := range scs {
+		ct.store(sc)
+	}
+
+	require.NotNil(t, addSubEvent(SubEvent{Name: "workshop",
+		Attendees: []kyber.Point{key.NewKeyPair(cothority.Suite).Public}}))
+	workshop := SubEvent{Name: "workshop", Attendees: atts[:2], StartTime: 2000, RewardMultiplier: 0.5}
+	require.Nil(t, addSubEvent(workshop))
+
+	ppi := ct.getPPI(t, popIID)
+	require.Equal(t, 2, ppi.State)
+	require.Equal(t, 2, len(ppi.SubEvents))
+	require.Equal(t, keynote.Name, ppi.SubEvents[0].Name)
+	require.Equal(t, 2.0, ppi.SubEvents[0].RewardMultiplier)
+	require.Equal(t, workshop.Name, ppi.SubEvents[1].Name)
+	require.Equal(t, 2, len(ppi.SubEvents[1].Attendees))
+	require.True(t, atts[1].Equal(ppi.SubEvents[1].Attendees[1]))
+}
+
+// A sub-event added before the party is finalized cannot keep attendees that
+// are not in the final statement.
+func TestContract_SubEventFinalize(t *testing.T) {
+	ct := newCT()
+	ppis := testPopPartyInstances()
+	popIID := byzcoin.NewInstanceID([]byte("party"))
+	atts := ppis[1].FinalStatement.Attendees
+	finalize := func(se SubEvent) error {
+		ppi := *ppis[0]
+		ppi.SubEvents = []SubEvent{se}
+		ct.storePPI(t, popIID, &ppi)
+		fsBuf, err := protobuf.Encode(ppis[1].FinalStatement)
+		require.Nil(t, err)
+		c, err := contractPopPartyFromBytes(ct.values[string(popIID.Slice())])
+		require.Nil(t, err)
+		_, _, err = c.Invoke(ct, byzcoin.Instruction{
+			InstanceID: popIID,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractPopParty,
+				Command:    "Finalize",
+				Args:       byzcoin.Arguments{{Name: "FinalStatement", Value: fsBuf}},
+			},
+		}, []byzcoin.Coin{{Name: contracts.CoinName, Value: FinalizeGas(len(atts))}})
+		return err
+	}
+
+	require.NotNil(t, finalize(SubEvent{Name: "outsiders",
+		Attendees: []kyber.Point{atts[0], key.NewKeyPair(cothority.Suite).Public}}))
+	require.Nil(t, finalize(SubEvent{Name: "workshop", Attendees: atts[:2]}))
+}
+
+func TestPopPartyFinalize_DuplicateAttendees(t *testing.T) {
+	ct := newCT()
+	ppis := testPopPartyInstances()
+	popIID := byzcoin.NewInstanceID([]byte("party"))
+	ct.storePPI(t, popIID, ppis[0])
+
+	fs := *ppis[1].FinalStatement
+	fs.Attendees = append(fs.Attendees, fs.Attendees[1])
+	fsBuf, err := protobuf.Encode(&fs)
+	require.Nil(t, err)
+	c, err := contractPopPartyFromBytes(ct.values[string(popIID.Slice())])
+	require.Nil(t, err)
+	finalize := func(gas uint64) error {
+		_, _, err := c.Invoke(ct, byzcoin.Instruction{
+			InstanceID: popIID,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractPopParty,
+				Command:    "Finalize",
+				Args:       byzcoin.Arguments{{Name: "FinalStatement", Value: fsBuf}},
+			},
+		}, []byzcoin.Coin{{Name: contracts.CoinName, Value: gas}})
+		return err
+	}
+	// The gas is charged before looking for duplicates.
+	require.Equal(t, ErrInsufficientGas, finalize(0))
+	err = finalize(FinalizeGas(len(fs.Attendees)))
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "listed twice")
+	require.Equal(t, 1, ct.getPPI(t, popIID).State)
+}
+
+func TestContract_UpdateDescription(t *testing.T) {
+	ct := newCT()
+	ppis := testPopPartyInstances()
+	configured := byzcoin.NewInstanceID([]byte("configured"))
+	ct.storePPI(t, configured, ppis[0])
+	finalized := byzcoin.NewInstanceID([]byte("finalized"))
+	ct.storePPI(t, finalized, ppis[1])
+	cancelled := byzcoin.NewInstanceID([]byte("cancelled"))
+	ppiCancelled := *ppis[0]
+	ppiCancelled.State = 4
+	ct.storePPI(t, cancelled, &ppiCancelled)
+
+	update := func(iid byzcoin.InstanceID, descBuf []byte) error {
+		return ct.invoke(byzcoin.Instruction{
+			InstanceID: iid,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractPopParty,
+				Command:    "UpdateDescription",
+				Args:       byzcoin.Arguments{{Name: "Description", Value: descBuf}},
+			},
+		})
+	}
+	desc := *ppis[0].FinalStatement.Desc
+	desc.Name = "corrected name"
+	desc.Location = "corrected location"
+	descBuf, err := protobuf.Encode(&desc)
+	require.Nil(t, err)
+
+	require.NotNil(t, update(configured, nil))
+	require.NotNil(t, update(configured, []byte("not a description")))
+	emptyBuf, err := protobuf.Encode(&PopDesc{})
+	require.Nil(t, err)
+	require.NotNil(t, update(configured, emptyBuf))
+	require.NotNil(t, update(finalized, descBuf))
+	require.Equal(t, ErrPartyCancelled, update(cancelled, descBuf))
+
+	require.Nil(t, update(configured, descBuf))
+	ppi := ct.getPPI(t, configured)
+	require.Equal(t, 1, ppi.State)
+	require.Equal(t, desc.Name, ppi.FinalStatement.Desc.Name)
+	require.Equal(t, desc.Location, ppi.FinalStatement.Desc.Location)
+}
+
+func TestContract_MaxAttendees(t *testing.T) {
+	ppis := testPopPartyInstances()
+	fs := ppis[1].FinalStatement
+	fsBuf, err := protobuf.Encode(fs)
+	require.Nil(t, err)
+	cfgBuf, err := protobuf.Encode(ppis[0].FinalStatement)
+	require.Nil(t, err)
+
+	for _, tc := range []struct {
+		name         string
+		maxAttendees []byte
+		spawnOK      bool
+		finalizeOK   bool
+	}{
+		{"no cap", nil, true, true},
+		{"unlimited", []byte{0, 0, 0, 0, 0, 0, 0, 0}, true, true},
+		{"at cap", []byte{3, 0, 0, 0, 0, 0, 0, 0}, true, true},
+		{"over cap", []byte{2, 0, 0, 0, 0, 0, 0, 0}, true, false},
+		{"invalid cap", []byte{2}, false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ct := newCT()
+			args := byzcoin.Arguments{{Name: "FinalStatement", Value: cfgBuf}}
+			if tc.maxAttendees != nil {
+				args = append(args, byzcoin.Argument{Name: "MaxAttendees", Value: tc.maxAttendees})
+			}
+			spawn := byzcoin.Instruction{
+				InstanceID: ct.storePartyDarc(t, 0),
+				Spawn:      &byzcoin.Spawn{ContractID: ContractPopParty, Args: args},
+			}
+			scs, _, err := (&contract{}).Spawn(ct, spawn, nil)
+			if !tc.spawnOK {
+				require.NotNil(t, err)
+				return
+			}
+			require.Nil(t, err)
+			ct.store(scs[0])
+
+			c, err := contractPopPartyFromBytes(scs[0].Value)
+			require.Nil(t, err)
+			_, _, err = c.Invoke(ct, byzcoin.Instruction{
+				InstanceID: spawn.DeriveID(""),
+				Invoke: &byzcoin.Invoke{
+					ContractID: ContractPopParty,
+					Command:    "Finalize",
+					Args:       byzcoin.Arguments{{Name: "FinalStatement", Value: fsBuf}},
+				},
+			}, []byzcoin.Coin{{Name: contracts.CoinName, Value: FinalizeGas(len(fs.Attendees))}})
+			if tc.finalizeOK {
+				require.Nil(t, err)
+			} else {
+				require.NotNil(t, err)
+			}
+		})
+	}
+}
+
+func TestContract_SpawnFinalizeExpiry(t *testing.T) {
+	cfgBuf, err := protobuf.Encode(testPopPartyInstances()[0].FinalStatement)
+	require.Nil(t, err)
+	for _, tc := range []struct {
+		name      string
+		expiresAt int64
+		err       error
+	}{
+		{"expired", 10, ErrFinalizeRuleExpired},
+		{"expires with the current block", 11, ErrFinalizeRuleExpired},
+		{"not yet expired", 12, nil},
+		{"permanent", 0, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ct := newCT()
+			partyDarc := ct.storePartyDarc(t, tc.expiresAt)
+			ct.index = 10
+			_, _, err := (&contract{}).Spawn(ct, byzcoin.Instruction{
+				InstanceID: partyDarc,
+				Spawn: &byzcoin.Spawn{
+					ContractID: ContractPopParty,
+					Args:       byzcoin.Arguments{{Name: "FinalStatement", Value: cfgBuf}},
+				},
+			}, nil)
+			require.Equal(t, tc.err, err)
+		})
+	}
+
+	// A darc without a Finalize rule is accepted as well.
+	ct := newCT()
+	org := darc.NewSignerEd25519(nil, nil).Identity()
+	d := darc.NewDarc(darc.InitRules([]darc.Identity{org}, []darc.Identity{org}), []byte("party"))
+	dBuf, err := d.ToProto()
+	require.Nil(t, err)
+	ct.store(byzcoin.StateChange{
+		InstanceID: d.GetBaseID(),
+		ContractID: []byte(byzcoin.ContractDarcID),
+		Value:      dBuf,
+		DarcID:     d.GetBaseID(),
+	})
+	_, _, err = (&contract{}).Spawn(ct, byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(d.GetBaseID()),
+		Spawn: &byzcoin.Spawn{
+			ContractID: ContractPopParty,
+			Args:       byzcoin.Arguments{{Name: "FinalStatement", Value: cfgBuf}},
+		},
+	}, nil)
+	require.Nil(t, err)
+}
+
+func TestContract_Cancel(t *testing.T) {
+	ct := newCT()
+	ppis := testPopPartyInstances()
+	var organizers []darc.Identity
+	for i := 0; i < 3; i++ {
+		organizers = append(organizers, darc.NewSignerEd25519(nil, nil).Identity())
+	}
+	var orgIDs []string
+	for _, org := range organizers {
+		orgIDs = append(orgIDs, org.String())
+	}
+	d := darc.NewDarc(darc.InitRules(organizers[:1], organizers[:1]), []byte("party"))
+	require.Nil(t, d.Rules.AddRule("invoke:"+ContractPopParty+".Finalize",
+		expression.InitOrExpr(orgIDs...)))
+	dBuf, err := d.ToProto()
+	require.Nil(t, err)
+	ct.store(byzcoin.StateChange{
+		InstanceID: d.GetBaseID(),
+		ContractID: []byte(byzcoin.ContractDarcID),
+		Value:      dBuf,
+		DarcID:     d.GetBaseID(),
+	})
+	storeParty := func(name string, ppi *PopPartyInstance) byzcoin.InstanceID {
+		iid := byzcoin.NewInstanceID([]byte(name))
+		buf, err := protobuf.Encode(ppi)
+		require.Nil(t, err)
+		ct.store(byzcoin.StateChange{
+			InstanceID: iid.Slice(),
+			ContractID: []byte(ContractPopParty),
+			Value:      buf,
+			DarcID:     d.GetBaseID(),
+		})
+		return iid
+	}
+	cancel := func(iid byzcoin.InstanceID, signers ...darc.Identity) error {
+		return ct.invoke(byzcoin.Instruction{
+			InstanceID: iid,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractPopParty,
+				Command:    "Cancel",
+			},
+			SignerIdentities: signers,
+		})
+	}
+
+	configured := storeParty("configured", ppis[0])
+	outsider := darc.NewSignerEd25519(nil, nil).Identity()
+	require.NotNil(t, cancel(configured, organizers[0]))
+	require.NotNil(t, cancel(configured, organizers[0], organizers[0]))
+	require.NotNil(t, cancel(configured, organizers[0], outsider))
+	require.Equal(t, 1, ct.getPPI(t, configured).State)
+	require.Nil(t, cancel(configured, organizers[0], organizers[2]))
+	require.Equal(t, 4, ct.getPPI(t, configured).State)
+
+	// A cancelled party doesn't accept any instruction.
+	require.Equal(t, ErrPartyCancelled, cancel(configured, organizers...))
+	fsBuf, err := protobuf.Encode(ppis[1].FinalStatement)
+	require.Nil(t, err)
+	require.Equal(t, ErrPartyCancelled, ct.invoke(byzcoin.Instruction{
+		InstanceID: configured,
+		Invoke: &byzcoin.Invoke{
+			ContractID: ContractPopParty,
+			Command:    "Finalize",
+			Args:       byzcoin.Arguments{{Name: "FinalStatement", Value: fsBuf}},
+		},
+	}))
+
+	finalized := storeParty("finalized", ppis[1])
+	require.Nil(t, cancel(finalized, organizers...))
+	require.Equal(t, 4, ct.getPPI(t, finalized).State)
+
+	// An organizer delegated to a darc signs through the sign rule of
+	// that darc.
+	member := darc.NewSignerEd25519(nil, nil).Identity()
+	delegate := darc.NewDarc(darc.InitRules([]darc.Identity{member},
+		[]darc.Identity{member}), []byte("delegate"))
+	delegateBuf, err := delegate.ToProto()
+	require.Nil(t, err)
+	ct.store(byzcoin.StateChange{
+		InstanceID: delegate.GetBaseID(),
+		ContractID: []byte(byzcoin.ContractDarcID),
+		Value:      delegateBuf,
+		DarcID:     delegate.GetBaseID(),
+	})
+	d = darc.NewDarc(darc.InitRules(organizers[:1], organizers[:1]), []byte("delegated party"))
+	require.Nil(t, d.Rules.AddRule("invoke:"+ContractPopParty+".Finalize",
+		expression.InitOrExpr(orgIDs[0], orgIDs[1],
+			darc.NewIdentityDarc(delegate.GetBaseID()).String())))
+	dBuf, err = d.ToProto()
+	require.Nil(t, err)
+	ct.store(byzcoin.StateChange{
+		InstanceID: d.GetBaseID(),
+		ContractID: []byte(byzcoin.ContractDarcID),
+		Value:      dBuf,
+		DarcID:     d.GetBaseID(),
+	})
+	delegated := storeParty("delegated", ppis[1])
+	require.NotNil(t, cancel(delegated, organizers[0], outsider))
+	require.Nil(t, cancel(delegated, organizers[0], member))
+	require.Equal(t, 4, ct.getPPI(t, delegated).State)
+}
+
+// cvTest is a simple in-memory ReadOnlyStateTrie used to call the contract
+// without a ledger.
+type cvTest struct {
+	values      map[string][]byte
+	contractIDs map[string]string
+	darcIDs     map[string]darc.ID
+	index       int
+}
+
+func newCT() *cvTest {
+	return &cvTest{
+		values:      make(map[string][]byte),
+		contractIDs: make(map[string]string),
+		darcIDs:     make(map[string]darc.ID),
+	}
+}
+
+func (ct *cvTest) GetValues(key []byte) (value []byte, version uint64, contractID string, darcID darc.ID, err error) {
+	k := string(key)
+	if _, ok := ct.values[k]; !ok {
+		return nil, 0, "", nil, errors.New("key not set")
+	}
+	return ct.values[k], 0, ct.contractIDs[k], ct.darcIDs[k], nil
+}
+
+func (ct *cvTest) GetProof(key []byte) (*trie.Proof, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (ct *cvTest) GetIndex() int {
+	return ct.index
+}
+
+// storePartyDarc stores a darc with a Finalize rule that expires at the block
+// with index expiresAtBlock and returns its instance ID.
+func (ct *cvTest) storePartyDarc(t *testing.T, expiresAtBlock int64) byzcoin.InstanceID {
+	org := darc.NewSignerEd25519(nil, nil).Identity()
+	d := darc.NewDarc(darc.InitRules([]darc.Identity{org}, []darc.Identity{org}), []byte("party"))
+	require.Nil(t, d.Rules.AddRule(finalizeAction, expression.Expr(org.String())))
+	d.Rules.List[len(d.Rules.List)-1].ExpiresAtBlock = expiresAtBlock
+	dBuf, err := d.ToProto()
+	require.Nil(t, err)
+	ct.store(byzcoin.StateChange{
+		InstanceID: d.GetBaseID(),
+		ContractID: []byte(byzcoin.ContractDarcID),
+		Value:      dBuf,
+		DarcID:     d.GetBaseID(),
+	})
+	return byzcoin.NewInstanceID(d.GetBaseID())
+}
+
+func (ct *cvTest) storePPI(t *testing.T, iid byzcoin.InstanceID, ppi *PopPartyInstance) {
+	buf, err := protobuf.Encode(ppi)
+	require.Nil(t, err)
+	ct.store(byzcoin.StateChange{
+		InstanceID: iid.Slice(),
+		ContractID: []byte(ContractPopParty),
+		Value:      buf,
+		DarcID:     darc.ID(iid.Slice()),
+	})
+}
+
+func (ct *cvTest) getPPI(t *testing.T, iid byzcoin.InstanceID) *PopPartyInstance {
+	var ppi PopPartyInstance
+	err := protobuf.DecodeWithConstructors(ct.values[string(iid.Slice())], &ppi,
+		network.DefaultConstructors(cothority.Suite))
+	require.Nil(t, err)
+	return &ppi
+}
+
+func (ct *cvTest) store(sc byzcoin.StateChange) {
+	k := string(sc.InstanceID)
+	ct.values[k] = sc.Value
+	ct.contractIDs[k] = string(sc.ContractID)
+	ct.darcIDs[k] = sc.DarcID
+	ct.index++
+}
+
+// invoke calls the contract stored at the instance of the instruction and
+// applies the resulting state changes.
+func (ct *cvTest) invoke(inst byzcoin.Instruction) error {
+	c, err := contractPopPartyFromBytes(ct.values[string(inst.InstanceID.Slice())])
+	if err != nil {
+		return err
+	}
+	scs, _, err := c.Invoke(ct, inst, nil)
+	if err != nil {
+		return err
+	}
+	for _, sc := range scs {
+		ct.store(sc)
+	}
+	return nil
+}
+
+// Proves on chain B the attendance to a party finalized on chain A.
+func TestContract_CrossChainProof(t *testing.T) {
+	local := onet.NewTCPTest(cothority.Suite)
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(3, true)
+	att := key.NewKeyPair(cothority.Suite)
+
+	clA, signerA, darcA := newPopLedger(t, roster)
+	fs := &FinalStatement{
+		Desc:      &PopDesc{Name: "party A", Roster: roster},
+		Attendees: []kyber.Point{att.Public},
+	}
+	partyA := spawnPopParty(t, clA, signerA, darcA, fs, nil)
+	_, err := PopPartyFinalizeWithGas(clA, partyA, fs, nil, coinIID(t, signerA), signerA)
+	require.Nil(t, err)
+	configuredA := spawnPopParty(t, clA, signerA, darcA, fs, nil)
+	_, err = PopPartyUpdateDescription(clA, configuredA, &PopDesc{Name: "party A'", Roster: roster}, signerA)
+	require.Nil(t, err)
+	_, err = PopPartyUpdateDescription(clA, partyA, &PopDesc{Name: "party A'", Roster: roster}, signerA)
+	require.NotNil(t, err)
+
+	clB, signerB, darcB := newPopLedger(t, roster)
+	fsB := &FinalStatement{
+		Desc: &PopDesc{Name: "party B", Roster: roster},
+	}
+	partyB := spawnPopParty(t, clB, signerB, darcB, fsB,
+		&LinkedChains{Chains: []ChainInfo{{ByzCoinID: clA.ID, Roster: roster}}})
+
+	getProof := func(cl *byzcoin.Client, iid byzcoin.InstanceID) byzcoin.Proof {
+		reply, err := cl.GetProof(iid.Slice())
+		require.Nil(t, err)
+		return reply.Proof
+	}
+	crossChainProof := func(srcID []byte, proof byzcoin.Proof, att kyber.Point) error {
+		proofBuf, err := protobuf.Encode(&proof)
+		require.Nil(t, err)
+		attBuf, err := att.MarshalBinary()
+		require.Nil(t, err)
+		ctrs, err := clB.GetSignerCounters(signerB.Identity().String())
+		require.Nil(t, err)
+		ctx := byzcoin.ClientTransaction{
+			Instructions: byzcoin.Instructions{{
+				InstanceID: partyB,
+				Invoke: &byzcoin.Invoke{
+					ContractID: ContractPopParty,
+					Command:    "CrossChainProof",
+					Args: byzcoin.Arguments{
+						{Name: "SourceByzCoinID", Value: srcID},
+						{Name: "Proof", Value: proofBuf},
+						{Name: "Attendee", Value: attBuf},
+					},
+				},
+				SignerCounter: []uint64{ctrs.Counters[0] + 1},
+			}},
+		}
+		require.Nil(t, ctx.FillSignersAndSignWith(signerB))
+		_, err = clB.AddTransactionAndWait(ctx, 10)
+		return err
+	}
+
+	proofA := getProof(clA, partyA)
+	// Party B must be finalized first.
+	require.NotNil(t, crossChainProof(clA.ID, proofA, att.Public))
+	_, err = PopPartyFinalizeWithGas(clB, partyB, fsB, nil, coinIID(t, signerB), signerB)
+	require.Nil(t, err)
+
+	// Not an attendee, a party that is not finalized, a chain that is not
+	// linked, and a proof from another chain.
+	require.NotNil(t, crossChainProof(clA.ID, proofA, key.NewKeyPair(cothority.Suite).Public))
+	require.NotNil(t, crossChainProof(clA.ID, getProof(clA, configuredA), att.Public))
+	require.NotNil(t, crossChainProof(clB.ID, getProof(clB, partyB), att.Public))
+	require.NotNil(t, crossChainProof(clA.ID, getProof(clB, partyB), att.Public))
+
+	require.Nil(t, crossChainProof(clA.ID, proofA, att.Public))
+	var ppi PopPartyInstance
+	require.Nil(t, getProof(clB, partyB).VerifyAndDecode(cothority.Suite, ContractPopParty, &ppi))
+	require.Equal(t, 1, len(ppi.CrossChainAttendees))
+	require.True(t, att.Public.Equal(ppi.CrossChainAttendees[0]))
+
+	// Sessions can also be added through the client helper. The attendees
+	// proven from chain A are attendees of party B.
+	_, err = PopPartyAddSubEvent(clB, partyB, &SubEvent{Name: "outsiders", StartTime: 1000,
+		RewardMultiplier: 1, Attendees: []kyber.Point{key.NewKeyPair(cothority.Suite).Public}}, signerB)
+	require.NotNil(t, err)
+	_, err = PopPartyAddSubEvent(clB, partyB, &SubEvent{Name: "session", StartTime: 1000,
+		RewardMultiplier: 1, Attendees: []kyber.Point{att.Public}}, signerB)
+	require.Nil(t, err)
+	var ppi2 PopPartyInstance
+	require.Nil(t, getProof(clB, partyB).VerifyAndDecode(cothority.Suite, ContractPopParty, &ppi2))
+	require.Equal(t, 1, len(ppi2.SubEvents))
+	require.Equal(t, 1, len(ppi2.CrossChainAttendees))
+
+	// The cap is stored when spawning through the client helper.
+	cappedB, err := PopPartySpawnWithCap(clB, darcB, &FinalStatement{
+		Desc: &PopDesc{Name: "party B'", Roster: roster},
+	}, 10, signerB)
+	require.Nil(t, err)
+	var ppiCapped PopPartyInstance
+	require.Nil(t, getProof(clB, cappedB).VerifyAndDecode(cothority.Suite, ContractPopParty, &ppiCapped))
+	require.Equal(t, uint64(10), ppiCapped.MaxAttendees)
+
+	// The signer is the only organizer, so it can cancel the party alone.
+	_, err = PopPartyCancel(clB, partyB, signerB)
+	require.Nil(t, err)
+	var ppi3 PopPartyInstance
+	require.Nil(t, getProof(clB, partyB).VerifyAndDecode(cothority.Suite, ContractPopParty, &ppi3))
+	require.Equal(t, 4, ppi3.State)
+}
+
+// Rotates the identity of the organizer in the darc of a party and finalizes
+// the party with the new identity.
+func TestContract_RotateOrganizer(t *testing.T) {
+	local := onet.NewTCPTest(cothority.Suite)
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(3, true)
+
+	cl, signer, darcID := newPopLedger(t, roster)
+	fs := &FinalStatement{
+		Desc:      &PopDesc{Name: "rotation party", Roster: roster},
+		Attendees: []kyber.Point{key.NewKeyPair(cothority.Suite).Public},
+	}
+	party := spawnPopParty(t, cl, signer, darcID, fs, nil)
+
+	reply, err := cl.GetProof(darcID)
+	require.Nil(t, err)
+	_, dBuf, _, _, err := reply.Proof.KeyValue()
+	require.Nil(t, err)
+	d, err := darc.NewFromProtobuf(dBuf)
+	require.Nil(t, err)
+	newSigner := darc.NewSignerEd25519(nil, nil)
+	rotated, err := darc.Rotate(signer, newSigner, d)
+	require.Nil(t, err)
+	rotatedBuf, err := rotated.ToProto()
+	require.Nil(t, err)
+
+	// The genesis darc also holds the evolve_unrestricted rule, which can
+	// only be changed by an unrestricted evolution.
+	ctrs, err := cl.GetSignerCounters(signer.Identity().String())
+	require.Nil(t, err)
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: byzcoin.NewInstanceID(darcID),
+			Invoke: &byzcoin.Invoke{
+				ContractID: byzcoin.ContractDarcID,
+				Command:    "evolve_unrestricted",
+				Args:       byzcoin.Arguments{{Name: "darc", Value: rotatedBuf}},
+			},
+			SignerCounter: []uint64{ctrs.Counters[0] + 1},
+		}},
+	}
+	require.Nil(t, ctx.FillSignersAndSignWith(signer))
+	_, err = cl.AddTransactionAndWait(ctx, 10)
+	require.Nil(t, err)
+
+	_, err = PopPartyFinalizeWithGas(cl, party, fs, nil, coinIID(t, signer), signer)
+	require.NotNil(t, err)
+	_, err = PopPartyFinalizeWithGas(cl, party, fs, nil, coinIID(t, signer), newSigner)
+	require.Nil(t, err)
+	var ppi PopPartyInstance
+	reply, err = cl.GetProof(party.Slice())
+	require.Nil(t, err)
+	require.Nil(t, reply.Proof.VerifyAndDecode(cothority.Suite, ContractPopParty, &ppi))
+	require.Equal(t, 2, ppi.State)
+}
+
+// Simulates the finalization of a party, which credits the attendee, and
+// checks that nothing is stored before finalizing it for real.
+func TestContract_SimulateFinalize(t *testing.T) {
+	local := onet.NewTCPTest(cothority.Suite)
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(3, true)
+
+	cl, signer, darcID := newPopLedger(t, roster)
+	att := key.NewKeyPair(cothority.Suite)
+	fs := &FinalStatement{
+		Desc:      &PopDesc{Name: "simulated party", Roster: roster},
+		Attendees: []kyber.Point{att.Public},
+	}
+	party := spawnPopParty(t, cl, signer, darcID, fs, nil)
+
+	fsBuf, err := protobuf.Encode(fs)
+	require.Nil(t, err)
+	gas := make([]byte, 8)
+	binary.LittleEndian.PutUint64(gas, FinalizeGas(len(fs.Attendees)))
+	ctrs, err := cl.GetSignerCounters(signer.Identity().String())
+	require.Nil(t, err)
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: coinIID(t, signer),
+			Invoke: &byzcoin.Invoke{
+				ContractID: contracts.ContractCoinID,
+				Command:    "fetch",
+				Args:       byzcoin.Arguments{{Name: "coins", Value: gas}},
+			},
+			SignerCounter: []uint64{ctrs.Counters[0] + 1},
+		}, {
+			InstanceID: party,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractPopParty,
+				Command:    "Finalize",
+				Args:       byzcoin.Arguments{{Name: "FinalStatement", Value: fsBuf}},
+			},
+			SignerCounter: []uint64{ctrs.Counters[0] + 2},
+		}},
+	}
+	require.Nil(t, ctx.FillSignersAndSignWith(signer))
+	scs, err := cl.SimulateTransaction(ctx)
+	require.Nil(t, err)
+
+	attBuf, err := att.Public.MarshalBinary()
+	require.Nil(t, err)
+	h := sha256.New()
+	h.Write(party.Slice())
+	h.Write(attBuf)
+	attCoin := h.Sum(nil)
+	var credited bool
+	for _, sc := range scs {
+		if sc.StateAction == byzcoin.Create && bytes.Equal(sc.InstanceID, attCoin) {
+			var coin byzcoin.Coin
+			require.Nil(t, protobuf.Decode(sc.Value, &coin))
+			require.True(t, coin.Name.Equal(PoPCoinName))
+			require.Equal(t, uint64(AttendeeReward), coin.Value)
+			credited = true
+		}
+	}
+	require.True(t, credited)
+
+	// Nothing has been stored, so the same transaction can be sent.
+	reply, err := cl.GetProof(attCoin)
+	require.Nil(t, err)
+	require.False(t, reply.Proof.InclusionProof.Match(attCoin))
+	_, err = cl.AddTransactionAndWait(ctx, 10)
+	require.Nil(t, err)
+	reply, err = cl.GetProof(attCoin)
+	require.Nil(t, err)
+	require.True(t, reply.Proof.InclusionProof.Match(attCoin))
+
+	// The signer counters have been used now.
+	_, err = cl.SimulateTransaction(ctx)
+	require.NotNil(t, err)
+}
+
+// Finalizing a party adds its attendance score to the reputation of the
+// attendees that have one.
+func TestContract_AttendanceReputation(t *testing.T) {
+	local := onet.NewTCPTest(cothority.Suite)
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(3, true)
+
+	cl, signer, darcID := newPopLedger(t, roster)
+	att := key.NewKeyPair(cothority.Suite)
+	other := key.NewKeyPair(cothority.Suite)
+	fs := &FinalStatement{
+		Desc:      &PopDesc{Name: "reputation party", Roster: roster},
+		Attendees: []kyber.Point{att.Public, other.Public},
+	}
+	score := make([]byte, 8)
+	binary.LittleEndian.PutUint64(score, 10)
+	party := spawnPopParty(t, cl, signer, darcID, fs, nil,
+		byzcoin.Argument{Name: "PartyAttendanceScore", Value: score})
+
+	repDarc := spawnReputationDarc(t, cl, signer, darcID)
+	owner := darc.NewSignerEd25519(att.Public, att.Private)
+	rid, err := contracts.ReputationSpawn(cl, repDarc, &owner, signer)
+	require.Nil(t, err)
+	rep, err := contracts.GetReputation(cl, rid)
+	require.Nil(t, err)
+	require.Equal(t, uint64(0), rep)
+
+	_, err = PopPartyFinalizeWithGas(cl, party, fs, nil, coinIID(t, signer), signer)
+	require.Nil(t, err)
+	rep, err = contracts.GetReputation(cl, rid)
+	require.Nil(t, err)
+	require.Equal(t, uint64(10), rep)
+
+	// The other attendee has no reputation, which doesn't stop the
+	// finalization.
+	otherBuf, err := other.Public.MarshalBinary()
+	require.Nil(t, err)
+	_, err = contracts.GetReputation(cl, contracts.ReputationInstanceID(otherBuf))
+	require.NotNil(t, err)
+}
+
+// The spawner of a party cannot choose a score above MaxPartyAttendanceScore.
+func TestContract_AttendanceScoreCap(t *testing.T) {
+	cfgBuf, err := protobuf.Encode(testPopPartyInstances()[0].FinalStatement)
+	require.Nil(t, err)
+	for _, tc := range []struct {
+		score uint64
+		ok    bool
+	}{{MaxPartyAttendanceScore, true}, {MaxPartyAttendanceScore + 1, false}} {
+		ct := newCT()
+		score := make([]byte, 8)
+		binary.LittleEndian.PutUint64(score, tc.score)
+		_, _, err := (&contract{}).Spawn(ct, byzcoin.Instruction{
+			InstanceID: ct.storePartyDarc(t, 0),
+			Spawn: &byzcoin.Spawn{
+				ContractID: ContractPopParty,
+				Args: byzcoin.Arguments{{Name: "FinalStatement", Value: cfgBuf},
+					{Name: "PartyAttendanceScore", Value: score}},
+			},
+		}, nil)
+		require.Equal(t, tc.ok, err == nil)
+	}
+}
+
+// newPopLedger creates a ledger where the signer can spawn and finalize
+// pop-parties, and mint coins to pay the gas.
+func newPopLedger(t *testing.T, roster *onet.Roster) (*byzcoin.Client, darc.Signer, darc.ID) {
+	signer := darc.NewSignerEd25519(nil, nil)
+	msg, err := byzcoin.DefaultGenesisMsg(byzcoin.CurrentVersion, roster,
+		[]string{"spawn:" + ContractPopParty, "invoke:" + ContractPopParty + ".Finalize",
+			"invoke:" + ContractPopParty + ".CrossChainProof", "invoke:" + ContractPopParty + ".addSubEvent",
+			"invoke:" + ContractPopParty + ".Cancel", "invoke:" + ContractPopParty + ".UpdateDescription",
+			"spawn:" + contracts.ContractCoinID, "invoke:" + contracts.ContractCoinID + ".mint",
+			"invoke:" + contracts.ContractCoinID + ".fetch",
+			"spawn:" + ContractPoll, "invoke:" + ContractPoll + ".vote"}, signer.Identity())
+	require.Nil(t, err)
+	msg.BlockInterval = 200 * time.Millisecond
+	cl, _, err := byzcoin.NewLedger(msg, false)
+	require.Nil(t, err)
+	return cl, signer, msg.GenesisDarc.GetBaseID()
+}
+
+// spawnReputationDarc spawns a darc where the signer can spawn reputations,
+// and whose reputations accept the scores of the parties of partyDarc.
+func spawnReputationDarc(t *testing.T, cl *byzcoin.Client, signer darc.Signer, partyDarc darc.ID) darc.ID {
+	owner := []darc.Identity{signer.Identity()}
+	rules := darc.InitRules(owner, owner)
+	require.Nil(t, rules.AddRule("spawn:"+contracts.ContractReputationID,
+		expression.Expr(signer.Identity().String())))
+	require.Nil(t, rules.AddRule(contracts.ReputationWhitelistAction(ContractPopParty),
+		expression.Expr(darc.NewIdentityDarc(partyDarc).String())))
+	d := darc.NewDarc(rules, []byte("reputations"))
+	dBuf, err := d.ToProto()
+	require.Nil(t, err)
+	ctrs, err := cl.GetSignerCounters(signer.Identity().String())
+	require.Nil(t, err)
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: byzcoin.NewInstanceID(partyDarc),
+			Spawn: &byzcoin.Spawn{
+				ContractID: byzcoin.ContractDarcID,
+				Args:       byzcoin.Arguments{{Name: "darc", Value: dBuf}},
+			},
+			SignerCounter: []uint64{ctrs.Counters[0] + 1},
+		}},
+	}
+	require.Nil(t, ctx.FillSignersAndSignWith(signer))
+	_, err = cl.AddTransactionAndWait(ctx, 10)
+	require.Nil(t, err)
+	return d.GetBaseID()
+}
+
+// coinIID returns the coin spawned by spawnPopParty for the signer.
+func coinIID(t *testing.T, signer darc.Signer) byzcoin.InstanceID {
+	pubBuf, err := signer.Ed25519.Point.MarshalBinary()
+	require.Nil(t, err)
+	h := sha256.New()
+	h.Write([]byte(contracts.ContractCoinID))
+	h.Write(pubBuf)
+	return byzcoin.NewInstanceID(h.Sum(nil))
+}
+
+// spawnPopParty spawns a party with the given final statement, linked
+// chains and extra arguments. The first time it is called for a signer, it
+// also creates the coin of the signer with enough coins to finalize the party.
+func spawnPopParty(t *testing.T, cl *byzcoin.Client, signer darc.Signer, darcID darc.ID,
+	fs *FinalStatement, lc *LinkedChains, extra ...byzcoin.Argument) byzcoin.InstanceID {
+	fsBuf, err := protobuf.Encode(fs)
+	require.Nil(t, err)
+	args := byzcoin.Arguments{{Name: "FinalStatement", Value: fsBuf}}
+	if lc != nil {
+		lcBuf, err := protobuf.Encode(lc)
+		require.Nil(t, err)
+		args = append(args, byzcoin.Argument{Name: "LinkedChains", Value: lcBuf})
+	}
+	args = append(args, extra...)
+	ctrs, err := cl.GetSignerCounters(signer.Identity().String())
+	require.Nil(t, err)
+	ctx := byzcoin.ClientTransaction{
+		Instructions: byzcoin.Instructions{{
+			InstanceID: byzcoin.NewInstanceID(darcID),
+			Spawn: &byzcoin.Spawn{
+				ContractID: ContractPopParty,
+				Args:       args,
+			},
+			SignerCounter: []uint64{ctrs.Counters[0] + 1},
+		}},
+	}
+	if ctrs.Counters[0] == 0 {
+		pubBuf, err := signer.Ed25519.Point.MarshalBinary()
+		require.Nil(t, err)
+		coins := make([]byte, 8)
+		binary.LittleEndian.PutUint64(coins, 1000)
+		ctx.Instructions = append(ctx.Instructions, byzcoin.Instruction{
+			InstanceID: byzcoin.NewInstanceID(darcID),
+			Spawn: &byzcoin.Spawn{
+				ContractID: contracts.ContractCoinID,
+				Args:       byzcoin.Arguments{{Name: "public", Value: pubBuf}},
+			},
+			SignerCounter: []uint64{ctrs.Counters[0] + 2},
+		}, byzcoin.Instruction{
+			InstanceID: coinIID(t, signer),
+			Invoke: &byzcoin.Invoke{
+				ContractID: contracts.ContractCoinID,
+				Command:    "mint",
+				Args:       byzcoin.Arguments{{Name: "coins", Value: coins}},
+			},
+			SignerCounter: []uint64{ctrs.Counters[0] + 3},
+		})
+	}
+	require.Nil(t, ctx.FillSignersAndSignWith(signer))
+	_, err = cl.AddTransactionAndWait(ctx, 10)
+	require.Nil(t, err)
+	return ctx.Instructions[0].DeriveID("")
+}
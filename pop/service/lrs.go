@@ -0,0 +1,122 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/sign/anon"
+)
+
+// lrsSig is how anon.Sign encodes a linkable ring signature.
+type lrsSig struct {
+	C0  kyber.Scalar
+	S   []kyber.Scalar
+	Tag kyber.Point
+}
+
+// BatchVerifyLRS verifies the linkable ring signature sigs[i] on messages[i]
+// by a member of rings[i] with the linkage scope scopes[i], like anon.Verify
+// does. It returns the linkage tags of all signatures, or an error listing
+// the indices of the invalid signatures.
+//
+// The challenges of a ring signature are chained through hashes, so the
+// scalar multiplications of different signatures cannot be merged. What is
+// shared is the link base, which is derived only once per scope, and the
+// signatures are verified in parallel.
+func BatchVerifyLRS(suite anon.Suite, messages [][]byte, rings [][]kyber.Point,
+	scopes [][]byte, sigs [][]byte) ([][]byte, error) {
+	n := len(sigs)
+	if len(messages) != n || len(rings) != n || len(scopes) != n {
+		return nil, errors.New("need as many messages, rings and scopes as signatures")
+	}
+	bases := make(map[string]kyber.Point)
+	for _, scope := range scopes {
+		if scope == nil {
+			return nil, errors.New("need a linkage scope for every signature")
+		}
+		if _, ok := bases[string(scope)]; !ok {
+			bases[string(scope)] = suite.Point().Pick(suite.XOF(scope))
+		}
+	}
+
+	tags := make([][]byte, n)
+	errs := make([]error, n)
+	indices := make(chan int, n)
+	for i := range sigs {
+		indices <- i
+	}
+	close(indices)
+	workers := runtime.NumCPU()
+	if workers > n {
+		workers = n
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				tags[i], errs[i] = verifyLRS(suite, messages[i], rings[i], scopes[i],
+					bases[string(scopes[i])], sigs[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, strconv.Itoa(i))
+		}
+	}
+	if len(failed) > 0 {
+		return nil, fmt.Errorf("invalid signatures at indices %s", strings.Join(failed, ", "))
+	}
+	return tags, nil
+}
+
+// verifyLRS is anon.Verify for a linkable ring signature, using the link base
+// already derived from the scope.
+func verifyLRS(suite anon.Suite, message []byte, ring []kyber.Point, scope []byte,
+	linkBase kyber.Point, sigBuf []byte) ([]byte, error) {
+	sig := lrsSig{S: make([]kyber.Scalar, len(ring))}
+	if err := suite.Read(bytes.NewBuffer(sigBuf), &sig); err != nil {
+		return nil, err
+	}
+	tag, err := sig.Tag.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	h1pre := suite.XOF(message)
+	h1pre.Write(scope)
+	h1pre.Write(tag)
+
+	P, PG, PH := suite.Point(), suite.Point(), suite.Point()
+	ci := sig.C0
+	for i := range ring {
+		PG.Add(PG.Mul(sig.S[i], nil), P.Mul(ci, ring[i]))
+		PH.Add(PH.Mul(sig.S[i], linkBase), P.Mul(ci, sig.Tag))
+		h1 := h1pre.Clone()
+		pgBuf, err := PG.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		phBuf, err := PH.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		h1.Write(pgBuf)
+		h1.Write(phBuf)
+		ci = suite.Scalar().Pick(h1)
+	}
+	if !ci.Equal(sig.C0) {
+		return nil, errors.New("invalid signature")
+	}
+	return tag, nil
+}
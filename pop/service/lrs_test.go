@@ -0,0 +1,87 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/sign/anon"
+	"go.dedis.ch/kyber/v3/util/key"
+)
+
+// lrsBatch returns n linkable ring signatures over a ring of size members,
+// alternating between two scopes.
+func lrsBatch(n, size int) (msgs [][]byte, rings [][]kyber.Point, scopes [][]byte, sigs [][]byte) {
+	suite := cothority.Suite.(anon.Suite)
+	ring := make([]kyber.Point, size)
+	privs := make([]kyber.Scalar, size)
+	for i := range ring {
+		kp := key.NewKeyPair(cothority.Suite)
+		ring[i], privs[i] = kp.Public, kp.Private
+	}
+	for i := 0; i < n; i++ {
+		msg := []byte(fmt.Sprintf("message %d", i))
+		scope := []byte(fmt.Sprintf("scope %d", i%2))
+		msgs = append(msgs, msg)
+		rings = append(rings, ring)
+		scopes = append(scopes, scope)
+		sigs = append(sigs, anon.Sign(suite, msg, anon.Set(ring), scope, i%size, privs[i%size]))
+	}
+	return
+}
+
+func TestBatchVerifyLRS(t *testing.T) {
+	suite := cothority.Suite.(anon.Suite)
+	msgs, rings, scopes, sigs := lrsBatch(10, 3)
+
+	tags, err := BatchVerifyLRS(suite, msgs, rings, scopes, sigs)
+	require.Nil(t, err)
+	require.Equal(t, len(sigs), len(tags))
+	for i := range sigs {
+		tag, err := anon.Verify(suite, msgs[i], anon.Set(rings[i]), scopes[i], sigs[i])
+		require.Nil(t, err)
+		require.Equal(t, tag, tags[i])
+	}
+	// The same signer is linked within a scope, but not across scopes.
+	require.Equal(t, tags[0], tags[6])
+	require.NotEqual(t, tags[0], tags[3])
+
+	msgs[3] = []byte("other message")
+	scopes[7] = []byte("other scope")
+	_, err = BatchVerifyLRS(suite, msgs, rings, scopes, sigs)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "indices 3, 7")
+
+	_, err = BatchVerifyLRS(suite, msgs, rings, scopes, sigs[1:])
+	require.NotNil(t, err)
+	scopes[0] = nil
+	_, err = BatchVerifyLRS(suite, msgs, rings, scopes, sigs)
+	require.NotNil(t, err)
+}
+
+// BenchmarkBatchVerifyLRS compares verifying 50 linkable ring signatures one
+// by one with verifying them in one batch.
+func BenchmarkBatchVerifyLRS(b *testing.B) {
+	suite := cothority.Suite.(anon.Suite)
+	msgs, rings, scopes, sigs := lrsBatch(50, 10)
+
+	b.Run("Individual", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for j := range sigs {
+				_, err := anon.Verify(suite, msgs[j], anon.Set(rings[j]), scopes[j], sigs[j])
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("Batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := BatchVerifyLRS(suite, msgs, rings, scopes, sigs); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/sign/anon"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+)
+
+// ContractPoll represents an anonymous poll among the attendees of a
+// pop-party. The following methods are available:
+//   - spawn proposes the poll given in the argument "Poll", which must be a
+//     protobuf encoded Poll without votes. The party must be finalized.
+//   - vote stores the vote for the option in the argument "Option", a 32-bit
+//     LittleEndian uint. The argument "Signature" must be a linkable ring
+//     signature on PollVoteScope by one of the attendees of the party, with
+//     PollVoteScope as the linkage scope. So every attendee can vote once for
+//     every option, without revealing who voted.
+//
+// The votes are counted with Poll.Tally.
+const ContractPoll = "poll"
+
+type contractPoll struct {
+	byzcoin.BasicContract
+	Poll
+}
+
+func contractPollFromBytes(in []byte) (byzcoin.Contract, error) {
+	c := &contractPoll{}
+	err := protobuf.Decode(in, &c.Poll)
+	if err != nil {
+		return nil, errors.New("couldn't unmarshal existing Poll: " + err.Error())
+	}
+	return c, nil
+}
+
+func (c *contractPoll) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (scs []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	pollBuf := inst.Spawn.Args.Search("Poll")
+	if pollBuf == nil {
+		return nil, nil, errors.New("need Poll argument")
+	}
+	err = protobuf.Decode(pollBuf, &c.Poll)
+	if err != nil {
+		return nil, nil, errors.New("couldn't unmarshal the poll: " + err.Error())
+	}
+	if len(c.Options) == 0 {
+		return nil, nil, errors.New("a poll needs at least one option")
+	}
+	if len(c.Votes) > 0 {
+		return nil, nil, errors.New("a new poll cannot have votes")
+	}
+	if _, err = c.attendees(rst); err != nil {
+		return
+	}
+
+	pollBuf, err = protobuf.Encode(&c.Poll)
+	if err != nil {
+		return nil, nil, errors.New("couldn't marshal Poll: " + err.Error())
+	}
+	scs = byzcoin.StateChanges{
+		byzcoin.NewStateChange(byzcoin.Create, inst.DeriveID(""), ContractPoll, pollBuf, darcID),
+	}
+	return
+}
+
+func (c *contractPoll) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (scs []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	switch inst.Invoke.Command {
+	case "vote":
+		optBuf := inst.Invoke.Args.Search("Option")
+		if len(optBuf) != 4 {
+			return nil, nil, errors.New("Option must be 4 bytes")
+		}
+		option := binary.LittleEndian.Uint32(optBuf)
+		if int(option) >= len(c.Options) {
+			return nil, nil, fmt.Errorf("poll has only %d options", len(c.Options))
+		}
+		sig := inst.Invoke.Args.Search("Signature")
+		if sig == nil {
+			return nil, nil, errors.New("missing argument: Signature")
+		}
+		atts, err := c.attendees(rst)
+		if err != nil {
+			return nil, nil, err
+		}
+		scope := PollVoteScope(inst.InstanceID, option)
+		tag, err := anon.Verify(cothority.Suite.(anon.Suite), scope, anon.Set(atts), scope, sig)
+		if err != nil {
+			return nil, nil, errors.New("invalid vote signature: " + err.Error())
+		}
+		for _, v := range c.Votes {
+			if bytes.Equal(v.Tag, tag) {
+				return nil, nil, errors.New("this attendee already voted for this option")
+			}
+		}
+		c.Votes = append(c.Votes, PollBallot{Tag: tag, Option: option})
+		log.Lvlf2("Added vote for option %d to poll %x", option, inst.InstanceID.Slice())
+
+		pollBuf, err := protobuf.Encode(&c.Poll)
+		if err != nil {
+			return nil, nil, errors.New("couldn't marshal Poll: " + err.Error())
+		}
+		scs = append(scs, byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID, ContractPoll, pollBuf, darcID))
+		return scs, cout, nil
+	default:
+		return nil, nil, errors.New("can only vote in a poll")
+	}
+}
+
+// attendees returns the attendees of the party of the poll, which must be
+// finalized.
+func (c *contractPoll) attendees(rst byzcoin.ReadOnlyStateTrie) ([]kyber.Point, error) {
+	buf, _, cid, _, err := rst.GetValues(c.PartyIID.Slice())
+	if err != nil {
+		return nil, errors.New("couldn't get the party: " + err.Error())
+	}
+	if cid != ContractPopParty {
+		return nil, errors.New("instance is not a pop-party but a " + cid)
+	}
+	var ppi PopPartyInstance
+	err = protobuf.DecodeWithConstructors(buf, &ppi, network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return nil, errors.New("couldn't unmarshal the party: " + err.Error())
+	}
+	if ppi.State != 2 || ppi.FinalStatement == nil {
+		return nil, ErrPartyNotFinalized
+	}
+	return ppi.FinalStatement.Attendees, nil
+}
+
+// PollVoteScope returns the message and the linkage scope of the ring
+// signature of a vote for the option of the poll.
+func PollVoteScope(pollIID byzcoin.InstanceID, option uint32) []byte {
+	scope := make([]byte, len(pollIID)+4)
+	copy(scope, pollIID[:])
+	binary.LittleEndian.PutUint32(scope[len(pollIID):], option)
+	return scope
+}
+
+// Tally returns the number of votes for every option of the poll.
+func (p Poll) Tally() []uint64 {
+	tally := make([]uint64, len(p.Options))
+	for _, v := range p.Votes {
+		if int(v.Option) < len(tally) {
+			tally[v.Option]++
+		}
+	}
+	return tally
+}
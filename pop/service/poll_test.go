@@ -0,0 +1,65 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/util/key"
+	"go.dedis.ch/onet/v3"
+)
+
+// Five attendees vote anonymously in a poll, and one of them tries to vote
+// twice for the same option.
+func TestPoll_Vote(t *testing.T) {
+	local := onet.NewTCPTest(cothority.Suite)
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(3, true)
+
+	cl, signer, darcID := newPopLedger(t, roster)
+	var voters []*key.Pair
+	var atts []kyber.Point
+	for i := 0; i < 5; i++ {
+		kp := key.NewKeyPair(cothority.Suite)
+		voters = append(voters, kp)
+		atts = append(atts, kp.Public)
+	}
+	fs := &FinalStatement{
+		Desc:      &PopDesc{Name: "poll party", Roster: roster},
+		Attendees: atts,
+	}
+	party := spawnPopParty(t, cl, signer, darcID, fs, nil)
+	options := []string{"yes", "no", "abstain"}
+
+	// Only the attendees of a finalized party can vote.
+	_, err := PollSpawn(cl, darcID, party, "Do we meet again?", options, signer)
+	require.NotNil(t, err)
+	_, err = PopPartyFinalizeWithGas(cl, party, fs, nil, coinIID(t, signer), signer)
+	require.Nil(t, err)
+	poll, err := PollSpawn(cl, darcID, party, "Do we meet again?", options, signer)
+	require.Nil(t, err)
+
+	for i, option := range []uint32{0, 0, 1, 2, 0} {
+		_, err = PollVote(cl, poll, option, voters[i].Private, signer)
+		require.Nil(t, err)
+	}
+	tally, err := PollTally(cl, poll)
+	require.Nil(t, err)
+	require.Equal(t, []uint64{3, 1, 1}, tally)
+	var sum uint64
+	for _, n := range tally {
+		sum += n
+	}
+	require.Equal(t, uint64(5), sum)
+
+	_, err = PollVote(cl, poll, 0, voters[0].Private, signer)
+	require.NotNil(t, err)
+	_, err = PollVote(cl, poll, 3, voters[0].Private, signer)
+	require.NotNil(t, err)
+	_, err = PollVote(cl, poll, 0, key.NewKeyPair(cothority.Suite).Private, signer)
+	require.NotNil(t, err)
+	tally, err = PollTally(cl, poll)
+	require.Nil(t, err)
+	require.Equal(t, []uint64{3, 1, 1}, tally)
+}
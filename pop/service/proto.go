@@ -29,6 +29,7 @@ func init() {
 // type :map\[string\]FinalStatement:map<string, FinalStatement>
 // type :byzcoin.InstanceID:bytes
 // type :darc.ID:bytes
+// type :float64:double
 // import "onet.proto";
 // import "darc.proto";
 //
@@ -264,6 +265,7 @@ type PopPartyInstance struct {
 	// State has one of the following values:
 	// 1: it is a configuration only
 	// 2: it is a finalized pop-party
+	// 4: it is a cancelled pop-party
 	State int
 	// FinalStatement has either only the Desc inside if State == 1, or all fields
 	// set if State == 2.
@@ -276,4 +278,84 @@ type PopPartyInstance struct {
 	Next byzcoin.InstanceID
 	// Public key of service - can be nil.
 	Service kyber.Point `protobuf:"opt"`
+	// AnchoredResults holds the results of off-chain computations on a
+	// finalized party.
+	AnchoredResults []AnchoredResult
+	// LinkedChains are the ledgers whose finalized parties are accepted by
+	// the CrossChainProof command. They are set when spawning the party.
+	LinkedChains []ChainInfo
+	// CrossChainAttendees are the attendees of parties on the linked chains
+	// that proved their attendance with CrossChainProof, once this party has
+	// been finalized. They count as attendees of this party for its
+	// sub-events.
+	CrossChainAttendees []kyber.Point
+	// SubEvents are the sessions of the party, added with addSubEvent.
+	SubEvents []SubEvent
+	// MaxAttendees is the highest number of attendees Finalize accepts. If
+	// it is 0, the number of attendees is not limited.
+	MaxAttendees uint64
+	// SchemaVersion is the version of this structure, see
+	// CurrentSchemaVersion.
+	byzcoin.ContractVersioned
+	// PartyAttendanceScore is added to the reputation of every attendee when
+	// the party is finalized, if the darc of the reputation whitelists the
+	// darc of the party. If it is 0, no reputation is changed. It is at most
+	// MaxPartyAttendanceScore.
+	PartyAttendanceScore uint64
+}
+
+// SubEvent is a session of a party with its own attendees.
+type SubEvent struct {
+	// Name of the session, unique in the party.
+	Name string
+	// Attendees of the session. Once the party is finalized, they must be
+	// attendees of the party.
+	Attendees []kyber.Point
+	// StartTime of the session, as unix-encoded seconds since 1970.
+	StartTime uint64
+	// RewardMultiplier scales the rewards for the attendees of the session.
+	RewardMultiplier float64
+}
+
+// LinkedChains is the protobuf-encoded "LinkedChains" argument of the spawn
+// instruction of a pop-party.
+type LinkedChains struct {
+	Chains []ChainInfo
+}
+
+// ChainInfo describes another ledger and the roster of its genesis block.
+type ChainInfo struct {
+	// ByzCoinID is the ID of the ledger.
+	ByzCoinID []byte
+	// Roster of the genesis block of the ledger.
+	Roster *onet.Roster
+}
+
+// AnchoredResult records the hash of the result of an off-chain computation
+// and where the result can be found.
+type AnchoredResult struct {
+	// ResultHash is the sha256 hash of the result.
+	ResultHash []byte
+	// ResultURL points to the result.
+	ResultURL string
+}
+
+// Poll is an anonymous poll among the attendees of a finalized pop-party.
+type Poll struct {
+	// PartyIID is the instance of the pop-party whose attendees can vote.
+	PartyIID byzcoin.InstanceID
+	// Question that is asked.
+	Question string
+	// Options the attendees can vote for.
+	Options []string
+	// Votes holds all votes, in the order they were cast.
+	Votes []PollBallot
+}
+
+// PollBallot is the vote of an attendee for an option of a poll.
+type PollBallot struct {
+	// Tag is the linkage tag of the ring signature of the vote.
+	Tag []byte
+	// Option is the index of the option in the poll.
+	Option uint32
 }
@@ -24,17 +24,47 @@
 // The contract "popParty" represents a pop-party in ByzCoin. It has the following
 // functionalities:
 //   * Spawn - takes a "FinalStatement" argument with the binary representation
-//     of the final statement to store.
+//     of the final statement to store. The party is then in state 1. The
+//     optional arguments are:
+//     * "LinkedChains" - a protobuf-encoded LinkedChains with the other
+//       ledgers whose attendees can join with "CrossChainProof".
+//     * "MaxAttendees" - a little-endian uint64 limiting the number of
+//       attendees of the final statement. 0 means no limit.
+//     * "PartyAttendanceScore" - a little-endian uint64 added to the
+//       reputation of every attendee at "Finalize". It cannot be higher than
+//       MaxPartyAttendanceScore.
 //   * Invoke - has the following Commands
 //     * "Finalize" - stores a final statement and doesn't let it be
 //       changed afterwards. It will also create a darc for every attendee
 //       and bind that darc to a coin account, putting an initial 1000000
 //       popCoins in it.
+//       The instruction must receive GasCostPerAttendee byzCoins per
+//       attendee, usually with a "fetch" instruction on a coin in the same
+//       transaction.
 //       This command has the following arguments:
 //       * "FinalStatement" - mandatory, to give the new final statement. It
 //         needs to be correctly finalized by the pop-service.
 //       * "Service" - when given, will create a darc and a coin-account for
 //         the service to use.
+//       The party is then in state 2.
+//     * "AnchorResult" - stores the hash of a result of a finalized party.
+//       It has the arguments "ResultHash", the sha256 hash of the result,
+//       and "ResultURL", where the result can be found.
+//     * "addSubEvent" - adds the protobuf-encoded "SubEvent" argument to a
+//       party in state 1 or 2. Its name must be unique in the party, and its
+//       RewardMultiplier a positive number. Its attendees must be attendees
+//       of the party: for a finalized party this is checked when the
+//       sub-event is added, otherwise at "Finalize".
+//     * "CrossChainProof" - adds an attendee of a finalized party on one of
+//       the LinkedChains to a party in state 2. It has the arguments
+//       "SourceByzCoinID", "Proof" of the party on the source chain and
+//       "Attendee".
+//     * "UpdateDescription" - replaces the description of a party in state 1
+//       with the protobuf-encoded PopDesc in the "Description" argument.
+//     * "Cancel" - sets a party in state 1 or 2 to state 4. After that, no
+//       command is accepted anymore. More than half of the organizers, the
+//       identities of the Finalize rule of the darc of the party, must sign
+//       the instruction.
 package service
 
 import (
@@ -1207,6 +1237,7 @@ func newService(c *onet.Context) (onet.Service, error) {
 	}
 
 	byzcoin.RegisterContract(c, ContractPopParty, contractPopPartyFromBytes)
+	byzcoin.RegisterContract(c, ContractPoll, contractPollFromBytes)
 
 	return s, nil
 }
@@ -70,6 +70,10 @@ testBCStore() {
   testFail runCl 1 bc finalize $BC $KEY ${pop_hash[1]}
   testOK runCl 3 org final ${pop_hash[1]}
 
+  # The gas for finalizing is paid from the coin account of the key.
+  testFail runCl 1 bc finalize $BC $KEY ${pop_hash[1]}
+  PUB=$( echo $KEY | sed -e "s/.*key-ed25519:\(.*\).cfg/\1/" )
+  testOK runBC 1 mint $BC $KEY $PUB 100
   testOK runCl 1 bc finalize $BC $KEY ${pop_hash[1]}
 
   runGrepSed "Coin balance" "s/.* //" runCl 1 bc coin show $BC $PARTYINSTID ${pub[1]}
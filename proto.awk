@@ -44,6 +44,12 @@ a == 3 && /^\}/ { print; a = 2; next }
 a == 3 && / *\/\/ optional/ { a = 4; next }
 #   ignore blank lines
 a == 3 && /^[[:blank:]]*$/ { next }
+#   embedded byzcoin.ContractVersioned is flattened into its field
+a == 3 && NF == 1 && $1 ~ /ContractVersioned$/ {
+					print_field("optional", "uint32", "SchemaVersion", i)
+					i = i + 1
+					next
+				}
 #   ignore hidden fields
 a == 3 && /^[[:blank:]]*[[:lower:]]/ { next }
 #   copy comments through